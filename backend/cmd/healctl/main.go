@@ -0,0 +1,128 @@
+// Command healctl runs administrative tasks against the Heal database.
+//
+//	go run ./cmd/healctl set-role <email> <user|counselor|staff|admin>
+//	go run ./cmd/healctl reencrypt [-rotate] [-batch 500]
+//	go run ./cmd/healctl import-services [-replace] <file.csv|file.geojson>
+//
+// reencrypt re-wraps every data key under the active master key, then
+// encrypts legacy plaintext and re-seals values written under older data
+// key versions. It can run while the API is serving traffic.
+//
+// import-services loads the support services directory from CSV or
+// GeoJSON (see services.ParseServicesCSV and ParseServicesGeoJSON).
+// Services are matched on id, or on type and name when there is no id, so
+// a file can be re-imported after edits. -replace removes services that are
+// not in the file.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+
+	"github.com/heal/internal/config"
+	"github.com/heal/internal/database"
+	"github.com/heal/internal/encryption"
+	"github.com/heal/internal/models"
+	"github.com/heal/internal/services"
+)
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+
+	cfg := config.Load()
+
+	db, err := database.Initialize(cfg.DatabaseURL)
+	if err != nil {
+		log.Fatal("Failed to initialize database:", err)
+	}
+	defer db.Close()
+
+	switch os.Args[1] {
+	case "set-role":
+		if len(os.Args) != 4 {
+			usage()
+		}
+		authService := services.NewAuthService(db, cfg.JWTSecret)
+		if err := authService.SetRole(os.Args[2], os.Args[3]); err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("%s is now %s\n", os.Args[2], os.Args[3])
+	case "reencrypt":
+		flags := flag.NewFlagSet("reencrypt", flag.ExitOnError)
+		rotate := flags.Bool("rotate", false, "give every user a new data key first")
+		batch := flags.Int("batch", 500, "rows per batch")
+		flags.Parse(os.Args[2:])
+
+		kms, err := encryption.NewKMS(cfg.EncryptionMasterKeys, cfg.EncryptionKeyFile)
+		if err != nil {
+			log.Fatal("Failed to initialize encryption keys:", err)
+		}
+		cipher := services.NewFieldCipher(db, kms)
+
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+		defer stop()
+
+		rewrapped, err := cipher.RewrapKeys(ctx)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("re-wrapped %d data keys under master key v%d\n", rewrapped, kms.ActiveVersion())
+
+		stats, err := cipher.ReencryptAll(ctx, *rotate, *batch)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("scanned %d values, re-encrypted %d, skipped %d shredded\n",
+			stats.Scanned, stats.Reencrypted, stats.Shredded)
+	case "import-services":
+		flags := flag.NewFlagSet("import-services", flag.ExitOnError)
+		replace := flags.Bool("replace", false, "remove services that are not in the file")
+		flags.Parse(os.Args[2:])
+		if flags.NArg() != 1 {
+			usage()
+		}
+		path := flags.Arg(0)
+
+		f, err := os.Open(path)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer f.Close()
+
+		var supportServices []models.SupportService
+		switch strings.ToLower(filepath.Ext(path)) {
+		case ".csv":
+			supportServices, err = services.ParseServicesCSV(f)
+		case ".geojson", ".json":
+			supportServices, err = services.ParseServicesGeoJSON(f)
+		default:
+			log.Fatal("import-services reads .csv or .geojson files")
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		imported, err := services.NewDirectoryService(db).ImportServices(supportServices, *replace)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("imported %d support services\n", imported)
+	default:
+		usage()
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: healctl set-role <email> <user|counselor|staff|admin>")
+	fmt.Fprintln(os.Stderr, "       healctl reencrypt [-rotate] [-batch 500]")
+	fmt.Fprintln(os.Stderr, "       healctl import-services [-replace] <file.csv|file.geojson>")
+	os.Exit(2)
+}
@@ -2,6 +2,7 @@ package config
 
 import (
 	"os"
+	"strconv"
 
 	"github.com/joho/godotenv"
 )
@@ -11,6 +12,88 @@ type Config struct {
 	JWTSecret   string
 	Port        string
 	Environment string
+
+	GeminiAPIKey string
+
+	// Master keys wrapping per-user data keys, as "1:<base64>,2:<base64>"
+	// (highest version active). Without them keys are read from, or
+	// generated into, EncryptionKeyFile.
+	EncryptionMasterKeys string
+	EncryptionKeyFile    string
+
+	// Chat retention: the longest any chat may be kept regardless of user
+	// settings (0 = no cap), and how often expired data is swept.
+	ChatMaxRetentionDays  int
+	RetentionSweepMinutes int
+
+	// Voice notes: where audio blobs are stored ("local" or "s3"), which
+	// speech-to-text backend transcribes them ("whisper" or "fake"), and
+	// which text-to-speech engine reads replies aloud ("piper", "fake", or
+	// empty to disable).
+	BlobStorage   string
+	BlobLocalDir  string
+	S3Endpoint    string
+	S3Region      string
+	S3Bucket      string
+	S3AccessKey   string
+	S3SecretKey   string
+	Transcriber   string
+	WhisperURL    string
+	VoiceMaxBytes int
+	TTS           string
+	PiperURL      string
+	PiperVoiceEN  string
+	PiperVoiceSW  string
+
+	// JSON file with the output moderation policy for Nia's replies; the
+	// built-in policy is used when empty.
+	ModerationPolicyFile string
+
+	// JSON file with the escalation policy for unacknowledged crisis alerts
+	// (the built-in policy is used when empty), and how often alerts are
+	// checked against it. An interval of 0 disables escalation.
+	EscalationPolicyFile   string
+	EscalationCheckSeconds int
+
+	// How often scheduled safety check-ins are sent and missed ones
+	// raised as alerts. 0 disables check-ins.
+	CheckinCheckSeconds int
+
+	// Texting emergency contacts when an alert is raised: the provider
+	// ("africastalking", "twilio", "fake", or empty to disable), its
+	// credentials, the lowest severity that texts anyone, an optional
+	// text/template for the message, the token delivery reports must carry,
+	// and how often queued messages are retried.
+	SMSProvider          string
+	AfricasTalkingURL    string
+	AfricasTalkingUser   string
+	AfricasTalkingAPIKey string
+	AfricasTalkingFrom   string
+	TwilioURL            string
+	TwilioAccountSID     string
+	TwilioAuthToken      string
+	TwilioFrom           string
+	SMSAlertMinSeverity  string
+	SMSAlertTemplate     string
+	SMSVerifyTemplate    string
+	SMSWebhookToken      string
+	SMSDispatchSeconds   int
+
+	// Cache of Nia's answers to common opening messages ("hi", "habari").
+	// A TTL of 0 disables it.
+	ResponseCacheTTLMinutes int
+	ResponseCacheMaxEntries int
+
+	// Chat rate limits (token buckets) and daily per-user LLM budgets.
+	// A budget of 0 means unlimited.
+	ChatUserRatePerMinute  float64
+	ChatUserBurst          int
+	ChatIPRatePerMinute    float64
+	ChatIPBurst            int
+	LLMDailyTokenBudget    int
+	LLMDailyCostBudgetUSD  float64
+	LLMPromptCostPer1K     float64
+	LLMCompletionCostPer1K float64
 }
 
 func Load() *Config {
@@ -22,6 +105,63 @@ func Load() *Config {
 		JWTSecret:   getEnv("JWT_SECRET", "your-super-secret-jwt-key-change-in-production"),
 		Port:        getEnv("PORT", "8080"),
 		Environment: getEnv("ENVIRONMENT", "development"),
+
+		GeminiAPIKey: getEnv("NEXT_PUBLIC_GEMINI_API_KEY", ""),
+
+		EncryptionMasterKeys: getEnv("ENCRYPTION_MASTER_KEYS", ""),
+		EncryptionKeyFile:    getEnv("ENCRYPTION_KEY_FILE", "heal-master-keys.json"),
+
+		ChatMaxRetentionDays:  getEnvInt("CHAT_MAX_RETENTION_DAYS", 0),
+		RetentionSweepMinutes: getEnvInt("RETENTION_SWEEP_MINUTES", 15),
+
+		BlobStorage:   getEnv("BLOB_STORAGE", "local"),
+		BlobLocalDir:  getEnv("BLOB_LOCAL_DIR", "uploads"),
+		S3Endpoint:    getEnv("S3_ENDPOINT", "http://localhost:9000"),
+		S3Region:      getEnv("S3_REGION", "us-east-1"),
+		S3Bucket:      getEnv("S3_BUCKET", "heal"),
+		S3AccessKey:   getEnv("S3_ACCESS_KEY", ""),
+		S3SecretKey:   getEnv("S3_SECRET_KEY", ""),
+		Transcriber:   getEnv("TRANSCRIBER", "whisper"),
+		WhisperURL:    getEnv("WHISPER_URL", "http://localhost:8081"),
+		VoiceMaxBytes: getEnvInt("VOICE_MAX_BYTES", 10<<20),
+		TTS:           getEnv("TTS", ""),
+		PiperURL:      getEnv("PIPER_URL", "http://localhost:5000"),
+		PiperVoiceEN:  getEnv("PIPER_VOICE_EN", "en_US-lessac-medium"),
+		PiperVoiceSW:  getEnv("PIPER_VOICE_SW", "sw_CD-lanfrica-medium"),
+
+		ModerationPolicyFile: getEnv("MODERATION_POLICY_FILE", ""),
+
+		EscalationPolicyFile:   getEnv("ESCALATION_POLICY_FILE", ""),
+		EscalationCheckSeconds: getEnvInt("ESCALATION_CHECK_SECONDS", 30),
+
+		CheckinCheckSeconds: getEnvInt("CHECKIN_CHECK_SECONDS", 30),
+
+		SMSProvider:          getEnv("SMS_PROVIDER", ""),
+		AfricasTalkingURL:    getEnv("AFRICASTALKING_URL", "https://api.africastalking.com"),
+		AfricasTalkingUser:   getEnv("AFRICASTALKING_USERNAME", "sandbox"),
+		AfricasTalkingAPIKey: getEnv("AFRICASTALKING_API_KEY", ""),
+		AfricasTalkingFrom:   getEnv("AFRICASTALKING_FROM", ""),
+		TwilioURL:            getEnv("TWILIO_URL", "https://api.twilio.com"),
+		TwilioAccountSID:     getEnv("TWILIO_ACCOUNT_SID", ""),
+		TwilioAuthToken:      getEnv("TWILIO_AUTH_TOKEN", ""),
+		TwilioFrom:           getEnv("TWILIO_FROM", ""),
+		SMSAlertMinSeverity:  getEnv("SMS_ALERT_MIN_SEVERITY", "high"),
+		SMSAlertTemplate:     getEnv("SMS_ALERT_TEMPLATE", ""),
+		SMSVerifyTemplate:    getEnv("SMS_VERIFY_TEMPLATE", ""),
+		SMSWebhookToken:      getEnv("SMS_WEBHOOK_TOKEN", ""),
+		SMSDispatchSeconds:   getEnvInt("SMS_DISPATCH_SECONDS", 15),
+
+		ResponseCacheTTLMinutes: getEnvInt("RESPONSE_CACHE_TTL_MINUTES", 60),
+		ResponseCacheMaxEntries: getEnvInt("RESPONSE_CACHE_MAX_ENTRIES", 500),
+
+		ChatUserRatePerMinute:  getEnvFloat("CHAT_USER_RATE_PER_MINUTE", 6),
+		ChatUserBurst:          getEnvInt("CHAT_USER_BURST", 3),
+		ChatIPRatePerMinute:    getEnvFloat("CHAT_IP_RATE_PER_MINUTE", 30),
+		ChatIPBurst:            getEnvInt("CHAT_IP_BURST", 10),
+		LLMDailyTokenBudget:    getEnvInt("LLM_DAILY_TOKEN_BUDGET", 50000),
+		LLMDailyCostBudgetUSD:  getEnvFloat("LLM_DAILY_COST_BUDGET_USD", 0),
+		LLMPromptCostPer1K:     getEnvFloat("LLM_PROMPT_COST_PER_1K", 0.0001),
+		LLMCompletionCostPer1K: getEnvFloat("LLM_COMPLETION_COST_PER_1K", 0.0004),
 	}
 }
 
@@ -30,4 +170,22 @@ func getEnv(key, defaultValue string) string {
 		return value
 	}
 	return defaultValue
-}
\ No newline at end of file
+}
+
+func getEnvInt(key string, defaultValue int) int {
+	if value := os.Getenv(key); value != "" {
+		if n, err := strconv.Atoi(value); err == nil {
+			return n
+		}
+	}
+	return defaultValue
+}
+
+func getEnvFloat(key string, defaultValue float64) float64 {
+	if value := os.Getenv(key); value != "" {
+		if f, err := strconv.ParseFloat(value, 64); err == nil {
+			return f
+		}
+	}
+	return defaultValue
+}
@@ -62,7 +62,7 @@ func createTables(db *sql.DB) error {
 			session_id TEXT NOT NULL,
 			user_id TEXT NOT NULL,
 			content TEXT NOT NULL,
-			sender_type TEXT NOT NULL, -- 'user' or 'ai'
+			sender_type TEXT NOT NULL, -- 'user', 'ai' or 'counselor'
 			message_type TEXT DEFAULT 'text', -- 'text', 'audio', 'video'
 			metadata TEXT, -- JSON for additional data
 			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
@@ -70,6 +70,306 @@ func createTables(db *sql.DB) error {
 			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
 		)`,
 
+		`CREATE TABLE IF NOT EXISTS message_feedback (
+			id TEXT PRIMARY KEY,
+			message_id TEXT NOT NULL,
+			session_id TEXT NOT NULL,
+			user_id TEXT NOT NULL,
+			rating INTEGER NOT NULL, -- 1-5
+			feedback TEXT,
+			prompt_version TEXT,
+			provider TEXT,
+			language TEXT,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (message_id) REFERENCES chat_messages(id) ON DELETE CASCADE,
+			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+			UNIQUE(message_id, user_id)
+		)`,
+
+		`CREATE INDEX IF NOT EXISTS idx_message_feedback_created_at ON message_feedback(created_at)`,
+
+		// One row per distinct transcript hash, kept from its first export
+		`CREATE TABLE IF NOT EXISTS transcript_exports (
+			hash TEXT PRIMARY KEY,
+			user_id TEXT NOT NULL,
+			session_id TEXT NOT NULL,
+			includes_ai BOOLEAN NOT NULL,
+			message_count INTEGER NOT NULL,
+			exported_at DATETIME NOT NULL,
+			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+		)`,
+
+		`CREATE TABLE IF NOT EXISTS handoff_requests (
+			id TEXT PRIMARY KEY,
+			session_id TEXT NOT NULL,
+			user_id TEXT NOT NULL,
+			reason TEXT NOT NULL, -- 'requested' or 'risk'
+			risk_level TEXT DEFAULT 'none',
+			status TEXT DEFAULT 'waiting', -- 'waiting', 'claimed', 'released'
+			counselor_id TEXT,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			claimed_at DATETIME,
+			released_at DATETIME,
+			FOREIGN KEY (session_id) REFERENCES chat_sessions(id) ON DELETE CASCADE,
+			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+		)`,
+
+		`CREATE INDEX IF NOT EXISTS idx_handoff_requests_status ON handoff_requests(status, created_at)`,
+
+		`CREATE TABLE IF NOT EXISTS llm_usage (
+			id TEXT PRIMARY KEY,
+			user_id TEXT NOT NULL,
+			session_id TEXT,
+			message_id TEXT,
+			purpose TEXT NOT NULL, -- 'reply', 'title', ...
+			provider TEXT NOT NULL,
+			prompt_tokens INTEGER DEFAULT 0,
+			completion_tokens INTEGER DEFAULT 0,
+			cost_usd REAL DEFAULT 0,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+		)`,
+
+		`CREATE INDEX IF NOT EXISTS idx_llm_usage_user_created ON llm_usage(user_id, created_at)`,
+
+		// Per-user data keys, wrapped by a KMS master key. Shredded keys keep
+		// their row (with wrapped_key cleared) so versions are never reused.
+		`CREATE TABLE IF NOT EXISTS user_data_keys (
+			user_id TEXT NOT NULL,
+			version INTEGER NOT NULL,
+			wrapped_key BLOB,
+			kms_version INTEGER NOT NULL,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			shredded_at DATETIME,
+			PRIMARY KEY (user_id, version),
+			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+		)`,
+
+		// Users who shredded their data keys. Kept after they get a new key so
+		// that anything left from before is never encrypted under it.
+		`CREATE TABLE IF NOT EXISTS shredded_users (
+			user_id TEXT PRIMARY KEY,
+			shredded_at DATETIME NOT NULL,
+			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+		)`,
+
+		`CREATE TABLE IF NOT EXISTS retention_settings (
+			user_id TEXT PRIMARY KEY,
+			chat_retention TEXT DEFAULT 'forever', -- 'forever', '24h', '7d', '30d', 'logout'
+			purge_mood_notes BOOLEAN DEFAULT FALSE,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+		)`,
+
+		// Counts of what each retention purge removed. Never holds content
+		// or user IDs.
+		`CREATE TABLE IF NOT EXISTS retention_audit (
+			id TEXT PRIMARY KEY,
+			reason TEXT NOT NULL, -- 'expired' or 'logout'
+			sessions_purged INTEGER DEFAULT 0,
+			messages_purged INTEGER DEFAULT 0,
+			mood_notes_purged INTEGER DEFAULT 0,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		)`,
+
+		// System prompt versions. A version's body never changes; weight is
+		// its share of A/B traffic among versions with the same name (0 =
+		// retired).
+		`CREATE TABLE IF NOT EXISTS prompt_templates (
+			id TEXT PRIMARY KEY,
+			name TEXT NOT NULL, -- 'nia'
+			version TEXT UNIQUE NOT NULL, -- recorded as promptVersion in message metadata
+			body TEXT NOT NULL, -- Go text/template
+			weight INTEGER DEFAULT 0,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		)`,
+
+		// Replies that failed the output moderation policy. Only the matched
+		// fragment of the reply is kept, for tuning the policy.
+		`CREATE TABLE IF NOT EXISTS moderation_events (
+			id TEXT PRIMARY KEY,
+			session_id TEXT NOT NULL,
+			message_id TEXT NOT NULL, -- the user message being answered
+			category TEXT NOT NULL,
+			matched_text TEXT,
+			action TEXT NOT NULL, -- 'regenerated' or 'replaced'
+			prompt_version TEXT,
+			provider TEXT,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_moderation_events_category ON moderation_events(category, created_at)`,
+
+		// User-defined labels on chat sessions, e.g. "legal" or "work stress"
+		`CREATE TABLE IF NOT EXISTS chat_session_tags (
+			session_id TEXT NOT NULL,
+			tag TEXT NOT NULL, -- lower-cased, whitespace collapsed
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (session_id, tag)
+		)`,
+
+		`CREATE TABLE IF NOT EXISTS crisis_alert_events (
+			id TEXT PRIMARY KEY,
+			alert_id TEXT NOT NULL,
+			user_id TEXT NOT NULL, -- the alert's owner, whose key encrypts note
+			from_status TEXT,
+			to_status TEXT NOT NULL,
+			actor_id TEXT,
+			actor_role TEXT NOT NULL, -- 'user', 'counselor', 'admin' or 'system'
+			note TEXT,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (alert_id) REFERENCES crisis_alerts(id) ON DELETE CASCADE
+		)`,
+
+		`CREATE INDEX IF NOT EXISTS idx_crisis_alert_events_alert ON crisis_alert_events(alert_id, created_at)`,
+
+		`CREATE TABLE IF NOT EXISTS crisis_alert_locations (
+			id TEXT PRIMARY KEY,
+			alert_id TEXT NOT NULL,
+			user_id TEXT NOT NULL, -- the alert's owner, whose key encrypts location
+			location TEXT NOT NULL, -- AlertLocation as JSON
+			recorded_at DATETIME NOT NULL, -- when the device took the fix
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (alert_id) REFERENCES crisis_alerts(id) ON DELETE CASCADE
+		)`,
+
+		`CREATE INDEX IF NOT EXISTS idx_crisis_alert_locations_alert ON crisis_alert_locations(alert_id, recorded_at)`,
+
+		`CREATE TABLE IF NOT EXISTS crisis_alert_responders (
+			alert_id TEXT NOT NULL,
+			type TEXT NOT NULL, -- 'police_gender_desk' or 'hospital'
+			user_id TEXT NOT NULL, -- the alert's owner, whose key encrypts responder
+			responder TEXT NOT NULL, -- AlertResponder as JSON, copied from the directory when attached
+			attached_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (alert_id, type),
+			FOREIGN KEY (alert_id) REFERENCES crisis_alerts(id) ON DELETE CASCADE
+		)`,
+
+		`CREATE TABLE IF NOT EXISTS crisis_alert_escalations (
+			alert_id TEXT NOT NULL,
+			step TEXT NOT NULL, -- action@<minutes>m, unique per alert
+			action TEXT NOT NULL, -- 'notify_primary_contact' or 'page_oncall'
+			status TEXT NOT NULL, -- 'done', 'skipped' or 'failed'
+			attempts INTEGER DEFAULT 0,
+			error TEXT,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			executed_at DATETIME,
+			PRIMARY KEY (alert_id, step),
+			FOREIGN KEY (alert_id) REFERENCES crisis_alerts(id) ON DELETE CASCADE
+		)`,
+
+		`CREATE TABLE IF NOT EXISTS sms_alert_settings (
+			user_id TEXT PRIMARY KEY,
+			recipients TEXT DEFAULT 'off', -- 'off', 'primary', 'all'
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+		)`,
+
+		`CREATE TABLE IF NOT EXISTS sms_messages (
+			id TEXT PRIMARY KEY,
+			user_id TEXT NOT NULL,
+			alert_id TEXT,
+			contact_id TEXT,
+			to_number TEXT NOT NULL,
+			body TEXT NOT NULL,
+			provider TEXT NOT NULL,
+			provider_message_id TEXT,
+			status TEXT NOT NULL, -- 'queued', 'sent', 'delivered', 'failed'
+			attempts INTEGER DEFAULT 0,
+			error TEXT,
+			next_attempt_at DATETIME,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			sent_at DATETIME,
+			delivered_at DATETIME,
+			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+			FOREIGN KEY (alert_id) REFERENCES crisis_alerts(id) ON DELETE CASCADE
+		)`,
+
+		`CREATE INDEX IF NOT EXISTS idx_sms_messages_status ON sms_messages(status)`,
+		`CREATE INDEX IF NOT EXISTS idx_sms_messages_provider_id ON sms_messages(provider, provider_message_id)`,
+		`CREATE INDEX IF NOT EXISTS idx_sms_messages_alert ON sms_messages(alert_id)`,
+
+		`CREATE TABLE IF NOT EXISTS safety_checkins (
+			id TEXT PRIMARY KEY,
+			user_id TEXT NOT NULL,
+			channel TEXT NOT NULL, -- 'push' or 'sms'
+			phone TEXT, -- where SMS check-ins are sent
+			interval_minutes INTEGER NOT NULL,
+			grace_minutes INTEGER NOT NULL,
+			pin_hash TEXT, -- bcrypt; NULL confirms with a tap
+			duress_pin_hash TEXT, -- bcrypt; confirms while raising an alert
+			failed_pin_attempts INTEGER DEFAULT 0,
+			status TEXT NOT NULL DEFAULT 'active', -- 'active', 'ended', 'cancelled'
+			next_due_at DATETIME, -- NULL once no more check-ins are scheduled
+			ends_at DATETIME,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+		)`,
+
+		`CREATE INDEX IF NOT EXISTS idx_safety_checkins_due ON safety_checkins(status, next_due_at)`,
+		`CREATE INDEX IF NOT EXISTS idx_safety_checkins_user ON safety_checkins(user_id)`,
+
+		`CREATE TABLE IF NOT EXISTS safety_checkin_prompts (
+			id TEXT PRIMARY KEY,
+			checkin_id TEXT NOT NULL,
+			user_id TEXT NOT NULL,
+			due_at DATETIME NOT NULL,
+			expires_at DATETIME NOT NULL, -- due_at plus the grace window
+			status TEXT NOT NULL DEFAULT 'pending', -- 'pending', 'confirmed', 'missed', 'cancelled'
+			duress BOOLEAN DEFAULT FALSE,
+			delivery_error TEXT,
+			alert_id TEXT, -- raised when missed or confirmed under duress
+			responded_at DATETIME,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			UNIQUE (checkin_id, due_at),
+			FOREIGN KEY (checkin_id) REFERENCES safety_checkins(id) ON DELETE CASCADE
+		)`,
+
+		`CREATE INDEX IF NOT EXISTS idx_safety_checkin_prompts_pending ON safety_checkin_prompts(status, expires_at)`,
+
+		`CREATE TABLE IF NOT EXISTS support_services (
+			id TEXT PRIMARY KEY,
+			name TEXT NOT NULL,
+			type TEXT NOT NULL, -- 'gbv_recovery_centre', 'police_gender_desk', 'hospital', 'shelter', 'legal_aid'
+			description TEXT,
+			phone TEXT,
+			address TEXT,
+			county TEXT,
+			latitude REAL NOT NULL,
+			longitude REAL NOT NULL,
+			hours TEXT,
+			languages TEXT, -- JSON array of language codes
+			services TEXT, -- JSON array of what is offered, e.g. 'pep', 'counselling'
+			location_hidden BOOLEAN DEFAULT FALSE, -- shelters: never reveal the exact address
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		)`,
+
+		`CREATE INDEX IF NOT EXISTS idx_support_services_location ON support_services(latitude, longitude)`,
+
+		`CREATE TABLE IF NOT EXISTS support_service_hours (
+			service_id TEXT NOT NULL,
+			weekday INTEGER NOT NULL, -- 0 = Sunday
+			opens TEXT NOT NULL, -- 'HH:MM' local time
+			closes TEXT NOT NULL, -- 'HH:MM', at or before opens when open past midnight
+			FOREIGN KEY (service_id) REFERENCES support_services(id) ON DELETE CASCADE
+		)`,
+
+		`CREATE INDEX IF NOT EXISTS idx_support_service_hours_service ON support_service_hours(service_id)`,
+
+		`CREATE TABLE IF NOT EXISTS support_service_exceptions (
+			service_id TEXT NOT NULL,
+			date TEXT NOT NULL, -- 'YYYY-MM-DD' local date
+			closed BOOLEAN DEFAULT FALSE,
+			opens TEXT,
+			closes TEXT,
+			note TEXT,
+			PRIMARY KEY (service_id, date),
+			FOREIGN KEY (service_id) REFERENCES support_services(id) ON DELETE CASCADE
+		)`,
+
 		`CREATE TABLE IF NOT EXISTS resources (
 			id TEXT PRIMARY KEY,
 			title TEXT NOT NULL,
@@ -112,8 +412,8 @@ func createTables(db *sql.DB) error {
 			user_id TEXT NOT NULL,
 			severity TEXT NOT NULL, -- 'low', 'medium', 'high', 'critical'
 			message TEXT,
-			location TEXT, -- JSON with lat/lng
-			status TEXT DEFAULT 'active', -- 'active', 'resolved', 'escalated'
+			location TEXT, -- latest AlertLocation as JSON
+			status TEXT DEFAULT 'active', -- 'active', 'acknowledged', 'escalated', 'resolved'
 			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
 			resolved_at DATETIME,
 			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
@@ -132,6 +432,21 @@ func createTables(db *sql.DB) error {
 			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
 		)`,
 
+		`CREATE TABLE IF NOT EXISTS safety_plan_versions (
+			plan_id TEXT NOT NULL,
+			user_id TEXT NOT NULL,
+			version INTEGER NOT NULL,
+			warning_signs TEXT, -- JSON array
+			coping_strategies TEXT, -- JSON array
+			support_contacts TEXT, -- JSON array
+			professional_contacts TEXT, -- JSON array
+			environment_safety TEXT, -- JSON
+			restored_from INTEGER, -- version this revision restored, if any
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (plan_id, version),
+			FOREIGN KEY (plan_id) REFERENCES safety_plans(id) ON DELETE CASCADE
+		)`,
+
 		`CREATE TABLE IF NOT EXISTS emergency_contacts (
 			id TEXT PRIMARY KEY,
 			user_id TEXT NOT NULL,
@@ -144,20 +459,186 @@ func createTables(db *sql.DB) error {
 		)`,
 	}
 
+	if err := dropPlaintextResponders(db); err != nil {
+		return fmt.Errorf("failed to migrate schema: %w", err)
+	}
 	for _, query := range queries {
 		if _, err := db.Exec(query); err != nil {
 			return fmt.Errorf("failed to execute query: %s, error: %w", query, err)
 		}
 	}
 
+	if err := migrate(db); err != nil {
+		return fmt.Errorf("failed to migrate schema: %w", err)
+	}
+
 	// Insert sample resources including Kenyan crisis contacts
 	if err := insertSampleData(db); err != nil {
 		return fmt.Errorf("failed to insert sample data: %w", err)
 	}
+	if err := insertSupportServices(db); err != nil {
+		return fmt.Errorf("failed to insert support services: %w", err)
+	}
 
 	return nil
 }
 
+// migrate applies additive schema changes to databases created before the
+// columns existed. CREATE TABLE IF NOT EXISTS alone leaves old tables as-is.
+func migrate(db *sql.DB) error {
+	columns := []struct {
+		table      string
+		column     string
+		definition string
+	}{
+		{"users", "role", "TEXT DEFAULT 'user'"}, // 'user', 'counselor', 'staff', 'admin'
+		{"chat_sessions", "title_source", "TEXT DEFAULT 'default'"}, // 'default', 'auto', 'user'
+		{"chat_sessions", "archived", "BOOLEAN DEFAULT FALSE"},
+		{"chat_sessions", "pinned", "BOOLEAN DEFAULT FALSE"},
+		{"chat_sessions", "mode", "TEXT DEFAULT 'gbv'"}, // 'gbv', 'stress', 'legal' or 'youth'
+		{"crisis_alerts", "acknowledged_at", "DATETIME"},
+		{"crisis_alerts", "acknowledged_by", "TEXT"},
+		{"crisis_alerts", "escalated_at", "DATETIME"},
+		{"crisis_alerts", "resolution_notes", "TEXT"},
+		{"crisis_alerts", "duress", "BOOLEAN DEFAULT FALSE"}, // raised by a check-in duress PIN; hidden from the user
+		{"safety_plans", "version", "INTEGER DEFAULT 0"}, // 0 until first saved with history
+		{"emergency_contacts", "verification_status", "TEXT DEFAULT 'unverified'"},
+		{"emergency_contacts", "verification_code", "TEXT"},
+		{"emergency_contacts", "verification_sent_at", "DATETIME"},
+		{"emergency_contacts", "verified_at", "DATETIME"},
+		{"support_services", "open_24_7", "BOOLEAN DEFAULT FALSE"},
+		{"support_services", "timezone", "TEXT DEFAULT 'Africa/Nairobi'"},
+		{"chat_sessions", "active_leaf_id", "TEXT"},
+		{"chat_sessions", "counselor_id", "TEXT"}, // set while a human counselor is attached
+		{"chat_messages", "parent_id", "TEXT"},
+	}
+
+	for _, c := range columns {
+		if err := addColumnIfMissing(db, c.table, c.column, c.definition); err != nil {
+			return err
+		}
+	}
+
+	if _, err := db.Exec(`CREATE INDEX IF NOT EXISTS idx_chat_messages_parent ON chat_messages(session_id, parent_id)`); err != nil {
+		return err
+	}
+
+	return linkLegacyMessages(db)
+}
+
+// linkLegacyMessages turns sessions stored before branching existed into a
+// single chronological branch, so their history keeps rendering.
+func linkLegacyMessages(db *sql.DB) error {
+	rows, err := db.Query(`
+		SELECT DISTINCT s.id FROM chat_sessions s
+		JOIN chat_messages m ON m.session_id = s.id
+		WHERE s.active_leaf_id IS NULL
+	`)
+	if err != nil {
+		return err
+	}
+	var sessionIDs []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		sessionIDs = append(sessionIDs, id)
+	}
+	rows.Close()
+
+	for _, sessionID := range sessionIDs {
+		rows, err := db.Query(`
+			SELECT id FROM chat_messages WHERE session_id = ? ORDER BY created_at ASC, rowid ASC
+		`, sessionID)
+		if err != nil {
+			return err
+		}
+		var messageIDs []string
+		for rows.Next() {
+			var id string
+			if err := rows.Scan(&id); err != nil {
+				rows.Close()
+				return err
+			}
+			messageIDs = append(messageIDs, id)
+		}
+		rows.Close()
+
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		for i := 1; i < len(messageIDs); i++ {
+			if _, err := tx.Exec("UPDATE chat_messages SET parent_id = ? WHERE id = ?", messageIDs[i-1], messageIDs[i]); err != nil {
+				tx.Rollback()
+				return err
+			}
+		}
+		if _, err := tx.Exec("UPDATE chat_sessions SET active_leaf_id = ? WHERE id = ?", messageIDs[len(messageIDs)-1], sessionID); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// dropPlaintextResponders drops crisis_alert_responders as first created,
+// when it stored the nearest services in plaintext, so it is created again
+// encrypted. Open alerts get responders again on their next location.
+func dropPlaintextResponders(db *sql.DB) error {
+	plaintext, err := hasColumn(db, "crisis_alert_responders", "distance_km")
+	if err != nil || !plaintext {
+		return err
+	}
+	_, err = db.Exec("DROP TABLE crisis_alert_responders")
+	return err
+}
+
+func addColumnIfMissing(db *sql.DB, table, column, definition string) error {
+	exists, err := hasColumn(db, table, column)
+	if err != nil || exists {
+		return err
+	}
+	_, err = db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+	if err != nil {
+		return fmt.Errorf("failed to add column %s.%s: %w", table, column, err)
+	}
+	return nil
+}
+
+// hasColumn reports whether table has column. A missing table has none.
+func hasColumn(db *sql.DB, table, column string) (bool, error) {
+	rows, err := db.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			cid        int
+			name       string
+			colType    string
+			notNull    bool
+			defaultVal sql.NullString
+			pk         int
+		)
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &defaultVal, &pk); err != nil {
+			return false, err
+		}
+		if name == column {
+			return true, nil
+		}
+	}
+	return false, rows.Err()
+}
+
 func insertSampleData(db *sql.DB) error {
 	// Check if resources already exist
 	var count int
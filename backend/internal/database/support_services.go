@@ -0,0 +1,144 @@
+package database
+
+import (
+	"database/sql"
+	"strings"
+)
+
+// insertSupportServices seeds the support services directory with major
+// public facilities. Shelters are not seeded: their locations are
+// confidential and are only added by import, with location_hidden set.
+// Deployments should keep the directory current with healctl
+// import-services.
+func insertSupportServices(db *sql.DB) error {
+	var count int
+	if err := db.QueryRow("SELECT COUNT(*) FROM support_services").Scan(&count); err != nil {
+		return err
+	}
+	if count > 0 {
+		return nil
+	}
+
+	services := []struct {
+		id, name, sType, description, phone, address, county string
+		lat, lng                                             float64
+		hours, languages, offered                            string
+		open24x7                                             bool
+		weekly                                               string // "weekday opens closes" rows
+	}{
+		{
+			"ke-gvrc-nairobi-womens-hurlingham", "Gender Violence Recovery Centre, Nairobi Women's Hospital",
+			"gbv_recovery_centre",
+			"Free medical care, counselling and forensic documentation for survivors of sexual and gender-based violence.",
+			"+254 709 660 000", "Argwings Kodhek Road, Hurlingham, Nairobi", "Nairobi",
+			-1.2966, 36.7905, "Open 24 hours", `["en","sw"]`,
+			`["medical","pep","emergency_contraception","counselling","forensic_exam","p3_form"]`,
+			true, "",
+		},
+		{
+			"ke-knh-gbvrc", "Kenyatta National Hospital GBV Recovery Centre", "gbv_recovery_centre",
+			"Medical care, PEP, counselling and forensic examination for survivors at the national referral hospital.",
+			"", "Hospital Road, Upper Hill, Nairobi", "Nairobi",
+			-1.3013, 36.8066, "Open 24 hours", `["en","sw"]`,
+			`["medical","pep","emergency_contraception","counselling","forensic_exam","p3_form"]`,
+			true, "",
+		},
+		{
+			"ke-mtrh-gbvrc", "Moi Teaching and Referral Hospital GBV Recovery Centre", "gbv_recovery_centre",
+			"Medical care, counselling and forensic examination for survivors in the North Rift.",
+			"", "Nandi Road, Eldoret", "Uasin Gishu",
+			0.5167, 35.2795, "Open 24 hours", `["en","sw"]`,
+			`["medical","pep","emergency_contraception","counselling","forensic_exam"]`,
+			true, "",
+		},
+		{
+			"ke-cgtrh-mombasa", "Coast General Teaching and Referral Hospital", "hospital",
+			"Regional referral hospital with emergency care for survivors of violence.",
+			"", "Moi Avenue, Mombasa", "Mombasa",
+			-4.0556, 39.6778, "Emergency department open 24 hours", `["en","sw"]`,
+			`["medical","pep","emergency_contraception","forensic_exam"]`,
+			true, "",
+		},
+		{
+			"ke-jootrh-kisumu", "Jaramogi Oginga Odinga Teaching and Referral Hospital", "hospital",
+			"Regional referral hospital with emergency care for survivors of violence.",
+			"", "Kisumu-Kakamega Road, Kisumu", "Kisumu",
+			-0.0880, 34.7700, "Emergency department open 24 hours", `["en","sw","luo"]`,
+			`["medical","pep","emergency_contraception","forensic_exam"]`,
+			true, "",
+		},
+		{
+			"ke-nakuru-level5", "Nakuru Level 5 Hospital", "hospital",
+			"County referral hospital with emergency care for survivors of violence.",
+			"", "Hospital Road, Nakuru", "Nakuru",
+			-0.2836, 36.0713, "Emergency department open 24 hours", `["en","sw"]`,
+			`["medical","pep","emergency_contraception"]`,
+			true, "",
+		},
+		{
+			"ke-police-central-nairobi", "Central Police Station Gender Desk, Nairobi", "police_gender_desk",
+			"Report violence and get a P3 form. Ask for the gender desk. In an emergency call 999 or 112.",
+			"+254 20 341 4906", "University Way, Nairobi", "Nairobi",
+			-1.2830, 36.8230, "Open 24 hours", `["en","sw"]`,
+			`["reporting","p3_form","protection"]`,
+			true, "",
+		},
+		{
+			"ke-police-kilimani", "Kilimani Police Station Gender Desk", "police_gender_desk",
+			"Report violence and get a P3 form. Ask for the gender desk. In an emergency call 999 or 112.",
+			"", "Argwings Kodhek Road, Kilimani, Nairobi", "Nairobi",
+			-1.2905, 36.7845, "Open 24 hours", `["en","sw"]`,
+			`["reporting","p3_form","protection"]`,
+			true, "",
+		},
+		{
+			"ke-police-central-mombasa", "Central Police Station Gender Desk, Mombasa", "police_gender_desk",
+			"Report violence and get a P3 form. Ask for the gender desk. In an emergency call 999 or 112.",
+			"", "Makadara Road, Mombasa", "Mombasa",
+			-4.0640, 39.6740, "Open 24 hours", `["en","sw"]`,
+			`["reporting","p3_form","protection"]`,
+			true, "",
+		},
+		{
+			"ke-police-central-kisumu", "Kisumu Central Police Station Gender Desk", "police_gender_desk",
+			"Report violence and get a P3 form. Ask for the gender desk. In an emergency call 999 or 112.",
+			"", "Kisumu Central, Kisumu", "Kisumu",
+			-0.1000, 34.7540, "Open 24 hours", `["en","sw","luo"]`,
+			`["reporting","p3_form","protection"]`,
+			true, "",
+		},
+		{
+			"ke-fida-nairobi", "FIDA Kenya", "legal_aid",
+			"Free legal advice and representation for women, including protection orders, custody and maintenance.",
+			"", "Amboseli Road, Lavington, Nairobi", "Nairobi",
+			-1.2775, 36.7700, "Weekdays 8am to 5pm", `["en","sw"]`,
+			`["legal_advice","protection_orders","court_support"]`,
+			false, "1 08:00 17:00,2 08:00 17:00,3 08:00 17:00,4 08:00 17:00,5 08:00 17:00",
+		},
+	}
+
+	for _, s := range services {
+		_, err := db.Exec(`
+			INSERT INTO support_services (id, name, type, description, phone, address, county,
+			                              latitude, longitude, hours, languages, services, open_24_7)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		`, s.id, s.name, s.sType, s.description, s.phone, s.address, s.county,
+			s.lat, s.lng, s.hours, s.languages, s.offered, s.open24x7)
+		if err != nil {
+			return err
+		}
+		for _, period := range strings.Split(s.weekly, ",") {
+			if period == "" {
+				continue
+			}
+			fields := strings.Fields(period)
+			_, err := db.Exec(`
+				INSERT INTO support_service_hours (service_id, weekday, opens, closes) VALUES (?, ?, ?, ?)
+			`, s.id, fields[0], fields[1], fields[2])
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
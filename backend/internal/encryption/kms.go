@@ -0,0 +1,214 @@
+// Package encryption provides the primitives behind field-level envelope
+// encryption: AES-256-GCM sealing and a key-management (KMS) abstraction
+// that wraps per-user data keys with versioned master keys.
+package encryption
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const KeySize = 32 // AES-256
+
+var ErrUnknownKeyVersion = errors.New("unknown master key version")
+
+// KMS wraps and unwraps data keys. Wrap always uses the active master key;
+// Unwrap accepts any version still held, so old keys keep working until
+// every data key has been re-wrapped.
+type KMS interface {
+	ActiveVersion() int
+	Wrap(dataKey []byte) (wrapped []byte, version int, err error)
+	Unwrap(wrapped []byte, version int) ([]byte, error)
+}
+
+// LocalKMS is a stand-in for a cloud KMS that keeps master keys in memory.
+type LocalKMS struct {
+	keys   map[int][]byte
+	active int
+}
+
+func NewLocalKMS(keys map[int][]byte, active int) (*LocalKMS, error) {
+	if _, ok := keys[active]; !ok {
+		return nil, fmt.Errorf("active master key version %d not configured", active)
+	}
+	for v, k := range keys {
+		if len(k) != KeySize {
+			return nil, fmt.Errorf("master key version %d must be %d bytes", v, KeySize)
+		}
+	}
+	return &LocalKMS{keys: keys, active: active}, nil
+}
+
+func (k *LocalKMS) ActiveVersion() int {
+	return k.active
+}
+
+func (k *LocalKMS) Wrap(dataKey []byte) ([]byte, int, error) {
+	wrapped, err := Seal(k.keys[k.active], dataKey, []byte("heal-dek"))
+	if err != nil {
+		return nil, 0, err
+	}
+	return wrapped, k.active, nil
+}
+
+func (k *LocalKMS) Unwrap(wrapped []byte, version int) ([]byte, error) {
+	key, ok := k.keys[version]
+	if !ok {
+		return nil, ErrUnknownKeyVersion
+	}
+	return Open(key, wrapped, []byte("heal-dek"))
+}
+
+// ParseMasterKeys parses "1:<base64>,2:<base64>" into versioned keys. The
+// highest version is returned as the active one.
+func ParseMasterKeys(spec string) (map[int][]byte, int, error) {
+	keys := map[int][]byte{}
+	active := 0
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		versionStr, encoded, ok := strings.Cut(part, ":")
+		if !ok {
+			return nil, 0, fmt.Errorf("master key entry must look like <version>:<base64>")
+		}
+		version, err := strconv.Atoi(versionStr)
+		if err != nil || version < 1 {
+			return nil, 0, fmt.Errorf("invalid master key version %q", versionStr)
+		}
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, 0, fmt.Errorf("master key version %d is not valid base64", version)
+		}
+		keys[version] = key
+		if version > active {
+			active = version
+		}
+	}
+	if len(keys) == 0 {
+		return nil, 0, errors.New("no master keys configured")
+	}
+	return keys, active, nil
+}
+
+type keyFile struct {
+	Active int               `json:"active"`
+	Keys   map[string]string `json:"keys"`
+}
+
+// LoadOrCreateKeyFile reads master keys from a JSON file, creating the file
+// with a fresh random key if it does not exist. It exists for development
+// and single-node deployments without a real KMS.
+func LoadOrCreateKeyFile(path string) (map[int][]byte, int, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		key := make([]byte, KeySize)
+		if _, err := rand.Read(key); err != nil {
+			return nil, 0, err
+		}
+		kf := keyFile{Active: 1, Keys: map[string]string{"1": base64.StdEncoding.EncodeToString(key)}}
+		data, _ = json.MarshalIndent(kf, "", "  ")
+		if err := os.WriteFile(path, data, 0o600); err != nil {
+			return nil, 0, fmt.Errorf("failed to create key file: %w", err)
+		}
+		return map[int][]byte{1: key}, 1, nil
+	}
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to read key file: %w", err)
+	}
+
+	var kf keyFile
+	if err := json.Unmarshal(data, &kf); err != nil {
+		return nil, 0, fmt.Errorf("invalid key file: %w", err)
+	}
+
+	versions := make([]string, 0, len(kf.Keys))
+	for v := range kf.Keys {
+		versions = append(versions, v)
+	}
+	sort.Strings(versions)
+
+	var spec []string
+	for _, v := range versions {
+		spec = append(spec, v+":"+kf.Keys[v])
+	}
+	keys, highest, err := ParseMasterKeys(strings.Join(spec, ","))
+	if err != nil {
+		return nil, 0, err
+	}
+	if kf.Active == 0 {
+		kf.Active = highest
+	}
+	return keys, kf.Active, nil
+}
+
+// NewDataKey returns a random AES-256 key.
+func NewDataKey() ([]byte, error) {
+	key := make([]byte, KeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// Seal encrypts plaintext with AES-GCM. The random nonce is prepended to
+// the ciphertext. additionalData is authenticated but not encrypted.
+func Seal(key, plaintext, additionalData []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+// Open reverses Seal.
+func Open(key, sealed, additionalData []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, errors.New("ciphertext too short")
+	}
+	nonce, ciphertext := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+	return gcm.Open(nil, nonce, ciphertext, additionalData)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// NewKMS builds the local KMS from a master key spec (see ParseMasterKeys),
+// falling back to keyFile when no spec is configured.
+func NewKMS(masterKeys, keyFile string) (KMS, error) {
+	var keys map[int][]byte
+	var active int
+	var err error
+	if masterKeys != "" {
+		keys, active, err = ParseMasterKeys(masterKeys)
+	} else {
+		keys, active, err = LoadOrCreateKeyFile(keyFile)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return NewLocalKMS(keys, active)
+}
@@ -0,0 +1,160 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/heal/internal/models"
+	"github.com/heal/internal/services"
+)
+
+type AdminHandler struct {
+	chatService   *services.ChatService
+	promptService *services.PromptService
+}
+
+func NewAdminHandler(chatService *services.ChatService, promptService *services.PromptService) *AdminHandler {
+	return &AdminHandler{chatService: chatService, promptService: promptService}
+}
+
+func (h *AdminHandler) GetFeedbackAnalytics(c *gin.Context) {
+	now := time.Now()
+	from, err := parseDateParam(c.Query("from"), now.AddDate(0, 0, -30))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid from parameter"})
+		return
+	}
+	to, err := parseDateParam(c.Query("to"), now)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid to parameter"})
+		return
+	}
+	interval := c.DefaultQuery("interval", "day")
+
+	aggregates, err := h.chatService.GetFeedbackAnalytics(from, to, interval)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"from":       from,
+		"to":         to,
+		"interval":   interval,
+		"aggregates": aggregates,
+	})
+}
+
+func (h *AdminHandler) GetPrompts(c *gin.Context) {
+	prompts, err := h.promptService.List()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"prompts": prompts})
+}
+
+// CreatePrompt stores a new prompt version. Existing versions are never
+// edited, so feedback stays attributable to the exact text that was used.
+func (h *AdminHandler) CreatePrompt(c *gin.Context) {
+	var req models.CreatePromptRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	prompt, err := h.promptService.Create(req)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrInvalidPrompt):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		case errors.Is(err, services.ErrPromptVersionExists):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	c.JSON(http.StatusCreated, prompt)
+}
+
+// UpdatePromptWeight changes a version's share of A/B traffic; 0 retires it.
+func (h *AdminHandler) UpdatePromptWeight(c *gin.Context) {
+	var req models.UpdatePromptWeightRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	prompt, err := h.promptService.SetWeight(c.Param("id"), *req.Weight)
+	if errors.Is(err, services.ErrPromptNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, prompt)
+}
+
+// ComparePrompts reports replies and feedback ratings per prompt version.
+func (h *AdminHandler) ComparePrompts(c *gin.Context) {
+	now := time.Now()
+	from, err := parseDateParam(c.Query("from"), now.AddDate(0, 0, -30))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid from parameter"})
+		return
+	}
+	to, err := parseDateParam(c.Query("to"), now)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid to parameter"})
+		return
+	}
+	name := c.DefaultQuery("name", "nia")
+
+	stats, err := h.promptService.CompareVersions(name, from, to)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"name":     name,
+		"from":     from,
+		"to":       to,
+		"versions": stats,
+	})
+}
+
+// GetModerationEvents lists replies that failed output moderation, newest
+// first, optionally filtered by category.
+func (h *AdminHandler) GetModerationEvents(c *gin.Context) {
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	offset, _ := strconv.Atoi(c.DefaultQuery("offset", "0"))
+
+	events, err := h.chatService.GetModerationEvents(c.Query("category"), limit, offset)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"events": events})
+}
+
+// parseDateParam accepts RFC 3339 timestamps or plain YYYY-MM-DD dates.
+func parseDateParam(value string, fallback time.Time) (time.Time, error) {
+	if value == "" {
+		return fallback, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	return time.ParseInLocation("2006-01-02", value, time.Local)
+}
@@ -9,11 +9,12 @@ import (
 )
 
 type AuthHandler struct {
-	authService *services.AuthService
+	authService      *services.AuthService
+	retentionService *services.RetentionService
 }
 
-func NewAuthHandler(authService *services.AuthService) *AuthHandler {
-	return &AuthHandler{authService: authService}
+func NewAuthHandler(authService *services.AuthService, retentionService *services.RetentionService) *AuthHandler {
+	return &AuthHandler{authService: authService, retentionService: retentionService}
 }
 
 func (h *AuthHandler) Register(c *gin.Context) {
@@ -50,6 +51,13 @@ func (h *AuthHandler) Login(c *gin.Context) {
 
 func (h *AuthHandler) Logout(c *gin.Context) {
 	// In a real implementation, you might invalidate the token
+
+	// Users who chose "delete on logout" lose their chats now
+	if _, err := h.retentionService.PurgeOnLogout(c.Request.Context(), c.GetString("user_id")); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete chat history"})
+		return
+	}
+
 	c.JSON(http.StatusOK, gin.H{"message": "Logged out successfully"})
 }
 
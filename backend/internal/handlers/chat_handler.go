@@ -1,8 +1,15 @@
 package handlers
 
 import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/heal/internal/models"
@@ -10,11 +17,71 @@ import (
 )
 
 type ChatHandler struct {
-	chatService *services.ChatService
+	chatService    *services.ChatService
+	handoffService *services.HandoffService
+	quotaService   *services.QuotaService
+	voiceService   *services.VoiceService
+	hub            *services.EventHub
+	maxVoiceBytes  int64
 }
 
-func NewChatHandler(chatService *services.ChatService) *ChatHandler {
-	return &ChatHandler{chatService: chatService}
+func NewChatHandler(chatService *services.ChatService, handoffService *services.HandoffService,
+	quotaService *services.QuotaService, voiceService *services.VoiceService, hub *services.EventHub,
+	maxVoiceBytes int64) *ChatHandler {
+	return &ChatHandler{
+		chatService:    chatService,
+		handoffService: handoffService,
+		quotaService:   quotaService,
+		voiceService:   voiceService,
+		hub:            hub,
+		maxVoiceBytes:  maxVoiceBytes,
+	}
+}
+
+// admitLLMRequest applies the per-user/per-IP rate limits and the daily LLM
+// budget to a request that will call the LLM. Crisis-flagged messages are
+// never rejected: over the limit they get the fixed fallback reply instead
+// (useFallback). For anything else a 429 is written and ok is false.
+func (h *ChatHandler) admitLLMRequest(c *gin.Context, userID, content string) (useFallback, ok bool) {
+	allowed, retryAfter := h.chargeLLMRequest(c, userID)
+	return h.admitCharged(c, allowed, retryAfter, content)
+}
+
+// chargeLLMRequest takes a request from the user's and the client IP's rate
+// limit buckets and checks the daily LLM budget.
+func (h *ChatHandler) chargeLLMRequest(c *gin.Context, userID string) (bool, time.Duration) {
+	allowed, retryAfter := h.quotaService.AllowRequest(userID, c.ClientIP())
+	if allowed {
+		var err error
+		allowed, retryAfter, err = h.quotaService.CheckBudget(userID)
+		if err != nil {
+			log.Printf("Warning: %v", err)
+			allowed = true
+		}
+	}
+	return allowed, retryAfter
+}
+
+// admitCharged is the second half of admitLLMRequest, for callers that
+// charge the request before they know its content.
+func (h *ChatHandler) admitCharged(c *gin.Context, allowed bool, retryAfter time.Duration, content string) (useFallback, ok bool) {
+	if allowed {
+		return false, true
+	}
+	if content != "" && services.RiskAtLeast(services.AssessRisk(content), services.RiskHigh) {
+		return true, true
+	}
+
+	seconds := int(math.Ceil(retryAfter.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	c.Header("Retry-After", strconv.Itoa(seconds))
+	c.JSON(http.StatusTooManyRequests, gin.H{
+		"error":      "Too many requests, please try again later",
+		"retryAfter": seconds,
+	})
+	return false, false
 }
 
 // func (h *ChatHandler) HandleChat(c *gin.Context) {
@@ -45,67 +112,182 @@ func (h *ChatHandler) SendMessage(c *gin.Context) {
 		return
 	}
 
+	useFallback, ok := h.admitLLMRequest(c, userID, req.Content)
+	if !ok {
+		return
+	}
+
 	// Create or get chat session
-	session, err := h.chatService.GetOrCreateSession(userID, req.SessionID)
+	session, err := h.chatService.GetOrCreateSession(userID, req.SessionID, req.Mode)
+	if errors.Is(err, services.ErrInvalidMode) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
 	// Save user message
-	userMessage, err := h.chatService.SaveMessage(session.ID, userID, req.Content, "user", req.MessageType)
+	userMessage, err := h.chatService.SaveMessage(session.ID, userID, req.Content, "user", req.MessageType, nil)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	// Fetch previous messages for context
-	// We fetch with a limit of 10, you can adjust this
-	previousMessages, err := h.chatService.GetChatHistory(userID, session.ID, 10, 0)
+	h.answer(c, userID, session, userMessage, useFallback)
+}
+
+// answer runs a saved survivor message through the rest of the pipeline:
+// handoff check, Nia's reply (or the crisis fallback) and the session title.
+func (h *ChatHandler) answer(c *gin.Context, userID string, session *models.ChatSession, userMessage *models.ChatMessage, useFallback bool) {
+	// Queue for a human counselor if asked for or if the message looks high-risk
+	handoff, err := h.handoffService.MaybeRequestHandoff(userID, session.ID, userMessage.Content)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch chat history: " + err.Error()})
-		return
+		log.Printf("Warning: failed to queue handoff for session %s: %v", session.ID, err)
 	}
 
-	var history []string
-	for _, msg := range previousMessages {
-		history = append(history, msg.Content)
+	// Get and save AI response; Nia stays quiet while a counselor is attached
+	var aiMessage *models.ChatMessage
+	if useFallback && session.CounselorID == "" {
+		aiMessage, err = h.chatService.FallbackReply(c.Request.Context(), userID, userMessage)
+	} else {
+		aiMessage, err = h.chatService.Reply(c.Request.Context(), userID, userMessage)
 	}
-
-	// Get AI response
-	aiResponse, err := h.chatService.GetAIResponse(c.Request.Context(), req.Content, history)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	if errors.Is(err, services.ErrCounselorAttached) {
+		c.JSON(http.StatusOK, gin.H{
+			"session":           session,
+			"userMessage":       userMessage,
+			"aiMessage":         nil,
+			"counselorAttached": true,
+		})
 		return
 	}
-
-	// Save AI message
-	aiMessage, err := h.chatService.SaveMessage(session.ID, userID, aiResponse, "ai", "text")
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
+	h.chatService.GenerateTitleAsync(userID, session.ID)
+
 	c.JSON(http.StatusOK, gin.H{
 		"session":     session,
 		"userMessage": userMessage,
 		"aiMessage":   aiMessage,
-		"response":    aiResponse,
+		"response":    aiMessage.Content,
+		"handoff":     handoff,
 	})
 }
 
+// SendVoiceMessage accepts a multipart voice note ("audio" file, optional
+// "sessionId"), transcribes it and answers the transcript like a text
+// message.
+func (h *ChatHandler) SendVoiceMessage(c *gin.Context) {
+	userID := c.GetString("user_id")
+
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, h.maxVoiceBytes+1<<20)
+	fileHeader, err := c.FormFile("audio")
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) || (err == nil && fileHeader.Size > h.maxVoiceBytes) {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "voice note is too large"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "audio file is required"})
+		return
+	}
+	contentType := fileHeader.Header.Get("Content-Type")
+	if !strings.HasPrefix(contentType, "audio/") {
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": "audio must have an audio/* content type"})
+		return
+	}
+
+	file, err := fileHeader.Open()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	audio, err := io.ReadAll(file)
+	file.Close()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Charge the rate limits before transcribing, which is costly too. Only
+	// whether an over-limit note gets the crisis fallback (instead of a 429,
+	// like a typed one would) waits for the transcript.
+	allowed, retryAfter := h.chargeLLMRequest(c, userID)
+
+	transcript, err := h.voiceService.Transcribe(c.Request.Context(), audio, contentType)
+	if err != nil {
+		log.Printf("Warning: %v", err)
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": services.ErrTranscriptionFailed.Error()})
+		return
+	}
+
+	useFallback, ok := h.admitCharged(c, allowed, retryAfter, transcript.Text)
+	if !ok {
+		return
+	}
+
+	session, err := h.chatService.GetOrCreateSession(userID, c.PostForm("sessionId"), c.PostForm("mode"))
+	if errors.Is(err, services.ErrInvalidMode) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	userMessage, err := h.voiceService.SaveVoiceMessage(c.Request.Context(), userID, session.ID, audio, contentType, transcript)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	h.answer(c, userID, session, userMessage, useFallback)
+}
+
+// GetMessageAudio streams the original recording of a voice message.
+func (h *ChatHandler) GetMessageAudio(c *gin.Context) {
+	userID := c.GetString("user_id")
+
+	audio, contentType, err := h.voiceService.GetAudio(c.Request.Context(), userID, c.Param("id"))
+	switch {
+	case errors.Is(err, services.ErrMessageNotFound), errors.Is(err, services.ErrNoAudio):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, services.ErrKeyShredded):
+		c.JSON(http.StatusGone, gin.H{"error": "this recording has been deleted"})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Header("Cache-Control", "private, no-store")
+	c.Data(http.StatusOK, contentType, audio)
+}
+
 func (h *ChatHandler) GetChatHistory(c *gin.Context) {
 	userID := c.GetString("user_id")
 	sessionID := c.Query("session_id")
 	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "50"))
 	offset, _ := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	withBranches := c.Query("branches") == "true"
 
 	if sessionID == "" {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "session_id is required"})
 		return
 	}
 
-	messages, err := h.chatService.GetChatHistory(userID, sessionID, limit, offset)
+	messages, err := h.chatService.GetChatHistory(userID, sessionID, limit, offset, withBranches)
+	if errors.Is(err, services.ErrSessionNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -114,12 +296,128 @@ func (h *ChatHandler) GetChatHistory(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"messages": messages})
 }
 
+func (h *ChatHandler) RegenerateMessage(c *gin.Context) {
+	userID := c.GetString("user_id")
+
+	if _, ok := h.admitLLMRequest(c, userID, ""); !ok {
+		return
+	}
+
+	aiMessage, err := h.chatService.RegenerateMessage(c.Request.Context(), userID, c.Param("id"))
+	if err != nil {
+		c.JSON(branchErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"aiMessage": aiMessage})
+}
+
+func (h *ChatHandler) EditMessage(c *gin.Context) {
+	userID := c.GetString("user_id")
+
+	var req models.EditMessageRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Edits are always answered by the LLM, so over the limit even a crisis
+	// message is rejected here; the survivor can still send it as a new message.
+	if _, ok := h.admitLLMRequest(c, userID, ""); !ok {
+		return
+	}
+
+	userMessage, aiMessage, err := h.chatService.EditMessage(c.Request.Context(), userID, c.Param("id"), req.Content)
+	if err != nil && !errors.Is(err, services.ErrCounselorAttached) {
+		c.JSON(branchErrorStatus(err), gin.H{"error": err.Error(), "userMessage": userMessage})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"userMessage": userMessage, "aiMessage": aiMessage})
+}
+
+func (h *ChatHandler) ActivateBranch(c *gin.Context) {
+	userID := c.GetString("user_id")
+
+	messages, err := h.chatService.ActivateBranch(userID, c.Param("id"))
+	if err != nil {
+		c.JSON(branchErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"messages": messages})
+}
+
+func branchErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, services.ErrMessageNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, services.ErrCounselorAttached):
+		return http.StatusConflict
+	case errors.Is(err, services.ErrNotEditable), errors.Is(err, services.ErrNotRegenerable),
+		errors.Is(err, services.ErrEmptyMessage):
+		return http.StatusBadRequest
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func (h *ChatHandler) RequestHandoff(c *gin.Context) {
+	userID := c.GetString("user_id")
+
+	request, err := h.handoffService.RequestHandoff(userID, c.Param("id"), "requested", services.RiskNone)
+	if err != nil {
+		c.JSON(handoffErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, request)
+}
+
+// StreamSessionEvents pushes new messages and counselor join/leave notices
+// for one of the user's sessions.
+func (h *ChatHandler) StreamSessionEvents(c *gin.Context) {
+	userID := c.GetString("user_id")
+	sessionID := c.Param("id")
+
+	if _, err := h.chatService.GetSession(userID, sessionID); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	streamSessionEvents(c, h.hub, services.SessionChannel(sessionID))
+}
+
+// GetChatSessions lists the user's sessions. Query parameters:
+// archived=true|all (archived sessions are hidden by default), pinned=true|false
+// and tag=<tag>.
 func (h *ChatHandler) GetChatSessions(c *gin.Context) {
 	userID := c.GetString("user_id")
 	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
 	offset, _ := strconv.Atoi(c.DefaultQuery("offset", "0"))
 
-	sessions, err := h.chatService.GetChatSessions(userID, limit, offset)
+	filter := models.SessionFilter{Tag: c.Query("tag"), Limit: limit, Offset: offset}
+	switch c.Query("archived") {
+	case "", "false":
+		filter.Archived = models.ArchivedExclude
+	case "true":
+		filter.Archived = models.ArchivedOnly
+	case "all":
+		filter.Archived = models.ArchivedInclude
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "archived must be true, false or all"})
+		return
+	}
+	if pinned := c.Query("pinned"); pinned != "" {
+		value, err := strconv.ParseBool(pinned)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "pinned must be true or false"})
+			return
+		}
+		filter.Pinned = &value
+	}
+
+	sessions, err := h.chatService.GetChatSessions(userID, filter)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -128,6 +426,36 @@ func (h *ChatHandler) GetChatSessions(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"sessions": sessions})
 }
 
+func (h *ChatHandler) UpdateChatSession(c *gin.Context) {
+	userID := c.GetString("user_id")
+	sessionID := c.Param("id")
+
+	var req models.UpdateSessionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Title == nil && req.Archived == nil && req.Pinned == nil && req.Tags == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "nothing to update"})
+		return
+	}
+
+	session, err := h.chatService.UpdateSession(userID, sessionID, req)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrSessionNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		case errors.Is(err, services.ErrInvalidTitle), errors.Is(err, services.ErrInvalidTags):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, session)
+}
+
 func (h *ChatHandler) DeleteChatSession(c *gin.Context) {
 	userID := c.GetString("user_id")
 	sessionID := c.Param("id")
@@ -141,6 +469,124 @@ func (h *ChatHandler) DeleteChatSession(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"message": "Session deleted successfully"})
 }
 
+// GetChatModes lists the assistant modes the user can start a session in.
+func (h *ChatHandler) GetChatModes(c *gin.Context) {
+	userID := c.GetString("user_id")
+
+	c.JSON(http.StatusOK, gin.H{"modes": h.chatService.GetChatModes(userID)})
+}
+
+// GetSessionTags lists the tags the user has put on sessions.
+func (h *ChatHandler) GetSessionTags(c *gin.Context) {
+	userID := c.GetString("user_id")
+
+	tags, err := h.chatService.GetSessionTags(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"tags": tags})
+}
+
+// ArchiveSessions archives several sessions at once, or unarchives them with
+// "archived": false.
+func (h *ChatHandler) ArchiveSessions(c *gin.Context) {
+	userID := c.GetString("user_id")
+
+	var req models.BulkArchiveRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	archived := req.Archived == nil || *req.Archived
+
+	updated, err := h.chatService.ArchiveSessions(userID, req.SessionIDs, archived)
+	if err != nil {
+		c.JSON(bulkErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"updated": updated, "archived": archived})
+}
+
+// DeleteSessions deletes several sessions at once.
+func (h *ChatHandler) DeleteSessions(c *gin.Context) {
+	userID := c.GetString("user_id")
+
+	var req models.BulkSessionsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	deleted, err := h.chatService.DeleteSessions(userID, req.SessionIDs)
+	if err != nil {
+		c.JSON(bulkErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"deleted": deleted})
+}
+
+func bulkErrorStatus(err error) int {
+	if errors.Is(err, services.ErrInvalidBulkRequest) {
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
+
+// ExportTranscript downloads the session's active conversation as pdf, md or
+// json. exclude_ai=true leaves out Nia's replies.
+func (h *ChatHandler) ExportTranscript(c *gin.Context) {
+	userID := c.GetString("user_id")
+	format := c.DefaultQuery("format", services.ExportPDF)
+	includeAI := c.Query("exclude_ai") != "true"
+
+	export, err := h.chatService.ExportTranscript(userID, c.Param("id"), includeAI)
+	if errors.Is(err, services.ErrSessionNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	document, contentType, err := services.RenderTranscript(export, format)
+	if errors.Is(err, services.ErrInvalidExportFormat) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	// The file name stays generic: the session title may be sensitive.
+	filename := fmt.Sprintf("transcript-%s.%s", export.ExportedAt.Format("2006-01-02"), format)
+	c.Header("Content-Disposition", `attachment; filename="`+filename+`"`)
+	c.Header("Cache-Control", "private, no-store")
+	c.Header("X-Transcript-SHA256", export.Hash)
+	c.Data(http.StatusOK, contentType, document)
+}
+
+// VerifyTranscript reports whether a transcript hash was exported from this
+// server, and when. It needs no account, so whoever was handed the document
+// can check it.
+func (h *ChatHandler) VerifyTranscript(c *gin.Context) {
+	receipt, err := h.chatService.VerifyTranscript(c.Param("hash"))
+	if errors.Is(err, services.ErrTranscriptNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"receipt": receipt})
+}
+
 func (h *ChatHandler) SubmitFeedback(c *gin.Context) {
 	userID := c.GetString("user_id")
 
@@ -156,11 +602,18 @@ func (h *ChatHandler) SubmitFeedback(c *gin.Context) {
 		return
 	}
 
-	err := h.chatService.SubmitFeedback(userID, req.SessionID, req.MessageID, req.Rating, req.Feedback)
+	feedback, err := h.chatService.SubmitFeedback(userID, req.SessionID, req.MessageID, req.Rating, req.Feedback)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		switch {
+		case errors.Is(err, services.ErrSessionNotFound), errors.Is(err, services.ErrMessageNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		case errors.Is(err, services.ErrFeedbackTarget):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "Feedback submitted successfully"})
+	c.JSON(http.StatusOK, gin.H{"message": "Feedback submitted successfully", "feedback": feedback})
 }
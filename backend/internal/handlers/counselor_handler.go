@@ -0,0 +1,126 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/heal/internal/services"
+)
+
+type CounselorHandler struct {
+	handoffService *services.HandoffService
+	hub            *services.EventHub
+}
+
+func NewCounselorHandler(handoffService *services.HandoffService, hub *services.EventHub) *CounselorHandler {
+	return &CounselorHandler{handoffService: handoffService, hub: hub}
+}
+
+func (h *CounselorHandler) GetQueue(c *gin.Context) {
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	offset, _ := strconv.Atoi(c.DefaultQuery("offset", "0"))
+
+	queue, err := h.handoffService.GetQueue(limit, offset)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"queue": queue})
+}
+
+func (h *CounselorHandler) ClaimHandoff(c *gin.Context) {
+	counselorID := c.GetString("user_id")
+
+	request, err := h.handoffService.Claim(counselorID, c.Param("id"))
+	if err != nil {
+		c.JSON(handoffErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, request)
+}
+
+func (h *CounselorHandler) ReleaseHandoff(c *gin.Context) {
+	counselorID := c.GetString("user_id")
+
+	var req struct {
+		Requeue bool `json:"requeue"`
+	}
+	// The body is optional; an empty body releases the session back to Nia.
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	request, err := h.handoffService.Release(counselorID, c.Param("id"), req.Requeue)
+	if err != nil {
+		c.JSON(handoffErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, request)
+}
+
+func (h *CounselorHandler) GetSessionMessages(c *gin.Context) {
+	counselorID := c.GetString("user_id")
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "100"))
+	offset, _ := strconv.Atoi(c.DefaultQuery("offset", "0"))
+
+	messages, err := h.handoffService.GetSessionHistory(counselorID, c.Param("id"), limit, offset)
+	if err != nil {
+		c.JSON(handoffErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"messages": messages})
+}
+
+func (h *CounselorHandler) SendMessage(c *gin.Context) {
+	counselorID := c.GetString("user_id")
+
+	var req struct {
+		Content string `json:"content" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	message, err := h.handoffService.SendCounselorMessage(counselorID, c.Param("id"), req.Content)
+	if err != nil {
+		c.JSON(handoffErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, message)
+}
+
+func (h *CounselorHandler) StreamSessionEvents(c *gin.Context) {
+	counselorID := c.GetString("user_id")
+	sessionID := c.Param("id")
+
+	if !h.handoffService.CanWatch(counselorID, sessionID) {
+		c.JSON(http.StatusForbidden, gin.H{"error": services.ErrNotYourHandoff.Error()})
+		return
+	}
+
+	streamSessionEvents(c, h.hub, services.SessionChannel(sessionID))
+}
+
+func handoffErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, services.ErrHandoffNotFound), errors.Is(err, services.ErrSessionNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, services.ErrHandoffClaimed):
+		return http.StatusConflict
+	case errors.Is(err, services.ErrNotYourHandoff):
+		return http.StatusForbidden
+	default:
+		return http.StatusInternalServerError
+	}
+}
@@ -1,44 +1,327 @@
 package handlers
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
 	"net/http"
 	"strconv"
+	"strings"
 
 	"github.com/gin-gonic/gin"
+	"github.com/heal/internal/models"
 	"github.com/heal/internal/services"
 )
 
 type CrisisHandler struct {
-	crisisService *services.CrisisService
+	crisisService     *services.CrisisService
+	escalationService *services.EscalationService
+	smsDispatcher     *services.SMSDispatcher
+	directoryService  *services.DirectoryService
+	checkinService    *services.CheckinService
+	hub               *services.EventHub
 }
 
-func NewCrisisHandler(crisisService *services.CrisisService) *CrisisHandler {
-	return &CrisisHandler{crisisService: crisisService}
+func NewCrisisHandler(crisisService *services.CrisisService, escalationService *services.EscalationService,
+	smsDispatcher *services.SMSDispatcher, directoryService *services.DirectoryService,
+	checkinService *services.CheckinService, hub *services.EventHub) *CrisisHandler {
+	return &CrisisHandler{
+		crisisService:     crisisService,
+		escalationService: escalationService,
+		smsDispatcher:     smsDispatcher,
+		directoryService:  directoryService,
+		checkinService:    checkinService,
+		hub:               hub,
+	}
 }
 
 func (h *CrisisHandler) CreateCrisisAlert(c *gin.Context) {
 	userID := c.GetString("user_id")
 
 	var req struct {
-		Severity string `json:"severity" binding:"required"`
-		Message  string `json:"message"`
-		Location string `json:"location"`
+		Severity string          `json:"severity" binding:"required"`
+		Message  string          `json:"message"`
+		Location json.RawMessage `json:"location"`
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	location, locationText := parseAlertLocation(req.Location)
 
-	alert, err := h.crisisService.CreateCrisisAlert(userID, req.Severity, req.Message, req.Location)
+	alert, err := h.crisisService.CreateCrisisAlert(userID, req.Severity, req.Message, location, locationText)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.JSON(alertErrorStatus(err), gin.H{"error": err.Error()})
 		return
 	}
 
 	c.JSON(http.StatusCreated, alert)
 }
 
+// parseAlertLocation reads an alert's location, given as an object or, as
+// older clients send it, as a string of JSON or free text. It is optional,
+// and a location that cannot be read is dropped rather than stopping the
+// alert.
+func parseAlertLocation(raw json.RawMessage) (*models.AlertLocation, string) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil, ""
+	}
+	var encoded string
+	if json.Unmarshal(raw, &encoded) == nil {
+		encoded = strings.TrimSpace(encoded)
+		if !strings.HasPrefix(encoded, "{") {
+			return nil, encoded
+		}
+		raw = json.RawMessage(encoded)
+	}
+	var location models.AlertLocation
+	if err := json.Unmarshal(raw, &location); err != nil {
+		log.Printf("Warning: dropping unreadable alert location: %v", err)
+		return nil, ""
+	}
+	return &location, ""
+}
+
+// UpdateAlertLocation adds a location fix to one of the user's open
+// alerts, building up a trail responders can follow.
+func (h *CrisisHandler) UpdateAlertLocation(c *gin.Context) {
+	userID := c.GetString("user_id")
+
+	var req models.AlertLocation
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	alert, err := h.crisisService.UpdateAlertLocation(userID, c.Param("id"), req)
+	if err != nil {
+		c.JSON(alertErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, alert)
+}
+
+// GetCrisisAlerts lists the user's own alerts, optionally by status.
+func (h *CrisisHandler) GetCrisisAlerts(c *gin.Context) {
+	userID := c.GetString("user_id")
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	offset, _ := strconv.Atoi(c.DefaultQuery("offset", "0"))
+
+	alerts, err := h.crisisService.GetCrisisAlerts(userID, c.Query("status"), limit, offset)
+	if err != nil {
+		c.JSON(alertErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"alerts": alerts})
+}
+
+// GetCrisisAlert returns one of the user's alerts with its history.
+func (h *CrisisHandler) GetCrisisAlert(c *gin.Context) {
+	userID := c.GetString("user_id")
+
+	alert, err := h.crisisService.GetCrisisAlert(userID, c.Param("id"))
+	if err != nil {
+		c.JSON(alertErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, alert)
+}
+
+// ListAlerts is the staff queue of crisis alerts. Without a status it lists
+// the alerts that are not resolved yet.
+func (h *CrisisHandler) ListAlerts(c *gin.Context) {
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	offset, _ := strconv.Atoi(c.DefaultQuery("offset", "0"))
+
+	alerts, err := h.crisisService.ListAlerts(c.Query("status"), limit, offset)
+	if err != nil {
+		c.JSON(alertErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"alerts": alerts})
+}
+
+func (h *CrisisHandler) GetAlert(c *gin.Context) {
+	alert, err := h.crisisService.GetAlert(c.Param("id"))
+	if err != nil {
+		c.JSON(alertErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, alert)
+}
+
+func (h *CrisisHandler) AcknowledgeAlert(c *gin.Context) {
+	var req struct {
+		Note string `json:"note"`
+	}
+	// The body is optional
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	staff := c.MustGet("user").(*models.User)
+	alert, err := h.crisisService.AcknowledgeAlert(staff.ID, staff.Role, c.Param("id"), req.Note)
+	if err != nil {
+		c.JSON(alertErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, alert)
+}
+
+func (h *CrisisHandler) EscalateAlert(c *gin.Context) {
+	var req struct {
+		Reason string `json:"reason" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	staff := c.MustGet("user").(*models.User)
+	alert, err := h.crisisService.EscalateAlert(staff.ID, staff.Role, c.Param("id"), req.Reason)
+	if err != nil {
+		c.JSON(alertErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, alert)
+}
+
+func (h *CrisisHandler) ResolveAlert(c *gin.Context) {
+	var req struct {
+		Notes string `json:"notes" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	staff := c.MustGet("user").(*models.User)
+	alert, err := h.crisisService.ResolveAlert(staff.ID, staff.Role, c.Param("id"), req.Notes)
+	if err != nil {
+		c.JSON(alertErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, alert)
+}
+
+// GetEscalations lists the escalation steps run for an alert.
+func (h *CrisisHandler) GetEscalations(c *gin.Context) {
+	if _, err := h.crisisService.GetAlert(c.Param("id")); err != nil {
+		c.JSON(alertErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	escalations, err := h.escalationService.GetEscalations(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"escalations": escalations})
+}
+
+// GetCrisisAlertNotifications lists the texts sent to the user's contacts
+// about one of their alerts, with their delivery status.
+func (h *CrisisHandler) GetCrisisAlertNotifications(c *gin.Context) {
+	userID := c.GetString("user_id")
+
+	if _, err := h.crisisService.GetCrisisAlert(userID, c.Param("id")); err != nil {
+		c.JSON(alertErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	h.alertNotifications(c)
+}
+
+// GetAlertNotifications is the staff view of the texts sent about an alert.
+func (h *CrisisHandler) GetAlertNotifications(c *gin.Context) {
+	if _, err := h.crisisService.GetAlert(c.Param("id")); err != nil {
+		c.JSON(alertErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	h.alertNotifications(c)
+}
+
+func (h *CrisisHandler) alertNotifications(c *gin.Context) {
+	messages, err := h.smsDispatcher.GetAlertMessages(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"notifications": messages})
+}
+
+func (h *CrisisHandler) GetSMSAlertSettings(c *gin.Context) {
+	userID := c.GetString("user_id")
+
+	settings, err := h.smsDispatcher.GetSettings(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, settings)
+}
+
+// UpdateSMSAlertSettings opts the user in to texting their primary or all
+// emergency contacts when they raise an alert, or out with "off".
+func (h *CrisisHandler) UpdateSMSAlertSettings(c *gin.Context) {
+	userID := c.GetString("user_id")
+
+	var req struct {
+		Recipients string `json:"recipients" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	settings, err := h.smsDispatcher.UpdateSettings(userID, req.Recipients)
+	if errors.Is(err, services.ErrInvalidSMSRecipients) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, settings)
+}
+
+// StreamOnCallPages streams pages for alerts nobody has acknowledged to the
+// on-call counselor group.
+func (h *CrisisHandler) StreamOnCallPages(c *gin.Context) {
+	streamSessionEvents(c, h.hub, services.OnCallChannel)
+}
+
+func alertErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, services.ErrAlertNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, services.ErrInvalidAlertStatus), errors.Is(err, services.ErrInvalidAlertLocation):
+		return http.StatusBadRequest
+	case errors.Is(err, services.ErrInvalidAlertTransition), errors.Is(err, services.ErrAlertResolved):
+		return http.StatusConflict
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
 func (h *CrisisHandler) GetEmergencyContacts(c *gin.Context) {
 	userID := c.GetString("user_id")
 
@@ -68,13 +351,94 @@ func (h *CrisisHandler) AddEmergencyContact(c *gin.Context) {
 
 	contact, err := h.crisisService.AddEmergencyContact(userID, req.Name, req.Phone, req.Relationship, req.IsPrimary)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.JSON(contactErrorStatus(err), gin.H{"error": err.Error()})
 		return
 	}
 
 	c.JSON(http.StatusCreated, contact)
 }
 
+// UpdateEmergencyContact changes a contact's name, phone and relationship.
+func (h *CrisisHandler) UpdateEmergencyContact(c *gin.Context) {
+	userID := c.GetString("user_id")
+
+	var req struct {
+		Name         string `json:"name" binding:"required"`
+		Phone        string `json:"phone" binding:"required"`
+		Relationship string `json:"relationship"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	contact, err := h.crisisService.UpdateEmergencyContact(userID, c.Param("id"), req.Name, req.Phone, req.Relationship)
+	if err != nil {
+		c.JSON(contactErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, contact)
+}
+
+func (h *CrisisHandler) DeleteEmergencyContact(c *gin.Context) {
+	userID := c.GetString("user_id")
+
+	if err := h.crisisService.DeleteEmergencyContact(userID, c.Param("id")); err != nil {
+		c.JSON(contactErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+func (h *CrisisHandler) SetPrimaryContact(c *gin.Context) {
+	userID := c.GetString("user_id")
+
+	contact, err := h.crisisService.SetPrimaryContact(userID, c.Param("id"))
+	if err != nil {
+		c.JSON(contactErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, contact)
+}
+
+// VerifyEmergencyContact texts the contact to ask whether they agree to be
+// an emergency contact. Their reply updates verificationStatus.
+func (h *CrisisHandler) VerifyEmergencyContact(c *gin.Context) {
+	userID := c.GetString("user_id")
+
+	contact, err := h.crisisService.RequestContactVerification(c.Request.Context(), userID, c.Param("id"))
+	if err != nil {
+		c.JSON(contactErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, contact)
+}
+
+func contactErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, services.ErrInvalidContact), errors.Is(err, services.ErrInvalidPhone):
+		return http.StatusBadRequest
+	case errors.Is(err, services.ErrContactNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, services.ErrContactVerified):
+		return http.StatusConflict
+	case errors.Is(err, services.ErrVerificationTooSoon):
+		return http.StatusTooManyRequests
+	case errors.Is(err, services.ErrVerificationNotEnabled):
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// GetLocalServices lists support services near lat/lng, nearest first.
+// radius is in kilometres; type takes a comma-separated list of service
+// types, and open_now=true keeps only services open at the moment.
 func (h *CrisisHandler) GetLocalServices(c *gin.Context) {
 	latStr := c.Query("lat")
 	lngStr := c.Query("lng")
@@ -96,19 +460,60 @@ func (h *CrisisHandler) GetLocalServices(c *gin.Context) {
 		return
 	}
 
-	services, err := h.crisisService.GetLocalServices(lat, lng)
+	radius, err := strconv.ParseFloat(c.DefaultQuery("radius", "0"), 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid radius parameter"})
+		return
+	}
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	openNow, _ := strconv.ParseBool(c.DefaultQuery("open_now", "false"))
+
+	var types []string
+	for _, t := range strings.Split(c.Query("type"), ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			types = append(types, t)
+		}
+	}
+
+	results, err := h.directoryService.Search(services.ServiceSearch{
+		Latitude:  lat,
+		Longitude: lng,
+		RadiusKm:  radius,
+		Types:     types,
+		OpenNow:   openNow,
+		Limit:     limit,
+	})
+	if errors.Is(err, services.ErrInvalidLocation) || errors.Is(err, services.ErrInvalidServiceType) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"services": results})
+}
+
+func (h *CrisisHandler) GetLocalService(c *gin.Context) {
+	service, err := h.directoryService.GetService(c.Param("id"))
+	if errors.Is(err, services.ErrServiceNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"services": services})
+	c.JSON(http.StatusOK, service)
 }
 
+// CreateSafetyPlan saves the user's safety plan as a new version.
 func (h *CrisisHandler) CreateSafetyPlan(c *gin.Context) {
 	userID := c.GetString("user_id")
 
-	var req map[string]interface{}
+	var req models.SafetyPlanContent
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
@@ -116,7 +521,7 @@ func (h *CrisisHandler) CreateSafetyPlan(c *gin.Context) {
 
 	plan, err := h.crisisService.CreateSafetyPlan(userID, req)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.JSON(safetyPlanErrorStatus(err), gin.H{"error": err.Error()})
 		return
 	}
 
@@ -128,9 +533,237 @@ func (h *CrisisHandler) GetSafetyPlan(c *gin.Context) {
 
 	plan, err := h.crisisService.GetSafetyPlan(userID)
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Safety plan not found"})
+		c.JSON(safetyPlanErrorStatus(err), gin.H{"error": err.Error()})
 		return
 	}
 
 	c.JSON(http.StatusOK, plan)
-}
\ No newline at end of file
+}
+
+// GetSafetyPlanVersions lists every saved version of the user's safety
+// plan, newest first.
+func (h *CrisisHandler) GetSafetyPlanVersions(c *gin.Context) {
+	userID := c.GetString("user_id")
+
+	versions, err := h.crisisService.GetSafetyPlanVersions(userID)
+	if err != nil {
+		c.JSON(safetyPlanErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"versions": versions})
+}
+
+func (h *CrisisHandler) GetSafetyPlanVersion(c *gin.Context) {
+	userID := c.GetString("user_id")
+	version, err := strconv.Atoi(c.Param("version"))
+	if err != nil || version < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid version"})
+		return
+	}
+
+	v, err := h.crisisService.GetSafetyPlanVersion(userID, version)
+	if err != nil {
+		c.JSON(safetyPlanErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, v)
+}
+
+// DiffSafetyPlan compares two versions of the user's safety plan. to
+// defaults to the latest version and from to the one before it.
+func (h *CrisisHandler) DiffSafetyPlan(c *gin.Context) {
+	userID := c.GetString("user_id")
+	from, err1 := strconv.Atoi(c.DefaultQuery("from", "0"))
+	to, err2 := strconv.Atoi(c.DefaultQuery("to", "0"))
+	if err1 != nil || err2 != nil || from < 0 || to < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "from and to must be version numbers"})
+		return
+	}
+
+	diff, err := h.crisisService.DiffSafetyPlanVersions(userID, from, to)
+	if err != nil {
+		c.JSON(safetyPlanErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, diff)
+}
+
+// RestoreSafetyPlanVersion makes an earlier version the current plan, as a
+// new version.
+func (h *CrisisHandler) RestoreSafetyPlanVersion(c *gin.Context) {
+	userID := c.GetString("user_id")
+	version, err := strconv.Atoi(c.Param("version"))
+	if err != nil || version < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid version"})
+		return
+	}
+
+	plan, err := h.crisisService.RestoreSafetyPlanVersion(userID, version)
+	if err != nil {
+		c.JSON(safetyPlanErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, plan)
+}
+
+// ExportSafetyPlan downloads the user's safety plan for use offline:
+// format=pdf is a printable page, txt is short enough to send by SMS and
+// vcf is a vCard bundle of the plan's contacts.
+func (h *CrisisHandler) ExportSafetyPlan(c *gin.Context) {
+	userID := c.GetString("user_id")
+	format := c.DefaultQuery("format", services.ExportPDF)
+
+	plan, err := h.crisisService.GetSafetyPlan(userID)
+	if err != nil {
+		c.JSON(safetyPlanErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	document, contentType, err := services.RenderSafetyPlan(plan, format)
+	if errors.Is(err, services.ErrInvalidPlanExportFormat) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if errors.Is(err, services.ErrNoPlanContacts) {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	// A generic file name, in case someone else sees the download
+	filename := fmt.Sprintf("plan-%s.%s", plan.UpdatedAt.Format("2006-01-02"), format)
+	c.Header("Content-Disposition", `attachment; filename="`+filename+`"`)
+	c.Header("Cache-Control", "private, no-store")
+	if format == services.ExportText {
+		c.Header("X-SMS-Segments", strconv.Itoa(services.SMSSegments(string(document))))
+	}
+	c.Data(http.StatusOK, contentType, document)
+}
+
+func safetyPlanErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, services.ErrInvalidSafetyPlan):
+		return http.StatusBadRequest
+	case errors.Is(err, services.ErrSafetyPlanNotFound), errors.Is(err, services.ErrSafetyPlanVersionNotFound):
+		return http.StatusNotFound
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// CreateCheckin schedules safety check-ins. A check-in that is not
+// confirmed within its grace window raises an alert and texts the user's
+// primary emergency contact. Push check-ins reach the app only while it is
+// open; otherwise they are texted to the user's phone if there is one.
+func (h *CrisisHandler) CreateCheckin(c *gin.Context) {
+	userID := c.GetString("user_id")
+
+	var req models.CreateCheckinRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	checkin, err := h.checkinService.CreateCheckin(userID, req)
+	if err != nil {
+		c.JSON(checkinErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, checkin)
+}
+
+func (h *CrisisHandler) GetCheckins(c *gin.Context) {
+	userID := c.GetString("user_id")
+
+	checkins, err := h.checkinService.GetCheckins(userID)
+	if err != nil {
+		c.JSON(checkinErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"checkins": checkins})
+}
+
+func (h *CrisisHandler) GetCheckin(c *gin.Context) {
+	userID := c.GetString("user_id")
+
+	checkin, err := h.checkinService.GetCheckin(userID, c.Param("id"))
+	if err != nil {
+		c.JSON(checkinErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, checkin)
+}
+
+type checkinPINRequest struct {
+	PIN string `json:"pin"`
+}
+
+// ConfirmCheckin records that the user is safe. Schedules with a PIN need
+// it to be confirmed.
+func (h *CrisisHandler) ConfirmCheckin(c *gin.Context) {
+	userID := c.GetString("user_id")
+
+	var req checkinPINRequest
+	if err := c.ShouldBindJSON(&req); err != nil && err != io.EOF {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	checkin, err := h.checkinService.Confirm(c.Request.Context(), userID, c.Param("id"), req.PIN)
+	if err != nil {
+		c.JSON(checkinErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, checkin)
+}
+
+// CancelCheckin stops a check-in schedule.
+func (h *CrisisHandler) CancelCheckin(c *gin.Context) {
+	userID := c.GetString("user_id")
+
+	var req checkinPINRequest
+	if err := c.ShouldBindJSON(&req); err != nil && err != io.EOF {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	checkin, err := h.checkinService.Cancel(c.Request.Context(), userID, c.Param("id"), req.PIN)
+	if err != nil {
+		c.JSON(checkinErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, checkin)
+}
+
+// StreamNotifications streams the user's in-app notifications, such as
+// check-ins that have fallen due.
+func (h *CrisisHandler) StreamNotifications(c *gin.Context) {
+	streamSessionEvents(c, h.hub, services.UserChannel(c.GetString("user_id")))
+}
+
+func checkinErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, services.ErrInvalidCheckin):
+		return http.StatusBadRequest
+	case errors.Is(err, services.ErrCheckinNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, services.ErrCheckinEnded):
+		return http.StatusConflict
+	case errors.Is(err, services.ErrWrongPIN):
+		return http.StatusForbidden
+	default:
+		return http.StatusInternalServerError
+	}
+}
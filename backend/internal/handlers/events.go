@@ -0,0 +1,40 @@
+package handlers
+
+import (
+	"io"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/heal/internal/services"
+)
+
+const eventKeepAlive = 25 * time.Second
+
+// streamSessionEvents sends the events of an EventHub channel to the client
+// as Server-Sent Events until the client disconnects.
+func streamSessionEvents(c *gin.Context, hub *services.EventHub, channel string) {
+	events, unsubscribe := hub.Subscribe(channel)
+	defer unsubscribe()
+
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+
+	ticker := time.NewTicker(eventKeepAlive)
+	defer ticker.Stop()
+
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				return false
+			}
+			c.SSEvent(event.Type, event.Data)
+			return true
+		case <-ticker.C:
+			c.SSEvent("ping", time.Now().Unix())
+			return true
+		case <-c.Request.Context().Done():
+			return false
+		}
+	})
+}
@@ -23,7 +23,17 @@ func (h *ResourceHandler) GetResources(c *gin.Context) {
 	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
 	offset, _ := strconv.Atoi(c.DefaultQuery("offset", "0"))
 
-	resources, err := h.resourceService.GetResources(category, resourceType, difficulty, limit, offset)
+	var categories []string
+	if mode := c.Query("mode"); mode != "" {
+		var err error
+		categories, err = services.ChatModeResourceCategories(mode)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	resources, err := h.resourceService.GetResources(category, resourceType, difficulty, categories, limit, offset)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
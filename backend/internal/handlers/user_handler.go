@@ -1,19 +1,22 @@
 package handlers
 
 import (
+	"errors"
 	"net/http"
 	"strconv"
 
 	"github.com/gin-gonic/gin"
+	"github.com/heal/internal/models"
 	"github.com/heal/internal/services"
 )
 
 type UserHandler struct {
-	userService *services.UserService
+	userService      *services.UserService
+	retentionService *services.RetentionService
 }
 
-func NewUserHandler(userService *services.UserService) *UserHandler {
-	return &UserHandler{userService: userService}
+func NewUserHandler(userService *services.UserService, retentionService *services.RetentionService) *UserHandler {
+	return &UserHandler{userService: userService, retentionService: retentionService}
 }
 
 func (h *UserHandler) GetProfile(c *gin.Context) {
@@ -91,4 +94,59 @@ func (h *UserHandler) GetMoodHistory(c *gin.Context) {
 	}
 
 	c.JSON(http.StatusOK, gin.H{"logs": logs})
-}
\ No newline at end of file
+}
+
+func (h *UserHandler) GetRetentionSettings(c *gin.Context) {
+	userID := c.GetString("user_id")
+
+	settings, err := h.retentionService.GetSettings(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, settings)
+}
+
+func (h *UserHandler) UpdateRetentionSettings(c *gin.Context) {
+	userID := c.GetString("user_id")
+
+	var req models.UpdateRetentionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	settings, err := h.retentionService.UpdateSettings(userID, req)
+	if errors.Is(err, services.ErrInvalidRetention) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, settings)
+}
+
+// ShredData destroys the user's data encryption keys. Everything encrypted
+// with them is unrecoverable afterwards, so the client must confirm.
+func (h *UserHandler) ShredData(c *gin.Context) {
+	userID := c.GetString("user_id")
+
+	var req struct {
+		Confirm bool `json:"confirm"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || !req.Confirm {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Set confirm to true to permanently delete your encrypted data"})
+		return
+	}
+
+	if err := h.userService.ShredData(userID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Your data key has been deleted; your messages, notes and safety plan can no longer be read, and session titles, tags and feedback comments have been cleared"})
+}
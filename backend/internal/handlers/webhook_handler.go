@@ -0,0 +1,94 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/heal/internal/services"
+)
+
+// WebhookHandler receives callbacks from third-party providers. They cannot
+// log in, so each callback URL carries a shared token instead.
+type WebhookHandler struct {
+	smsDispatcher *services.SMSDispatcher
+	crisisService *services.CrisisService
+	token         string
+}
+
+func NewWebhookHandler(smsDispatcher *services.SMSDispatcher, crisisService *services.CrisisService, token string) *WebhookHandler {
+	return &WebhookHandler{smsDispatcher: smsDispatcher, crisisService: crisisService, token: token}
+}
+
+func (h *WebhookHandler) authorized(c *gin.Context) bool {
+	if h.token == "" || subtle.ConstantTimeCompare([]byte(c.Query("token")), []byte(h.token)) != 1 {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid webhook token"})
+		return false
+	}
+	return true
+}
+
+// SMSDeliveryReport takes a delivery report from Africa's Talking or a
+// Twilio status callback. Configure the callback URL as
+// /api/v1/webhooks/sms/<provider>?token=<SMS_WEBHOOK_TOKEN>.
+func (h *WebhookHandler) SMSDeliveryReport(c *gin.Context) {
+	if !h.authorized(c) {
+		return
+	}
+
+	provider := c.Param("provider")
+	var messageID, status, reason string
+	switch provider {
+	case "africastalking":
+		messageID, status, reason = c.PostForm("id"), c.PostForm("status"), c.PostForm("failureReason")
+	case "twilio":
+		messageID, status, reason = c.PostForm("MessageSid"), c.PostForm("MessageStatus"), c.PostForm("ErrorCode")
+	default:
+		c.JSON(http.StatusNotFound, gin.H{"error": services.ErrUnknownSMSProvider.Error()})
+		return
+	}
+	if messageID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "delivery report has no message ID"})
+		return
+	}
+
+	if err := h.smsDispatcher.UpdateDeliveryStatus(provider, messageID, status, reason); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// SMSInbound takes an incoming SMS from Africa's Talking or Twilio, such as
+// an emergency contact replying YES or NO to a verification request.
+// Configure it as /api/v1/webhooks/sms/<provider>/inbound?token=<SMS_WEBHOOK_TOKEN>.
+func (h *WebhookHandler) SMSInbound(c *gin.Context) {
+	if !h.authorized(c) {
+		return
+	}
+
+	var from, text string
+	switch c.Param("provider") {
+	case "africastalking":
+		from, text = c.PostForm("from"), c.PostForm("text")
+	case "twilio":
+		from, text = c.PostForm("From"), c.PostForm("Body")
+	default:
+		c.JSON(http.StatusNotFound, gin.H{"error": services.ErrUnknownSMSProvider.Error()})
+		return
+	}
+	if from == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "incoming message has no sender"})
+		return
+	}
+
+	// Messages that answer nothing are acknowledged all the same, so the
+	// provider does not retry them
+	if _, err := h.crisisService.HandleContactReply(from, text); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
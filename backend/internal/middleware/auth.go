@@ -5,6 +5,7 @@ import (
 	"strings"
 
 	"github.com/gin-gonic/gin"
+	"github.com/heal/internal/models"
 	"github.com/heal/internal/services"
 )
 
@@ -38,4 +39,28 @@ func AuthRequired(authService *services.AuthService) gin.HandlerFunc {
 		c.Set("user", user)
 		c.Next()
 	}
-}
\ No newline at end of file
+}
+
+// RequireRole must run after AuthRequired. It rejects users whose role is
+// not one of the given roles.
+func RequireRole(roles ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, ok := c.Get("user")
+		if !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
+			c.Abort()
+			return
+		}
+
+		role := user.(*models.User).Role
+		for _, allowed := range roles {
+			if role == allowed {
+				c.Next()
+				return
+			}
+		}
+
+		c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient permissions"})
+		c.Abort()
+	}
+}
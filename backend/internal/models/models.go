@@ -11,6 +11,7 @@ type User struct {
 	FirstName     string    `json:"firstName" db:"first_name"`
 	LastName      string    `json:"lastName" db:"last_name"`
 	EmailVerified bool      `json:"emailVerified" db:"email_verified"`
+	Role          string    `json:"role" db:"role"`
 	CreatedAt     time.Time `json:"createdAt" db:"created_at"`
 	UpdatedAt     time.Time `json:"updatedAt" db:"updated_at"`
 }
@@ -26,22 +27,171 @@ type UserProfile struct {
 }
 
 type ChatSession struct {
-	ID        string    `json:"id" db:"id"`
-	UserID    string    `json:"userId" db:"user_id"`
-	Title     string    `json:"title" db:"title"`
-	CreatedAt time.Time `json:"createdAt" db:"created_at"`
-	UpdatedAt time.Time `json:"updatedAt" db:"updated_at"`
+	ID          string    `json:"id" db:"id"`
+	UserID      string    `json:"userId" db:"user_id"`
+	Title       string    `json:"title" db:"title"`
+	TitleSource string    `json:"titleSource" db:"title_source"` // 'default', 'auto' or 'user'
+	Archived    bool      `json:"archived" db:"archived"`
+	Pinned      bool      `json:"pinned" db:"pinned"`
+	Mode        string    `json:"mode" db:"mode"` // 'gbv', 'stress', 'legal' or 'youth'
+	Tags        []string  `json:"tags" db:"-"`
+	CounselorID string    `json:"counselorId,omitempty" db:"counselor_id"`
+	CreatedAt   time.Time `json:"createdAt" db:"created_at"`
+	UpdatedAt   time.Time `json:"updatedAt" db:"updated_at"`
+}
+
+// ChatMode is an assistant mode a session can be created in.
+type ChatMode struct {
+	ID                 string   `json:"id"`
+	Name               string   `json:"name"`
+	Description        string   `json:"description"`
+	Topics             []string `json:"topics"`
+	ResourceCategories []string `json:"resourceCategories"`
+}
+
+// Values of SessionFilter.Archived.
+const (
+	ArchivedExclude = "exclude" // the default
+	ArchivedOnly    = "only"
+	ArchivedInclude = "include"
+)
+
+// SessionFilter selects sessions for the session list.
+type SessionFilter struct {
+	Archived string
+	Pinned   *bool // nil for both
+	Tag      string
+	Limit    int
+	Offset   int
+}
+
+// SessionTagCount is one of a user's tags and how many sessions carry it.
+type SessionTagCount struct {
+	Tag      string `json:"tag"`
+	Sessions int    `json:"sessions"`
 }
 
 type ChatMessage struct {
 	ID          string    `json:"id" db:"id"`
 	SessionID   string    `json:"sessionId" db:"session_id"`
 	UserID      string    `json:"userId" db:"user_id"`
+	ParentID    string    `json:"parentId,omitempty" db:"parent_id"`
 	Content     string    `json:"content" db:"content"`
-	SenderType  string    `json:"senderType" db:"sender_type"` // 'user' or 'ai'
+	SenderType  string    `json:"senderType" db:"sender_type"` // 'user', 'ai' or 'counselor'
 	MessageType string    `json:"messageType" db:"message_type"` // 'text', 'audio', 'video'
 	Metadata    string    `json:"metadata" db:"metadata"` // JSON for additional data
 	CreatedAt   time.Time `json:"createdAt" db:"created_at"`
+
+	// SiblingIDs lists every message sharing this message's parent, this one
+	// included, oldest first. Only filled when branches are requested.
+	SiblingIDs []string `json:"siblingIds,omitempty" db:"-"`
+}
+
+// Transcript is a session's active conversation as exported for a counselor
+// or lawyer. Its compact JSON encoding is what the integrity hash covers.
+type Transcript struct {
+	SessionID  string              `json:"sessionId"`
+	Title      string              `json:"title"`
+	IncludesAI bool                `json:"includesAi"`
+	Messages   []TranscriptMessage `json:"messages"`
+}
+
+type TranscriptMessage struct {
+	ID          string    `json:"id"`
+	SenderType  string    `json:"senderType"`
+	Sender      string    `json:"sender"` // display label
+	MessageType string    `json:"messageType"`
+	Content     string    `json:"content"`
+	CreatedAt   time.Time `json:"createdAt"` // UTC
+}
+
+// TranscriptExport is a transcript with its integrity hash.
+type TranscriptExport struct {
+	Transcript Transcript `json:"transcript"`
+	Algorithm  string     `json:"algorithm"`
+	Hash       string     `json:"hash"`
+	ExportedAt time.Time  `json:"exportedAt"`
+}
+
+// TranscriptReceipt is the server's record of an exported transcript hash.
+// It says nothing about the content, so anyone holding the document can
+// check it.
+type TranscriptReceipt struct {
+	Algorithm    string    `json:"algorithm"`
+	Hash         string    `json:"hash"`
+	IncludesAI   bool      `json:"includesAi"`
+	MessageCount int       `json:"messageCount"`
+	ExportedAt   time.Time `json:"exportedAt"` // first export with this hash
+}
+
+type HandoffRequest struct {
+	ID          string     `json:"id" db:"id"`
+	SessionID   string     `json:"sessionId" db:"session_id"`
+	UserID      string     `json:"userId" db:"user_id"`
+	Reason      string     `json:"reason" db:"reason"` // 'requested' or 'risk'
+	RiskLevel   string     `json:"riskLevel" db:"risk_level"`
+	Status      string     `json:"status" db:"status"` // 'waiting', 'claimed', 'released'
+	CounselorID string     `json:"counselorId,omitempty" db:"counselor_id"`
+	CreatedAt   time.Time  `json:"createdAt" db:"created_at"`
+	ClaimedAt   *time.Time `json:"claimedAt" db:"claimed_at"`
+	ReleasedAt  *time.Time `json:"releasedAt" db:"released_at"`
+}
+
+type MessageFeedback struct {
+	ID            string    `json:"id" db:"id"`
+	MessageID     string    `json:"messageId" db:"message_id"`
+	SessionID     string    `json:"sessionId" db:"session_id"`
+	UserID        string    `json:"userId" db:"user_id"`
+	Rating        int       `json:"rating" db:"rating"`
+	Feedback      string    `json:"feedback" db:"feedback"`
+	PromptVersion string    `json:"promptVersion" db:"prompt_version"`
+	Provider      string    `json:"provider" db:"provider"`
+	Language      string    `json:"language" db:"language"`
+	CreatedAt     time.Time `json:"createdAt" db:"created_at"`
+	UpdatedAt     time.Time `json:"updatedAt" db:"updated_at"`
+}
+
+// FeedbackAggregate is one row of the admin feedback report: the average
+// rating for a prompt version/provider/language combination in one period.
+type FeedbackAggregate struct {
+	Period        string  `json:"period"`
+	PromptVersion string  `json:"promptVersion"`
+	Provider      string  `json:"provider"`
+	Language      string  `json:"language"`
+	AverageRating float64 `json:"averageRating"`
+	Count         int     `json:"count"`
+}
+
+type PromptTemplate struct {
+	ID        string    `json:"id" db:"id"`
+	Name      string    `json:"name" db:"name"`
+	Version   string    `json:"version" db:"version"`
+	Body      string    `json:"body" db:"body"`
+	Weight    int       `json:"weight" db:"weight"`
+	CreatedAt time.Time `json:"createdAt" db:"created_at"`
+}
+
+// PromptVersionStats compares one prompt version's replies and ratings.
+type PromptVersionStats struct {
+	Version       string  `json:"version"`
+	Weight        int     `json:"weight"`
+	Replies       int     `json:"replies"`
+	Ratings       int     `json:"ratings"`
+	AverageRating float64 `json:"averageRating"`
+	PositiveRate  float64 `json:"positiveRate"` // share of ratings >= 4
+}
+
+// ModerationEvent records a reply from Nia that broke the output policy.
+type ModerationEvent struct {
+	ID            string    `json:"id" db:"id"`
+	SessionID     string    `json:"sessionId" db:"session_id"`
+	MessageID     string    `json:"messageId" db:"message_id"` // the user message being answered
+	Category      string    `json:"category" db:"category"`
+	MatchedText   string    `json:"matchedText" db:"matched_text"`
+	Action        string    `json:"action" db:"action"` // 'regenerated' or 'replaced'
+	PromptVersion string    `json:"promptVersion" db:"prompt_version"`
+	Provider      string    `json:"provider" db:"provider"`
+	CreatedAt     time.Time `json:"createdAt" db:"created_at"`
 }
 
 type Resource struct {
@@ -78,36 +228,303 @@ type MoodLog struct {
 }
 
 type CrisisAlert struct {
-	ID         string     `json:"id" db:"id"`
-	UserID     string     `json:"userId" db:"user_id"`
-	Severity   string     `json:"severity" db:"severity"`
-	Message    string     `json:"message" db:"message"`
-	Location   string     `json:"location" db:"location"`
-	Status     string     `json:"status" db:"status"`
+	ID              string             `json:"id" db:"id"`
+	UserID          string             `json:"userId" db:"user_id"`
+	Severity        string             `json:"severity" db:"severity"`
+	Message         string             `json:"message" db:"message"`
+	Location        *AlertLocation     `json:"location" db:"location"`
+	LocationText    string             `json:"locationText,omitempty" db:"location"` // free-text location, when no usable fix was sent
+	Status          string             `json:"status" db:"status"` // 'active', 'acknowledged', 'escalated', 'resolved'
+	AcknowledgedBy  string             `json:"acknowledgedBy,omitempty" db:"acknowledged_by"`
+	ResolutionNotes string             `json:"resolutionNotes,omitempty" db:"resolution_notes"`
+	CreatedAt       time.Time          `json:"createdAt" db:"created_at"`
+	AcknowledgedAt  *time.Time         `json:"acknowledgedAt" db:"acknowledged_at"`
+	EscalatedAt     *time.Time         `json:"escalatedAt" db:"escalated_at"`
+	ResolvedAt      *time.Time         `json:"resolvedAt" db:"resolved_at"`
+	Duress          bool               `json:"duress,omitempty" db:"duress"` // staff only; never shown to the user
+	Events          []CrisisAlertEvent `json:"events,omitempty" db:"-"`
+	Trail           []AlertLocation    `json:"trail,omitempty" db:"-"`
+	Responders      []AlertResponder   `json:"responders,omitempty" db:"-"`
+}
+
+// AlertLocation is a position fix sent with a crisis alert. An alert keeps
+// every fix it is sent as a breadcrumb trail.
+type AlertLocation struct {
+	Latitude   float64   `json:"lat"`
+	Longitude  float64   `json:"lng"`
+	Accuracy   float64   `json:"accuracy,omitempty"` // metres, 0 if unknown
+	Source     string    `json:"source"`             // 'gps', 'network' or 'manual'
+	RecordedAt time.Time `json:"timestamp"`
+}
+
+// AlertResponder is the nearest support service of a type to an alert's
+// latest location, as it was when attached.
+type AlertResponder struct {
+	Type       string    `json:"type"` // 'police_gender_desk' or 'hospital'
+	ServiceID  string    `json:"serviceId"`
+	Name       string    `json:"name"`
+	Phone      string    `json:"phone,omitempty"`
+	Address    string    `json:"address,omitempty"`
+	Latitude   float64   `json:"latitude"`
+	Longitude  float64   `json:"longitude"`
+	DistanceKm float64   `json:"distanceKm"`
+	AttachedAt time.Time `json:"attachedAt"`
+}
+
+// CrisisAlertEvent records one status change of a crisis alert.
+type CrisisAlertEvent struct {
+	ID         string    `json:"id" db:"id"`
+	AlertID    string    `json:"alertId" db:"alert_id"`
+	FromStatus string    `json:"fromStatus" db:"from_status"` // empty for the alert being raised
+	ToStatus   string    `json:"toStatus" db:"to_status"`
+	ActorID    string    `json:"actorId" db:"actor_id"`
+	ActorRole  string    `json:"actorRole" db:"actor_role"` // 'user', 'counselor', 'admin' or 'system'
+	Note       string    `json:"note,omitempty" db:"note"`
+	CreatedAt  time.Time `json:"createdAt" db:"created_at"`
+}
+
+// AlertEscalation is one escalation step run for an unacknowledged crisis
+// alert.
+type AlertEscalation struct {
+	AlertID    string     `json:"alertId" db:"alert_id"`
+	Step       string     `json:"step" db:"step"`
+	Action     string     `json:"action" db:"action"`
+	Status     string     `json:"status" db:"status"` // 'done', 'skipped' or 'failed'
+	Attempts   int        `json:"attempts" db:"attempts"`
+	Error      string     `json:"error,omitempty" db:"error"`
 	CreatedAt  time.Time  `json:"createdAt" db:"created_at"`
-	ResolvedAt *time.Time `json:"resolvedAt" db:"resolved_at"`
+	ExecutedAt *time.Time `json:"executedAt" db:"executed_at"`
+}
+
+// SMSAlertSettings is a user's opt-in to having their emergency contacts
+// texted when they raise a crisis alert.
+type SMSAlertSettings struct {
+	UserID      string    `json:"userId" db:"user_id"`
+	Recipients  string    `json:"recipients" db:"recipients"`   // 'off', 'primary' or 'all'
+	MinSeverity string    `json:"minSeverity,omitempty" db:"-"` // deployment-wide, alerts below it text nobody
+	UpdatedAt   time.Time `json:"updatedAt" db:"updated_at"`
+}
+
+// SMSMessage is a text sent to an emergency contact about a crisis alert.
+type SMSMessage struct {
+	ID          string     `json:"id" db:"id"`
+	AlertID     string     `json:"alertId" db:"alert_id"`
+	ContactID   string     `json:"contactId" db:"contact_id"`
+	ContactName string     `json:"contactName" db:"-"`
+	To          string     `json:"to" db:"to_number"`
+	Body        string     `json:"body" db:"body"`
+	Provider    string     `json:"provider" db:"provider"`
+	Status      string     `json:"status" db:"status"` // 'queued', 'sent', 'delivered' or 'failed'
+	Attempts    int        `json:"attempts" db:"attempts"`
+	Error       string     `json:"error,omitempty" db:"error"`
+	CreatedAt   time.Time  `json:"createdAt" db:"created_at"`
+	SentAt      *time.Time `json:"sentAt" db:"sent_at"`
+	DeliveredAt *time.Time `json:"deliveredAt" db:"delivered_at"`
+}
+
+// SupportService is a place survivors can go for help: a GBV recovery
+// centre, police gender desk, hospital, shelter or legal aid office.
+type SupportService struct {
+	ID          string   `json:"id" db:"id"`
+	Name        string   `json:"name" db:"name"`
+	Type        string   `json:"type" db:"type"`
+	Description string   `json:"description" db:"description"`
+	Phone       string   `json:"phone" db:"phone"`
+	Address     string   `json:"address" db:"address"`
+	County      string   `json:"county" db:"county"`
+	Latitude    float64  `json:"latitude" db:"latitude"`
+	Longitude   float64  `json:"longitude" db:"longitude"`
+	Hours       string   `json:"hours" db:"hours"` // as shown to people, e.g. "Weekdays 8am to 5pm"
+	Languages   []string `json:"languages" db:"languages"`
+	Services    []string `json:"services" db:"services"`
+	// LocationHidden services (shelters) are listed with an approximate
+	// location and no address.
+	LocationHidden bool      `json:"locationHidden" db:"location_hidden"`
+	DistanceKm     *float64  `json:"distanceKm,omitempty" db:"-"`
+	CreatedAt      time.Time `json:"createdAt" db:"created_at"`
+	UpdatedAt      time.Time `json:"updatedAt" db:"updated_at"`
+
+	// Structured opening hours, in Timezone. OpenNow is nil when a service
+	// has no structured hours; OpensAt is set when it is closed and
+	// ClosesAt when it is open, if that happens within the next week.
+	Open24x7     bool             `json:"open24x7" db:"open_24_7"`
+	Timezone     string           `json:"timezone" db:"timezone"`
+	OpeningHours []OpeningPeriod  `json:"openingHours" db:"-"`
+	Exceptions   []HoursException `json:"exceptions" db:"-"`
+	OpenNow      *bool            `json:"openNow" db:"-"`
+	OpensAt      *time.Time       `json:"opensAt,omitempty" db:"-"`
+	ClosesAt     *time.Time       `json:"closesAt,omitempty" db:"-"`
+}
+
+// OpeningPeriod is a weekly opening period. Weekday is 0 for Sunday; a
+// period that closes at or before it opens runs past midnight.
+type OpeningPeriod struct {
+	Weekday int    `json:"weekday" db:"weekday"`
+	Opens   string `json:"opens" db:"opens"`   // "08:00"
+	Closes  string `json:"closes" db:"closes"` // "17:00", or "24:00" for midnight
+}
+
+// HoursException overrides a service's weekly hours on one date, such as a
+// public holiday.
+type HoursException struct {
+	Date   string `json:"date" db:"date"` // "2026-12-25"
+	Closed bool   `json:"closed" db:"closed"`
+	Opens  string `json:"opens,omitempty" db:"opens"`
+	Closes string `json:"closes,omitempty" db:"closes"`
+	Note   string `json:"note,omitempty" db:"note"`
 }
 
 type SafetyPlan struct {
-	ID                    string    `json:"id" db:"id"`
-	UserID                string    `json:"userId" db:"user_id"`
-	WarningSigns          string    `json:"warningSigns" db:"warning_signs"`
-	CopingStrategies      string    `json:"copingStrategies" db:"coping_strategies"`
-	SupportContacts       string    `json:"supportContacts" db:"support_contacts"`
-	ProfessionalContacts  string    `json:"professionalContacts" db:"professional_contacts"`
-	EnvironmentSafety     string    `json:"environmentSafety" db:"environment_safety"`
-	CreatedAt             time.Time `json:"createdAt" db:"created_at"`
-	UpdatedAt             time.Time `json:"updatedAt" db:"updated_at"`
+	ID      string `json:"id" db:"id"`
+	UserID  string `json:"userId" db:"user_id"`
+	Version int    `json:"version" db:"version"`
+	SafetyPlanContent
+	CreatedAt time.Time `json:"createdAt" db:"created_at"`
+	UpdatedAt time.Time `json:"updatedAt" db:"updated_at"`
+}
+
+// SafetyPlanContent is what a survivor writes in their safety plan. Each
+// section is stored as a JSON column.
+type SafetyPlanContent struct {
+	WarningSigns         []WarningSign         `json:"warningSigns" db:"warning_signs"`
+	CopingStrategies     []CopingStrategy      `json:"copingStrategies" db:"coping_strategies"`
+	SupportContacts      []SupportContact      `json:"supportContacts" db:"support_contacts"`
+	ProfessionalContacts []ProfessionalContact `json:"professionalContacts" db:"professional_contacts"`
+	EnvironmentSafety    EnvironmentSafety     `json:"environmentSafety" db:"environment_safety"`
+}
+
+// WarningSign is a sign that danger is building.
+type WarningSign struct {
+	Sign     string `json:"sign"`
+	Category string `json:"category,omitempty"` // 'thought', 'feeling', 'situation' or 'behaviour'
+}
+
+// CopingStrategy is something a survivor can do to stay safe or calm.
+type CopingStrategy struct {
+	Strategy string `json:"strategy"`
+	Category string `json:"category,omitempty"` // 'internal' or 'social'
+}
+
+// SupportContact is a trusted person. EmergencyContactID links it to one of
+// the user's emergency contacts, whose current name and phone are shown.
+type SupportContact struct {
+	EmergencyContactID string `json:"emergencyContactId,omitempty"`
+	Name               string `json:"name"`
+	Phone              string `json:"phone,omitempty"`
+	Relationship       string `json:"relationship,omitempty"`
+}
+
+// ProfessionalContact is a counsellor, clinic, hotline or legal service.
+type ProfessionalContact struct {
+	Name         string `json:"name"`
+	Role         string `json:"role,omitempty"`
+	Organization string `json:"organization,omitempty"`
+	Phone        string `json:"phone"`
+}
+
+// EnvironmentSafety covers making the surroundings safer and leaving
+// quickly if needed.
+type EnvironmentSafety struct {
+	SafePlaces    []string `json:"safePlaces"`
+	EmergencyBag  []string `json:"emergencyBag"`  // documents, money, medication to keep ready
+	ItemsToSecure []string `json:"itemsToSecure"` // weapons or other dangers to remove
+	EscapePlan    string   `json:"escapePlan,omitempty"`
+	CodeWord      string   `json:"codeWord,omitempty"` // agreed with trusted people to signal danger
+	Notes         string   `json:"notes,omitempty"`
+}
+
+// SafetyPlanVersion is one saved revision of a safety plan. RestoredFrom is
+// set when the revision restored an earlier version.
+type SafetyPlanVersion struct {
+	PlanID       string `json:"planId" db:"plan_id"`
+	Version      int    `json:"version" db:"version"`
+	RestoredFrom *int   `json:"restoredFrom,omitempty" db:"restored_from"`
+	SafetyPlanContent
+	CreatedAt time.Time `json:"createdAt" db:"created_at"`
+}
+
+// SafetyPlanDiff lists what changed between two versions of a safety plan.
+type SafetyPlanDiff struct {
+	From    int                `json:"from"`
+	To      int                `json:"to"`
+	Changes []SafetyPlanChange `json:"changes"`
 }
 
+// SafetyPlanChange is a change to one section. List sections report added
+// and removed items; single-value fields report From and To.
+type SafetyPlanChange struct {
+	Section string        `json:"section"` // e.g. "warningSigns" or "environmentSafety.codeWord"
+	Added   []interface{} `json:"added,omitempty"`
+	Removed []interface{} `json:"removed,omitempty"`
+	From    string        `json:"from,omitempty"`
+	To      string        `json:"to,omitempty"`
+}
+
+// SafetyCheckin is a schedule of check-ins. If the user does not confirm
+// one within the grace window, an alert is raised and their primary
+// emergency contact told. Whether a duress PIN is set is never shown.
+type SafetyCheckin struct {
+	ID              string                `json:"id" db:"id"`
+	UserID          string                `json:"userId" db:"user_id"`
+	Channel         string                `json:"channel" db:"channel"` // 'push' or 'sms'
+	Phone           string                `json:"phone,omitempty" db:"phone"`
+	IntervalMinutes int                   `json:"intervalMinutes" db:"interval_minutes"`
+	GraceMinutes    int                   `json:"graceMinutes" db:"grace_minutes"`
+	HasPIN          bool                  `json:"hasPin" db:"-"`
+	Status          string                `json:"status" db:"status"` // 'active', 'ended' or 'cancelled'
+	NextDueAt       *time.Time            `json:"nextDueAt,omitempty" db:"next_due_at"`
+	EndsAt          *time.Time            `json:"endsAt,omitempty" db:"ends_at"`
+	Pending         *SafetyCheckinPrompt  `json:"pending,omitempty" db:"-"`
+	Prompts         []SafetyCheckinPrompt `json:"prompts,omitempty" db:"-"`
+	CreatedAt       time.Time             `json:"createdAt" db:"created_at"`
+	UpdatedAt       time.Time             `json:"updatedAt" db:"updated_at"`
+}
+
+// SafetyCheckinPrompt is one check-in the user is asked to confirm. A
+// check-in confirmed with the duress PIN shows as confirmed.
+type SafetyCheckinPrompt struct {
+	ID          string     `json:"id" db:"id"`
+	CheckinID   string     `json:"checkinId" db:"checkin_id"`
+	DueAt       time.Time  `json:"dueAt" db:"due_at"`
+	ExpiresAt   time.Time  `json:"expiresAt" db:"expires_at"`
+	Status      string     `json:"status" db:"status"` // 'pending', 'confirmed', 'missed' or 'cancelled'
+	AlertID     string     `json:"alertId,omitempty" db:"alert_id"`
+	RespondedAt *time.Time `json:"respondedAt,omitempty" db:"responded_at"`
+}
+
+type CreateCheckinRequest struct {
+	IntervalMinutes int        `json:"intervalMinutes" binding:"required"`
+	GraceMinutes    int        `json:"graceMinutes"`
+	StartsAt        *time.Time `json:"startsAt"` // first check-in; defaults to one interval from now
+	EndsAt          *time.Time `json:"endsAt"`
+	Channel         string     `json:"channel"` // defaults to 'push'
+	Phone           string     `json:"phone"`   // for SMS, and push prompts the app is not open for; defaults to the profile's phone
+	PIN             string     `json:"pin"`
+	DuressPIN       string     `json:"duressPin"`
+}
+
+type RetentionSettings struct {
+	UserID           string    `json:"userId" db:"user_id"`
+	ChatRetention    string    `json:"chatRetention" db:"chat_retention"`
+	PurgeMoodNotes   bool      `json:"purgeMoodNotes" db:"purge_mood_notes"`
+	MaxRetentionDays int       `json:"maxRetentionDays,omitempty" db:"-"` // deployment-wide cap, 0 if none
+	UpdatedAt        time.Time `json:"updatedAt" db:"updated_at"`
+}
+
+
 type EmergencyContact struct {
-	ID           string    `json:"id" db:"id"`
-	UserID       string    `json:"userId" db:"user_id"`
-	Name         string    `json:"name" db:"name"`
-	Phone        string    `json:"phone" db:"phone"`
-	Relationship string    `json:"relationship" db:"relationship"`
-	IsPrimary    bool      `json:"isPrimary" db:"is_primary"`
-	CreatedAt    time.Time `json:"createdAt" db:"created_at"`
+	ID           string `json:"id" db:"id"`
+	UserID       string `json:"userId" db:"user_id"`
+	Name         string `json:"name" db:"name"`
+	Phone        string `json:"phone" db:"phone"`
+	Relationship string `json:"relationship" db:"relationship"`
+	IsPrimary    bool   `json:"isPrimary" db:"is_primary"`
+	// VerificationStatus is 'unverified', 'pending', 'verified', 'declined'
+	// or 'expired'
+	VerificationStatus string     `json:"verificationStatus" db:"verification_status"`
+	VerificationSentAt *time.Time `json:"verificationSentAt,omitempty" db:"verification_sent_at"`
+	VerifiedAt         *time.Time `json:"verifiedAt,omitempty" db:"verified_at"`
+	CreatedAt          time.Time  `json:"createdAt" db:"created_at"`
 }
 
 // Request/Response models
@@ -134,6 +551,43 @@ type SendMessageRequest struct {
 	SessionID   string `json:"sessionId"`
 	Content     string `json:"content" binding:"required"`
 	MessageType string `json:"messageType"`
+	Mode        string `json:"mode"` // only used when the message starts a new session
+}
+
+type UpdateSessionRequest struct {
+	Title    *string   `json:"title"`
+	Archived *bool     `json:"archived"`
+	Pinned   *bool     `json:"pinned"`
+	Tags     *[]string `json:"tags"` // replaces the session's tags
+}
+
+type BulkSessionsRequest struct {
+	SessionIDs []string `json:"sessionIds" binding:"required"`
+}
+
+type BulkArchiveRequest struct {
+	SessionIDs []string `json:"sessionIds" binding:"required"`
+	Archived   *bool    `json:"archived"` // defaults to true
+}
+
+type EditMessageRequest struct {
+	Content string `json:"content" binding:"required"`
+}
+
+type CreatePromptRequest struct {
+	Name    string `json:"name" binding:"required"`
+	Version string `json:"version" binding:"required"`
+	Body    string `json:"body" binding:"required"`
+	Weight  int    `json:"weight" binding:"min=0"`
+}
+
+type UpdatePromptWeightRequest struct {
+	Weight *int `json:"weight" binding:"required,min=0"`
+}
+
+type UpdateRetentionRequest struct {
+	ChatRetention  *string `json:"chatRetention"`
+	PurgeMoodNotes *bool   `json:"purgeMoodNotes"`
 }
 
 type UserStats struct {
@@ -0,0 +1,259 @@
+// Package pdf writes simple text documents as PDF: headings, wrapped
+// paragraphs and a footer on every page, in the standard Helvetica fonts so
+// nothing needs to be embedded. It is just enough for transcripts and
+// printable plans; anything outside Windows-1252 is printed as "?".
+package pdf
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// A4 portrait, in points.
+const (
+	pageWidth  = 595.0
+	pageHeight = 842.0
+	margin     = 50.0
+)
+
+const (
+	regular = "F1"
+	bold    = "F2"
+)
+
+type line struct {
+	font string
+	size float64
+	x, y float64
+	text string
+}
+
+// Document is a PDF being built. The zero value is not usable; call New.
+type Document struct {
+	title  string
+	footer string
+	pages  [][]line
+	y      float64 // baseline of the next line on the current page
+}
+
+// New starts an empty document. The title is stored in the document
+// information dictionary.
+func New(title string) *Document {
+	d := &Document{title: title}
+	d.newPage()
+	return d
+}
+
+// SetFooter sets text printed at the bottom of every page, next to the page
+// number.
+func (d *Document) SetFooter(text string) {
+	d.footer = text
+}
+
+// Heading writes text in large bold type.
+func (d *Document) Heading(text string) {
+	d.write(bold, 16, text)
+	d.Space(4)
+}
+
+// Label writes text in bold at body size, e.g. a section or speaker name.
+func (d *Document) Label(text string) {
+	d.write(bold, 10, text)
+}
+
+// Text writes a paragraph, wrapped to the page width. Newlines start new
+// lines.
+func (d *Document) Text(text string) {
+	d.write(regular, 10, text)
+}
+
+// Small writes text in a smaller regular font, e.g. timestamps.
+func (d *Document) Small(text string) {
+	d.write(regular, 8, text)
+}
+
+// Space adds vertical space of the given height in points.
+func (d *Document) Space(height float64) {
+	d.y -= height
+}
+
+// PageCount is the number of pages written so far.
+func (d *Document) PageCount() int {
+	return len(d.pages)
+}
+
+func (d *Document) newPage() {
+	d.pages = append(d.pages, nil)
+	d.y = pageHeight - margin
+}
+
+func (d *Document) write(font string, size float64, text string) {
+	leading := size * 1.4
+	for _, paragraph := range strings.Split(text, "\n") {
+		for _, l := range wrap(paragraph, font, size, pageWidth-2*margin) {
+			// leave room for the footer
+			if d.y-leading < margin+20 {
+				d.newPage()
+			}
+			d.y -= leading
+			page := len(d.pages) - 1
+			d.pages[page] = append(d.pages[page], line{font: font, size: size, x: margin, y: d.y, text: l})
+		}
+	}
+}
+
+// Bytes renders the document.
+func (d *Document) Bytes() []byte {
+	var buf bytes.Buffer
+	var offsets []int
+
+	object := func(body string) {
+		offsets = append(offsets, buf.Len())
+		fmt.Fprintf(&buf, "%d 0 obj\n%s\nendobj\n", len(offsets), body)
+	}
+
+	buf.WriteString("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+
+	// Objects 1-5 are fixed; each page then takes two: the page and its
+	// content stream.
+	n := len(d.pages)
+	kids := make([]string, n)
+	for i := range d.pages {
+		kids[i] = fmt.Sprintf("%d 0 R", 6+2*i)
+	}
+	object("<< /Type /Catalog /Pages 2 0 R >>")
+	object(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), n))
+	object("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>")
+	object("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica-Bold /Encoding /WinAnsiEncoding >>")
+	object(fmt.Sprintf("<< /Title (%s) /Producer (HEAL) >>", escape(encode(d.title))))
+
+	for i, lines := range d.pages {
+		var content bytes.Buffer
+		for _, l := range lines {
+			writeText(&content, l)
+		}
+		footer := fmt.Sprintf("Page %d of %d", i+1, n)
+		if d.footer != "" {
+			footer = d.footer + "  |  " + footer
+		}
+		writeText(&content, line{font: regular, size: 7, x: margin, y: margin, text: footer})
+
+		object(fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %.0f %.0f] "+
+			"/Resources << /Font << /F1 3 0 R /F2 4 0 R >> >> /Contents %d 0 R >>",
+			pageWidth, pageHeight, 7+2*i))
+		object(fmt.Sprintf("<< /Length %d >>\nstream\n%sendstream", content.Len(), content.String()))
+	}
+
+	xref := buf.Len()
+	fmt.Fprintf(&buf, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&buf, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&buf, "trailer\n<< /Size %d /Root 1 0 R /Info 5 0 R >>\nstartxref\n%d\n%%%%EOF\n",
+		len(offsets)+1, xref)
+	return buf.Bytes()
+}
+
+func writeText(w *bytes.Buffer, l line) {
+	fmt.Fprintf(w, "BT /%s %.1f Tf %.2f %.2f Td (%s) Tj ET\n", l.font, l.size, l.x, l.y, escape(encode(l.text)))
+}
+
+// wrap breaks text into lines no wider than width, splitting on spaces and,
+// for words longer than a line, inside the word.
+func wrap(text, font string, size, width float64) []string {
+	words := strings.Fields(text)
+	if len(words) == 0 {
+		return []string{""}
+	}
+
+	var lines []string
+	current := ""
+	for _, word := range words {
+		candidate := word
+		if current != "" {
+			candidate = current + " " + word
+		}
+		if textWidth(candidate, font, size) <= width {
+			current = candidate
+			continue
+		}
+		if current != "" {
+			lines = append(lines, current)
+		}
+		for textWidth(word, font, size) > width {
+			cut := 1
+			for cut < len([]rune(word)) && textWidth(string([]rune(word)[:cut+1]), font, size) <= width {
+				cut++
+			}
+			lines = append(lines, string([]rune(word)[:cut]))
+			word = string([]rune(word)[cut:])
+		}
+		current = word
+	}
+	return append(lines, current)
+}
+
+func textWidth(text, font string, size float64) float64 {
+	total := 0.0
+	for _, b := range encode(text) {
+		w := 556.0
+		if b >= 32 && b < 127 {
+			w = helveticaWidths[b-32]
+		}
+		total += w
+	}
+	if font == bold {
+		total *= 1.06 // Helvetica-Bold is slightly wider; close enough for wrapping
+	}
+	return total * size / 1000
+}
+
+// helveticaWidths are the Helvetica glyph widths for ASCII 32-126, in
+// thousandths of the font size.
+var helveticaWidths = [95]float64{
+	278, 278, 355, 556, 556, 889, 667, 191, 333, 333, 389, 584, 278, 333, 278, 278,
+	556, 556, 556, 556, 556, 556, 556, 556, 556, 556, 278, 278, 584, 584, 584, 556,
+	1015, 667, 667, 722, 722, 667, 611, 778, 722, 278, 500, 667, 556, 833, 722, 778,
+	667, 778, 722, 667, 611, 722, 667, 944, 667, 667, 611, 278, 278, 278, 469, 556,
+	333, 556, 556, 500, 556, 556, 278, 556, 556, 222, 222, 500, 222, 833, 556, 556,
+	556, 556, 333, 500, 278, 556, 500, 722, 500, 500, 500, 334, 260, 334, 584,
+}
+
+// windows1252 maps the characters outside Latin-1 that WinAnsiEncoding has.
+var windows1252 = map[rune]byte{
+	'€': 0x80, '…': 0x85, '‘': 0x91, '’': 0x92, '“': 0x93, '”': 0x94,
+	'•': 0x95, '–': 0x96, '—': 0x97, '™': 0x99,
+}
+
+// encode converts text to WinAnsiEncoding bytes.
+func encode(text string) []byte {
+	out := make([]byte, 0, len(text))
+	for _, r := range text {
+		switch {
+		case r == '\t':
+			out = append(out, ' ')
+		case r >= 32 && r < 127, r >= 0xA0 && r <= 0xFF:
+			out = append(out, byte(r))
+		case windows1252[r] != 0:
+			out = append(out, windows1252[r])
+		case r < 32:
+			// drop other control characters
+		default:
+			out = append(out, '?')
+		}
+	}
+	return out
+}
+
+// escape makes encoded text safe inside a PDF literal string.
+func escape(text []byte) string {
+	var b strings.Builder
+	for _, c := range text {
+		if c == '(' || c == ')' || c == '\\' {
+			b.WriteByte('\\')
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
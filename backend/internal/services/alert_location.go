@@ -0,0 +1,316 @@
+package services
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/heal/internal/models"
+)
+
+// Alert location sources.
+const (
+	LocationGPS     = "gps"
+	LocationNetwork = "network"
+	LocationManual  = "manual"
+)
+
+const (
+	// maxLocationAge is how old a fix can be and still be sent. Fixes a
+	// little in the future are allowed for devices whose clocks run fast.
+	maxLocationAge  = 24 * time.Hour
+	maxLocationSkew = 5 * time.Minute
+	// maxLocationAccuracy is the least accurate fix accepted, in metres.
+	maxLocationAccuracy = 50000
+	// maxAlertBreadcrumbs caps the trail kept for one alert.
+	maxAlertBreadcrumbs = 1000
+	// maxLocationTextLength caps a free-text location, in characters.
+	maxLocationTextLength = 500
+	// responderRadiusKm is how far to look for the nearest responders.
+	responderRadiusKm = 100
+)
+
+// alertResponderRoles are the responders attached to an alert and the
+// directory types that can fill each role. GBV recovery centres are
+// hospital units, so they count as hospitals.
+var alertResponderRoles = []struct {
+	role  string
+	types []string
+}{
+	{ServicePoliceGenderDesk, []string{ServicePoliceGenderDesk}},
+	{ServiceHospital, []string{ServiceHospital, ServiceGBVRecoveryCentre}},
+}
+
+// validateAlertLocation checks a location fix, defaulting its source to
+// manual and its timestamp to now.
+func validateAlertLocation(loc *models.AlertLocation, now time.Time) error {
+	if loc.Latitude < -90 || loc.Latitude > 90 || loc.Longitude < -180 || loc.Longitude > 180 ||
+		math.IsNaN(loc.Latitude) || math.IsNaN(loc.Longitude) {
+		return fmt.Errorf("%w: lat must be between -90 and 90 and lng between -180 and 180", ErrInvalidAlertLocation)
+	}
+	// 0,0 is what broken location providers report
+	if loc.Latitude == 0 && loc.Longitude == 0 {
+		return fmt.Errorf("%w: lat and lng are both 0", ErrInvalidAlertLocation)
+	}
+	if loc.Accuracy < 0 || loc.Accuracy > maxLocationAccuracy {
+		return fmt.Errorf("%w: accuracy must be between 0 and %d metres", ErrInvalidAlertLocation, maxLocationAccuracy)
+	}
+	switch loc.Source {
+	case "":
+		loc.Source = LocationManual
+	case LocationGPS, LocationNetwork, LocationManual:
+	default:
+		return fmt.Errorf("%w: source must be one of gps, network or manual", ErrInvalidAlertLocation)
+	}
+	if loc.RecordedAt.IsZero() {
+		loc.RecordedAt = now
+	}
+	if loc.RecordedAt.After(now.Add(maxLocationSkew)) || now.Sub(loc.RecordedAt) > maxLocationAge {
+		return fmt.Errorf("%w: timestamp must be within the last 24 hours", ErrInvalidAlertLocation)
+	}
+	return nil
+}
+
+// sealLocation encodes and encrypts a location for storage.
+func (s *CrisisService) sealLocation(userID string, loc *models.AlertLocation) (string, error) {
+	data, err := json.Marshal(loc)
+	if err != nil {
+		return "", err
+	}
+	return s.cipher.Encrypt(userID, string(data))
+}
+
+// openLocation decodes a stored location. Values that are not a location,
+// such as free text saved before locations were typed, come back as nil.
+func (s *CrisisService) openLocation(userID, stored string) *models.AlertLocation {
+	if stored == "" {
+		return nil
+	}
+	var loc models.AlertLocation
+	if err := json.Unmarshal([]byte(s.cipher.Reveal(userID, stored)), &loc); err != nil {
+		return nil
+	}
+	if loc.Latitude == 0 && loc.Longitude == 0 {
+		return nil
+	}
+	return &loc
+}
+
+// openLocationText returns a stored location that is free text rather
+// than a fix, such as one typed by the user or saved by older clients.
+func (s *CrisisService) openLocationText(userID, stored string) string {
+	if stored == "" {
+		return ""
+	}
+	text := strings.TrimSpace(s.cipher.Reveal(userID, stored))
+	if strings.HasPrefix(text, "{") {
+		return ""
+	}
+	return text
+}
+
+func (s *CrisisService) insertBreadcrumb(tx *sql.Tx, alertID, userID, sealed string, loc *models.AlertLocation, now time.Time) error {
+	_, err := tx.Exec(`
+		INSERT INTO crisis_alert_locations (id, alert_id, user_id, location, recorded_at, created_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`, uuid.New().String(), alertID, userID, sealed, loc.RecordedAt, now)
+	if err != nil {
+		return fmt.Errorf("failed to record alert location: %w", err)
+	}
+	return nil
+}
+
+// UpdateAlertLocation adds a location fix to the trail of one of the
+// user's alerts. A fix newer than the alert's location replaces it, and the
+// nearest responders are looked up again. Resolved alerts take no more
+// fixes.
+func (s *CrisisService) UpdateAlertLocation(userID, alertID string, loc models.AlertLocation) (*models.CrisisAlert, error) {
+	now := time.Now()
+	if err := validateAlertLocation(&loc, now); err != nil {
+		return nil, err
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var status, storedLocation string
+	err = tx.QueryRow(`
+		SELECT COALESCE(status, 'active'), COALESCE(location, '') FROM crisis_alerts WHERE id = ? AND `+userAlertFilter+`
+	`, alertID, userID).Scan(&status, &storedLocation)
+	if err == sql.ErrNoRows {
+		return nil, ErrAlertNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if status == AlertResolved {
+		return nil, ErrAlertResolved
+	}
+	var breadcrumbs int
+	if err := tx.QueryRow("SELECT COUNT(*) FROM crisis_alert_locations WHERE alert_id = ?", alertID).Scan(&breadcrumbs); err != nil {
+		return nil, err
+	}
+	if breadcrumbs >= maxAlertBreadcrumbs {
+		return nil, fmt.Errorf("%w: the alert already has %d locations", ErrInvalidAlertLocation, maxAlertBreadcrumbs)
+	}
+
+	sealed, err := s.sealLocation(userID, &loc)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.insertBreadcrumb(tx, alertID, userID, sealed, &loc, now); err != nil {
+		return nil, err
+	}
+	// Fixes can arrive out of order over a poor connection
+	current := s.openLocation(userID, storedLocation)
+	latest := current == nil || loc.RecordedAt.After(current.RecordedAt)
+	if latest {
+		if _, err := tx.Exec("UPDATE crisis_alerts SET location = ? WHERE id = ?", sealed, alertID); err != nil {
+			return nil, fmt.Errorf("failed to update alert location: %w", err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	if latest {
+		s.attachResponders(userID, alertID, &loc)
+	}
+	return s.GetCrisisAlert(userID, alertID)
+}
+
+// attachResponders records the police gender desk and hospital nearest to
+// an alert's location. Which services are nearest gives away where the
+// user is, so each is stored encrypted with the user's key. A role with
+// none in range is cleared rather than left pointing somewhere the user
+// has moved away from. Failures are only logged: the alert stands without
+// them.
+func (s *CrisisService) attachResponders(userID, alertID string, loc *models.AlertLocation) {
+	if s.directory == nil {
+		return
+	}
+	for _, r := range alertResponderRoles {
+		nearest, err := s.directory.Search(ServiceSearch{
+			Latitude:  loc.Latitude,
+			Longitude: loc.Longitude,
+			RadiusKm:  responderRadiusKm,
+			Types:     r.types,
+			Limit:     1,
+		})
+		if err != nil {
+			log.Printf("Warning: failed to find nearest %s for alert %s: %v", r.role, alertID, err)
+			continue
+		}
+		if len(nearest) == 0 {
+			_, err = s.db.Exec("DELETE FROM crisis_alert_responders WHERE alert_id = ? AND type = ?", alertID, r.role)
+		} else {
+			err = s.saveResponder(userID, alertID, r.role, nearest[0])
+		}
+		if err != nil {
+			log.Printf("Warning: failed to attach nearest %s to alert %s: %v", r.role, alertID, err)
+		}
+	}
+}
+
+func (s *CrisisService) saveResponder(userID, alertID, role string, service models.SupportService) error {
+	now := time.Now()
+	data, err := json.Marshal(models.AlertResponder{
+		Type:       role,
+		ServiceID:  service.ID,
+		Name:       service.Name,
+		Phone:      service.Phone,
+		Address:    service.Address,
+		Latitude:   service.Latitude,
+		Longitude:  service.Longitude,
+		DistanceKm: *service.DistanceKm,
+		AttachedAt: now,
+	})
+	if err != nil {
+		return err
+	}
+	sealed, err := s.cipher.Encrypt(userID, string(data))
+	if err != nil {
+		return err
+	}
+	_, err = s.db.Exec(`
+		INSERT INTO crisis_alert_responders (alert_id, type, user_id, responder, attached_at)
+		VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT(alert_id, type) DO UPDATE SET
+			responder = excluded.responder, attached_at = excluded.attached_at
+	`, alertID, role, userID, sealed, now)
+	return err
+}
+
+// alertTrail is every location fix sent with an alert, oldest first.
+func (s *CrisisService) alertTrail(userID, alertID string) ([]models.AlertLocation, error) {
+	rows, err := s.db.Query(`
+		SELECT location FROM crisis_alert_locations WHERE alert_id = ? ORDER BY recorded_at, rowid
+	`, alertID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	trail := []models.AlertLocation{}
+	for rows.Next() {
+		var stored string
+		if err := rows.Scan(&stored); err != nil {
+			return nil, err
+		}
+		if loc := s.openLocation(userID, stored); loc != nil {
+			trail = append(trail, *loc)
+		}
+	}
+	return trail, rows.Err()
+}
+
+// alertResponders returns the responders attached to an alert, nearest
+// first. Ones that can no longer be decrypted are left out.
+func (s *CrisisService) alertResponders(userID, alertID string) ([]models.AlertResponder, error) {
+	rows, err := s.db.Query("SELECT responder FROM crisis_alert_responders WHERE alert_id = ?", alertID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	responders := []models.AlertResponder{}
+	for rows.Next() {
+		var stored string
+		if err := rows.Scan(&stored); err != nil {
+			return nil, err
+		}
+		var r models.AlertResponder
+		if err := json.Unmarshal([]byte(s.cipher.Reveal(userID, stored)), &r); err != nil {
+			continue
+		}
+		responders = append(responders, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	sort.Slice(responders, func(i, j int) bool { return responders[i].DistanceKm < responders[j].DistanceKm })
+	return responders, nil
+}
+
+// loadAlertDetail fills in what the single-alert views show beyond the
+// alert itself.
+func (s *CrisisService) loadAlertDetail(alert *models.CrisisAlert) error {
+	var err error
+	if alert.Events, err = s.alertEvents(alert.UserID, alert.ID); err != nil {
+		return err
+	}
+	if alert.Trail, err = s.alertTrail(alert.UserID, alert.ID); err != nil {
+		return err
+	}
+	alert.Responders, err = s.alertResponders(alert.UserID, alert.ID)
+	return err
+}
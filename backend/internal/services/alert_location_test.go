@@ -0,0 +1,151 @@
+package services
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/heal/internal/models"
+)
+
+func TestValidateAlertLocation(t *testing.T) {
+	now := time.Now()
+	loc := models.AlertLocation{Latitude: -1.2864, Longitude: 36.8172}
+	if err := validateAlertLocation(&loc, now); err != nil {
+		t.Fatalf("validateAlertLocation: %v", err)
+	}
+	if loc.Source != LocationManual || !loc.RecordedAt.Equal(now) {
+		t.Errorf("defaults = %+v, want a manual fix recorded now", loc)
+	}
+
+	for name, bad := range map[string]models.AlertLocation{
+		"latitude":  {Latitude: 91, Longitude: 36.8},
+		"null fix":  {},
+		"accuracy":  {Latitude: -1.28, Longitude: 36.82, Accuracy: -1},
+		"source":    {Latitude: -1.28, Longitude: 36.82, Source: "wifi"},
+		"stale":     {Latitude: -1.28, Longitude: 36.82, RecordedAt: now.Add(-25 * time.Hour)},
+		"in future": {Latitude: -1.28, Longitude: 36.82, RecordedAt: now.Add(time.Hour)},
+	} {
+		if err := validateAlertLocation(&bad, now); !errors.Is(err, ErrInvalidAlertLocation) {
+			t.Errorf("%s: err = %v, want ErrInvalidAlertLocation", name, err)
+		}
+	}
+}
+
+func TestAlertLocationTrailAndResponders(t *testing.T) {
+	db := newTestDB(t)
+	directory := NewDirectoryService(db)
+	_, err := directory.ImportServices([]models.SupportService{
+		{Name: "Central Gender Desk", Type: ServicePoliceGenderDesk, Latitude: -1.2833, Longitude: 36.8219},
+		{Name: "Kenyatta Hospital", Type: ServiceHospital, Latitude: -1.3010, Longitude: 36.8070},
+		{Name: "Mombasa Gender Desk", Type: ServicePoliceGenderDesk, Latitude: -4.0435, Longitude: 39.6682},
+	}, true)
+	if err != nil {
+		t.Fatalf("ImportServices: %v", err)
+	}
+	crisis := NewCrisisService(db, newTestCipher(t, db), nil, directory)
+	userID := createTestUser(t, db, "Amani")
+
+	start := time.Now().Add(-10 * time.Minute)
+	alert, err := crisis.CreateCrisisAlert(userID, RiskHigh, "help", &models.AlertLocation{
+		Latitude: -1.2864, Longitude: 36.8172, Source: LocationGPS, RecordedAt: start,
+	}, "")
+	if err != nil {
+		t.Fatalf("CreateCrisisAlert: %v", err)
+	}
+	got, err := crisis.GetCrisisAlert(userID, alert.ID)
+	if err != nil {
+		t.Fatalf("GetCrisisAlert: %v", err)
+	}
+	if len(got.Responders) != 2 || got.Responders[0].Name != "Central Gender Desk" {
+		t.Fatalf("responders = %+v, want the Nairobi desk and hospital", got.Responders)
+	}
+
+	// A late fix from before the latest one joins the trail but does not
+	// move the alert
+	if _, err := crisis.UpdateAlertLocation(userID, alert.ID, models.AlertLocation{
+		Latitude: -4.0435, Longitude: 39.6682, Source: LocationGPS, RecordedAt: start.Add(5 * time.Minute),
+	}); err != nil {
+		t.Fatalf("UpdateAlertLocation: %v", err)
+	}
+	got, err = crisis.UpdateAlertLocation(userID, alert.ID, models.AlertLocation{
+		Latitude: -1.2900, Longitude: 36.8200, Source: LocationNetwork, RecordedAt: start.Add(-time.Minute),
+	})
+	if err != nil {
+		t.Fatalf("UpdateAlertLocation: %v", err)
+	}
+	if len(got.Trail) != 3 || got.Trail[0].Source != LocationNetwork {
+		t.Fatalf("trail = %+v, want three fixes oldest first", got.Trail)
+	}
+	if got.Location == nil || got.Location.Latitude != -4.0435 {
+		t.Errorf("location = %+v, want the newest fix", got.Location)
+	}
+	if len(got.Responders) != 1 || got.Responders[0].Name != "Mombasa Gender Desk" {
+		t.Errorf("responders = %+v, want only the Mombasa desk in range", got.Responders)
+	}
+
+	var stored string
+	if err := db.QueryRow("SELECT location FROM crisis_alert_locations LIMIT 1").Scan(&stored); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(stored, "36.8") || strings.Contains(stored, "39.6") {
+		t.Error("a location fix was stored in plaintext")
+	}
+	if err := db.QueryRow("SELECT responder FROM crisis_alert_responders LIMIT 1").Scan(&stored); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(stored, "Mombasa") || strings.Contains(stored, "39.6") {
+		t.Error("a responder was stored in plaintext")
+	}
+}
+
+func TestShredDataDeletesAlertResponders(t *testing.T) {
+	db := newTestDB(t)
+	directory := NewDirectoryService(db)
+	_, err := directory.ImportServices([]models.SupportService{
+		{Name: "Central Gender Desk", Type: ServicePoliceGenderDesk, Latitude: -1.2833, Longitude: 36.8219},
+	}, true)
+	if err != nil {
+		t.Fatalf("ImportServices: %v", err)
+	}
+	cipher := newTestCipher(t, db)
+	crisis := NewCrisisService(db, cipher, nil, directory)
+	userID := createTestUser(t, db, "Amani")
+	fix := &models.AlertLocation{Latitude: -1.2864, Longitude: 36.8172}
+	if _, err := crisis.CreateCrisisAlert(userID, RiskHigh, "help", fix, ""); err != nil {
+		t.Fatalf("CreateCrisisAlert: %v", err)
+	}
+	if n := countRows(t, db, "SELECT COUNT(*) FROM crisis_alert_responders WHERE user_id = ?", userID); n != 1 {
+		t.Fatalf("%d responders attached, want 1", n)
+	}
+
+	if err := NewUserService(db, cipher).ShredData(userID); err != nil {
+		t.Fatalf("ShredData: %v", err)
+	}
+	if n := countRows(t, db, "SELECT COUNT(*) FROM crisis_alert_responders WHERE user_id = ?", userID); n != 0 {
+		t.Errorf("%d responders survived shredding", n)
+	}
+}
+
+func TestUpdateAlertLocationChecksAlert(t *testing.T) {
+	db := newTestDB(t)
+	crisis := NewCrisisService(db, nil, nil, nil)
+	userID := createTestUser(t, db, "Amani")
+	alert, err := crisis.CreateCrisisAlert(userID, RiskHigh, "help", nil, "")
+	if err != nil {
+		t.Fatalf("CreateCrisisAlert: %v", err)
+	}
+	fix := models.AlertLocation{Latitude: -1.28, Longitude: 36.82}
+
+	other := createTestUser(t, db, "Baraka")
+	if _, err := crisis.UpdateAlertLocation(other, alert.ID, fix); !errors.Is(err, ErrAlertNotFound) {
+		t.Errorf("another user's alert: err = %v, want ErrAlertNotFound", err)
+	}
+	if _, err := crisis.TransitionAlert(alert.ID, AlertResolved, "staff-1", "admin", "safe"); err != nil {
+		t.Fatalf("TransitionAlert: %v", err)
+	}
+	if _, err := crisis.UpdateAlertLocation(userID, alert.ID, fix); !errors.Is(err, ErrAlertResolved) {
+		t.Errorf("resolved alert: err = %v, want ErrAlertResolved", err)
+	}
+}
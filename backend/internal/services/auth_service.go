@@ -146,13 +146,33 @@ func (s *AuthService) ValidateToken(tokenString string) (*models.User, error) {
 	return nil, errors.New("invalid token")
 }
 
+// SetRole changes a user's role. Roles gate the admin, staff and counselor
+// endpoints; there is deliberately no HTTP route for this.
+func (s *AuthService) SetRole(email, role string) error {
+	switch role {
+	case "user", "counselor", "staff", "admin":
+	default:
+		return fmt.Errorf("unknown role: %s", role)
+	}
+
+	result, err := s.db.Exec("UPDATE users SET role = ?, updated_at = ? WHERE email = ?", role, time.Now(), email)
+	if err != nil {
+		return fmt.Errorf("failed to set role: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return errors.New("user not found")
+	}
+	return nil
+}
+
 func (s *AuthService) getUserByID(id string) (*models.User, error) {
 	user := &models.User{}
 	err := s.db.QueryRow(`
-		SELECT id, email, password_hash, first_name, last_name, email_verified, created_at, updated_at
+		SELECT id, email, password_hash, first_name, last_name, email_verified,
+		       COALESCE(role, 'user'), created_at, updated_at
 		FROM users WHERE id = ?
 	`, id).Scan(&user.ID, &user.Email, &user.PasswordHash, &user.FirstName, &user.LastName,
-		&user.EmailVerified, &user.CreatedAt, &user.UpdatedAt)
+		&user.EmailVerified, &user.Role, &user.CreatedAt, &user.UpdatedAt)
 	if err != nil {
 		return nil, err
 	}
@@ -162,10 +182,11 @@ func (s *AuthService) getUserByID(id string) (*models.User, error) {
 func (s *AuthService) getUserByEmail(email string) (*models.User, error) {
 	user := &models.User{}
 	err := s.db.QueryRow(`
-		SELECT id, email, password_hash, first_name, last_name, email_verified, created_at, updated_at
+		SELECT id, email, password_hash, first_name, last_name, email_verified,
+		       COALESCE(role, 'user'), created_at, updated_at
 		FROM users WHERE email = ?
 	`, email).Scan(&user.ID, &user.Email, &user.PasswordHash, &user.FirstName, &user.LastName,
-		&user.EmailVerified, &user.CreatedAt, &user.UpdatedAt)
+		&user.EmailVerified, &user.Role, &user.CreatedAt, &user.UpdatedAt)
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,206 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/heal/internal/models"
+)
+
+// Chat history is a tree: every message points at the message it follows
+// (parent_id) and each session remembers which leaf is currently shown
+// (active_leaf_id). Regenerating a reply or editing a user message adds a
+// sibling branch instead of rewriting what was said.
+
+type messageTree struct {
+	byID       map[string]models.ChatMessage
+	children   map[string][]string // parent ID ("" for roots) -> child IDs, oldest first
+	activeLeaf string
+}
+
+func (s *ChatService) loadMessageTree(sessionID string) (*messageTree, error) {
+	var activeLeaf sql.NullString
+	if err := s.db.QueryRow("SELECT active_leaf_id FROM chat_sessions WHERE id = ?", sessionID).Scan(&activeLeaf); err != nil {
+		return nil, err
+	}
+
+	rows, err := s.db.Query(`
+		SELECT id, session_id, user_id, COALESCE(parent_id, ''), content, sender_type, message_type,
+		       COALESCE(metadata, '{}'), created_at
+		FROM chat_messages
+		WHERE session_id = ?
+		ORDER BY created_at ASC, rowid ASC
+	`, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tree := &messageTree{
+		byID:       map[string]models.ChatMessage{},
+		children:   map[string][]string{},
+		activeLeaf: activeLeaf.String,
+	}
+	for rows.Next() {
+		var m models.ChatMessage
+		err := rows.Scan(&m.ID, &m.SessionID, &m.UserID, &m.ParentID, &m.Content,
+			&m.SenderType, &m.MessageType, &m.Metadata, &m.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		m.Content = s.cipher.Reveal(m.UserID, m.Content)
+		tree.byID[m.ID] = m
+		tree.children[m.ParentID] = append(tree.children[m.ParentID], m.ID)
+	}
+
+	return tree, rows.Err()
+}
+
+// pathTo returns the messages from the root down to leafID, inclusive.
+func (t *messageTree) pathTo(leafID string) []models.ChatMessage {
+	var path []models.ChatMessage
+	seen := map[string]bool{}
+	for id := leafID; id != "" && !seen[id]; {
+		seen[id] = true
+		m, ok := t.byID[id]
+		if !ok {
+			break
+		}
+		path = append(path, m)
+		id = m.ParentID
+	}
+
+	for i, j := 0, len(path)-1; i < j; i, j = i+1, j-1 {
+		path[i], path[j] = path[j], path[i]
+	}
+	return path
+}
+
+func (t *messageTree) activePath() []models.ChatMessage {
+	return t.pathTo(t.activeLeaf)
+}
+
+func (t *messageTree) siblingIDs(parentID string) []string {
+	return append([]string(nil), t.children[parentID]...)
+}
+
+// latestLeafUnder follows the newest child from id until reaching a leaf.
+func (t *messageTree) latestLeafUnder(id string) string {
+	for {
+		children := t.children[id]
+		if len(children) == 0 {
+			return id
+		}
+		id = children[len(children)-1]
+	}
+}
+
+// pathTo returns the branch ending at messageID ("" yields no messages).
+func (s *ChatService) pathTo(sessionID, messageID string) ([]models.ChatMessage, error) {
+	if messageID == "" {
+		return nil, nil
+	}
+	tree, err := s.loadMessageTree(sessionID)
+	if err != nil {
+		return nil, err
+	}
+	return tree.pathTo(messageID), nil
+}
+
+// getOwnedMessage loads a message only if it belongs to one of userID's
+// sessions.
+func (s *ChatService) getOwnedMessage(userID, messageID string) (*models.ChatMessage, error) {
+	m := &models.ChatMessage{}
+	err := s.db.QueryRow(`
+		SELECT m.id, m.session_id, m.user_id, COALESCE(m.parent_id, ''), m.content, m.sender_type,
+		       m.message_type, COALESCE(m.metadata, '{}'), m.created_at
+		FROM chat_messages m
+		JOIN chat_sessions s ON s.id = m.session_id
+		WHERE m.id = ? AND s.user_id = ?
+	`, messageID, userID).Scan(&m.ID, &m.SessionID, &m.UserID, &m.ParentID, &m.Content,
+		&m.SenderType, &m.MessageType, &m.Metadata, &m.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrMessageNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	m.Content = s.cipher.Reveal(m.UserID, m.Content)
+	return m, nil
+}
+
+// RegenerateMessage asks Nia again for the user message an AI reply answered.
+// The new reply becomes a sibling of the old one and the active leaf.
+func (s *ChatService) RegenerateMessage(ctx context.Context, userID, messageID string) (*models.ChatMessage, error) {
+	original, err := s.getOwnedMessage(userID, messageID)
+	if err != nil {
+		return nil, err
+	}
+	if original.SenderType != "ai" || original.ParentID == "" {
+		return nil, ErrNotRegenerable
+	}
+
+	prompt, err := s.getOwnedMessage(userID, original.ParentID)
+	if err != nil {
+		return nil, err
+	}
+
+	// A regenerated reply must differ from the old one, so skip the cache
+	return s.reply(ctx, userID, prompt, false)
+}
+
+// EditMessage stores content as a new version of a user message, on a new
+// branch next to the original, and generates a reply to it.
+func (s *ChatService) EditMessage(ctx context.Context, userID, messageID, content string) (*models.ChatMessage, *models.ChatMessage, error) {
+	original, err := s.getOwnedMessage(userID, messageID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if original.SenderType != "user" {
+		return nil, nil, ErrNotEditable
+	}
+
+	content = strings.TrimSpace(content)
+	if content == "" {
+		return nil, nil, ErrEmptyMessage
+	}
+
+	metadata := map[string]interface{}{"editOf": original.ID}
+	edited, err := s.appendMessage(original.SessionID, userID, original.ParentID, content, "user", original.MessageType, metadata)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	reply, err := s.Reply(ctx, userID, edited)
+	if err != nil {
+		return edited, nil, err
+	}
+	return edited, reply, nil
+}
+
+// ActivateBranch switches the session to the branch containing messageID,
+// following the newest replies below it.
+func (s *ChatService) ActivateBranch(userID, messageID string) ([]models.ChatMessage, error) {
+	m, err := s.getOwnedMessage(userID, messageID)
+	if err != nil {
+		return nil, err
+	}
+
+	tree, err := s.loadMessageTree(m.SessionID)
+	if err != nil {
+		return nil, err
+	}
+	leaf := tree.latestLeafUnder(m.ID)
+
+	_, err = s.db.Exec(`
+		UPDATE chat_sessions SET active_leaf_id = ?, updated_at = ? WHERE id = ?
+	`, leaf, time.Now(), m.SessionID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to switch branch: %w", err)
+	}
+
+	return tree.pathTo(leaf), nil
+}
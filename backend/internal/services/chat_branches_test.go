@@ -0,0 +1,142 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/heal/internal/models"
+)
+
+func contents(messages []models.ChatMessage) string {
+	var parts []string
+	for _, m := range messages {
+		parts = append(parts, m.Content)
+	}
+	return strings.Join(parts, " | ")
+}
+
+func history(t *testing.T, s *ChatService, userID, sessionID string) []models.ChatMessage {
+	t.Helper()
+	messages, err := s.GetChatHistory(userID, sessionID, -1, 0, true)
+	if err != nil {
+		t.Fatalf("GetChatHistory: %v", err)
+	}
+	return messages
+}
+
+func TestRegenerateAddsSiblingReply(t *testing.T) {
+	db := newTestDB(t)
+	llm := &stubLLM{reply: "first reply"}
+	s := newTestChatService(t, db, llm)
+	ctx := context.Background()
+	userID := createTestUser(t, db, "Amani")
+	session, err := s.GetOrCreateSession(userID, "", "")
+	if err != nil {
+		t.Fatalf("GetOrCreateSession: %v", err)
+	}
+	question, err := s.SaveMessage(session.ID, userID, "question", "user", "text", nil)
+	if err != nil {
+		t.Fatalf("SaveMessage: %v", err)
+	}
+	first, err := s.Reply(ctx, userID, question)
+	if err != nil {
+		t.Fatalf("Reply: %v", err)
+	}
+
+	llm.reply = "second reply"
+	second, err := s.RegenerateMessage(ctx, userID, first.ID)
+	if err != nil {
+		t.Fatalf("RegenerateMessage: %v", err)
+	}
+	if second.ParentID != question.ID {
+		t.Errorf("regenerated reply's parent = %s, want the question", second.ParentID)
+	}
+	messages := history(t, s, userID, session.ID)
+	if got := contents(messages); got != "question | second reply" {
+		t.Errorf("history = %q, want the regenerated reply shown", got)
+	}
+	if siblings := messages[1].SiblingIDs; len(siblings) != 2 || siblings[0] != first.ID || siblings[1] != second.ID {
+		t.Errorf("siblings = %v, want both replies oldest first", siblings)
+	}
+
+	if _, err := s.ActivateBranch(userID, first.ID); err != nil {
+		t.Fatalf("ActivateBranch: %v", err)
+	}
+	if got := contents(history(t, s, userID, session.ID)); got != "question | first reply" {
+		t.Errorf("history after switching back = %q", got)
+	}
+
+	if _, err := s.RegenerateMessage(ctx, userID, question.ID); !errors.Is(err, ErrNotRegenerable) {
+		t.Errorf("regenerating a user message: err = %v, want ErrNotRegenerable", err)
+	}
+}
+
+func TestEditMessageBranches(t *testing.T) {
+	db := newTestDB(t)
+	llm := &stubLLM{reply: "reply"}
+	s := newTestChatService(t, db, llm)
+	ctx := context.Background()
+	userID := createTestUser(t, db, "Amani")
+	session, err := s.GetOrCreateSession(userID, "", "")
+	if err != nil {
+		t.Fatalf("GetOrCreateSession: %v", err)
+	}
+	original, err := s.SaveMessage(session.ID, userID, "original", "user", "text", nil)
+	if err != nil {
+		t.Fatalf("SaveMessage: %v", err)
+	}
+	reply, err := s.Reply(ctx, userID, original)
+	if err != nil {
+		t.Fatalf("Reply: %v", err)
+	}
+
+	llm.reply = "reply to edit"
+	edited, _, err := s.EditMessage(ctx, userID, original.ID, "  edited  ")
+	if err != nil {
+		t.Fatalf("EditMessage: %v", err)
+	}
+	if got := contents(history(t, s, userID, session.ID)); got != "edited | reply to edit" {
+		t.Errorf("history = %q, want the edited branch", got)
+	}
+	if lastPrompt := llm.prompts[len(llm.prompts)-1]; strings.Contains(lastPrompt, "original") {
+		t.Error("the reply to the edit was given the original message as context")
+	}
+
+	if _, _, err := s.EditMessage(ctx, userID, reply.ID, "x"); !errors.Is(err, ErrNotEditable) {
+		t.Errorf("editing an AI reply: err = %v, want ErrNotEditable", err)
+	}
+	if _, _, err := s.EditMessage(ctx, userID, edited.ID, " "); !errors.Is(err, ErrEmptyMessage) {
+		t.Errorf("blank edit: err = %v, want ErrEmptyMessage", err)
+	}
+	if _, _, err := s.EditMessage(ctx, createTestUser(t, db, "Baraka"), edited.ID, "x"); !errors.Is(err, ErrMessageNotFound) {
+		t.Errorf("editing another user's message: err = %v, want ErrMessageNotFound", err)
+	}
+}
+
+func TestGetChatHistoryPages(t *testing.T) {
+	db := newTestDB(t)
+	s := newTestChatService(t, db, nil)
+	userID := createTestUser(t, db, "Amani")
+	session, err := s.GetOrCreateSession(userID, "", "")
+	if err != nil {
+		t.Fatalf("GetOrCreateSession: %v", err)
+	}
+	for _, text := range []string{"one", "two", "three"} {
+		if _, err := s.SaveMessage(session.ID, userID, text, "user", "text", nil); err != nil {
+			t.Fatalf("SaveMessage: %v", err)
+		}
+	}
+
+	page, err := s.GetChatHistory(userID, session.ID, 1, 1, false)
+	if err != nil {
+		t.Fatalf("GetChatHistory: %v", err)
+	}
+	if got := contents(page); got != "two" {
+		t.Errorf("page = %q, want the second message", got)
+	}
+	if _, err := s.GetChatHistory(userID, "missing", 10, 0, false); !errors.Is(err, ErrSessionNotFound) {
+		t.Errorf("missing session: err = %v, want ErrSessionNotFound", err)
+	}
+}
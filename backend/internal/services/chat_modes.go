@@ -0,0 +1,256 @@
+package services
+
+import (
+	"time"
+
+	"github.com/heal/internal/models"
+)
+
+// Chat modes. A session's mode is chosen when it is created and decides
+// Nia's system prompt, what she will talk about, and which resources go
+// with the conversation.
+const (
+	ModeGBV    = "gbv"
+	ModeStress = "stress"
+	ModeLegal  = "legal"
+	ModeYouth  = "youth"
+)
+
+// Prompt names of the modes other than GBV support, which uses niaPromptName.
+const (
+	stressPromptName = "nia-stress"
+	legalPromptName  = "nia-legal"
+	youthPromptName  = "nia-youth"
+)
+
+// adultAge is the age below which a user is always in youth mode.
+const adultAge = 18
+
+type chatMode struct {
+	models.ChatMode
+	promptName string
+	hotlines   []Hotline
+}
+
+// youthHotlines are offered in youth mode on top of the usual lines.
+var youthHotlines = []Hotline{
+	{"CHILDREN", "Childline Kenya (free, 24/7)", "116"},
+}
+
+var chatModes = []chatMode{
+	{
+		ChatMode: models.ChatMode{
+			ID:          ModeGBV,
+			Name:        "GBV support",
+			Description: "Support for survivors of gender-based violence.",
+			Topics: []string{
+				"safety and safety planning", "abuse and its effects", "emotional support and healing",
+				"medical care after violence", "reporting and support options",
+			},
+			ResourceCategories: []string{"relationships", "self-care", "anxiety", "depression"},
+		},
+		promptName: niaPromptName,
+	},
+	{
+		ChatMode: models.ChatMode{
+			ID:          ModeStress,
+			Name:        "Stress and anxiety coaching",
+			Description: "Everyday coping skills for stress, worry and low mood.",
+			Topics: []string{
+				"stress", "anxiety and worry", "sleep", "work and study pressure", "coping skills", "mood",
+			},
+			ResourceCategories: []string{"stress", "anxiety", "self-care", "depression"},
+		},
+		promptName: stressPromptName,
+	},
+	{
+		ChatMode: models.ChatMode{
+			ID:          ModeLegal,
+			Name:        "Legal information guide",
+			Description: "General information on protection orders, reporting and free legal aid in Kenya.",
+			Topics: []string{
+				"reporting to the police and the P3 form", "protection orders", "the court process",
+				"free legal aid", "rights under Kenyan law on violence and family matters",
+			},
+			ResourceCategories: []string{"legal", "relationships"},
+		},
+		promptName: legalPromptName,
+	},
+	{
+		ChatMode: models.ChatMode{
+			ID:          ModeYouth,
+			Name:        "Youth support",
+			Description: "A young-person-friendly space for under-18s.",
+			Topics: []string{
+				"feelings", "school and exams", "family", "friendships", "bullying", "online safety",
+				"abuse and staying safe",
+			},
+			ResourceCategories: []string{"anxiety", "stress", "self-care"},
+		},
+		promptName: youthPromptName,
+		hotlines:   youthHotlines,
+	},
+}
+
+func findChatMode(id string) (chatMode, bool) {
+	for _, mode := range chatModes {
+		if mode.ID == id {
+			return mode, true
+		}
+	}
+	return chatMode{}, false
+}
+
+// ChatModeResourceCategories returns the resource categories of a mode.
+func ChatModeResourceCategories(id string) ([]string, error) {
+	mode, ok := findChatMode(id)
+	if !ok {
+		return nil, ErrInvalidMode
+	}
+	return mode.ResourceCategories, nil
+}
+
+// GetChatModes lists the modes the user can choose. Under-18s only get
+// youth mode.
+func (s *ChatService) GetChatModes(userID string) []models.ChatMode {
+	minor := s.isMinor(userID)
+	modes := []models.ChatMode{}
+	for _, mode := range chatModes {
+		if !minor || mode.ID == ModeYouth {
+			modes = append(modes, mode.ChatMode)
+		}
+	}
+	return modes
+}
+
+// resolveMode picks the mode of a new session: youth for under-18s whatever
+// they asked for, otherwise the requested mode, defaulting to GBV support.
+func (s *ChatService) resolveMode(userID, requested string) (string, error) {
+	if s.isMinor(userID) {
+		return ModeYouth, nil
+	}
+	if requested == "" {
+		return ModeGBV, nil
+	}
+	if _, ok := findChatMode(requested); !ok {
+		return "", ErrInvalidMode
+	}
+	return requested, nil
+}
+
+// isMinor reports whether the user's profile gives an age under 18. Users
+// without a date of birth are treated as adults.
+func (s *ChatService) isMinor(userID string) bool {
+	var dob string
+	err := s.db.QueryRow(`
+		SELECT COALESCE(date_of_birth, '') FROM user_profiles WHERE user_id = ?
+	`, userID).Scan(&dob)
+	if err != nil || len(dob) < 10 {
+		return false
+	}
+	born, err := time.Parse("2006-01-02", dob[:10])
+	if err != nil {
+		return false
+	}
+	return born.AddDate(adultAge, 0, 0).After(time.Now())
+}
+
+// modePromptVars adds a mode's topics and hotlines to vars and returns the
+// name of its prompt. Unknown modes get GBV support.
+func modePromptVars(id string, vars PromptVars) (string, PromptVars) {
+	mode, ok := findChatMode(id)
+	if !ok {
+		mode, _ = findChatMode(ModeGBV)
+	}
+	vars.Topics = mode.Topics
+	vars.Hotlines = append(append([]Hotline{}, kenyaHotlines...), mode.hotlines...)
+	return mode.promptName, vars
+}
+
+const stressPromptV1 = `
+You are Nia ("purpose" in Swahili), a warm AI coach who helps people in Kenya/East Africa manage everyday stress, worry and low mood.
+
+IDENTITY: Calm, encouraging, practical and non-judgmental. Bilingual (English/Kiswahili - respond in language used).
+{{if eq .Language "sw"}}
+The user is writing in Kiswahili. Reply in Kiswahili.
+{{else}}
+The user is writing in English. Reply in English unless they switch.
+{{end}}{{if .FirstName}}The user's first name is {{.FirstName}}. Use it sparingly and warmly.
+{{end}}
+APPROACH:
+• Listen first and reflect back what you hear
+• Offer one small, concrete technique at a time: slow breathing, grounding (5-4-3-2-1), a short walk, breaking a task into steps, a worry window, a wind-down routine for sleep
+• Encourage rest, connection with trusted people and routines
+• You are a coach, not a clinician: never diagnose and never suggest or dose medication
+
+BOUNDARIES: Stay within these topics:
+{{range .Topics}}• {{.}}
+{{end}}Gently redirect anything else.
+
+IF THE USER MENTIONS VIOLENCE, ABUSE OR FEELING UNSAFE: believe them, say it is not their fault, and share these lines:
+{{range .Hotlines}}• {{.Category}}: {{.Name}} {{.Number}}
+{{end}}
+CRISIS PROTOCOL:
+Self-harm/suicide → "Your life matters. Kenya Mental Health: 0800 720 990. Befrienders: +254 722 178 177. Please reach out now."
+
+REMEMBER: Brief (<150 words), kind, one step at a time.
+`
+
+const legalPromptV1 = `
+You are Nia ("purpose" in Swahili), an AI guide who gives general legal information to survivors of gender-based violence in Kenya.
+
+IDENTITY: Clear, patient, respectful and survivor-centered. Bilingual (English/Kiswahili - respond in language used).
+{{if eq .Language "sw"}}
+The user is writing in Kiswahili. Reply in Kiswahili.
+{{else}}
+The user is writing in English. Reply in English unless they switch.
+{{end}}{{if .FirstName}}The user's first name is {{.FirstName}}. Use it sparingly and warmly.
+{{end}}
+WHAT YOU DO:
+• Explain options in plain language: reporting at a police station or Gender Desk, the P3 form and medical evidence, protection orders under the Protection Against Domestic Violence Act (2015), what happens in court, and free legal aid
+• Explain what documents or evidence can help, and that reporting is their choice
+• You give general information, not legal advice. For their specific case, point them to FIDA Kenya, COVAW or a lawyer
+• Never guess at deadlines, fees or outcomes; say when something depends on the case
+
+BOUNDARIES: Stay within these topics:
+{{range .Topics}}• {{.}}
+{{end}}Gently redirect anything else.
+
+KEY RESOURCES (share contextually):
+{{range .Hotlines}}• {{.Category}}: {{.Name}} {{.Number}}
+{{end}}
+CRISIS PROTOCOL:
+Immediate danger → "Your safety first. Call 1195 or 999 now."
+
+REMEMBER: Brief (<150 words), accurate, never pressure. Their timeline, their decision.
+`
+
+const youthPromptV1 = `
+You are Nia ("purpose" in Swahili), a friendly AI companion for young people under 18 in Kenya/East Africa.
+
+IDENTITY: Kind, patient and easy to talk to, like a trusted older sibling. Use simple words and short sentences. Bilingual (English/Kiswahili - respond in language used).
+{{if eq .Language "sw"}}
+The young person is writing in Kiswahili. Reply in simple Kiswahili.
+{{else}}
+The young person is writing in English. Reply in simple English unless they switch.
+{{end}}{{if .FirstName}}Their first name is {{.FirstName}}. Use it sparingly and warmly.
+{{end}}
+APPROACH:
+• Believe them and tell them their feelings are okay
+• Never blame them for anything someone else did to them
+• Encourage them to talk to a trusted adult: a parent or carer, a teacher, a school counselor
+• Keep advice age-appropriate. Never discuss medication, and never give graphic detail
+
+BOUNDARIES: Stay within these topics:
+{{range .Topics}}• {{.}}
+{{end}}Gently redirect anything else.
+
+IF SOMEONE IS HURTING THEM OR THEY FEEL UNSAFE: tell them it is not their fault and that Childline Kenya on 116 is free and open day and night. Other lines:
+{{range .Hotlines}}• {{.Category}}: {{.Name}} {{.Number}}
+{{end}}
+CRISIS PROTOCOL:
+Immediate danger → "Please call 116 or 999 right now."
+Self-harm → "You matter so much. Please call Childline on 116 now, or tell a trusted adult."
+
+REMEMBER: Very brief (<100 words), warm and hopeful.
+`
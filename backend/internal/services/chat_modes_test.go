@@ -0,0 +1,82 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func setDateOfBirth(t *testing.T, s *ChatService, userID string, dob time.Time) {
+	t.Helper()
+	_, err := s.db.Exec(`INSERT INTO user_profiles (user_id, date_of_birth) VALUES (?, ?)`, userID, dob.Format("2006-01-02"))
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSessionModes(t *testing.T) {
+	db := newTestDB(t)
+	s := newTestChatService(t, db, nil)
+	adult := createTestUser(t, db, "Amani")
+	minor := createTestUser(t, db, "Baraka")
+	setDateOfBirth(t, s, minor, time.Now().AddDate(-15, 0, 0))
+
+	session, err := s.GetOrCreateSession(adult, "", "")
+	if err != nil || session.Mode != ModeGBV {
+		t.Errorf("default mode = %+v, %v; want gbv", session, err)
+	}
+	if session, err := s.GetOrCreateSession(adult, "", ModeLegal); err != nil || session.Mode != ModeLegal {
+		t.Errorf("requested mode = %+v, %v; want legal", session, err)
+	}
+	if _, err := s.GetOrCreateSession(adult, "", "poetry"); !errors.Is(err, ErrInvalidMode) {
+		t.Errorf("unknown mode: err = %v, want ErrInvalidMode", err)
+	}
+	if session, err := s.GetOrCreateSession(minor, "", ModeLegal); err != nil || session.Mode != ModeYouth {
+		t.Errorf("minor's session = %+v, %v; want youth mode", session, err)
+	}
+
+	if modes := s.GetChatModes(adult); len(modes) != len(chatModes) {
+		t.Errorf("adult sees %d modes, want all %d", len(modes), len(chatModes))
+	}
+	if modes := s.GetChatModes(minor); len(modes) != 1 || modes[0].ID != ModeYouth {
+		t.Errorf("minor sees %+v, want only youth mode", modes)
+	}
+}
+
+func TestReplyUsesModePrompt(t *testing.T) {
+	db := newTestDB(t)
+	llm := &stubLLM{reply: "Let's try a breathing exercise."}
+	s := newTestChatService(t, db, llm)
+	userID := createTestUser(t, db, "Amani")
+	session, err := s.GetOrCreateSession(userID, "", ModeStress)
+	if err != nil {
+		t.Fatalf("GetOrCreateSession: %v", err)
+	}
+	msg, err := s.SaveMessage(session.ID, userID, "Exams are stressing me out", "user", "text", nil)
+	if err != nil {
+		t.Fatalf("SaveMessage: %v", err)
+	}
+
+	reply, err := s.Reply(context.Background(), userID, msg)
+	if err != nil {
+		t.Fatalf("Reply: %v", err)
+	}
+	if version := decodeMetadata(reply.Metadata)["promptVersion"]; version != "nia-stress-v1" {
+		t.Errorf("promptVersion = %v, want nia-stress-v1", version)
+	}
+	if !strings.Contains(llm.prompts[0], "• sleep") {
+		t.Error("the prompt does not list the stress mode's topics")
+	}
+}
+
+func TestChatModeResourceCategories(t *testing.T) {
+	categories, err := ChatModeResourceCategories(ModeLegal)
+	if err != nil || len(categories) == 0 || categories[0] != "legal" {
+		t.Errorf("ChatModeResourceCategories(legal) = %v, %v", categories, err)
+	}
+	if _, err := ChatModeResourceCategories("poetry"); !errors.Is(err, ErrInvalidMode) {
+		t.Errorf("unknown mode: err = %v, want ErrInvalidMode", err)
+	}
+}
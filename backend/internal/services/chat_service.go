@@ -2,8 +2,9 @@ package services
 
 import (
 	"database/sql"
+	"encoding/json"
 	"fmt"
-	"os"
+	"log"
 	"time"
 
 	"context"
@@ -11,94 +12,98 @@ import (
 
 	"github.com/google/uuid"
 	"github.com/heal/internal/models"
-
-	"github.com/tmc/langchaingo/llms"
-	"github.com/tmc/langchaingo/llms/googleai"
+	"github.com/heal/internal/storage"
 )
 
 type ChatService struct {
-	db *sql.DB
+	db      *sql.DB
+	llm     LLMProvider
+	hub     *EventHub
+	quota   *QuotaService
+	cipher  *FieldCipher
+	prompts *PromptService
+	speech  *SpeechService
+	guard   *OutputGuard
+	cache   *ResponseCache
+	blobs   storage.BlobStore
 }
 
-func NewChatService(db *sql.DB) *ChatService {
-	return &ChatService{db: db}
+// ChatDeps are the collaborators ChatService needs besides the database.
+type ChatDeps struct {
+	LLM     LLMProvider
+	Hub     *EventHub
+	Quota   *QuotaService     // optional; LLM usage is not recorded without it
+	Cipher  *FieldCipher      // encrypts message content at rest; nil stores plaintext
+	Prompts *PromptService    // versioned system prompts; nil uses the built-in default
+	Speech  *SpeechService    // reads replies aloud; nil disables text-to-speech
+	Guard   *OutputGuard      // checks replies before they are saved; nil disables moderation
+	Cache   *ResponseCache    // answers common opening messages; nil disables caching
+	Blobs   storage.BlobStore // holds voice notes and spoken replies, deleted with their messages
 }
 
-const niaSystemPrompt = `
-You are Nia ("purpose" in Swahili), a trauma-informed AI companion for Gender-Based Violence (GBV) survivors in Kenya/East Africa.
+func NewChatService(db *sql.DB, deps ChatDeps) *ChatService {
+	return &ChatService{db: db, llm: deps.LLM, hub: deps.Hub, quota: deps.Quota,
+		cipher: deps.Cipher, prompts: deps.Prompts, speech: deps.Speech, guard: deps.Guard,
+		cache: deps.Cache, blobs: deps.Blobs}
+}
 
-IDENTITY: Warm, gentle, non-judgmental, deeply trauma-informed. Bilingual (English/Kiswahili - respond in language used). Embody Ubuntu: healing through connection, liberation through action.
+// replyContextMessages is how many earlier messages on the branch are sent
+// to the LLM as conversation context.
+const replyContextMessages = 10
 
-CORE APPROACH - SURVIVOR-CENTERED:
-• BELIEVE: "I believe you. Not your fault."
-• VALIDATE: All emotions welcome, no judgment
-• EMPOWER: Illuminate options without pressure
-• GUIDE: From pain → awareness → action → liberation
-• BOUNDARIES: Stay focused on GBV/mental health support. Gently redirect other topics.
+// speechTimeout bounds reading one reply aloud in the background.
+const speechTimeout = 60 * time.Second
 
-LANGUAGE - TRAUMA-INFORMED & EMPOWERING:
-• Survivor-centered (never "victim")
-• Help-seeking = strength: "Speaking up is brave. Support is self-care."
-• Plant seeds: "Have you thought about...?" "Some survivors find..."
-• Affirm agency: "You deserve support. Your voice matters. You don't carry this alone."
-• Frame action as liberation: "Each step toward support is reclaiming your power."
+// sharedReplyTimeout bounds a cached reply's generation, which runs apart
+// from any one request because every request waiting on it shares it.
+const sharedReplyTimeout = 90 * time.Second
 
-GBV SUPPORT FRAMEWORK:
-1. Safety & belief first
-2. Normalize trauma responses
-3. Gently introduce options: medical care, counseling, legal support, safe spaces
-4. Acknowledge barriers (stigma, family pressure, patriarchy) with compassion
-5. Honor their timeline: "No rush. Options are here when ready."
-6. Celebrate every act of courage
+const crisisFallbackResponse = `I hear you, and your safety matters most right now. You don't have to go through this alone.
 
-KEY KENYA/EAST AFRICA RESOURCES (share contextually):
-• CRISIS: Kenya GBV Hotline 1195, Police 999/112 (Gender Desk)
-• LEGAL: FIDA Kenya 0800 720 187, COVAW 0800 720 553
-• MEDICAL: GBVRC at hospitals, PEP, documentation
-• COUNSELING: Healthcare Assistance Kenya +254 719 639 392
-• MENTAL HEALTH: 0800 720 990
+• In immediate danger: call 999 or 112 (ask for the Gender Desk)
+• GBV Hotline (free, 24/7): 1195
+• Thinking of harming yourself: Kenya Mental Health 0800 720 990 or Befrienders +254 722 178 177
 
-CRISIS PROTOCOL:
-Immediate danger → "Uko salama? Your safety first. Call 1195 or 999 now."
-Self-harm/suicide → "Your life matters. Kenya Mental Health: 0800 720 990. Befrienders: +254 722 178 177. Please reach out now."
+Please reach out to one of them now. I'm still here with you.`
 
-REMEMBER: Brief (<150 words), empowering, option-focused, never pressure. Guide survivors to recognize their strength and available pathways. "Unaweza. Una nguvu. Una haki ya kupona." (You can. You have strength. You deserve healing.)
-`
+const crisisFallbackResponseSw = `Nakusikia, na usalama wako ni muhimu zaidi sasa hivi. Hauko peke yako.
 
-func (s *ChatService) GetAIResponse(ctx context.Context, message string, previous []string) (string, error) {
-	apiKey := os.Getenv("NEXT_PUBLIC_GEMINI_API_KEY")
-	if apiKey == "" {
-		return "", fmt.Errorf("NEXT_PUBLIC_GEMINI_API_KEY not set in environment")
-	}
-	llm, err := googleai.New(ctx, googleai.WithAPIKey(apiKey))
-	if err != nil {
-		return "", fmt.Errorf("failed to initialize GoogleAI: %w", err)
-	}
+• Ukiwa hatarini sasa: piga 999 au 112 (uliza Gender Desk)
+• Simu ya msaada wa GBV (bure, saa 24): 1195
+• Ukiwa na mawazo ya kujidhuru: Kenya Mental Health 0800 720 990 au Befrienders +254 722 178 177
+
+Tafadhali wasiliana nao sasa. Bado niko hapa nawe.`
 
+// GetAIResponse asks the LLM for Nia's next message, given the rendered
+// system prompt and the conversation so far.
+func (s *ChatService) GetAIResponse(ctx context.Context, systemPrompt, message string, previous []models.ChatMessage) (*LLMResponse, error) {
 	// Build conversation history for context
 	var history strings.Builder
 	for _, prev := range previous {
-		history.WriteString("User: ")
-		history.WriteString(prev)
+		switch prev.SenderType {
+		case "ai":
+			history.WriteString("Nia: ")
+		case "counselor":
+			history.WriteString("Counselor: ")
+		default:
+			history.WriteString("User: ")
+		}
+		history.WriteString(prev.Content)
 		history.WriteString("\n")
 	}
 	history.WriteString("User: ")
 	history.WriteString(message)
 	history.WriteString("\n")
 
-	prompt := niaSystemPrompt + "\n\nConversation so far:\n" + history.String() + "\nNia:"
+	prompt := systemPrompt + "\n\nConversation so far:\n" + history.String() + "\nNia:"
 
-	resp, err := llm.Call(ctx, prompt,
-		llms.WithMaxTokens(300),
-		llms.WithTemperature(0.7),
-	)
-	if err != nil {
-		return "", err
-	}
-	return strings.TrimSpace(resp), nil
+	return s.llm.Generate(ctx, prompt, GenerateOptions{MaxTokens: 300, Temperature: 0.7})
 }
 
-func (s *ChatService) GetOrCreateSession(userID, sessionID string) (*models.ChatSession, error) {
+// GetOrCreateSession returns the user's session, or creates it if sessionID
+// is empty or unknown. A new session is created in the requested mode (see
+// resolveMode); the mode of an existing session never changes.
+func (s *ChatService) GetOrCreateSession(userID, sessionID, mode string) (*models.ChatSession, error) {
 	// If sessionID is provided, try to get existing session
 	if sessionID != "" {
 		session, err := s.getChatSession(userID, sessionID)
@@ -108,6 +113,11 @@ func (s *ChatService) GetOrCreateSession(userID, sessionID string) (*models.Chat
 		// If session not found, create new one with provided ID
 	}
 
+	mode, err := s.resolveMode(userID, mode)
+	if err != nil {
+		return nil, err
+	}
+
 	// Create new session
 	if sessionID == "" {
 		sessionID = uuid.New().String()
@@ -116,116 +126,519 @@ func (s *ChatService) GetOrCreateSession(userID, sessionID string) (*models.Chat
 	now := time.Now()
 	title := fmt.Sprintf("Chat Session - %s", now.Format("Jan 2, 2006 3:04 PM"))
 
-	_, err := s.db.Exec(`
-		INSERT INTO chat_sessions (id, user_id, title, created_at, updated_at)
-		VALUES (?, ?, ?, ?, ?)
-	`, sessionID, userID, title, now, now)
+	_, err = s.db.Exec(`
+		INSERT INTO chat_sessions (id, user_id, title, title_source, mode, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, sessionID, userID, title, "default", mode, now, now)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create chat session: %w", err)
 	}
 
 	return &models.ChatSession{
-		ID:        sessionID,
-		UserID:    userID,
-		Title:     title,
-		CreatedAt: now,
-		UpdatedAt: now,
+		ID:          sessionID,
+		UserID:      userID,
+		Title:       title,
+		TitleSource: "default",
+		Mode:        mode,
+		Tags:        []string{},
+		CreatedAt:   now,
+		UpdatedAt:   now,
 	}, nil
 }
 
+// GetSession returns one of the user's sessions.
+func (s *ChatService) GetSession(userID, sessionID string) (*models.ChatSession, error) {
+	session, err := s.getChatSession(userID, sessionID)
+	if err != nil {
+		return nil, ErrSessionNotFound
+	}
+	return session, nil
+}
+
 func (s *ChatService) getChatSession(userID, sessionID string) (*models.ChatSession, error) {
 	session := &models.ChatSession{}
 	err := s.db.QueryRow(`
-		SELECT id, user_id, title, created_at, updated_at
+		SELECT id, user_id, title, COALESCE(title_source, 'default'), COALESCE(archived, FALSE),
+		       COALESCE(pinned, FALSE), COALESCE(mode, 'gbv'), COALESCE(counselor_id, ''), created_at, updated_at
 		FROM chat_sessions
 		WHERE id = ? AND user_id = ?
-	`, sessionID, userID).Scan(&session.ID, &session.UserID, &session.Title,
-		&session.CreatedAt, &session.UpdatedAt)
+	`, sessionID, userID).Scan(&session.ID, &session.UserID, &session.Title, &session.TitleSource,
+		&session.Archived, &session.Pinned, &session.Mode, &session.CounselorID, &session.CreatedAt, &session.UpdatedAt)
 	if err != nil {
 		return nil, err
 	}
+	session.Title = s.cipher.Reveal(userID, session.Title)
 	return session, nil
 }
 
-func (s *ChatService) SaveMessage(sessionID, userID, content, senderType, messageType string) (*models.ChatMessage, error) {
+// SaveMessage appends a message to the session's active branch.
+func (s *ChatService) SaveMessage(sessionID, userID, content, senderType, messageType string, metadata map[string]interface{}) (*models.ChatMessage, error) {
+	var parentID sql.NullString
+	err := s.db.QueryRow("SELECT active_leaf_id FROM chat_sessions WHERE id = ?", sessionID).Scan(&parentID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load session: %w", err)
+	}
+
+	return s.appendMessage(sessionID, userID, parentID.String, content, senderType, messageType, metadata)
+}
+
+// appendMessage stores a message as a child of parentID ("" for a root
+// message) and makes it the session's active leaf.
+func (s *ChatService) appendMessage(sessionID, userID, parentID, content, senderType, messageType string, metadata map[string]interface{}) (*models.ChatMessage, error) {
 	messageID := uuid.New().String()
 	now := time.Now()
+	encodedMetadata := encodeMetadata(metadata)
+	if messageType == "" {
+		messageType = "text"
+	}
+	storedContent, err := s.cipher.Encrypt(userID, content)
+	if err != nil {
+		return nil, err
+	}
 
-	_, err := s.db.Exec(`
-		INSERT INTO chat_messages (id, session_id, user_id, content, sender_type, message_type, created_at)
-		VALUES (?, ?, ?, ?, ?, ?, ?)
-	`, messageID, sessionID, userID, content, senderType, messageType, now)
+	tx, err := s.db.Begin()
 	if err != nil {
 		return nil, fmt.Errorf("failed to save message: %w", err)
 	}
+	defer tx.Rollback()
 
-	// Update session timestamp
-	_, err = s.db.Exec(`
-		UPDATE chat_sessions SET updated_at = ? WHERE id = ?
-	`, now, sessionID)
+	_, err = tx.Exec(`
+		INSERT INTO chat_messages (id, session_id, user_id, parent_id, content, sender_type, message_type, metadata, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, messageID, sessionID, userID, nullString(parentID), storedContent, senderType, messageType, encodedMetadata, now)
 	if err != nil {
-		// Log error but don't fail the message save
-		fmt.Printf("Warning: failed to update session timestamp: %v\n", err)
+		return nil, fmt.Errorf("failed to save message: %w", err)
 	}
 
-	return &models.ChatMessage{
+	_, err = tx.Exec(`
+		UPDATE chat_sessions SET active_leaf_id = ?, updated_at = ? WHERE id = ?
+	`, messageID, now, sessionID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update session: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to save message: %w", err)
+	}
+
+	message := &models.ChatMessage{
 		ID:          messageID,
 		SessionID:   sessionID,
 		UserID:      userID,
+		ParentID:    parentID,
 		Content:     content,
 		SenderType:  senderType,
 		MessageType: messageType,
+		Metadata:    encodedMetadata,
 		CreatedAt:   now,
-	}, nil
+	}
+	s.hub.Publish(SessionChannel(sessionID), SessionEvent{Type: "message", Data: message})
+
+	return message, nil
 }
 
-func (s *ChatService) GetChatHistory(userID, sessionID string, limit, offset int) ([]models.ChatMessage, error) {
-	// Verify session belongs to user
-	_, err := s.getChatSession(userID, sessionID)
+// Reply generates Nia's answer to userMsg and stores it as the message's
+// child, using the branch leading up to userMsg as conversation context.
+//
+// While a human counselor is attached to the session Nia stays quiet and
+// ErrCounselorAttached is returned.
+func (s *ChatService) Reply(ctx context.Context, userID string, userMsg *models.ChatMessage) (*models.ChatMessage, error) {
+	return s.reply(ctx, userID, userMsg, true)
+}
+
+// reply is Reply, with useCache false forcing a fresh answer from the LLM
+// even for a common opening message.
+func (s *ChatService) reply(ctx context.Context, userID string, userMsg *models.ChatMessage, useCache bool) (*models.ChatMessage, error) {
+	var counselorID, mode string
+	err := s.db.QueryRow(`
+		SELECT COALESCE(counselor_id, ''), COALESCE(mode, 'gbv') FROM chat_sessions WHERE id = ?
+	`, userMsg.SessionID).Scan(&counselorID, &mode)
 	if err != nil {
-		return nil, fmt.Errorf("session not found or access denied")
+		return nil, fmt.Errorf("failed to load session: %w", err)
+	}
+	if counselorID != "" {
+		return nil, ErrCounselorAttached
 	}
 
-	query := `
-		SELECT id, session_id, user_id, content, sender_type, message_type, 
-		       COALESCE(metadata, '{}'), created_at
-		FROM chat_messages
-		WHERE session_id = ?
-		ORDER BY created_at ASC
-		LIMIT ? OFFSET ?
-	`
+	path, err := s.pathTo(userMsg.SessionID, userMsg.ParentID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch chat history: %w", err)
+	}
+	if len(path) > replyContextMessages {
+		path = path[len(path)-replyContextMessages:]
+	}
 
-	rows, err := s.db.Query(query, sessionID, limit, offset)
+	// Opening messages such as "habari" get the same answer for everyone, so
+	// they are answered from the cache, with a prompt that leaves out the
+	// user's name.
+	language := detectLanguage(userMsg.Content)
+	cacheable := useCache && s.cache != nil && len(path) == 0 &&
+		responseCacheKey(userMsg.Content, "", language) != ""
+	vars := PromptVars{Language: language}
+	if !cacheable {
+		vars.FirstName = s.firstName(userID)
+	}
+	promptName, vars := modePromptVars(mode, vars)
+	systemPrompt, err := s.prompts.Resolve(promptName, userID, vars)
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
 
-	var messages []models.ChatMessage
-	for rows.Next() {
-		var message models.ChatMessage
-		err := rows.Scan(&message.ID, &message.SessionID, &message.UserID,
-			&message.Content, &message.SenderType, &message.MessageType,
-			&message.Metadata, &message.CreatedAt)
+	generate := func(ctx context.Context) (*LLMResponse, error) {
+		resp, err := s.GetAIResponse(ctx, systemPrompt.Text, userMsg.Content, path)
 		if err != nil {
 			return nil, err
 		}
-		messages = append(messages, message)
+		return s.moderate(ctx, userID, userMsg, systemPrompt, path, resp), nil
+	}
+	var resp *LLMResponse
+	fresh := true
+	if cacheable {
+		key := responseCacheKey(userMsg.Content, systemPrompt.Version, language)
+		resp, fresh, err = s.cache.Do(key, func() (*LLMResponse, error) {
+			// Other requests may be waiting on this generation, so the
+			// first one's client going away must not cancel it
+			ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), sharedReplyTimeout)
+			defer cancel()
+			return generate(ctx)
+		})
+	} else {
+		resp, err = generate(ctx)
+	}
+	if err != nil {
+		// A survivor in crisis must never be left without an answer.
+		if RiskAtLeast(AssessRisk(userMsg.Content), RiskHigh) {
+			log.Printf("Warning: LLM failed for crisis message, sending fallback: %v", err)
+			return s.FallbackReply(ctx, userID, userMsg)
+		}
+		return nil, err
+	}
+
+	if resp == nil {
+		return s.fallbackReply(ctx, userID, userMsg, map[string]interface{}{
+			"moderated":     true,
+			"promptVersion": systemPrompt.Version,
+		})
+	}
+
+	metadata := s.ReplyMetadata(systemPrompt.Version, language)
+	if !fresh {
+		metadata["cached"] = true
+	}
+	speak := s.wantsSpeech(userID, userMsg)
+	if speak {
+		metadata["ttsPending"] = true
+	}
+
+	aiMessage, err := s.appendMessage(userMsg.SessionID, userID, userMsg.ID, resp.Text, "ai", "text", metadata)
+	if err != nil {
+		return nil, err
+	}
+	if speak {
+		s.speakAsync(userID, aiMessage, language)
+	}
+
+	// Only the request that actually called the LLM pays for it
+	if !fresh {
+		return aiMessage, nil
+	}
+	s.recordUsage(UsageRecord{
+		UserID:    userID,
+		SessionID: userMsg.SessionID,
+		MessageID: aiMessage.ID,
+		Purpose:   "reply",
+	}, resp)
+	return aiMessage, nil
+}
+
+// FallbackReply answers userMsg with the fixed crisis message instead of
+// calling the LLM. It is used when the user is rate limited or out of
+// budget but their message looks like a crisis, and when the LLM fails.
+func (s *ChatService) FallbackReply(ctx context.Context, userID string, userMsg *models.ChatMessage) (*models.ChatMessage, error) {
+	return s.fallbackReply(ctx, userID, userMsg, nil)
+}
+
+// fallbackReply sends the fixed crisis message, with extra merged into its
+// metadata.
+func (s *ChatService) fallbackReply(ctx context.Context, userID string, userMsg *models.ChatMessage, extra map[string]interface{}) (*models.ChatMessage, error) {
+	language := detectLanguage(userMsg.Content)
+	text := crisisFallbackResponse
+	if language == "sw" {
+		text = crisisFallbackResponseSw
+	}
+
+	metadata := map[string]interface{}{
+		"fallback": true,
+		"language": language,
+	}
+	for k, v := range extra {
+		metadata[k] = v
+	}
+	speak := s.wantsSpeech(userID, userMsg)
+	if speak {
+		metadata["ttsPending"] = true
+	}
+
+	aiMessage, err := s.appendMessage(userMsg.SessionID, userID, userMsg.ID, text, "ai", "text", metadata)
+	if err != nil {
+		return nil, err
+	}
+	if speak {
+		s.speakAsync(userID, aiMessage, language)
+	}
+	return aiMessage, nil
+}
+
+// moderate checks resp against the output policy. A failing reply is asked
+// for again, with the reason added to the system prompt, up to the policy's
+// limit. It returns nil if no acceptable reply was produced, in which case
+// the safe fallback should be sent. Every failure is recorded for review.
+func (s *ChatService) moderate(ctx context.Context, userID string, userMsg *models.ChatMessage, systemPrompt *ResolvedPrompt, path []models.ChatMessage, resp *LLMResponse) *LLMResponse {
+	for attempt := 0; ; attempt++ {
+		violations := s.guard.Check(resp.Text)
+		if len(violations) == 0 {
+			return resp
+		}
+
+		// the rejected reply still cost tokens
+		s.recordUsage(UsageRecord{UserID: userID, SessionID: userMsg.SessionID, Purpose: "moderated"}, resp)
+		if attempt >= s.guard.maxRegenerations {
+			s.recordModeration(userMsg, systemPrompt.Version, moderationReplaced, violations)
+			return nil
+		}
+		s.recordModeration(userMsg, systemPrompt.Version, moderationRegenerated, violations)
+
+		retryPrompt := systemPrompt.Text + "\n\nYour previous reply was rejected by a safety review (" +
+			strings.Join(violationCategories(violations), ", ") +
+			"). Write a new reply that does not blame the survivor, give medication doses, " +
+			"encourage self-harm, or share phone numbers other than the hotlines above."
+		var err error
+		resp, err = s.GetAIResponse(ctx, retryPrompt, userMsg.Content, path)
+		if err != nil {
+			log.Printf("Warning: failed to regenerate moderated reply: %v", err)
+			return nil
+		}
+	}
+}
+
+func (s *ChatService) recordUsage(record UsageRecord, resp *LLMResponse) {
+	if s.quota == nil || resp == nil {
+		return
+	}
+	record.Provider = s.llm.Name()
+	record.PromptTokens = resp.PromptTokens
+	record.CompletionTokens = resp.CompletionTokens
+	if err := s.quota.RecordUsage(record); err != nil {
+		log.Printf("Warning: %v", err)
+	}
+}
+
+// ReplyMetadata is the metadata stored on an AI reply. It identifies what
+// produced the reply so feedback can be attributed.
+func (s *ChatService) ReplyMetadata(promptVersion, language string) map[string]interface{} {
+	return map[string]interface{}{
+		"promptVersion": promptVersion,
+		"provider":      s.llm.Name(),
+		"language":      language,
+	}
+}
+
+// wantsSpeech reports whether the reply to userMsg should be read aloud:
+// the survivor spoke to Nia or prefers spoken replies.
+func (s *ChatService) wantsSpeech(userID string, userMsg *models.ChatMessage) bool {
+	if s.speech == nil {
+		return false
+	}
+	return userMsg.MessageType == "audio" || s.preferences(userID).SpokenReplies
+}
+
+// speakAsync reads a saved AI reply aloud in the background, so synthesis
+// never holds up the text, and links the audio from the reply's metadata
+// once it is ready. The reply is saved with ttsPending set; a
+// "message_audio" event tells the client when that is settled. Failures
+// only cost the audio.
+func (s *ChatService) speakAsync(userID string, reply *models.ChatMessage, language string) {
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), speechTimeout)
+		defer cancel()
+
+		audio, err := s.speech.Speak(ctx, reply.Content, language)
+		if err != nil {
+			log.Printf("Warning: failed to synthesize reply speech: %v", err)
+		}
+		metadata, err := s.updateMessageMetadata(ctx, reply.ID, func(metadata map[string]interface{}) {
+			delete(metadata, "ttsPending")
+			if audio != nil {
+				metadata["ttsKey"] = audio.Key
+				metadata["ttsContentType"] = audio.ContentType
+				metadata["ttsLanguage"] = language
+			}
+		})
+		if err == sql.ErrNoRows {
+			// the reply was deleted while it was being read aloud
+			if audio != nil {
+				deleteMessageBlobs(ctx, s.db, s.blobs, []string{audio.Key})
+			}
+			return
+		}
+		if err != nil {
+			log.Printf("Warning: failed to link reply speech to message %s: %v", reply.ID, err)
+			return
+		}
+		s.hub.Publish(SessionChannel(reply.SessionID), SessionEvent{Type: "message_audio", Data: map[string]interface{}{
+			"messageId": reply.ID,
+			"metadata":  metadata,
+		}})
+	}()
+}
+
+// updateMessageMetadata applies update to a message's metadata and returns
+// the result as stored.
+func (s *ChatService) updateMessageMetadata(ctx context.Context, messageID string, update func(map[string]interface{})) (string, error) {
+	var raw sql.NullString
+	err := s.db.QueryRowContext(ctx, "SELECT metadata FROM chat_messages WHERE id = ?", messageID).Scan(&raw)
+	if err != nil {
+		return "", err
+	}
+	metadata := decodeMetadata(raw.String)
+	update(metadata)
+	encoded := encodeMetadata(metadata)
+
+	result, err := s.db.ExecContext(ctx, "UPDATE chat_messages SET metadata = ? WHERE id = ?", encoded, messageID)
+	if err != nil {
+		return "", err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return "", sql.ErrNoRows
+	}
+	return encoded, nil
+}
+
+// userPreferences are the chat-related settings in user_profiles.preferences.
+type userPreferences struct {
+	DiscreetTitles bool `json:"discreetTitles"`
+	SpokenReplies  bool `json:"spokenReplies"`
+}
+
+// preferences loads the user's preferences. If they cannot be read,
+// discreet titles are assumed, as that is the safer choice.
+func (s *ChatService) preferences(userID string) userPreferences {
+	var raw string
+	err := s.db.QueryRow(`
+		SELECT COALESCE(preferences, '{}') FROM user_profiles WHERE user_id = ?
+	`, userID).Scan(&raw)
+	if err != nil {
+		return userPreferences{DiscreetTitles: true}
+	}
+
+	var prefs userPreferences
+	if err := json.Unmarshal([]byte(raw), &prefs); err != nil {
+		return userPreferences{DiscreetTitles: true}
+	}
+	return prefs
+}
+
+// firstName is offered to prompt templates; it is empty if unknown.
+func (s *ChatService) firstName(userID string) string {
+	var name string
+	s.db.QueryRow("SELECT first_name FROM users WHERE id = ?", userID).Scan(&name)
+	return name
+}
+
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
+
+func encodeMetadata(metadata map[string]interface{}) string {
+	if len(metadata) == 0 {
+		return "{}"
+	}
+	data, err := json.Marshal(metadata)
+	if err != nil {
+		return "{}"
+	}
+	return string(data)
+}
+
+func decodeMetadata(raw string) map[string]interface{} {
+	metadata := map[string]interface{}{}
+	if raw != "" {
+		_ = json.Unmarshal([]byte(raw), &metadata)
+	}
+	return metadata
+}
+
+func metadataString(metadata map[string]interface{}, key string) string {
+	if v, ok := metadata[key].(string); ok {
+		return v
+	}
+	return ""
+}
+
+// GetChatHistory returns the session's active branch, oldest first. With
+// withBranches set, each message also lists its sibling branches.
+func (s *ChatService) GetChatHistory(userID, sessionID string, limit, offset int, withBranches bool) ([]models.ChatMessage, error) {
+	// Verify session belongs to user
+	_, err := s.getChatSession(userID, sessionID)
+	if err != nil {
+		return nil, ErrSessionNotFound
+	}
+
+	tree, err := s.loadMessageTree(sessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	messages := tree.activePath()
+	if withBranches {
+		for i := range messages {
+			messages[i].SiblingIDs = tree.siblingIDs(messages[i].ParentID)
+		}
+	}
+
+	if offset >= len(messages) {
+		return []models.ChatMessage{}, nil
+	}
+	messages = messages[offset:]
+	if limit >= 0 && limit < len(messages) {
+		messages = messages[:limit]
 	}
 
 	return messages, nil
 }
 
-func (s *ChatService) GetChatSessions(userID string, limit, offset int) ([]models.ChatSession, error) {
+// GetChatSessions lists the user's sessions matching filter, pinned ones
+// first, then by last activity. Archived sessions are left out unless the
+// filter asks for them.
+func (s *ChatService) GetChatSessions(userID string, filter models.SessionFilter) ([]models.ChatSession, error) {
 	query := `
-		SELECT id, user_id, title, created_at, updated_at
+		SELECT id, user_id, title, COALESCE(title_source, 'default'), COALESCE(archived, FALSE),
+		       COALESCE(pinned, FALSE), COALESCE(mode, 'gbv'), COALESCE(counselor_id, ''), created_at, updated_at
 		FROM chat_sessions
-		WHERE user_id = ?
-		ORDER BY updated_at DESC
-		LIMIT ? OFFSET ?
-	`
+		WHERE user_id = ?`
+	args := []interface{}{userID}
+
+	switch filter.Archived {
+	case models.ArchivedOnly:
+		query += " AND COALESCE(archived, FALSE) = TRUE"
+	case models.ArchivedInclude:
+	default:
+		query += " AND COALESCE(archived, FALSE) = FALSE"
+	}
+	if filter.Pinned != nil {
+		query += " AND COALESCE(pinned, FALSE) = ?"
+		args = append(args, *filter.Pinned)
+	}
+	if filter.Tag != "" {
+		query += " AND id IN (SELECT session_id FROM chat_session_tags WHERE tag = ?)"
+		args = append(args, normalizeTag(filter.Tag))
+	}
+	query += `
+		ORDER BY COALESCE(pinned, FALSE) DESC, updated_at DESC
+		LIMIT ? OFFSET ?`
+	args = append(args, filter.Limit, filter.Offset)
 
-	rows, err := s.db.Query(query, userID, limit, offset)
+	rows, err := s.db.Query(query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -234,59 +647,207 @@ func (s *ChatService) GetChatSessions(userID string, limit, offset int) ([]model
 	var sessions []models.ChatSession
 	for rows.Next() {
 		var session models.ChatSession
-		err := rows.Scan(&session.ID, &session.UserID, &session.Title,
-			&session.CreatedAt, &session.UpdatedAt)
+		err := rows.Scan(&session.ID, &session.UserID, &session.Title, &session.TitleSource,
+			&session.Archived, &session.Pinned, &session.Mode, &session.CounselorID, &session.CreatedAt, &session.UpdatedAt)
 		if err != nil {
 			return nil, err
 		}
+		session.Title = s.cipher.Reveal(userID, session.Title)
 		sessions = append(sessions, session)
 	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
 
+	if err := s.loadSessionTags(userID, sessions); err != nil {
+		return nil, err
+	}
 	return sessions, nil
 }
 
-func (s *ChatService) DeleteChatSession(userID, sessionID string) error {
-	// Verify session belongs to user
-	_, err := s.getChatSession(userID, sessionID)
+// UpdateSession applies a user rename, archive or pin toggle, and/or a new
+// set of tags. A user-supplied title is never overwritten by automatic title
+// generation.
+func (s *ChatService) UpdateSession(userID, sessionID string, req models.UpdateSessionRequest) (*models.ChatSession, error) {
+	session, err := s.getChatSession(userID, sessionID)
+	if err == sql.ErrNoRows {
+		return nil, ErrSessionNotFound
+	}
 	if err != nil {
-		return fmt.Errorf("session not found or access denied")
+		return nil, err
 	}
 
-	// Delete messages first (due to foreign key constraint)
-	_, err = s.db.Exec("DELETE FROM chat_messages WHERE session_id = ?", sessionID)
+	if req.Title != nil {
+		title := strings.TrimSpace(*req.Title)
+		if title == "" {
+			return nil, fmt.Errorf("%w: title cannot be empty", ErrInvalidTitle)
+		}
+		if len([]rune(title)) > maxSessionTitleLength {
+			return nil, fmt.Errorf("%w: title must be at most %d characters", ErrInvalidTitle, maxSessionTitleLength)
+		}
+		session.Title = title
+		session.TitleSource = "user"
+	}
+	if req.Archived != nil {
+		session.Archived = *req.Archived
+	}
+	if req.Pinned != nil {
+		session.Pinned = *req.Pinned
+	}
+	var tags []string
+	if req.Tags != nil {
+		if tags, err = normalizeTags(*req.Tags); err != nil {
+			return nil, err
+		}
+	}
+	storedTitle, err := s.cipher.Encrypt(userID, session.Title)
 	if err != nil {
-		return fmt.Errorf("failed to delete messages: %w", err)
+		return nil, err
 	}
 
-	// Delete session
-	_, err = s.db.Exec("DELETE FROM chat_sessions WHERE id = ? AND user_id = ?", sessionID, userID)
+	tx, err := s.db.Begin()
 	if err != nil {
-		return fmt.Errorf("failed to delete session: %w", err)
+		return nil, fmt.Errorf("failed to update session: %w", err)
+	}
+	defer tx.Rollback()
+
+	session.UpdatedAt = time.Now()
+	_, err = tx.Exec(`
+		UPDATE chat_sessions SET title = ?, title_source = ?, archived = ?, pinned = ?, updated_at = ?
+		WHERE id = ? AND user_id = ?
+	`, storedTitle, session.TitleSource, session.Archived, session.Pinned, session.UpdatedAt, sessionID, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update session: %w", err)
+	}
+	if req.Tags != nil {
+		if err := replaceSessionTags(tx, sessionID, tags); err != nil {
+			return nil, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to update session: %w", err)
 	}
 
-	return nil
+	sessions := []models.ChatSession{*session}
+	if err := s.loadSessionTags(userID, sessions); err != nil {
+		return nil, err
+	}
+	return &sessions[0], nil
 }
 
-func (s *ChatService) SubmitFeedback(userID, sessionID, messageID string, rating int, feedback string) error {
+func (s *ChatService) DeleteChatSession(userID, sessionID string) error {
 	// Verify session belongs to user
 	_, err := s.getChatSession(userID, sessionID)
 	if err != nil {
 		return fmt.Errorf("session not found or access denied")
 	}
 
-	// For now, we'll store feedback in the message metadata
-	// In a production system, you might want a separate feedback table
-	feedbackJSON := fmt.Sprintf(`{"rating": %d, "feedback": "%s", "submitted_at": "%s"}`,
-		rating, feedback, time.Now().Format(time.RFC3339))
+	_, err = s.DeleteSessions(userID, []string{sessionID})
+	return err
+}
 
-	_, err = s.db.Exec(`
-		UPDATE chat_messages 
-		SET metadata = ? 
+// SubmitFeedback records (or replaces) the user's rating of an AI reply.
+// The prompt version, provider and language are copied from the message
+// metadata so the admin report can group by them without parsing JSON.
+func (s *ChatService) SubmitFeedback(userID, sessionID, messageID string, rating int, feedback string) (*models.MessageFeedback, error) {
+	// Verify session belongs to user
+	_, err := s.getChatSession(userID, sessionID)
+	if err != nil {
+		return nil, ErrSessionNotFound
+	}
+
+	var senderType, rawMetadata string
+	err = s.db.QueryRow(`
+		SELECT sender_type, COALESCE(metadata, '{}')
+		FROM chat_messages
 		WHERE id = ? AND session_id = ?
-	`, feedbackJSON, messageID, sessionID)
+	`, messageID, sessionID).Scan(&senderType, &rawMetadata)
+	if err == sql.ErrNoRows {
+		return nil, ErrMessageNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load message: %w", err)
+	}
+	if senderType != "ai" {
+		return nil, ErrFeedbackTarget
+	}
+
+	metadata := decodeMetadata(rawMetadata)
+	now := time.Now()
+	fb := &models.MessageFeedback{
+		ID:            uuid.New().String(),
+		MessageID:     messageID,
+		SessionID:     sessionID,
+		UserID:        userID,
+		Rating:        rating,
+		Feedback:      feedback,
+		PromptVersion: metadataString(metadata, "promptVersion"),
+		Provider:      metadataString(metadata, "provider"),
+		Language:      metadataString(metadata, "language"),
+		CreatedAt:     now,
+		UpdatedAt:     now,
+	}
+
+	// A re-rating keeps the original row, so its ID and creation time are
+	// read back rather than taken from the values above
+	err = s.db.QueryRow(`
+		INSERT INTO message_feedback (id, message_id, session_id, user_id, rating, feedback,
+		                              prompt_version, provider, language, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(message_id, user_id) DO UPDATE SET
+			rating = excluded.rating,
+			feedback = excluded.feedback,
+			updated_at = excluded.updated_at
+		RETURNING id, created_at
+	`, fb.ID, fb.MessageID, fb.SessionID, fb.UserID, fb.Rating, fb.Feedback,
+		fb.PromptVersion, fb.Provider, fb.Language, fb.CreatedAt, fb.UpdatedAt).Scan(&fb.ID, &fb.CreatedAt)
 	if err != nil {
-		return fmt.Errorf("failed to save feedback: %w", err)
+		return nil, fmt.Errorf("failed to save feedback: %w", err)
+	}
+
+	return fb, nil
+}
+
+// GetFeedbackAnalytics reports average ratings grouped by period, prompt
+// version, provider and language. interval is "day", "week" or "month".
+func (s *ChatService) GetFeedbackAnalytics(from, to time.Time, interval string) ([]models.FeedbackAggregate, error) {
+	var periodFormat string
+	switch interval {
+	case "day":
+		periodFormat = "%Y-%m-%d"
+	case "week":
+		periodFormat = "%Y-W%W"
+	case "month":
+		periodFormat = "%Y-%m"
+	default:
+		return nil, fmt.Errorf("invalid interval: %s", interval)
+	}
+
+	rows, err := s.db.Query(`
+		SELECT strftime(?, created_at) AS period,
+		       COALESCE(NULLIF(prompt_version, ''), 'unknown') AS prompt_version,
+		       COALESCE(NULLIF(provider, ''), 'unknown') AS provider,
+		       COALESCE(NULLIF(language, ''), 'unknown') AS language,
+		       AVG(rating), COUNT(*)
+		FROM message_feedback
+		WHERE created_at >= ? AND created_at < ?
+		GROUP BY period, prompt_version, provider, language
+		ORDER BY period ASC, prompt_version ASC, provider ASC, language ASC
+	`, periodFormat, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	aggregates := []models.FeedbackAggregate{}
+	for rows.Next() {
+		var a models.FeedbackAggregate
+		if err := rows.Scan(&a.Period, &a.PromptVersion, &a.Provider, &a.Language,
+			&a.AverageRating, &a.Count); err != nil {
+			return nil, err
+		}
+		aggregates = append(aggregates, a)
 	}
 
-	return nil
+	return aggregates, rows.Err()
 }
@@ -0,0 +1,94 @@
+package services
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestSubmitFeedback(t *testing.T) {
+	db := newTestDB(t)
+	s := newTestChatService(t, db, &stubLLM{})
+	userID := createTestUser(t, db, "Amani")
+	session := newTestChatSession(t, s, userID, "Habari, nina shida nyumbani")
+	reply, err := s.SaveMessage(session.ID, userID, "Pole sana", "ai", "text", s.ReplyMetadata("nia-v2", "sw"))
+	if err != nil {
+		t.Fatalf("SaveMessage: %v", err)
+	}
+
+	fb, err := s.SubmitFeedback(userID, session.ID, reply.ID, 2, "too short")
+	if err != nil {
+		t.Fatalf("SubmitFeedback: %v", err)
+	}
+	if fb.PromptVersion != "nia-v2" || fb.Provider != "stub" || fb.Language != "sw" {
+		t.Errorf("feedback = %+v, want it attributed to the reply's prompt, provider and language", fb)
+	}
+
+	// Rating again replaces the first rating
+	again, err := s.SubmitFeedback(userID, session.ID, reply.ID, 5, "")
+	if err != nil {
+		t.Fatalf("SubmitFeedback again: %v", err)
+	}
+	if again.ID != fb.ID || !again.CreatedAt.Equal(fb.CreatedAt) {
+		t.Errorf("re-rating returned ID %s created %v, want the stored %s created %v", again.ID, again.CreatedAt, fb.ID, fb.CreatedAt)
+	}
+	var count, rating int
+	if err := db.QueryRow("SELECT COUNT(*), MAX(rating) FROM message_feedback WHERE message_id = ?", reply.ID).Scan(&count, &rating); err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 || rating != 5 {
+		t.Errorf("stored %d ratings with rating %d, want one rating of 5", count, rating)
+	}
+}
+
+func TestSubmitFeedbackRejectsBadTargets(t *testing.T) {
+	db := newTestDB(t)
+	s := newTestChatService(t, db, &stubLLM{})
+	userID := createTestUser(t, db, "Amani")
+	session := newTestChatSession(t, s, userID, "hello")
+	own, err := s.SaveMessage(session.ID, userID, "my own message", "user", "text", nil)
+	if err != nil {
+		t.Fatalf("SaveMessage: %v", err)
+	}
+
+	if _, err := s.SubmitFeedback(userID, session.ID, own.ID, 4, ""); !errors.Is(err, ErrFeedbackTarget) {
+		t.Errorf("feedback on a user message: err = %v, want ErrFeedbackTarget", err)
+	}
+	if _, err := s.SubmitFeedback(userID, session.ID, "missing", 4, ""); !errors.Is(err, ErrMessageNotFound) {
+		t.Errorf("feedback on a missing message: err = %v, want ErrMessageNotFound", err)
+	}
+	if _, err := s.SubmitFeedback(createTestUser(t, db, "Baraka"), session.ID, own.ID, 4, ""); !errors.Is(err, ErrSessionNotFound) {
+		t.Errorf("feedback on another user's session: err = %v, want ErrSessionNotFound", err)
+	}
+}
+
+func TestGetFeedbackAnalytics(t *testing.T) {
+	db := newTestDB(t)
+	s := newTestChatService(t, db, &stubLLM{})
+	userID := createTestUser(t, db, "Amani")
+	session := newTestChatSession(t, s, userID, "hello")
+	for _, rating := range []int{2, 4} {
+		reply, err := s.SaveMessage(session.ID, userID, "reply", "ai", "text", s.ReplyMetadata("nia-v2", "en"))
+		if err != nil {
+			t.Fatalf("SaveMessage: %v", err)
+		}
+		if _, err := s.SubmitFeedback(userID, session.ID, reply.ID, rating, ""); err != nil {
+			t.Fatalf("SubmitFeedback: %v", err)
+		}
+	}
+
+	now := time.Now()
+	report, err := s.GetFeedbackAnalytics(now.Add(-time.Hour), now.Add(time.Hour), "day")
+	if err != nil {
+		t.Fatalf("GetFeedbackAnalytics: %v", err)
+	}
+	if len(report) != 1 {
+		t.Fatalf("report has %d rows, want 1", len(report))
+	}
+	if r := report[0]; r.Count != 2 || r.AverageRating != 3 || r.Language != "en" || r.Provider != "stub" {
+		t.Errorf("report row = %+v", r)
+	}
+	if _, err := s.GetFeedbackAnalytics(now, now, "year"); err == nil {
+		t.Error("GetFeedbackAnalytics accepted an unknown interval")
+	}
+}
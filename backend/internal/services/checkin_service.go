@@ -0,0 +1,673 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/heal/internal/models"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Check-in schedule statuses.
+const (
+	CheckinActive    = "active"
+	CheckinEnded     = "ended"
+	CheckinCancelled = "cancelled"
+)
+
+// Check-in prompt statuses.
+const (
+	PromptPending   = "pending"
+	PromptConfirmed = "confirmed"
+	PromptMissed    = "missed"
+	PromptCancelled = "cancelled"
+)
+
+// Channels check-ins are delivered on.
+const (
+	CheckinPush = "push"
+	CheckinSMS  = "sms"
+)
+
+const (
+	minCheckinInterval  = 15 // minutes
+	maxCheckinInterval  = 24 * 60
+	defaultCheckinGrace = 15
+	minCheckinGrace     = 5
+	maxCheckinDuration  = 30 * 24 * time.Hour
+	// maxCheckinPINAttempts wrong PINs in a row are treated as a missed
+	// check-in, so a PIN cannot be guessed.
+	maxCheckinPINAttempts = 5
+)
+
+var checkinPIN = regexp.MustCompile(`^[0-9]{4,8}$`)
+
+// checkinPromptText is sent when a check-in is due. It stays vague in case
+// someone else sees the phone.
+const checkinPromptText = "Time to check in. Open the app to confirm."
+
+// CheckinService runs scheduled safety check-ins. A background worker
+// sends each check-in when it falls due and, once its grace window has
+// passed without a confirmation, raises a crisis alert and texts the user's
+// primary emergency contact. Confirming with the duress PIN looks like an
+// ordinary confirmation but raises a critical alert.
+type CheckinService struct {
+	db     *sql.DB
+	crisis *CrisisService
+	sms    *SMSDispatcher // nil disables SMS check-ins and contact texts
+	push   PushSender
+	clock  Clock
+}
+
+func NewCheckinService(db *sql.DB, crisis *CrisisService, sms *SMSDispatcher, push PushSender, clock Clock) *CheckinService {
+	if clock == nil {
+		clock = SystemClock{}
+	}
+	return &CheckinService{db: db, crisis: crisis, sms: sms, push: push, clock: clock}
+}
+
+func checkinError(format string, args ...interface{}) error {
+	return fmt.Errorf("%w: "+format, append([]interface{}{ErrInvalidCheckin}, args...)...)
+}
+
+// CreateCheckin schedules check-ins for userID.
+func (s *CheckinService) CreateCheckin(userID string, req models.CreateCheckinRequest) (*models.SafetyCheckin, error) {
+	now := s.clock.Now()
+
+	if req.IntervalMinutes < minCheckinInterval || req.IntervalMinutes > maxCheckinInterval {
+		return nil, checkinError("intervalMinutes must be between %d and %d", minCheckinInterval, maxCheckinInterval)
+	}
+	if req.GraceMinutes == 0 {
+		req.GraceMinutes = defaultCheckinGrace
+	}
+	if req.GraceMinutes < minCheckinGrace || req.GraceMinutes >= req.IntervalMinutes {
+		return nil, checkinError("graceMinutes must be at least %d and less than the interval", minCheckinGrace)
+	}
+
+	first := now.Add(time.Duration(req.IntervalMinutes) * time.Minute)
+	if req.StartsAt != nil {
+		if req.StartsAt.Before(now.Add(-time.Minute)) {
+			return nil, checkinError("startsAt must not be in the past")
+		}
+		first = *req.StartsAt
+	}
+	if req.EndsAt != nil && (req.EndsAt.Before(first) || req.EndsAt.Sub(now) > maxCheckinDuration) {
+		return nil, checkinError("endsAt must be after the first check-in and within 30 days")
+	}
+
+	if req.Channel == "" {
+		req.Channel = CheckinPush
+	}
+	switch req.Channel {
+	case CheckinPush:
+		// optional: where prompts are texted when the app is not open
+		if req.Phone = strings.TrimSpace(req.Phone); req.Phone != "" {
+			phone, err := NormalizePhone(req.Phone)
+			if err != nil {
+				return nil, checkinError("phone must be a valid phone number")
+			}
+			req.Phone = phone
+		}
+	case CheckinSMS:
+		if s.sms == nil || s.sms.sender == nil {
+			return nil, checkinError("SMS check-ins are not available")
+		}
+		req.Phone = strings.TrimSpace(req.Phone)
+		if req.Phone == "" {
+			s.db.QueryRow("SELECT COALESCE(phone, '') FROM user_profiles WHERE user_id = ?", userID).Scan(&req.Phone)
+		}
+		phone, err := NormalizePhone(req.Phone)
+		if err != nil {
+			return nil, checkinError("a valid phone number is required for SMS check-ins")
+		}
+		req.Phone = phone
+	default:
+		return nil, checkinError("channel must be push or sms")
+	}
+
+	var pinHash, duressHash string
+	if req.PIN != "" {
+		if !checkinPIN.MatchString(req.PIN) {
+			return nil, checkinError("pin must be 4 to 8 digits")
+		}
+		hash, err := bcrypt.GenerateFromPassword([]byte(req.PIN), bcrypt.DefaultCost)
+		if err != nil {
+			return nil, err
+		}
+		pinHash = string(hash)
+	}
+	if req.DuressPIN != "" {
+		if req.PIN == "" {
+			return nil, checkinError("a duress PIN needs a PIN as well")
+		}
+		if !checkinPIN.MatchString(req.DuressPIN) || req.DuressPIN == req.PIN {
+			return nil, checkinError("duressPin must be 4 to 8 digits and differ from the PIN")
+		}
+		hash, err := bcrypt.GenerateFromPassword([]byte(req.DuressPIN), bcrypt.DefaultCost)
+		if err != nil {
+			return nil, err
+		}
+		duressHash = string(hash)
+	}
+
+	checkin := &models.SafetyCheckin{
+		ID:              uuid.New().String(),
+		UserID:          userID,
+		Channel:         req.Channel,
+		Phone:           req.Phone,
+		IntervalMinutes: req.IntervalMinutes,
+		GraceMinutes:    req.GraceMinutes,
+		HasPIN:          pinHash != "",
+		Status:          CheckinActive,
+		NextDueAt:       &first,
+		EndsAt:          req.EndsAt,
+		CreatedAt:       now,
+		UpdatedAt:       now,
+	}
+	_, err := s.db.Exec(`
+		INSERT INTO safety_checkins (id, user_id, channel, phone, interval_minutes, grace_minutes, pin_hash,
+		                             duress_pin_hash, status, next_due_at, ends_at, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, checkin.ID, userID, checkin.Channel, nullString(checkin.Phone), checkin.IntervalMinutes,
+		checkin.GraceMinutes, nullString(pinHash), nullString(duressHash), checkin.Status, first, req.EndsAt, now, now)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create check-in: %w", err)
+	}
+	return checkin, nil
+}
+
+const checkinColumns = `id, user_id, channel, COALESCE(phone, ''), interval_minutes, grace_minutes,
+	pin_hash IS NOT NULL, status, next_due_at, ends_at, created_at, updated_at`
+
+func scanCheckin(row rowScanner) (*models.SafetyCheckin, error) {
+	c := &models.SafetyCheckin{}
+	var nextDue, endsAt sql.NullTime
+	err := row.Scan(&c.ID, &c.UserID, &c.Channel, &c.Phone, &c.IntervalMinutes, &c.GraceMinutes,
+		&c.HasPIN, &c.Status, &nextDue, &endsAt, &c.CreatedAt, &c.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if nextDue.Valid {
+		c.NextDueAt = &nextDue.Time
+	}
+	if endsAt.Valid {
+		c.EndsAt = &endsAt.Time
+	}
+	return c, nil
+}
+
+// GetCheckins lists the user's check-in schedules, newest first, with the
+// check-in waiting for confirmation if there is one.
+func (s *CheckinService) GetCheckins(userID string) ([]models.SafetyCheckin, error) {
+	rows, err := s.db.Query(`SELECT `+checkinColumns+` FROM safety_checkins
+		WHERE user_id = ? ORDER BY created_at DESC`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	checkins := []models.SafetyCheckin{}
+	for rows.Next() {
+		c, err := scanCheckin(rows)
+		if err != nil {
+			return nil, err
+		}
+		checkins = append(checkins, *c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i := range checkins {
+		if checkins[i].Pending, err = s.pendingPrompt(checkins[i].ID); err != nil {
+			return nil, err
+		}
+	}
+	return checkins, nil
+}
+
+// GetCheckin returns one of the user's check-in schedules with every
+// check-in sent so far.
+func (s *CheckinService) GetCheckin(userID, checkinID string) (*models.SafetyCheckin, error) {
+	c, err := s.checkin(userID, checkinID)
+	if err != nil {
+		return nil, err
+	}
+	if c.Pending, err = s.pendingPrompt(c.ID); err != nil {
+		return nil, err
+	}
+	if c.Prompts, err = s.prompts(c.ID); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (s *CheckinService) checkin(userID, checkinID string) (*models.SafetyCheckin, error) {
+	c, err := scanCheckin(s.db.QueryRow(`SELECT `+checkinColumns+` FROM safety_checkins
+		WHERE id = ? AND user_id = ?`, checkinID, userID))
+	if err == sql.ErrNoRows {
+		return nil, ErrCheckinNotFound
+	}
+	return c, err
+}
+
+const checkinPromptColumns = `id, checkin_id, due_at, expires_at, status, duress, COALESCE(alert_id, ''), responded_at`
+
+func scanPrompt(row rowScanner) (*models.SafetyCheckinPrompt, error) {
+	p := &models.SafetyCheckinPrompt{}
+	var duress bool
+	var respondedAt sql.NullTime
+	err := row.Scan(&p.ID, &p.CheckinID, &p.DueAt, &p.ExpiresAt, &p.Status, &duress, &p.AlertID, &respondedAt)
+	if err != nil {
+		return nil, err
+	}
+	if respondedAt.Valid {
+		p.RespondedAt = &respondedAt.Time
+	}
+	// A duress confirmation must look like any other
+	if duress {
+		p.AlertID = ""
+	}
+	return p, nil
+}
+
+func (s *CheckinService) pendingPrompt(checkinID string) (*models.SafetyCheckinPrompt, error) {
+	p, err := scanPrompt(s.db.QueryRow(`SELECT `+checkinPromptColumns+` FROM safety_checkin_prompts
+		WHERE checkin_id = ? AND status = ? ORDER BY due_at DESC LIMIT 1`, checkinID, PromptPending))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return p, err
+}
+
+func (s *CheckinService) prompts(checkinID string) ([]models.SafetyCheckinPrompt, error) {
+	rows, err := s.db.Query(`SELECT `+checkinPromptColumns+` FROM safety_checkin_prompts
+		WHERE checkin_id = ? ORDER BY due_at DESC`, checkinID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	prompts := []models.SafetyCheckinPrompt{}
+	for rows.Next() {
+		p, err := scanPrompt(rows)
+		if err != nil {
+			return nil, err
+		}
+		prompts = append(prompts, *p)
+	}
+	return prompts, rows.Err()
+}
+
+// verifyPIN checks pin against the schedule's PINs. Without a PIN any
+// confirmation is accepted. Too many wrong PINs in a row count as a missed
+// check-in.
+func (s *CheckinService) verifyPIN(ctx context.Context, userID, checkinID, pin string) (duress bool, err error) {
+	var pinHash, duressHash sql.NullString
+	err = s.db.QueryRow("SELECT pin_hash, duress_pin_hash FROM safety_checkins WHERE id = ?", checkinID).
+		Scan(&pinHash, &duressHash)
+	if err != nil {
+		return false, err
+	}
+	if !pinHash.Valid {
+		return false, nil
+	}
+
+	switch {
+	case bcrypt.CompareHashAndPassword([]byte(pinHash.String), []byte(pin)) == nil:
+	case duressHash.Valid && bcrypt.CompareHashAndPassword([]byte(duressHash.String), []byte(pin)) == nil:
+		duress = true
+	default:
+		var attempts int
+		err := s.db.QueryRow(`
+			UPDATE safety_checkins SET failed_pin_attempts = failed_pin_attempts + 1
+			WHERE id = ? RETURNING failed_pin_attempts
+		`, checkinID).Scan(&attempts)
+		if err != nil {
+			return false, err
+		}
+		if attempts >= maxCheckinPINAttempts {
+			s.db.Exec("UPDATE safety_checkins SET failed_pin_attempts = 0 WHERE id = ?", checkinID)
+			if err := s.raiseAlert(ctx, userID, checkinID, "", RiskHigh, "Safety check-in: too many wrong PINs", false); err != nil {
+				log.Printf("Warning: failed to raise alert for check-in %s: %v", checkinID, err)
+			}
+		}
+		return false, ErrWrongPIN
+	}
+
+	_, err = s.db.Exec("UPDATE safety_checkins SET failed_pin_attempts = 0 WHERE id = ?", checkinID)
+	return duress, err
+}
+
+// Confirm records that the user is safe. It answers the check-in waiting
+// for confirmation or, if none is, pushes the next one back a full
+// interval. With the duress PIN it also raises a critical alert, but
+// returns exactly what an ordinary confirmation would.
+func (s *CheckinService) Confirm(ctx context.Context, userID, checkinID, pin string) (*models.SafetyCheckin, error) {
+	c, err := s.checkin(userID, checkinID)
+	if err != nil {
+		return nil, err
+	}
+	if c.Status != CheckinActive {
+		return nil, ErrCheckinEnded
+	}
+	duress, err := s.verifyPIN(ctx, userID, checkinID, pin)
+	if err != nil {
+		return nil, err
+	}
+
+	now := s.clock.Now()
+	pending, err := s.pendingPrompt(checkinID)
+	if err != nil {
+		return nil, err
+	}
+	if pending != nil {
+		_, err = s.db.Exec(`
+			UPDATE safety_checkin_prompts SET status = ?, duress = ?, responded_at = ?
+			WHERE id = ? AND status = ?
+		`, PromptConfirmed, duress, now, pending.ID, PromptPending)
+	} else if c.NextDueAt != nil {
+		next := now.Add(time.Duration(c.IntervalMinutes) * time.Minute)
+		_, err = s.db.Exec("UPDATE safety_checkins SET next_due_at = ?, updated_at = ? WHERE id = ?",
+			s.scheduleAfter(c, next), now, checkinID)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to confirm check-in: %w", err)
+	}
+
+	if duress {
+		promptID := ""
+		if pending != nil {
+			promptID = pending.ID
+		}
+		if err := s.raiseAlert(ctx, userID, checkinID, promptID, RiskCritical, "Safety check-in", true); err != nil {
+			log.Printf("Warning: failed to raise alert for check-in %s: %v", checkinID, err)
+		}
+	}
+	return s.GetCheckin(userID, checkinID)
+}
+
+// Cancel stops a check-in schedule. A schedule with a PIN needs it to be
+// cancelled; the duress PIN cancels it while raising a critical alert.
+func (s *CheckinService) Cancel(ctx context.Context, userID, checkinID, pin string) (*models.SafetyCheckin, error) {
+	c, err := s.checkin(userID, checkinID)
+	if err != nil {
+		return nil, err
+	}
+	if c.Status != CheckinActive {
+		return nil, ErrCheckinEnded
+	}
+	duress, err := s.verifyPIN(ctx, userID, checkinID, pin)
+	if err != nil {
+		return nil, err
+	}
+
+	now := s.clock.Now()
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	_, err = tx.Exec("UPDATE safety_checkins SET status = ?, next_due_at = NULL, updated_at = ? WHERE id = ?",
+		CheckinCancelled, now, checkinID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to cancel check-in: %w", err)
+	}
+	_, err = tx.Exec("UPDATE safety_checkin_prompts SET status = ?, responded_at = ? WHERE checkin_id = ? AND status = ?",
+		PromptCancelled, now, checkinID, PromptPending)
+	if err != nil {
+		return nil, fmt.Errorf("failed to cancel check-in: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	if duress {
+		if err := s.raiseAlert(ctx, userID, checkinID, "", RiskCritical, "Safety check-in", true); err != nil {
+			log.Printf("Warning: failed to raise alert for check-in %s: %v", checkinID, err)
+		}
+	}
+	return s.GetCheckin(userID, checkinID)
+}
+
+// scheduleAfter returns when the check-in after next falls, or nil if that
+// is past the schedule's end.
+func (s *CheckinService) scheduleAfter(c *models.SafetyCheckin, next time.Time) *time.Time {
+	if c.EndsAt != nil && next.After(*c.EndsAt) {
+		return nil
+	}
+	return &next
+}
+
+// Run sends due check-ins and handles missed ones every interval until ctx
+// is cancelled.
+func (s *CheckinService) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := s.Tick(ctx); err != nil {
+			log.Printf("Warning: safety check-in run failed: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Tick raises alerts for check-ins whose grace window has passed, sends
+// the check-ins that have fallen due and ends finished schedules.
+func (s *CheckinService) Tick(ctx context.Context) error {
+	now := s.clock.Now()
+	if err := s.expirePrompts(ctx, now); err != nil {
+		return err
+	}
+	if err := s.sendDuePrompts(ctx, now); err != nil {
+		return err
+	}
+	_, err := s.db.ExecContext(ctx, `
+		UPDATE safety_checkins SET status = ?, updated_at = ?
+		WHERE status = ? AND next_due_at IS NULL
+		  AND NOT EXISTS (SELECT 1 FROM safety_checkin_prompts p WHERE p.checkin_id = safety_checkins.id AND p.status = ?)
+	`, CheckinEnded, now, CheckinActive, PromptPending)
+	return err
+}
+
+func (s *CheckinService) expirePrompts(ctx context.Context, now time.Time) error {
+	type expired struct {
+		id, checkinID, userID string
+		dueAt, expiresAt      time.Time
+	}
+	// There are few pending check-ins, so the expiry check is done here
+	// rather than in SQL
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, checkin_id, user_id, due_at, expires_at FROM safety_checkin_prompts WHERE status = ?
+	`, PromptPending)
+	if err != nil {
+		return err
+	}
+	var batch []expired
+	for rows.Next() {
+		var e expired
+		if err := rows.Scan(&e.id, &e.checkinID, &e.userID, &e.dueAt, &e.expiresAt); err != nil {
+			rows.Close()
+			return err
+		}
+		if !now.Before(e.expiresAt) {
+			batch = append(batch, e)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, e := range batch {
+		result, err := s.db.ExecContext(ctx, `
+			UPDATE safety_checkin_prompts SET status = ? WHERE id = ? AND status = ?
+		`, PromptMissed, e.id, PromptPending)
+		if err != nil {
+			return err
+		}
+		// Confirmed in the meantime
+		if n, _ := result.RowsAffected(); n == 0 {
+			continue
+		}
+		message := fmt.Sprintf("Missed safety check-in due at %s", e.dueAt.UTC().Format("2006-01-02 15:04 UTC"))
+		if err := s.raiseAlert(ctx, e.userID, e.checkinID, e.id, RiskHigh, message, false); err != nil {
+			log.Printf("Warning: failed to raise alert for missed check-in %s: %v", e.id, err)
+		}
+	}
+	return nil
+}
+
+func (s *CheckinService) sendDuePrompts(ctx context.Context, now time.Time) error {
+	rows, err := s.db.QueryContext(ctx, `SELECT `+checkinColumns+` FROM safety_checkins
+		WHERE status = ? AND next_due_at IS NOT NULL`, CheckinActive)
+	if err != nil {
+		return err
+	}
+	var due []models.SafetyCheckin
+	for rows.Next() {
+		c, err := scanCheckin(rows)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		if !c.NextDueAt.After(now) {
+			due = append(due, *c)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for i := range due {
+		if err := s.sendPrompt(ctx, &due[i], now); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sendPrompt asks the user to confirm the check-in due now and schedules
+// the next one. Check-ins that fell due while the server was down are
+// collapsed into this one.
+func (s *CheckinService) sendPrompt(ctx context.Context, c *models.SafetyCheckin, now time.Time) error {
+	interval := time.Duration(c.IntervalMinutes) * time.Minute
+	dueAt := *c.NextDueAt
+	next := dueAt.Add(interval)
+	for !next.After(now) {
+		next = next.Add(interval)
+	}
+
+	promptID := uuid.New().String()
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	_, err = tx.Exec(`
+		INSERT INTO safety_checkin_prompts (id, checkin_id, user_id, due_at, expires_at, status, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(checkin_id, due_at) DO NOTHING
+	`, promptID, c.ID, c.UserID, dueAt, now.Add(time.Duration(c.GraceMinutes)*time.Minute), PromptPending, now)
+	if err != nil {
+		return fmt.Errorf("failed to record check-in: %w", err)
+	}
+	_, err = tx.Exec("UPDATE safety_checkins SET next_due_at = ?, updated_at = ? WHERE id = ?",
+		s.scheduleAfter(c, next), now, c.ID)
+	if err != nil {
+		return fmt.Errorf("failed to schedule next check-in: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	// The check-in stands even if it could not be delivered; the user can
+	// still confirm it in the app
+	if err := s.deliver(ctx, c, promptID); err != nil {
+		log.Printf("Warning: failed to deliver check-in %s: %v", promptID, err)
+		s.db.Exec("UPDATE safety_checkin_prompts SET delivery_error = ? WHERE id = ?", err.Error(), promptID)
+	}
+	return nil
+}
+
+// deliver sends a check-in prompt. Push prompts only reach an open app
+// (see HubPushSender), so when one cannot be delivered it is texted
+// instead, to the check-in's phone or else the one in the user's profile.
+// A user with neither only sees prompts while the app is open, and a prompt
+// they never saw still counts as missed.
+func (s *CheckinService) deliver(ctx context.Context, c *models.SafetyCheckin, promptID string) error {
+	if c.Channel == CheckinSMS {
+		return s.sms.SendToUser(ctx, c.UserID, c.Phone, checkinPromptText)
+	}
+
+	pushErr := fmt.Errorf("no push sender configured")
+	if s.push != nil {
+		pushErr = s.push.Send(ctx, c.UserID, PushNotification{
+			Title: "Reminder",
+			Body:  checkinPromptText,
+			Data:  map[string]string{"type": "checkin", "checkinId": c.ID, "promptId": promptID},
+		})
+		if pushErr == nil {
+			return nil
+		}
+	}
+
+	phone := c.Phone
+	if phone == "" {
+		var profilePhone string
+		s.db.QueryRow("SELECT COALESCE(phone, '') FROM user_profiles WHERE user_id = ?", c.UserID).Scan(&profilePhone)
+		phone, _ = NormalizePhone(profilePhone)
+	}
+	if phone == "" {
+		return fmt.Errorf("%v, and the user has no phone to text instead", pushErr)
+	}
+	if err := s.sms.SendToUser(ctx, c.UserID, phone, checkinPromptText); err != nil {
+		return fmt.Errorf("%v, and texting instead failed: %v", pushErr, err)
+	}
+	return nil
+}
+
+// raiseAlert creates a crisis alert for a check-in and texts the user's
+// primary emergency contact, unless raising the alert already texts them.
+// Alerts raised by the duress PIN are hidden from the user.
+func (s *CheckinService) raiseAlert(ctx context.Context, userID, checkinID, promptID, severity, message string, duress bool) error {
+	alert, err := s.crisis.createAlert(userID, severity, message, nil, "", duress)
+	if err != nil {
+		return err
+	}
+	if promptID != "" {
+		if _, err := s.db.Exec("UPDATE safety_checkin_prompts SET alert_id = ? WHERE id = ?", alert.ID, promptID); err != nil {
+			return err
+		}
+	}
+
+	if s.sms.textsContacts(alert) {
+		return nil
+	}
+	contact, err := s.crisis.primaryContact(userID)
+	if err == errNoPrimaryContact {
+		log.Printf("Warning: check-in %s raised alert %s but the user has no emergency contact", checkinID, alert.ID)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	err = s.sms.SendToContact(ctx, alert, *contact)
+	if err == errNoSMSProvider {
+		log.Printf("Warning: check-in %s raised alert %s but no SMS provider is configured to text a contact", checkinID, alert.ID)
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,218 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/heal/internal/models"
+)
+
+type testCheckins struct {
+	checkins *CheckinService
+	crisis   *CrisisService
+	sms      *SMSDispatcher
+	sender   *FakeSMSSender
+	clock    *fakeClock
+	userID   string
+	contact  *models.EmergencyContact
+}
+
+func newTestCheckins(t *testing.T, push PushSender) *testCheckins {
+	t.Helper()
+	db := newTestDB(t)
+	sender := &FakeSMSSender{}
+	clock := newFakeClock(time.Now())
+	sms, err := NewSMSDispatcher(db, sender, SMSOptions{Clock: clock})
+	if err != nil {
+		t.Fatalf("NewSMSDispatcher: %v", err)
+	}
+	crisis := NewCrisisService(db, newTestCipher(t, db), sms, nil)
+	userID := createTestUser(t, db, "Amani")
+	contact, err := crisis.AddEmergencyContact(userID, "Wanjiku", "+254722000111", "sister", true)
+	if err != nil {
+		t.Fatalf("AddEmergencyContact: %v", err)
+	}
+	return &testCheckins{
+		checkins: NewCheckinService(db, crisis, sms, push, clock),
+		crisis:   crisis,
+		sms:      sms,
+		sender:   sender,
+		clock:    clock,
+		userID:   userID,
+		contact:  contact,
+	}
+}
+
+// sent flushes the SMS queue and returns the numbers texted so far.
+func (tc *testCheckins) sent(t *testing.T) []string {
+	t.Helper()
+	if _, err := tc.sms.Flush(context.Background()); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+	var to []string
+	for _, m := range tc.sender.Messages() {
+		to = append(to, m.To)
+	}
+	return to
+}
+
+func (tc *testCheckins) tick(t *testing.T) {
+	t.Helper()
+	if err := tc.checkins.Tick(context.Background()); err != nil {
+		t.Fatalf("Tick: %v", err)
+	}
+}
+
+func TestMissedCheckinRaisesAlert(t *testing.T) {
+	tc := newTestCheckins(t, nil)
+	checkin, err := tc.checkins.CreateCheckin(tc.userID, models.CreateCheckinRequest{
+		IntervalMinutes: 60,
+		GraceMinutes:    15,
+		Channel:         CheckinSMS,
+		Phone:           "+254712345678",
+	})
+	if err != nil {
+		t.Fatalf("CreateCheckin: %v", err)
+	}
+
+	tc.clock.Advance(59 * time.Minute)
+	tc.tick(t)
+	if to := tc.sent(t); len(to) != 0 {
+		t.Fatalf("texted %v before the check-in was due", to)
+	}
+
+	tc.clock.Advance(time.Minute)
+	tc.tick(t)
+	if to := tc.sent(t); len(to) != 1 || to[0] != "+254712345678" {
+		t.Fatalf("texted %v, want the check-in sent to the user", to)
+	}
+
+	tc.clock.Advance(14 * time.Minute)
+	tc.tick(t)
+	if alerts, _ := tc.crisis.GetCrisisAlerts(tc.userID, "", 10, 0); len(alerts) != 0 {
+		t.Fatalf("raised %d alerts inside the grace window", len(alerts))
+	}
+
+	tc.clock.Advance(time.Minute)
+	tc.tick(t)
+	alerts, err := tc.crisis.GetCrisisAlerts(tc.userID, "", 10, 0)
+	if err != nil {
+		t.Fatalf("GetCrisisAlerts: %v", err)
+	}
+	if len(alerts) != 1 || alerts[0].Severity != RiskHigh {
+		t.Fatalf("alerts = %+v, want one high alert", alerts)
+	}
+	if to := tc.sent(t); len(to) != 2 || to[1] != tc.contact.Phone {
+		t.Fatalf("texted %v, want the primary contact texted after the check-in", to)
+	}
+
+	got, err := tc.checkins.GetCheckin(tc.userID, checkin.ID)
+	if err != nil {
+		t.Fatalf("GetCheckin: %v", err)
+	}
+	if len(got.Prompts) != 1 || got.Prompts[0].Status != PromptMissed || got.Prompts[0].AlertID != alerts[0].ID {
+		t.Errorf("prompts = %+v, want one missed prompt linked to the alert", got.Prompts)
+	}
+}
+
+func TestConfirmedCheckinRaisesNoAlert(t *testing.T) {
+	tc := newTestCheckins(t, nil)
+	checkin, err := tc.checkins.CreateCheckin(tc.userID, models.CreateCheckinRequest{
+		IntervalMinutes: 60,
+		Channel:         CheckinSMS,
+		Phone:           "+254712345678",
+		PIN:             "1234",
+	})
+	if err != nil {
+		t.Fatalf("CreateCheckin: %v", err)
+	}
+
+	tc.clock.Advance(time.Hour)
+	tc.tick(t)
+	ctx := context.Background()
+	if _, err := tc.checkins.Confirm(ctx, tc.userID, checkin.ID, "0000"); !errors.Is(err, ErrWrongPIN) {
+		t.Fatalf("Confirm with a wrong PIN: err = %v, want ErrWrongPIN", err)
+	}
+	if _, err := tc.checkins.Confirm(ctx, tc.userID, checkin.ID, "1234"); err != nil {
+		t.Fatalf("Confirm: %v", err)
+	}
+
+	tc.clock.Advance(30 * time.Minute)
+	tc.tick(t)
+	if alerts, _ := tc.crisis.ListAlerts("", 10, 0); len(alerts) != 0 {
+		t.Errorf("raised %d alerts for a confirmed check-in", len(alerts))
+	}
+}
+
+func TestDuressPINRaisesHiddenAlert(t *testing.T) {
+	tc := newTestCheckins(t, nil)
+	checkin, err := tc.checkins.CreateCheckin(tc.userID, models.CreateCheckinRequest{
+		IntervalMinutes: 60,
+		PIN:             "1234",
+		DuressPIN:       "9999",
+	})
+	if err != nil {
+		t.Fatalf("CreateCheckin: %v", err)
+	}
+	ctx := context.Background()
+
+	normal, err := tc.checkins.Confirm(ctx, tc.userID, checkin.ID, "1234")
+	if err != nil {
+		t.Fatalf("Confirm: %v", err)
+	}
+	duress, err := tc.checkins.Confirm(ctx, tc.userID, checkin.ID, "9999")
+	if err != nil {
+		t.Fatalf("Confirm with the duress PIN: %v", err)
+	}
+	if duress.Status != normal.Status || len(duress.Prompts) != len(normal.Prompts) {
+		t.Errorf("duress confirmation %+v does not look like %+v", duress, normal)
+	}
+
+	if alerts, _ := tc.crisis.GetCrisisAlerts(tc.userID, "", 10, 0); len(alerts) != 0 {
+		t.Errorf("the user can see %d alerts, want the duress alert hidden", len(alerts))
+	}
+	alerts, err := tc.crisis.ListAlerts("", 10, 0)
+	if err != nil {
+		t.Fatalf("ListAlerts: %v", err)
+	}
+	if len(alerts) != 1 || !alerts[0].Duress || alerts[0].Severity != RiskCritical {
+		t.Fatalf("staff see %+v, want one critical duress alert", alerts)
+	}
+	if _, err := tc.crisis.GetCrisisAlert(tc.userID, alerts[0].ID); !errors.Is(err, ErrAlertNotFound) {
+		t.Errorf("GetCrisisAlert for the duress alert: err = %v, want ErrAlertNotFound", err)
+	}
+	if to := tc.sent(t); len(to) != 1 || to[0] != tc.contact.Phone {
+		t.Errorf("texted %v, want the primary contact", to)
+	}
+}
+
+func TestCheckinRequiresPINForDuressPIN(t *testing.T) {
+	tc := newTestCheckins(t, nil)
+	_, err := tc.checkins.CreateCheckin(tc.userID, models.CreateCheckinRequest{
+		IntervalMinutes: 60,
+		DuressPIN:       "9999",
+	})
+	if !errors.Is(err, ErrInvalidCheckin) {
+		t.Errorf("CreateCheckin with only a duress PIN: err = %v, want ErrInvalidCheckin", err)
+	}
+}
+
+func TestPushCheckinFallsBackToSMS(t *testing.T) {
+	hub := NewEventHub()
+	tc := newTestCheckins(t, &HubPushSender{Hub: hub})
+	_, err := tc.checkins.CreateCheckin(tc.userID, models.CreateCheckinRequest{
+		IntervalMinutes: 60,
+		Phone:           "0712345678",
+	})
+	if err != nil {
+		t.Fatalf("CreateCheckin: %v", err)
+	}
+
+	tc.clock.Advance(time.Hour)
+	tc.tick(t)
+	if to := tc.sent(t); len(to) != 1 || to[0] != "+254712345678" {
+		t.Errorf("texted %v, want the check-in texted while the app is closed", to)
+	}
+}
@@ -0,0 +1,300 @@
+package services
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/heal/internal/models"
+)
+
+// Crisis alert statuses.
+const (
+	AlertActive       = "active"
+	AlertAcknowledged = "acknowledged"
+	AlertEscalated    = "escalated"
+	AlertResolved     = "resolved"
+)
+
+// alertTransitions is the crisis alert state machine: the statuses each
+// status can move to. Resolved alerts are final.
+var alertTransitions = map[string][]string{
+	AlertActive:       {AlertAcknowledged, AlertEscalated, AlertResolved},
+	AlertAcknowledged: {AlertEscalated, AlertResolved},
+	AlertEscalated:    {AlertAcknowledged, AlertResolved},
+	AlertResolved:     {},
+}
+
+// CanTransitionAlert reports whether an alert may move from one status to
+// another.
+func CanTransitionAlert(from, to string) bool {
+	for _, next := range alertTransitions[from] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+const crisisAlertColumns = `id, user_id, severity, COALESCE(message, ''), COALESCE(location, ''),
+	COALESCE(status, 'active'), COALESCE(acknowledged_by, ''), COALESCE(resolution_notes, ''),
+	created_at, acknowledged_at, escalated_at, resolved_at, COALESCE(duress, FALSE)`
+
+func (s *CrisisService) scanAlert(row rowScanner) (*models.CrisisAlert, error) {
+	a := &models.CrisisAlert{}
+	var location string
+	var acknowledgedAt, escalatedAt, resolvedAt sql.NullTime
+	err := row.Scan(&a.ID, &a.UserID, &a.Severity, &a.Message, &location, &a.Status,
+		&a.AcknowledgedBy, &a.ResolutionNotes, &a.CreatedAt, &acknowledgedAt, &escalatedAt, &resolvedAt, &a.Duress)
+	if err != nil {
+		return nil, err
+	}
+	a.Message = s.cipher.Reveal(a.UserID, a.Message)
+	a.ResolutionNotes = s.cipher.Reveal(a.UserID, a.ResolutionNotes)
+	a.Location = s.openLocation(a.UserID, location)
+	if a.Location == nil {
+		a.LocationText = s.openLocationText(a.UserID, location)
+	}
+	if acknowledgedAt.Valid {
+		a.AcknowledgedAt = &acknowledgedAt.Time
+	}
+	if escalatedAt.Valid {
+		a.EscalatedAt = &escalatedAt.Time
+	}
+	if resolvedAt.Valid {
+		a.ResolvedAt = &resolvedAt.Time
+	}
+	return a, nil
+}
+
+func (s *CrisisService) queryAlerts(query string, args ...interface{}) ([]models.CrisisAlert, error) {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	alerts := []models.CrisisAlert{}
+	for rows.Next() {
+		alert, err := s.scanAlert(rows)
+		if err != nil {
+			return nil, err
+		}
+		alerts = append(alerts, *alert)
+	}
+	return alerts, rows.Err()
+}
+
+// userAlertFilter leaves duress alerts out of what the user sees: whoever
+// made them enter the duress PIN may be watching the app.
+const userAlertFilter = "user_id = ? AND NOT COALESCE(duress, FALSE)"
+
+// GetCrisisAlerts lists the user's own alerts, newest first. An empty
+// status lists all of them.
+func (s *CrisisService) GetCrisisAlerts(userID, status string, limit, offset int) ([]models.CrisisAlert, error) {
+	if status != "" {
+		if _, ok := alertTransitions[status]; !ok {
+			return nil, ErrInvalidAlertStatus
+		}
+		return s.queryAlerts(`
+			SELECT `+crisisAlertColumns+` FROM crisis_alerts
+			WHERE `+userAlertFilter+` AND status = ?
+			ORDER BY created_at DESC LIMIT ? OFFSET ?
+		`, userID, status, limit, offset)
+	}
+	return s.queryAlerts(`
+		SELECT `+crisisAlertColumns+` FROM crisis_alerts
+		WHERE `+userAlertFilter+`
+		ORDER BY created_at DESC LIMIT ? OFFSET ?
+	`, userID, limit, offset)
+}
+
+// GetCrisisAlert returns one of the user's alerts with its history.
+func (s *CrisisService) GetCrisisAlert(userID, alertID string) (*models.CrisisAlert, error) {
+	alert, err := s.scanAlert(s.db.QueryRow(`
+		SELECT `+crisisAlertColumns+` FROM crisis_alerts WHERE id = ? AND `+userAlertFilter+`
+	`, alertID, userID))
+	if err == sql.ErrNoRows {
+		return nil, ErrAlertNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := s.loadAlertDetail(alert); err != nil {
+		return nil, err
+	}
+	return alert, nil
+}
+
+// ListAlerts is the staff view of alerts across all users: open alerts by
+// default, most severe and oldest first.
+func (s *CrisisService) ListAlerts(status string, limit, offset int) ([]models.CrisisAlert, error) {
+	where := "status != 'resolved'"
+	args := []interface{}{}
+	if status != "" {
+		if _, ok := alertTransitions[status]; !ok {
+			return nil, ErrInvalidAlertStatus
+		}
+		where = "status = ?"
+		args = append(args, status)
+	}
+	args = append(args, limit, offset)
+
+	return s.queryAlerts(`
+		SELECT `+crisisAlertColumns+` FROM crisis_alerts
+		WHERE `+where+`
+		ORDER BY CASE severity
+			WHEN 'critical' THEN 0
+			WHEN 'high' THEN 1
+			WHEN 'medium' THEN 2
+			WHEN 'low' THEN 3
+			ELSE 4 END,
+			created_at ASC
+		LIMIT ? OFFSET ?
+	`, args...)
+}
+
+// GetAlert is the staff view of a single alert with its history.
+func (s *CrisisService) GetAlert(alertID string) (*models.CrisisAlert, error) {
+	alert, err := s.scanAlert(s.db.QueryRow(`SELECT `+crisisAlertColumns+` FROM crisis_alerts WHERE id = ?`, alertID))
+	if err == sql.ErrNoRows {
+		return nil, ErrAlertNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := s.loadAlertDetail(alert); err != nil {
+		return nil, err
+	}
+	return alert, nil
+}
+
+// AcknowledgeAlert records that a staff member has seen the alert and is
+// dealing with it.
+func (s *CrisisService) AcknowledgeAlert(staffID, staffRole, alertID, note string) (*models.CrisisAlert, error) {
+	return s.TransitionAlert(alertID, AlertAcknowledged, staffID, staffRole, note)
+}
+
+// EscalateAlert hands the alert on to emergency services or a supervisor.
+func (s *CrisisService) EscalateAlert(staffID, staffRole, alertID, reason string) (*models.CrisisAlert, error) {
+	return s.TransitionAlert(alertID, AlertEscalated, staffID, staffRole, reason)
+}
+
+// ResolveAlert closes the alert with the staff member's notes.
+func (s *CrisisService) ResolveAlert(staffID, staffRole, alertID, notes string) (*models.CrisisAlert, error) {
+	return s.TransitionAlert(alertID, AlertResolved, staffID, staffRole, notes)
+}
+
+// TransitionAlert moves an alert to a new status if the state machine
+// allows it, and records the change in the alert's history. A concurrent
+// transition of the same alert makes this one fail with
+// ErrInvalidAlertTransition rather than overwrite it.
+func (s *CrisisService) TransitionAlert(alertID, to, actorID, actorRole, note string) (*models.CrisisAlert, error) {
+	if _, ok := alertTransitions[to]; !ok {
+		return nil, ErrInvalidAlertStatus
+	}
+
+	var userID, from string
+	err := s.db.QueryRow(`
+		SELECT user_id, COALESCE(status, 'active') FROM crisis_alerts WHERE id = ?
+	`, alertID).Scan(&userID, &from)
+	if err == sql.ErrNoRows {
+		return nil, ErrAlertNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !CanTransitionAlert(from, to) {
+		return nil, fmt.Errorf("%w: %s to %s", ErrInvalidAlertTransition, from, to)
+	}
+
+	storedNote, err := s.cipher.Encrypt(userID, note)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var result sql.Result
+	switch to {
+	case AlertAcknowledged:
+		result, err = tx.Exec(`
+			UPDATE crisis_alerts SET status = ?, acknowledged_at = ?, acknowledged_by = ?
+			WHERE id = ? AND COALESCE(status, 'active') = ?
+		`, to, now, actorID, alertID, from)
+	case AlertEscalated:
+		result, err = tx.Exec(`
+			UPDATE crisis_alerts SET status = ?, escalated_at = ?
+			WHERE id = ? AND COALESCE(status, 'active') = ?
+		`, to, now, alertID, from)
+	case AlertResolved:
+		result, err = tx.Exec(`
+			UPDATE crisis_alerts SET status = ?, resolved_at = ?, resolution_notes = ?
+			WHERE id = ? AND COALESCE(status, 'active') = ?
+		`, to, now, storedNote, alertID, from)
+	default:
+		result, err = tx.Exec(`
+			UPDATE crisis_alerts SET status = ? WHERE id = ? AND COALESCE(status, 'active') = ?
+		`, to, alertID, from)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to update crisis alert: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return nil, fmt.Errorf("%w: the alert changed while updating it", ErrInvalidAlertTransition)
+	}
+
+	if err := insertAlertEvent(tx, alertID, userID, from, to, actorID, actorRole, storedNote, now); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return s.GetAlert(alertID)
+}
+
+// insertAlertEvent writes a history row. note must already be encrypted.
+func insertAlertEvent(tx *sql.Tx, alertID, userID, from, to, actorID, actorRole, note string, at time.Time) error {
+	_, err := tx.Exec(`
+		INSERT INTO crisis_alert_events (id, alert_id, user_id, from_status, to_status, actor_id, actor_role, note, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, uuid.New().String(), alertID, userID, nullString(from), to, nullString(actorID), actorRole, nullString(note), at)
+	if err != nil {
+		return fmt.Errorf("failed to record crisis alert event: %w", err)
+	}
+	return nil
+}
+
+func (s *CrisisService) alertEvents(userID, alertID string) ([]models.CrisisAlertEvent, error) {
+	rows, err := s.db.Query(`
+		SELECT id, alert_id, COALESCE(from_status, ''), to_status, COALESCE(actor_id, ''),
+		       actor_role, COALESCE(note, ''), created_at
+		FROM crisis_alert_events
+		WHERE alert_id = ?
+		ORDER BY created_at, rowid
+	`, alertID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []models.CrisisAlertEvent{}
+	for rows.Next() {
+		var e models.CrisisAlertEvent
+		err := rows.Scan(&e.ID, &e.AlertID, &e.FromStatus, &e.ToStatus, &e.ActorID,
+			&e.ActorRole, &e.Note, &e.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		e.Note = s.cipher.Reveal(userID, e.Note)
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
@@ -0,0 +1,124 @@
+package services
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/heal/internal/models"
+)
+
+func TestCanTransitionAlert(t *testing.T) {
+	tests := []struct {
+		from, to string
+		want     bool
+	}{
+		{AlertActive, AlertAcknowledged, true},
+		{AlertActive, AlertEscalated, true},
+		{AlertActive, AlertResolved, true},
+		{AlertAcknowledged, AlertEscalated, true},
+		{AlertAcknowledged, AlertResolved, true},
+		{AlertAcknowledged, AlertActive, false},
+		{AlertEscalated, AlertAcknowledged, true},
+		{AlertEscalated, AlertActive, false},
+		{AlertResolved, AlertActive, false},
+		{AlertResolved, AlertAcknowledged, false},
+		{AlertActive, AlertActive, false},
+		{"unknown", AlertResolved, false},
+	}
+	for _, tt := range tests {
+		if got := CanTransitionAlert(tt.from, tt.to); got != tt.want {
+			t.Errorf("CanTransitionAlert(%s, %s) = %v, want %v", tt.from, tt.to, got, tt.want)
+		}
+	}
+}
+
+func TestTransitionAlertRecordsHistory(t *testing.T) {
+	db := newTestDB(t)
+	crisis := NewCrisisService(db, newTestCipher(t, db), nil, nil)
+	userID := createTestUser(t, db, "Amani")
+	alert, err := crisis.CreateCrisisAlert(userID, RiskHigh, "help", nil, "")
+	if err != nil {
+		t.Fatalf("CreateCrisisAlert: %v", err)
+	}
+
+	acknowledged, err := crisis.AcknowledgeAlert("staff-1", "counselor", alert.ID, "calling now")
+	if err != nil {
+		t.Fatalf("AcknowledgeAlert: %v", err)
+	}
+	if acknowledged.Status != AlertAcknowledged || acknowledged.AcknowledgedBy != "staff-1" || acknowledged.AcknowledgedAt == nil {
+		t.Errorf("acknowledged alert = %+v", acknowledged)
+	}
+
+	resolved, err := crisis.ResolveAlert("staff-1", "counselor", alert.ID, "safe with family")
+	if err != nil {
+		t.Fatalf("ResolveAlert: %v", err)
+	}
+	if resolved.Status != AlertResolved || resolved.ResolutionNotes != "safe with family" || resolved.ResolvedAt == nil {
+		t.Errorf("resolved alert = %+v", resolved)
+	}
+
+	if _, err := crisis.EscalateAlert("staff-1", "counselor", alert.ID, "again"); !errors.Is(err, ErrInvalidAlertTransition) {
+		t.Errorf("escalating a resolved alert: err = %v, want ErrInvalidAlertTransition", err)
+	}
+	if _, err := crisis.TransitionAlert(alert.ID, "closed", "staff-1", "counselor", ""); !errors.Is(err, ErrInvalidAlertStatus) {
+		t.Errorf("unknown status: err = %v, want ErrInvalidAlertStatus", err)
+	}
+	if _, err := crisis.TransitionAlert("missing", AlertResolved, "staff-1", "counselor", ""); !errors.Is(err, ErrAlertNotFound) {
+		t.Errorf("unknown alert: err = %v, want ErrAlertNotFound", err)
+	}
+
+	got, err := crisis.GetCrisisAlert(userID, alert.ID)
+	if err != nil {
+		t.Fatalf("GetCrisisAlert: %v", err)
+	}
+	want := []string{AlertActive, AlertAcknowledged, AlertResolved}
+	if len(got.Events) != len(want) {
+		t.Fatalf("recorded %d events, want %d", len(got.Events), len(want))
+	}
+	for i, e := range got.Events {
+		if e.ToStatus != want[i] {
+			t.Errorf("event %d to %s, want %s", i, e.ToStatus, want[i])
+		}
+	}
+
+	var storedNotes string
+	if err := db.QueryRow("SELECT resolution_notes FROM crisis_alerts WHERE id = ?", alert.ID).Scan(&storedNotes); err != nil {
+		t.Fatal(err)
+	}
+	if storedNotes == "safe with family" {
+		t.Error("resolution notes were stored in plaintext")
+	}
+}
+
+func TestCreateCrisisAlertKeepsAlertWithBadLocation(t *testing.T) {
+	db := newTestDB(t)
+	crisis := NewCrisisService(db, newTestCipher(t, db), nil, nil)
+	userID := createTestUser(t, db, "Amani")
+
+	stale := &models.AlertLocation{Latitude: -1.28, Longitude: 36.82, RecordedAt: time.Now().Add(-48 * time.Hour)}
+	alert, err := crisis.CreateCrisisAlert(userID, RiskHigh, "help", stale, "")
+	if err != nil {
+		t.Fatalf("CreateCrisisAlert with a stale fix: %v", err)
+	}
+	if alert.Location != nil || len(alert.Trail) != 0 {
+		t.Errorf("stale fix was kept: %+v", alert)
+	}
+
+	alert, err = crisis.CreateCrisisAlert(userID, RiskHigh, "help", nil, "near the market")
+	if err != nil {
+		t.Fatalf("CreateCrisisAlert with a free-text location: %v", err)
+	}
+	if alert.LocationText != "near the market" {
+		t.Errorf("locationText = %q, want the text sent", alert.LocationText)
+	}
+
+	fix := &models.AlertLocation{Latitude: -1.28, Longitude: 36.82, Source: LocationGPS, RecordedAt: time.Now()}
+	alert, err = crisis.CreateCrisisAlert(userID, RiskHigh, "help", fix, "")
+	if err != nil {
+		t.Fatalf("CreateCrisisAlert with a fix: %v", err)
+	}
+	if alert.Location == nil || len(alert.Trail) != 1 {
+		t.Errorf("alert = %+v, want the fix as its location and trail", alert)
+	}
+}
@@ -2,8 +2,8 @@ package services
 
 import (
 	"database/sql"
-	"encoding/json"
 	"fmt"
+	"log"
 	"time"
 
 	"github.com/google/uuid"
@@ -11,197 +11,102 @@ import (
 )
 
 type CrisisService struct {
-	db *sql.DB
+	db        *sql.DB
+	cipher    *FieldCipher
+	sms       *SMSDispatcher    // nil disables texting contacts
+	directory *DirectoryService // nil disables attaching the nearest responders
 }
 
-func NewCrisisService(db *sql.DB) *CrisisService {
-	return &CrisisService{db: db}
+func NewCrisisService(db *sql.DB, cipher *FieldCipher, sms *SMSDispatcher, directory *DirectoryService) *CrisisService {
+	return &CrisisService{db: db, cipher: cipher, sms: sms, directory: directory}
 }
 
-func (s *CrisisService) CreateCrisisAlert(userID, severity, message, location string) (*models.CrisisAlert, error) {
-	alertID := uuid.New().String()
-	now := time.Now()
-
-	_, err := s.db.Exec(`
-		INSERT INTO crisis_alerts (id, user_id, severity, message, location, status, created_at)
-		VALUES (?, ?, ?, ?, ?, ?, ?)
-	`, alertID, userID, severity, message, location, "active", now)
+// CreateCrisisAlert raises an alert. location is optional; when given, it
+// starts the alert's breadcrumb trail and the nearest police gender desk
+// and hospital are attached. locationText is a free-text location kept
+// when there is no usable fix. A bad location never stops the alert.
+func (s *CrisisService) CreateCrisisAlert(userID, severity, message string, location *models.AlertLocation, locationText string) (*models.CrisisAlert, error) {
+	alert, err := s.createAlert(userID, severity, message, location, locationText, false)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create crisis alert: %w", err)
+		return nil, err
 	}
-
-	return &models.CrisisAlert{
-		ID:        alertID,
-		UserID:    userID,
-		Severity:  severity,
-		Message:   message,
-		Location:  location,
-		Status:    "active",
-		CreatedAt: now,
-	}, nil
+	return s.GetCrisisAlert(userID, alert.ID)
 }
 
-func (s *CrisisService) GetEmergencyContacts(userID string) ([]models.EmergencyContact, error) {
-	query := `
-		SELECT id, user_id, name, phone, relationship, is_primary, created_at
-		FROM emergency_contacts
-		WHERE user_id = ?
-		ORDER BY is_primary DESC, created_at ASC
-	`
+// createAlert raises an alert. A duress alert is left out of everything
+// the user can see, and only staff and the user's contacts hear of it.
+func (s *CrisisService) createAlert(userID, severity, message string, location *models.AlertLocation, locationText string, duress bool) (*models.CrisisAlert, error) {
+	alertID := uuid.New().String()
+	now := time.Now()
 
-	rows, err := s.db.Query(query, userID)
+	storedMessage, err := s.cipher.Encrypt(userID, message)
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
-
-	var contacts []models.EmergencyContact
-	for rows.Next() {
-		var contact models.EmergencyContact
-		err := rows.Scan(&contact.ID, &contact.UserID, &contact.Name,
-			&contact.Phone, &contact.Relationship, &contact.IsPrimary, &contact.CreatedAt)
-		if err != nil {
+	if location != nil {
+		if err := validateAlertLocation(location, now); err != nil {
+			log.Printf("Warning: dropping location sent with alert %s: %v", alertID, err)
+			location = nil
+		}
+	}
+	var storedLocation string
+	if location != nil {
+		locationText = ""
+		if storedLocation, err = s.sealLocation(userID, location); err != nil {
+			return nil, err
+		}
+	} else if locationText != "" {
+		if runes := []rune(locationText); len(runes) > maxLocationTextLength {
+			locationText = string(runes[:maxLocationTextLength])
+		}
+		if storedLocation, err = s.cipher.Encrypt(userID, locationText); err != nil {
 			return nil, err
 		}
-		contacts = append(contacts, contact)
 	}
 
-	return contacts, nil
-}
-
-func (s *CrisisService) AddEmergencyContact(userID, name, phone, relationship string, isPrimary bool) (*models.EmergencyContact, error) {
-	contactID := uuid.New().String()
-	now := time.Now()
-
-	// If this is set as primary, unset other primary contacts
-	if isPrimary {
-		_, err := s.db.Exec("UPDATE emergency_contacts SET is_primary = FALSE WHERE user_id = ?", userID)
-		if err != nil {
-			return nil, fmt.Errorf("failed to update existing primary contacts: %w", err)
-		}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
 	}
+	defer tx.Rollback()
 
-	_, err := s.db.Exec(`
-		INSERT INTO emergency_contacts (id, user_id, name, phone, relationship, is_primary, created_at)
-		VALUES (?, ?, ?, ?, ?, ?, ?)
-	`, contactID, userID, name, phone, relationship, isPrimary, now)
+	_, err = tx.Exec(`
+		INSERT INTO crisis_alerts (id, user_id, severity, message, location, status, duress, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`, alertID, userID, severity, storedMessage, nullString(storedLocation), AlertActive, duress, now)
 	if err != nil {
-		return nil, fmt.Errorf("failed to add emergency contact: %w", err)
+		return nil, fmt.Errorf("failed to create crisis alert: %w", err)
+	}
+	if location != nil {
+		if err := s.insertBreadcrumb(tx, alertID, userID, storedLocation, location, now); err != nil {
+			return nil, err
+		}
+	}
+	if err := insertAlertEvent(tx, alertID, userID, "", AlertActive, userID, "user", "", now); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	if location != nil {
+		s.attachResponders(userID, alertID, location)
 	}
 
-	return &models.EmergencyContact{
-		ID:           contactID,
+	alert := &models.CrisisAlert{
+		ID:           alertID,
 		UserID:       userID,
-		Name:         name,
-		Phone:        phone,
-		Relationship: relationship,
-		IsPrimary:    isPrimary,
+		Severity:     severity,
+		Message:      message,
+		Location:     location,
+		LocationText: locationText,
+		Status:       AlertActive,
 		CreatedAt:    now,
-	}, nil
-}
-
-func (s *CrisisService) GetLocalServices(lat, lng float64) ([]map[string]interface{}, error) {
-	// In a real implementation, this would integrate with external APIs
-	// For now, return mock data
-	services := []map[string]interface{}{
-		{
-			"name":        "Central Hospital Emergency Room",
-			"phone":       "911",
-			"address":     "123 Main St, City, State",
-			"distance":    1.2,
-			"type":        "hospital",
-			"available":   true,
-			"description": "24/7 emergency services",
-		},
-		{
-			"name":        "Crisis Intervention Center",
-			"phone":       "555-0123",
-			"address":     "456 Oak Ave, City, State",
-			"distance":    2.8,
-			"type":        "crisis_center",
-			"available":   true,
-			"description": "Walk-in crisis support available",
-		},
-		{
-			"name":        "Mental Health Urgent Care",
-			"phone":       "555-0456",
-			"address":     "789 Pine St, City, State",
-			"distance":    3.5,
-			"type":        "urgent_care",
-			"available":   true,
-			"description": "Specialized mental health urgent care",
-		},
-	}
-
-	return services, nil
-}
-
-func (s *CrisisService) CreateSafetyPlan(userID string, plan map[string]interface{}) (*models.SafetyPlan, error) {
-	planID := uuid.New().String()
-	now := time.Now()
-
-	// Convert plan components to JSON strings
-	warningSigns, _ := json.Marshal(plan["warningSigns"])
-	copingStrategies, _ := json.Marshal(plan["copingStrategies"])
-	supportContacts, _ := json.Marshal(plan["supportContacts"])
-	professionalContacts, _ := json.Marshal(plan["professionalContacts"])
-	environmentSafety, _ := json.Marshal(plan["environmentSafety"])
-
-	// Check if user already has a safety plan
-	var existingID string
-	err := s.db.QueryRow("SELECT id FROM safety_plans WHERE user_id = ?", userID).Scan(&existingID)
-	
-	if err == sql.ErrNoRows {
-		// Create new safety plan
-		_, err = s.db.Exec(`
-			INSERT INTO safety_plans (id, user_id, warning_signs, coping_strategies, 
-			                         support_contacts, professional_contacts, environment_safety, 
-			                         created_at, updated_at)
-			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
-		`, planID, userID, string(warningSigns), string(copingStrategies),
-			string(supportContacts), string(professionalContacts), string(environmentSafety),
-			now, now)
-	} else if err == nil {
-		// Update existing safety plan
-		planID = existingID
-		_, err = s.db.Exec(`
-			UPDATE safety_plans 
-			SET warning_signs = ?, coping_strategies = ?, support_contacts = ?, 
-			    professional_contacts = ?, environment_safety = ?, updated_at = ?
-			WHERE id = ?
-		`, string(warningSigns), string(copingStrategies), string(supportContacts),
-			string(professionalContacts), string(environmentSafety), now, planID)
+		Duress:       duress,
 	}
 
-	if err != nil {
-		return nil, fmt.Errorf("failed to save safety plan: %w", err)
+	// The alert is raised even if the texts cannot be queued
+	if _, err := s.sms.AlertRaised(alert); err != nil {
+		log.Printf("Warning: failed to queue alert SMS for alert %s: %v", alertID, err)
 	}
-
-	return &models.SafetyPlan{
-		ID:                   planID,
-		UserID:               userID,
-		WarningSigns:         string(warningSigns),
-		CopingStrategies:     string(copingStrategies),
-		SupportContacts:      string(supportContacts),
-		ProfessionalContacts: string(professionalContacts),
-		EnvironmentSafety:    string(environmentSafety),
-		CreatedAt:            now,
-		UpdatedAt:            now,
-	}, nil
+	return alert, nil
 }
-
-func (s *CrisisService) GetSafetyPlan(userID string) (*models.SafetyPlan, error) {
-	plan := &models.SafetyPlan{}
-	err := s.db.QueryRow(`
-		SELECT id, user_id, warning_signs, coping_strategies, support_contacts,
-		       professional_contacts, environment_safety, created_at, updated_at
-		FROM safety_plans WHERE user_id = ?
-	`, userID).Scan(&plan.ID, &plan.UserID, &plan.WarningSigns, &plan.CopingStrategies,
-		&plan.SupportContacts, &plan.ProfessionalContacts, &plan.EnvironmentSafety,
-		&plan.CreatedAt, &plan.UpdatedAt)
-	
-	if err != nil {
-		return nil, err
-	}
-	return plan, nil
-}
\ No newline at end of file
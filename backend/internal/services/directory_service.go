@@ -0,0 +1,577 @@
+package services
+
+import (
+	"crypto/sha1"
+	"database/sql"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/heal/internal/models"
+)
+
+// Support service types.
+const (
+	ServiceGBVRecoveryCentre = "gbv_recovery_centre"
+	ServicePoliceGenderDesk  = "police_gender_desk"
+	ServiceHospital          = "hospital"
+	ServiceShelter           = "shelter"
+	ServiceLegalAid          = "legal_aid"
+)
+
+var supportServiceTypes = map[string]bool{
+	ServiceGBVRecoveryCentre: true,
+	ServicePoliceGenderDesk:  true,
+	ServiceHospital:          true,
+	ServiceShelter:           true,
+	ServiceLegalAid:          true,
+}
+
+const (
+	defaultSearchRadiusKm = 25
+	maxSearchRadiusKm     = 500
+	earthRadiusKm         = 6371.0
+)
+
+// DirectoryService is the directory of local support services survivors
+// can be pointed to.
+type DirectoryService struct {
+	db *sql.DB
+}
+
+func NewDirectoryService(db *sql.DB) *DirectoryService {
+	return &DirectoryService{db: db}
+}
+
+// ServiceSearch filters a directory search. A zero RadiusKm means the
+// default radius; empty Types means every type. Opening hours are evaluated
+// at At, or the current time when it is zero; OpenNow keeps only services
+// known to be open then.
+type ServiceSearch struct {
+	Latitude  float64
+	Longitude float64
+	RadiusKm  float64
+	Types     []string
+	OpenNow   bool
+	At        time.Time
+	Limit     int
+}
+
+const supportServiceColumns = `id, name, type, COALESCE(description, ''), COALESCE(phone, ''),
+	COALESCE(address, ''), COALESCE(county, ''), latitude, longitude, COALESCE(hours, ''),
+	COALESCE(languages, '[]'), COALESCE(services, '[]'), COALESCE(location_hidden, FALSE),
+	created_at, updated_at, COALESCE(open_24_7, FALSE), COALESCE(timezone, '')`
+
+// Search returns the services within the radius of a point, nearest first.
+func (s *DirectoryService) Search(q ServiceSearch) ([]models.SupportService, error) {
+	if q.Latitude < -90 || q.Latitude > 90 || q.Longitude < -180 || q.Longitude > 180 {
+		return nil, ErrInvalidLocation
+	}
+	if q.RadiusKm <= 0 {
+		q.RadiusKm = defaultSearchRadiusKm
+	}
+	q.RadiusKm = math.Min(q.RadiusKm, maxSearchRadiusKm)
+	for _, t := range q.Types {
+		if !supportServiceTypes[t] {
+			return nil, ErrInvalidServiceType
+		}
+	}
+
+	// A bounding box narrows the rows before the exact distance is worked
+	// out; it is widened near the poles where longitude degrees shrink
+	latDelta := q.RadiusKm / 111.0
+	lngDelta := 180.0
+	if cos := math.Cos(q.Latitude * math.Pi / 180); cos > 0.01 {
+		lngDelta = math.Min(q.RadiusKm/(111.0*cos), 180)
+	}
+	query := `SELECT ` + supportServiceColumns + ` FROM support_services
+		WHERE latitude BETWEEN ? AND ? AND longitude BETWEEN ? AND ?`
+	args := []interface{}{q.Latitude - latDelta, q.Latitude + latDelta, q.Longitude - lngDelta, q.Longitude + lngDelta}
+	if len(q.Types) > 0 {
+		query += " AND type IN (" + strings.TrimSuffix(strings.Repeat("?,", len(q.Types)), ",") + ")"
+		for _, t := range q.Types {
+			args = append(args, t)
+		}
+	}
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := []models.SupportService{}
+	for rows.Next() {
+		service, err := scanSupportService(rows)
+		if err != nil {
+			return nil, err
+		}
+		distance := HaversineKm(q.Latitude, q.Longitude, service.Latitude, service.Longitude)
+		if distance > q.RadiusKm {
+			continue
+		}
+		distance = math.Round(distance*10) / 10
+		service.DistanceKm = &distance
+		results = append(results, *service)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if err := s.loadHours(results); err != nil {
+		return nil, err
+	}
+
+	at := q.At
+	if at.IsZero() {
+		at = time.Now()
+	}
+	open := results[:0]
+	for i := range results {
+		evaluateHours(&results[i], at)
+		if q.OpenNow && (results[i].OpenNow == nil || !*results[i].OpenNow) {
+			continue
+		}
+		open = append(open, results[i])
+	}
+	results = open
+
+	sort.SliceStable(results, func(i, j int) bool { return *results[i].DistanceKm < *results[j].DistanceKm })
+	if q.Limit > 0 && len(results) > q.Limit {
+		results = results[:q.Limit]
+	}
+	for i := range results {
+		hideLocation(&results[i])
+	}
+	return results, nil
+}
+
+// GetService returns one service from the directory.
+func (s *DirectoryService) GetService(id string) (*models.SupportService, error) {
+	service, err := scanSupportService(s.db.QueryRow(`SELECT `+supportServiceColumns+` FROM support_services WHERE id = ?`, id))
+	if err == sql.ErrNoRows {
+		return nil, ErrServiceNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	services := []models.SupportService{*service}
+	if err := s.loadHours(services); err != nil {
+		return nil, err
+	}
+	service = &services[0]
+	evaluateHours(service, time.Now())
+	hideLocation(service)
+	return service, nil
+}
+
+// loadHours fills in the weekly hours and exceptions of services.
+func (s *DirectoryService) loadHours(services []models.SupportService) error {
+	if len(services) == 0 {
+		return nil
+	}
+	index := make(map[string]int, len(services))
+	args := make([]interface{}, len(services))
+	for i := range services {
+		index[services[i].ID] = i
+		args[i] = services[i].ID
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(services)), ",")
+
+	rows, err := s.db.Query(`SELECT service_id, weekday, opens, closes FROM support_service_hours
+		WHERE service_id IN (`+placeholders+`) ORDER BY weekday, opens`, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var serviceID string
+		var p models.OpeningPeriod
+		if err := rows.Scan(&serviceID, &p.Weekday, &p.Opens, &p.Closes); err != nil {
+			return err
+		}
+		service := &services[index[serviceID]]
+		service.OpeningHours = append(service.OpeningHours, p)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	rows, err = s.db.Query(`SELECT service_id, date, COALESCE(closed, FALSE), COALESCE(opens, ''),
+		COALESCE(closes, ''), COALESCE(note, '') FROM support_service_exceptions
+		WHERE service_id IN (`+placeholders+`) ORDER BY date`, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var serviceID string
+		var e models.HoursException
+		if err := rows.Scan(&serviceID, &e.Date, &e.Closed, &e.Opens, &e.Closes, &e.Note); err != nil {
+			return err
+		}
+		service := &services[index[serviceID]]
+		service.Exceptions = append(service.Exceptions, e)
+	}
+	return rows.Err()
+}
+
+// HaversineKm is the great-circle distance between two points in
+// kilometres.
+func HaversineKm(lat1, lng1, lat2, lng2 float64) float64 {
+	toRad := math.Pi / 180
+	dLat := (lat2 - lat1) * toRad
+	dLng := (lng2 - lng1) * toRad
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(lat1*toRad)*math.Cos(lat2*toRad)*math.Sin(dLng/2)*math.Sin(dLng/2)
+	return 2 * earthRadiusKm * math.Asin(math.Min(1, math.Sqrt(a)))
+}
+
+// hideLocation blurs a confidential service to about a kilometre and drops
+// its address. Distances are worked out before this.
+func hideLocation(service *models.SupportService) {
+	if !service.LocationHidden {
+		return
+	}
+	service.Address = ""
+	service.Latitude = math.Round(service.Latitude*100) / 100
+	service.Longitude = math.Round(service.Longitude*100) / 100
+	if service.DistanceKm != nil {
+		rounded := math.Round(*service.DistanceKm)
+		service.DistanceKm = &rounded
+	}
+}
+
+func scanSupportService(row rowScanner) (*models.SupportService, error) {
+	service := &models.SupportService{}
+	var languages, offered string
+	err := row.Scan(&service.ID, &service.Name, &service.Type, &service.Description, &service.Phone,
+		&service.Address, &service.County, &service.Latitude, &service.Longitude, &service.Hours,
+		&languages, &offered, &service.LocationHidden, &service.CreatedAt, &service.UpdatedAt,
+		&service.Open24x7, &service.Timezone)
+	if err != nil {
+		return nil, err
+	}
+	service.Languages = decodeStringList(languages)
+	service.Services = decodeStringList(offered)
+	if service.Timezone == "" {
+		service.Timezone = DefaultServiceTimezone
+	}
+	service.OpeningHours = []models.OpeningPeriod{}
+	service.Exceptions = []models.HoursException{}
+	return service, nil
+}
+
+func decodeStringList(raw string) []string {
+	list := []string{}
+	json.Unmarshal([]byte(raw), &list)
+	return list
+}
+
+// ImportServices validates and upserts services into the directory in one
+// transaction. With replace, services not in the import are removed. It
+// returns the number imported.
+func (s *DirectoryService) ImportServices(services []models.SupportService, replace bool) (int, error) {
+	for i := range services {
+		if err := validateSupportService(&services[i]); err != nil {
+			return 0, fmt.Errorf("service %d (%q): %w", i+1, services[i].Name, err)
+		}
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	if replace {
+		for _, table := range []string{"support_service_hours", "support_service_exceptions", "support_services"} {
+			if _, err := tx.Exec("DELETE FROM " + table); err != nil {
+				return 0, fmt.Errorf("failed to clear support services: %w", err)
+			}
+		}
+	}
+
+	now := time.Now()
+	for _, service := range services {
+		languages, _ := json.Marshal(service.Languages)
+		offered, _ := json.Marshal(service.Services)
+		_, err := tx.Exec(`
+			INSERT INTO support_services (id, name, type, description, phone, address, county, latitude,
+			                              longitude, hours, languages, services, location_hidden,
+			                              open_24_7, timezone, created_at, updated_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+			ON CONFLICT(id) DO UPDATE SET
+				name = excluded.name, type = excluded.type, description = excluded.description,
+				phone = excluded.phone, address = excluded.address, county = excluded.county,
+				latitude = excluded.latitude, longitude = excluded.longitude, hours = excluded.hours,
+				languages = excluded.languages, services = excluded.services,
+				location_hidden = excluded.location_hidden, open_24_7 = excluded.open_24_7,
+				timezone = excluded.timezone, updated_at = excluded.updated_at
+		`, service.ID, service.Name, service.Type, service.Description, service.Phone, service.Address,
+			service.County, service.Latitude, service.Longitude, service.Hours, string(languages),
+			string(offered), service.LocationHidden, service.Open24x7, service.Timezone, now, now)
+		if err != nil {
+			return 0, fmt.Errorf("failed to import %q: %w", service.Name, err)
+		}
+		if err := replaceHours(tx, service); err != nil {
+			return 0, fmt.Errorf("failed to import hours for %q: %w", service.Name, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return len(services), nil
+}
+
+// replaceHours swaps a service's stored hours and exceptions for the ones
+// imported with it.
+func replaceHours(tx *sql.Tx, service models.SupportService) error {
+	if _, err := tx.Exec("DELETE FROM support_service_hours WHERE service_id = ?", service.ID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM support_service_exceptions WHERE service_id = ?", service.ID); err != nil {
+		return err
+	}
+	for _, p := range service.OpeningHours {
+		_, err := tx.Exec(`INSERT INTO support_service_hours (service_id, weekday, opens, closes) VALUES (?, ?, ?, ?)`,
+			service.ID, p.Weekday, p.Opens, p.Closes)
+		if err != nil {
+			return err
+		}
+	}
+	for _, e := range service.Exceptions {
+		_, err := tx.Exec(`
+			INSERT INTO support_service_exceptions (service_id, date, closed, opens, closes, note)
+			VALUES (?, ?, ?, ?, ?, ?)
+			ON CONFLICT(service_id, date) DO UPDATE SET
+				closed = excluded.closed, opens = excluded.opens, closes = excluded.closes, note = excluded.note
+		`, service.ID, e.Date, e.Closed, nullString(e.Opens), nullString(e.Closes), nullString(e.Note))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateSupportService checks an imported service and fills in what can
+// be derived: an ID stable across re-imports, and hidden locations for
+// shelters.
+func validateSupportService(service *models.SupportService) error {
+	service.Name = strings.TrimSpace(service.Name)
+	service.Type = strings.TrimSpace(strings.ToLower(service.Type))
+	if service.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+	if !supportServiceTypes[service.Type] {
+		return ErrInvalidServiceType
+	}
+	if service.Latitude < -90 || service.Latitude > 90 || service.Longitude < -180 || service.Longitude > 180 ||
+		(service.Latitude == 0 && service.Longitude == 0) {
+		return ErrInvalidLocation
+	}
+	if service.Type == ServiceShelter {
+		service.LocationHidden = true
+	}
+	if service.Languages == nil {
+		service.Languages = []string{}
+	}
+	if service.Services == nil {
+		service.Services = []string{}
+	}
+	if err := validateHours(service); err != nil {
+		return err
+	}
+	if service.ID == "" {
+		sum := sha1.Sum([]byte(service.Type + "\x00" + strings.ToLower(service.Name)))
+		service.ID = "svc-" + hex.EncodeToString(sum[:8])
+	}
+	return nil
+}
+
+// ParseServicesCSV reads services from CSV with a header row. Recognised
+// columns are id, name, type, description, phone, address, county,
+// latitude, longitude, hours, languages, services, location_hidden,
+// open_24_7, timezone, opening_hours and exceptions; languages and services
+// are separated by semicolons, and opening_hours and exceptions use the
+// forms read by ParseWeeklyHours and ParseHoursExceptions.
+func ParseServicesCSV(r io.Reader) ([]models.SupportService, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CSV header: %w", err)
+	}
+	columns := map[string]int{}
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, required := range []string{"name", "type", "latitude", "longitude"} {
+		if _, ok := columns[required]; !ok {
+			return nil, fmt.Errorf("CSV is missing the %s column", required)
+		}
+	}
+
+	var services []models.SupportService
+	for line := 2; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		field := func(name string) string {
+			if i, ok := columns[name]; ok && i < len(record) {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+
+		lat, err := strconv.ParseFloat(field("latitude"), 64)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid latitude", line)
+		}
+		lng, err := strconv.ParseFloat(field("longitude"), 64)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid longitude", line)
+		}
+		hidden, _ := strconv.ParseBool(field("location_hidden"))
+		open24x7, _ := strconv.ParseBool(field("open_24_7"))
+		weekly, err := ParseWeeklyHours(field("opening_hours"))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		exceptions, err := ParseHoursExceptions(field("exceptions"))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+
+		services = append(services, models.SupportService{
+			ID:             field("id"),
+			Name:           field("name"),
+			Type:           field("type"),
+			Description:    field("description"),
+			Phone:          field("phone"),
+			Address:        field("address"),
+			County:         field("county"),
+			Latitude:       lat,
+			Longitude:      lng,
+			Hours:          field("hours"),
+			Languages:      splitList(field("languages")),
+			Services:       splitList(field("services")),
+			LocationHidden: hidden,
+			Open24x7:       open24x7,
+			Timezone:       field("timezone"),
+			OpeningHours:   weekly,
+			Exceptions:     exceptions,
+		})
+	}
+	return services, nil
+}
+
+// ParseServicesGeoJSON reads services from a FeatureCollection of Point
+// features. Properties use the CSV column names; languages and services may
+// be arrays or semicolon-separated strings.
+func ParseServicesGeoJSON(r io.Reader) ([]models.SupportService, error) {
+	var collection struct {
+		Type     string `json:"type"`
+		Features []struct {
+			ID       interface{} `json:"id"`
+			Geometry struct {
+				Type        string    `json:"type"`
+				Coordinates []float64 `json:"coordinates"`
+			} `json:"geometry"`
+			Properties map[string]interface{} `json:"properties"`
+		} `json:"features"`
+	}
+	if err := json.NewDecoder(r).Decode(&collection); err != nil {
+		return nil, fmt.Errorf("invalid GeoJSON: %w", err)
+	}
+	if collection.Type != "FeatureCollection" {
+		return nil, fmt.Errorf("GeoJSON must be a FeatureCollection")
+	}
+
+	services := make([]models.SupportService, 0, len(collection.Features))
+	for i, feature := range collection.Features {
+		if feature.Geometry.Type != "Point" || len(feature.Geometry.Coordinates) < 2 {
+			return nil, fmt.Errorf("feature %d: only Point geometries are supported", i+1)
+		}
+		props := feature.Properties
+		str := func(name string) string {
+			if v, ok := props[name].(string); ok {
+				return strings.TrimSpace(v)
+			}
+			return ""
+		}
+		list := func(name string) []string {
+			switch v := props[name].(type) {
+			case string:
+				return splitList(v)
+			case []interface{}:
+				items := []string{}
+				for _, item := range v {
+					if s, ok := item.(string); ok && strings.TrimSpace(s) != "" {
+						items = append(items, strings.TrimSpace(s))
+					}
+				}
+				return items
+			}
+			return []string{}
+		}
+
+		id := str("id")
+		if id == "" && feature.ID != nil {
+			id = fmt.Sprint(feature.ID)
+		}
+		hidden, _ := props["location_hidden"].(bool)
+		open24x7, _ := props["open_24_7"].(bool)
+		weekly, err := ParseWeeklyHours(str("opening_hours"))
+		if err != nil {
+			return nil, fmt.Errorf("feature %d: %w", i+1, err)
+		}
+		exceptions, err := ParseHoursExceptions(str("exceptions"))
+		if err != nil {
+			return nil, fmt.Errorf("feature %d: %w", i+1, err)
+		}
+
+		services = append(services, models.SupportService{
+			ID:          id,
+			Name:        str("name"),
+			Type:        str("type"),
+			Description: str("description"),
+			Phone:       str("phone"),
+			Address:     str("address"),
+			County:      str("county"),
+			// GeoJSON positions are longitude first
+			Latitude:       feature.Geometry.Coordinates[1],
+			Longitude:      feature.Geometry.Coordinates[0],
+			Hours:          str("hours"),
+			Languages:      list("languages"),
+			Services:       list("services"),
+			LocationHidden: hidden,
+			Open24x7:       open24x7,
+			Timezone:       str("timezone"),
+			OpeningHours:   weekly,
+			Exceptions:     exceptions,
+		})
+	}
+	return services, nil
+}
+
+func splitList(value string) []string {
+	items := []string{}
+	for _, item := range strings.Split(value, ";") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,91 @@
+package services
+
+import (
+	"errors"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/heal/internal/models"
+)
+
+func TestHaversineKm(t *testing.T) {
+	// Nairobi to Mombasa is about 440 km as the crow flies
+	if d := HaversineKm(-1.2864, 36.8172, -4.0435, 39.6682); math.Abs(d-440) > 10 {
+		t.Errorf("HaversineKm(Nairobi, Mombasa) = %.0f", d)
+	}
+}
+
+func TestDirectorySearch(t *testing.T) {
+	db := newTestDB(t)
+	s := NewDirectoryService(db)
+	imported, err := s.ImportServices([]models.SupportService{
+		{Name: "Near Desk", Type: ServicePoliceGenderDesk, Latitude: -1.2900, Longitude: 36.8200, Address: "Kenyatta Ave"},
+		{Name: "Safe House", Type: ServiceShelter, Latitude: -1.2950, Longitude: 36.8250, Address: "Secret Road"},
+		{Name: "Far Clinic", Type: ServiceHospital, Latitude: -4.0435, Longitude: 39.6682},
+	}, true)
+	if err != nil || imported != 3 {
+		t.Fatalf("ImportServices = %d, %v", imported, err)
+	}
+
+	results, err := s.Search(ServiceSearch{Latitude: -1.2864, Longitude: 36.8172, RadiusKm: 10})
+	if err != nil {
+		t.Fatalf("Search: %v", err)
+	}
+	if len(results) != 2 || results[0].Name != "Near Desk" || results[1].Name != "Safe House" {
+		t.Fatalf("results = %+v, want the two nearby services, nearest first", results)
+	}
+	if shelter := results[1]; !shelter.LocationHidden || shelter.Address != "" || shelter.Latitude != -1.3 {
+		t.Errorf("shelter = %+v, want its address hidden and location blurred", shelter)
+	}
+
+	results, err = s.Search(ServiceSearch{Latitude: -1.2864, Longitude: 36.8172, Types: []string{ServiceShelter}})
+	if err != nil || len(results) != 1 || results[0].Type != ServiceShelter {
+		t.Errorf("type filter = %+v, %v", results, err)
+	}
+	if _, err := s.Search(ServiceSearch{Latitude: 91}); !errors.Is(err, ErrInvalidLocation) {
+		t.Errorf("Search(lat 91): err = %v, want ErrInvalidLocation", err)
+	}
+	if _, err := s.Search(ServiceSearch{Types: []string{"spa"}}); !errors.Is(err, ErrInvalidServiceType) {
+		t.Errorf("Search(type spa): err = %v, want ErrInvalidServiceType", err)
+	}
+	if _, err := s.GetService("missing"); !errors.Is(err, ErrServiceNotFound) {
+		t.Errorf("GetService(missing): err = %v, want ErrServiceNotFound", err)
+	}
+}
+
+func TestImportServicesValidates(t *testing.T) {
+	s := NewDirectoryService(newTestDB(t))
+	if _, err := s.ImportServices([]models.SupportService{{Name: "Nowhere", Type: ServiceHospital}}, false); !errors.Is(err, ErrInvalidLocation) {
+		t.Errorf("service at 0,0: err = %v, want ErrInvalidLocation", err)
+	}
+	if _, err := s.ImportServices([]models.SupportService{{Name: "Spa", Type: "spa", Latitude: 1, Longitude: 1}}, false); !errors.Is(err, ErrInvalidServiceType) {
+		t.Errorf("unknown type: err = %v, want ErrInvalidServiceType", err)
+	}
+}
+
+func TestParseServices(t *testing.T) {
+	csvInput := "name,type,latitude,longitude,languages\n" +
+		"Gender Desk,police_gender_desk,-1.28,36.82,en; sw\n"
+	services, err := ParseServicesCSV(strings.NewReader(csvInput))
+	if err != nil || len(services) != 1 {
+		t.Fatalf("ParseServicesCSV = %+v, %v", services, err)
+	}
+	if got := services[0]; got.Latitude != -1.28 || strings.Join(got.Languages, ",") != "en,sw" {
+		t.Errorf("CSV service = %+v", got)
+	}
+	if _, err := ParseServicesCSV(strings.NewReader("name,type\nx,hospital\n")); err == nil {
+		t.Error("ParseServicesCSV accepted a file without coordinates")
+	}
+
+	geoJSON := `{"type":"FeatureCollection","features":[{"type":"Feature","id":7,
+		"geometry":{"type":"Point","coordinates":[36.82,-1.28]},
+		"properties":{"name":"Clinic","type":"hospital","services":["pep","medical"]}}]}`
+	services, err = ParseServicesGeoJSON(strings.NewReader(geoJSON))
+	if err != nil || len(services) != 1 {
+		t.Fatalf("ParseServicesGeoJSON = %+v, %v", services, err)
+	}
+	if got := services[0]; got.ID != "7" || got.Latitude != -1.28 || got.Longitude != 36.82 || len(got.Services) != 2 {
+		t.Errorf("GeoJSON service = %+v", got)
+	}
+}
@@ -0,0 +1,360 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"github.com/heal/internal/models"
+)
+
+// Emergency contact verification statuses. A contact is asked by SMS
+// whether they agree to be an emergency contact and answers by replying
+// YES or NO. Contacts who decline are never texted about alerts.
+const (
+	ContactUnverified = "unverified"
+	ContactPending    = "pending"
+	ContactVerified   = "verified"
+	ContactDeclined   = "declined"
+	ContactExpired    = "expired" // pending, but too long ago to answer
+)
+
+const (
+	// contactVerificationTTL is how long a contact has to reply.
+	contactVerificationTTL = 7 * 24 * time.Hour
+	// contactVerificationCooldown stops a contact being sent request after
+	// request.
+	contactVerificationCooldown = 10 * time.Minute
+	maxContactNameLength        = 100
+	maxRelationshipLength       = 50
+)
+
+// Replies accepted from a contact, in English and Swahili.
+var (
+	contactYes = map[string]bool{"YES": true, "Y": true, "NDIO": true, "NDIYO": true}
+	contactNo  = map[string]bool{"NO": true, "N": true, "HAPANA": true}
+)
+
+const contactColumns = `id, user_id, name, phone, COALESCE(relationship, ''), is_primary,
+	COALESCE(verification_status, 'unverified'), verification_sent_at, verified_at, created_at`
+
+func scanContact(row rowScanner) (*models.EmergencyContact, error) {
+	var contact models.EmergencyContact
+	var sentAt, verifiedAt sql.NullTime
+	err := row.Scan(&contact.ID, &contact.UserID, &contact.Name, &contact.Phone, &contact.Relationship,
+		&contact.IsPrimary, &contact.VerificationStatus, &sentAt, &verifiedAt, &contact.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if sentAt.Valid {
+		contact.VerificationSentAt = &sentAt.Time
+		if contact.VerificationStatus == ContactPending && time.Since(sentAt.Time) > contactVerificationTTL {
+			contact.VerificationStatus = ContactExpired
+		}
+	}
+	if verifiedAt.Valid {
+		contact.VerifiedAt = &verifiedAt.Time
+	}
+	return &contact, nil
+}
+
+func (s *CrisisService) GetEmergencyContacts(userID string) ([]models.EmergencyContact, error) {
+	rows, err := s.db.Query(`SELECT `+contactColumns+` FROM emergency_contacts
+		WHERE user_id = ?
+		ORDER BY is_primary DESC, created_at ASC`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var contacts []models.EmergencyContact
+	for rows.Next() {
+		contact, err := scanContact(rows)
+		if err != nil {
+			return nil, err
+		}
+		contacts = append(contacts, *contact)
+	}
+	return contacts, rows.Err()
+}
+
+func (s *CrisisService) getEmergencyContact(userID, contactID string) (*models.EmergencyContact, error) {
+	contact, err := scanContact(s.db.QueryRow(`SELECT `+contactColumns+` FROM emergency_contacts
+		WHERE id = ? AND user_id = ?`, contactID, userID))
+	if err == sql.ErrNoRows {
+		return nil, ErrContactNotFound
+	}
+	return contact, err
+}
+
+// primaryContact is the user's primary emergency contact, or their first
+// one if none is marked primary. Contacts who declined are passed over.
+func (s *CrisisService) primaryContact(userID string) (*models.EmergencyContact, error) {
+	contacts, err := s.GetEmergencyContacts(userID)
+	if err != nil {
+		return nil, err
+	}
+	for i := range contacts {
+		if contacts[i].VerificationStatus != ContactDeclined {
+			return &contacts[i], nil
+		}
+	}
+	return nil, errNoPrimaryContact
+}
+
+// validateContact trims a contact's details and normalizes its phone
+// number to E.164.
+func validateContact(name, phone, relationship *string) error {
+	*name = strings.TrimSpace(*name)
+	*relationship = strings.TrimSpace(*relationship)
+	if *name == "" || utf8.RuneCountInString(*name) > maxContactNameLength {
+		return fmt.Errorf("%w: name is required and must be at most %d characters", ErrInvalidContact, maxContactNameLength)
+	}
+	if utf8.RuneCountInString(*relationship) > maxRelationshipLength {
+		return fmt.Errorf("%w: relationship must be at most %d characters", ErrInvalidContact, maxRelationshipLength)
+	}
+	normalized, err := NormalizePhone(*phone)
+	if err != nil {
+		return err
+	}
+	*phone = normalized
+	return nil
+}
+
+func (s *CrisisService) AddEmergencyContact(userID, name, phone, relationship string, isPrimary bool) (*models.EmergencyContact, error) {
+	if err := validateContact(&name, &phone, &relationship); err != nil {
+		return nil, err
+	}
+	contactID := uuid.New().String()
+	now := time.Now()
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// If this is set as primary, unset other primary contacts
+	if isPrimary {
+		_, err := tx.Exec("UPDATE emergency_contacts SET is_primary = FALSE WHERE user_id = ?", userID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to update existing primary contacts: %w", err)
+		}
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO emergency_contacts (id, user_id, name, phone, relationship, is_primary, verification_status, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`, contactID, userID, name, phone, relationship, isPrimary, ContactUnverified, now)
+	if err != nil {
+		return nil, fmt.Errorf("failed to add emergency contact: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return &models.EmergencyContact{
+		ID:                 contactID,
+		UserID:             userID,
+		Name:               name,
+		Phone:              phone,
+		Relationship:       relationship,
+		IsPrimary:          isPrimary,
+		VerificationStatus: ContactUnverified,
+		CreatedAt:          now,
+	}, nil
+}
+
+// UpdateEmergencyContact changes a contact's details. A new phone number
+// has to be verified again.
+func (s *CrisisService) UpdateEmergencyContact(userID, contactID, name, phone, relationship string) (*models.EmergencyContact, error) {
+	if err := validateContact(&name, &phone, &relationship); err != nil {
+		return nil, err
+	}
+	contact, err := s.getEmergencyContact(userID, contactID)
+	if err != nil {
+		return nil, err
+	}
+
+	if phone != contact.Phone {
+		_, err = s.db.Exec(`
+			UPDATE emergency_contacts SET name = ?, phone = ?, relationship = ?, verification_status = ?,
+			       verification_code = NULL, verification_sent_at = NULL, verified_at = NULL
+			WHERE id = ? AND user_id = ?
+		`, name, phone, relationship, ContactUnverified, contactID, userID)
+	} else {
+		_, err = s.db.Exec("UPDATE emergency_contacts SET name = ?, relationship = ? WHERE id = ? AND user_id = ?",
+			name, relationship, contactID, userID)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to update emergency contact: %w", err)
+	}
+	return s.getEmergencyContact(userID, contactID)
+}
+
+// DeleteEmergencyContact removes a contact. If it was the primary contact,
+// the next one stands in until another is made primary.
+func (s *CrisisService) DeleteEmergencyContact(userID, contactID string) error {
+	result, err := s.db.Exec("DELETE FROM emergency_contacts WHERE id = ? AND user_id = ?", contactID, userID)
+	if err != nil {
+		return fmt.Errorf("failed to delete emergency contact: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrContactNotFound
+	}
+	return nil
+}
+
+// SetPrimaryContact makes a contact the user's primary contact.
+func (s *CrisisService) SetPrimaryContact(userID, contactID string) (*models.EmergencyContact, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var exists bool
+	err = tx.QueryRow("SELECT 1 FROM emergency_contacts WHERE id = ? AND user_id = ?", contactID, userID).Scan(&exists)
+	if err == sql.ErrNoRows {
+		return nil, ErrContactNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	_, err = tx.Exec("UPDATE emergency_contacts SET is_primary = (id = ?) WHERE user_id = ?", contactID, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to set primary contact: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return s.getEmergencyContact(userID, contactID)
+}
+
+// RequestContactVerification texts a contact to ask whether they agree to
+// be the user's emergency contact. They answer by replying YES or NO with
+// the code in the message.
+func (s *CrisisService) RequestContactVerification(ctx context.Context, userID, contactID string) (*models.EmergencyContact, error) {
+	if s.sms == nil || s.sms.sender == nil {
+		return nil, ErrVerificationNotEnabled
+	}
+	contact, err := s.getEmergencyContact(userID, contactID)
+	if err != nil {
+		return nil, err
+	}
+	switch contact.VerificationStatus {
+	case ContactVerified, ContactDeclined:
+		return nil, ErrContactVerified
+	case ContactPending:
+		if time.Since(*contact.VerificationSentAt) < contactVerificationCooldown {
+			return nil, ErrVerificationTooSoon
+		}
+	}
+
+	// Contacts added before numbers were normalized may not be in E.164,
+	// which replies are matched on
+	phone, err := NormalizePhone(contact.Phone)
+	if err != nil {
+		return nil, err
+	}
+	code, err := verificationCode()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	_, err = s.db.Exec(`
+		UPDATE emergency_contacts SET phone = ?, verification_status = ?, verification_code = ?,
+		       verification_sent_at = ?, verified_at = NULL
+		WHERE id = ?
+	`, phone, ContactPending, code, now, contactID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start verification: %w", err)
+	}
+
+	contact.Phone = phone
+	if err := s.sms.requestVerification(ctx, *contact, code); err != nil {
+		return nil, err
+	}
+	return s.getEmergencyContact(userID, contactID)
+}
+
+// HandleContactReply records a contact's answer to a verification request,
+// received as an SMS from the given number. The code can be left out if
+// only one request to that number is waiting. It reports whether the reply
+// answered a request.
+func (s *CrisisService) HandleContactReply(from, text string) (bool, error) {
+	phone, err := NormalizePhone(from)
+	if err != nil {
+		return false, nil
+	}
+	fields := strings.Fields(strings.ToUpper(text))
+	if len(fields) == 0 || len(fields) > 2 {
+		return false, nil
+	}
+	var status string
+	switch {
+	case contactYes[fields[0]]:
+		status = ContactVerified
+	case contactNo[fields[0]]:
+		status = ContactDeclined
+	default:
+		return false, nil
+	}
+
+	query := `SELECT id, verification_sent_at FROM emergency_contacts WHERE phone = ? AND verification_status = ?`
+	args := []interface{}{phone, ContactPending}
+	if len(fields) == 2 {
+		query += " AND verification_code = ?"
+		args = append(args, fields[1])
+	}
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return false, err
+	}
+	var ids []string
+	for rows.Next() {
+		var id string
+		var sentAt time.Time
+		if err := rows.Scan(&id, &sentAt); err != nil {
+			rows.Close()
+			return false, err
+		}
+		if time.Since(sentAt) <= contactVerificationTTL {
+			ids = append(ids, id)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return false, err
+	}
+	// Several users may have asked the same person; without a code there
+	// is no telling which request the reply answers
+	if len(ids) != 1 {
+		return false, nil
+	}
+
+	_, err = s.db.Exec(`
+		UPDATE emergency_contacts SET verification_status = ?, verification_code = NULL, verified_at = ?
+		WHERE id = ? AND verification_status = ?
+	`, status, time.Now(), ids[0], ContactPending)
+	if err != nil {
+		return false, fmt.Errorf("failed to record contact reply: %w", err)
+	}
+	return true, nil
+}
+
+// verificationCode returns a random four-digit code.
+func verificationCode() (string, error) {
+	n, err := rand.Int(rand.Reader, big.NewInt(10000))
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%04d", n.Int64()), nil
+}
@@ -0,0 +1,130 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"testing"
+)
+
+func TestNormalizePhone(t *testing.T) {
+	for raw, want := range map[string]string{
+		"0712 345 678":     "+254712345678",
+		"712345678":        "+254712345678",
+		"254712345678":     "+254712345678",
+		"+254 712 345 678": "+254712345678",
+		"00254712345678":   "+254712345678",
+		"+44 20 7946 0958": "+442079460958",
+	} {
+		if got, err := NormalizePhone(raw); err != nil || got != want {
+			t.Errorf("NormalizePhone(%q) = %q, %v; want %q", raw, got, err, want)
+		}
+	}
+	for _, raw := range []string{"1195", "999", "+254012345678", "0712-CALL-ME", ""} {
+		if got, err := NormalizePhone(raw); !errors.Is(err, ErrInvalidPhone) {
+			t.Errorf("NormalizePhone(%q) = %q, %v; want ErrInvalidPhone", raw, got, err)
+		}
+	}
+}
+
+func TestEmergencyContactPrimary(t *testing.T) {
+	db := newTestDB(t)
+	crisis := NewCrisisService(db, nil, nil, nil)
+	userID := createTestUser(t, db, "Amani")
+
+	first, err := crisis.AddEmergencyContact(userID, "Wanjiku", "0712345678", "sister", true)
+	if err != nil {
+		t.Fatalf("AddEmergencyContact: %v", err)
+	}
+	second, err := crisis.AddEmergencyContact(userID, "Juma", "0722000111", "friend", false)
+	if err != nil {
+		t.Fatalf("AddEmergencyContact: %v", err)
+	}
+	if first.Phone != "+254712345678" {
+		t.Errorf("phone = %q, want it normalized", first.Phone)
+	}
+
+	if _, err := crisis.SetPrimaryContact(userID, second.ID); err != nil {
+		t.Fatalf("SetPrimaryContact: %v", err)
+	}
+	primary, err := crisis.primaryContact(userID)
+	if err != nil || primary.ID != second.ID {
+		t.Fatalf("primaryContact = %+v, %v; want Juma", primary, err)
+	}
+
+	if err := crisis.DeleteEmergencyContact(userID, second.ID); err != nil {
+		t.Fatalf("DeleteEmergencyContact: %v", err)
+	}
+	if primary, err := crisis.primaryContact(userID); err != nil || primary.ID != first.ID {
+		t.Errorf("primaryContact after delete = %+v, %v; want Wanjiku to stand in", primary, err)
+	}
+	other := createTestUser(t, db, "Baraka")
+	if err := crisis.DeleteEmergencyContact(other, first.ID); !errors.Is(err, ErrContactNotFound) {
+		t.Errorf("deleting another user's contact: err = %v, want ErrContactNotFound", err)
+	}
+}
+
+var verificationCodePattern = regexp.MustCompile(`YES (\d{4})`)
+
+func TestEmergencyContactVerification(t *testing.T) {
+	d, sender, _ := newTestDispatcher(t)
+	crisis := NewCrisisService(d.db, nil, d, nil)
+	userID := createTestUser(t, d.db, "Amani")
+	contact := createContact(t, d, userID)
+	ctx := context.Background()
+
+	pending, err := crisis.RequestContactVerification(ctx, userID, contact.ID)
+	if err != nil || pending.VerificationStatus != ContactPending {
+		t.Fatalf("RequestContactVerification = %+v, %v", pending, err)
+	}
+	if _, err := crisis.RequestContactVerification(ctx, userID, contact.ID); !errors.Is(err, ErrVerificationTooSoon) {
+		t.Errorf("second request: err = %v, want ErrVerificationTooSoon", err)
+	}
+	if _, err := d.Flush(ctx); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+	sent := sender.Messages()
+	if len(sent) != 1 || sent[0].To != contact.Phone {
+		t.Fatalf("sent %+v, want one request to the contact", sent)
+	}
+	code := verificationCodePattern.FindStringSubmatch(sent[0].Body)
+	if code == nil {
+		t.Fatalf("no code in %q", sent[0].Body)
+	}
+
+	if ok, err := crisis.HandleContactReply(contact.Phone, "yes 0000"); err != nil || ok {
+		t.Errorf("reply with the wrong code = %v, %v; want it ignored", ok, err)
+	}
+	if ok, err := crisis.HandleContactReply("0712 345 678", "Ndio "+code[1]); err != nil || !ok {
+		t.Fatalf("HandleContactReply = %v, %v", ok, err)
+	}
+	verified, err := crisis.getEmergencyContact(userID, contact.ID)
+	if err != nil || verified.VerificationStatus != ContactVerified {
+		t.Fatalf("contact = %+v, %v; want verified", verified, err)
+	}
+	if _, err := crisis.RequestContactVerification(ctx, userID, contact.ID); !errors.Is(err, ErrContactVerified) {
+		t.Errorf("request after answering: err = %v, want ErrContactVerified", err)
+	}
+
+	updated, err := crisis.UpdateEmergencyContact(userID, contact.ID, "Wanjiku", "0733000222", "sister")
+	if err != nil || updated.VerificationStatus != ContactUnverified {
+		t.Errorf("changing the number = %+v, %v; want it unverified again", updated, err)
+	}
+}
+
+func TestDeclinedContactIsPassedOver(t *testing.T) {
+	d, _, _ := newTestDispatcher(t)
+	crisis := NewCrisisService(d.db, nil, d, nil)
+	userID := createTestUser(t, d.db, "Amani")
+	contact := createContact(t, d, userID)
+
+	if _, err := crisis.RequestContactVerification(context.Background(), userID, contact.ID); err != nil {
+		t.Fatalf("RequestContactVerification: %v", err)
+	}
+	if ok, err := crisis.HandleContactReply(contact.Phone, "HAPANA"); err != nil || !ok {
+		t.Fatalf("HandleContactReply = %v, %v", ok, err)
+	}
+	if c, err := crisis.primaryContact(userID); err != errNoPrimaryContact {
+		t.Errorf("primaryContact = %+v, %v; want the declined contact passed over", c, err)
+	}
+}
@@ -0,0 +1,68 @@
+package services
+
+import "errors"
+
+// Sentinel errors that handlers map onto HTTP status codes.
+var (
+	ErrSessionNotFound = errors.New("session not found or access denied")
+	ErrMessageNotFound = errors.New("message not found")
+	ErrFeedbackTarget  = errors.New("feedback can only be given on AI replies")
+	ErrNotEditable     = errors.New("only your own messages can be edited")
+	ErrNotRegenerable  = errors.New("only AI replies can be regenerated")
+	ErrEmptyMessage    = errors.New("content cannot be empty")
+
+	ErrCounselorAttached = errors.New("a counselor is attached to this session")
+	ErrHandoffNotFound   = errors.New("handoff request not found")
+	ErrHandoffClaimed    = errors.New("handoff request is no longer waiting")
+	ErrNotYourHandoff    = errors.New("this session is not assigned to you")
+
+	ErrKeyShredded      = errors.New("data key has been shredded")
+	ErrInvalidRetention = errors.New("chatRetention must be one of forever, 24h, 7d, 30d or logout")
+
+	ErrInvalidPrompt       = errors.New("invalid prompt template")
+	ErrPromptVersionExists = errors.New("prompt version already exists")
+	ErrPromptNotFound      = errors.New("prompt template not found")
+
+	ErrTranscriptionFailed = errors.New("could not transcribe voice note")
+	ErrNoAudio             = errors.New("message has no audio")
+
+	ErrInvalidExportFormat = errors.New("format must be one of pdf, md or json")
+	ErrTranscriptNotFound  = errors.New("no transcript with that hash was exported")
+
+	ErrInvalidTitle       = errors.New("invalid title")
+	ErrInvalidTags        = errors.New("invalid tags")
+	ErrInvalidBulkRequest = errors.New("invalid bulk request")
+
+	ErrInvalidMode = errors.New("mode must be one of gbv, stress, legal or youth")
+
+	ErrAlertNotFound          = errors.New("crisis alert not found")
+	ErrInvalidAlertStatus     = errors.New("status must be one of active, acknowledged, escalated or resolved")
+	ErrInvalidAlertTransition = errors.New("crisis alert cannot move to that status")
+	ErrInvalidAlertLocation   = errors.New("invalid alert location")
+	ErrAlertResolved          = errors.New("crisis alert has been resolved")
+
+	ErrInvalidSMSRecipients = errors.New("recipients must be one of off, primary or all")
+	ErrUnknownSMSProvider   = errors.New("unknown SMS provider")
+
+	ErrInvalidLocation    = errors.New("invalid latitude or longitude")
+	ErrInvalidServiceType = errors.New("type must be one of gbv_recovery_centre, police_gender_desk, hospital, shelter or legal_aid")
+	ErrServiceNotFound    = errors.New("support service not found")
+
+	ErrInvalidSafetyPlan         = errors.New("invalid safety plan")
+	ErrSafetyPlanNotFound        = errors.New("safety plan not found")
+	ErrSafetyPlanVersionNotFound = errors.New("safety plan version not found")
+	ErrInvalidPlanExportFormat   = errors.New("format must be one of pdf, txt or vcf")
+	ErrNoPlanContacts            = errors.New("the safety plan has no contacts with phone numbers")
+
+	ErrInvalidCheckin  = errors.New("invalid check-in")
+	ErrCheckinNotFound = errors.New("check-in not found")
+	ErrCheckinEnded    = errors.New("check-in is no longer active")
+	ErrWrongPIN        = errors.New("incorrect PIN")
+
+	ErrInvalidPhone           = errors.New("invalid phone number")
+	ErrInvalidContact         = errors.New("invalid emergency contact")
+	ErrContactNotFound        = errors.New("emergency contact not found")
+	ErrContactVerified        = errors.New("emergency contact has already answered")
+	ErrVerificationTooSoon    = errors.New("a verification SMS was sent recently; try again later")
+	ErrVerificationNotEnabled = errors.New("SMS verification is not available")
+)
@@ -0,0 +1,361 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/heal/internal/models"
+)
+
+// Escalation actions.
+const (
+	EscalateNotifyPrimaryContact = "notify_primary_contact"
+	EscalatePageOnCall           = "page_oncall"
+)
+
+// OnCallChannel is the EventHub channel the on-call counselor group
+// listens on for pages.
+const OnCallChannel = "staff:oncall"
+
+// maxEscalationAttempts is how many times a failing step is tried before it
+// is given up on.
+const maxEscalationAttempts = 3
+
+// Clock tells the time. The escalation worker takes one so its policies can
+// be tested without waiting.
+type Clock interface {
+	Now() time.Time
+}
+
+// SystemClock is the wall clock.
+type SystemClock struct{}
+
+func (SystemClock) Now() time.Time { return time.Now() }
+
+// EscalationPolicy lists, per alert severity, what happens while an alert
+// stays unacknowledged. It can be loaded from a JSON file.
+type EscalationPolicy struct {
+	Severities map[string][]EscalationStep `json:"severities"`
+}
+
+// EscalationStep runs once for an alert that is still unacknowledged
+// AfterMinutes after it was raised. With Escalate, the alert is also moved
+// to the escalated status.
+type EscalationStep struct {
+	AfterMinutes int    `json:"afterMinutes"`
+	Action       string `json:"action"`
+	Escalate     bool   `json:"escalate"`
+}
+
+// key identifies the step among an alert's steps in crisis_alert_escalations.
+func (s EscalationStep) key() string {
+	return fmt.Sprintf("%s@%dm", s.Action, s.AfterMinutes)
+}
+
+// DefaultEscalationPolicy is used when no policy file is configured.
+func DefaultEscalationPolicy() EscalationPolicy {
+	return EscalationPolicy{Severities: map[string][]EscalationStep{
+		"critical": {
+			{AfterMinutes: 5, Action: EscalateNotifyPrimaryContact},
+			{AfterMinutes: 15, Action: EscalatePageOnCall, Escalate: true},
+		},
+		"high": {
+			{AfterMinutes: 30, Action: EscalatePageOnCall, Escalate: true},
+		},
+	}}
+}
+
+// LoadEscalationPolicy reads a policy file, or returns the default policy
+// when path is empty.
+func LoadEscalationPolicy(path string) (EscalationPolicy, error) {
+	if path == "" {
+		return DefaultEscalationPolicy(), nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return EscalationPolicy{}, fmt.Errorf("failed to read escalation policy: %w", err)
+	}
+	var policy EscalationPolicy
+	if err := json.Unmarshal(data, &policy); err != nil {
+		return EscalationPolicy{}, fmt.Errorf("invalid escalation policy: %w", err)
+	}
+	return policy, policy.validate()
+}
+
+func (p EscalationPolicy) validate() error {
+	for severity, steps := range p.Severities {
+		if _, ok := riskRank[severity]; !ok || severity == RiskNone {
+			return fmt.Errorf("invalid escalation policy: unknown severity %q", severity)
+		}
+		seen := map[string]bool{}
+		for _, step := range steps {
+			if step.Action != EscalateNotifyPrimaryContact && step.Action != EscalatePageOnCall {
+				return fmt.Errorf("invalid escalation policy: unknown action %q", step.Action)
+			}
+			if step.AfterMinutes < 0 {
+				return fmt.Errorf("invalid escalation policy: afterMinutes must not be negative")
+			}
+			if seen[step.key()] {
+				return fmt.Errorf("invalid escalation policy: %s is listed twice for %s", step.key(), severity)
+			}
+			seen[step.key()] = true
+		}
+	}
+	return nil
+}
+
+// AlertNotifier delivers escalation notifications.
+type AlertNotifier interface {
+	// NotifyContact tells a survivor's emergency contact that they may need
+	// help.
+	NotifyContact(ctx context.Context, alert *models.CrisisAlert, contact models.EmergencyContact) error
+	// PageOnCall pages the on-call counselor group about an alert.
+	PageOnCall(ctx context.Context, alert *models.CrisisAlert) error
+}
+
+// errNoOnCall means a page could not be delivered because no on-call
+// counselor is connected to receive it.
+var errNoOnCall = errors.New("no on-call counselor is connected to receive the page")
+
+// HubNotifier pages on-call counselors through the EventHub and texts
+// contacts through SMS. Without an SMS dispatcher contact notifications are
+// only logged. A page only reaches counselors connected at the time, so
+// with none connected PageOnCall fails with errNoOnCall and the escalation
+// step is retried.
+type HubNotifier struct {
+	Hub *EventHub
+	SMS *SMSDispatcher
+}
+
+func (n *HubNotifier) NotifyContact(ctx context.Context, alert *models.CrisisAlert, contact models.EmergencyContact) error {
+	if n.SMS == nil {
+		log.Printf("Warning: no SMS provider configured; emergency contact %s was not notified", contact.ID)
+		return nil
+	}
+	return n.SMS.NotifyContact(ctx, alert, contact)
+}
+
+func (n *HubNotifier) PageOnCall(ctx context.Context, alert *models.CrisisAlert) error {
+	if n.Hub.Subscribers(OnCallChannel) == 0 {
+		return errNoOnCall
+	}
+	n.Hub.Publish(OnCallChannel, SessionEvent{Type: "alert_page", Data: map[string]interface{}{
+		"alertId":   alert.ID,
+		"severity":  alert.Severity,
+		"status":    alert.Status,
+		"createdAt": alert.CreatedAt,
+	}})
+	return nil
+}
+
+// EscalationService escalates crisis alerts nobody has acknowledged. A
+// background worker checks open alerts against the policy for their
+// severity; every step it runs is recorded in crisis_alert_escalations, so
+// steps run once even across restarts, and steps that fell due while the
+// server was down run on the next check.
+type EscalationService struct {
+	db       *sql.DB
+	crisis   *CrisisService
+	policy   EscalationPolicy
+	notifier AlertNotifier
+	clock    Clock
+}
+
+func NewEscalationService(db *sql.DB, crisis *CrisisService, policy EscalationPolicy, notifier AlertNotifier, clock Clock) (*EscalationService, error) {
+	if err := policy.validate(); err != nil {
+		return nil, err
+	}
+	// Tick relies on each severity's steps being in time order
+	sorted := EscalationPolicy{Severities: map[string][]EscalationStep{}}
+	for severity, steps := range policy.Severities {
+		steps = append([]EscalationStep{}, steps...)
+		sort.SliceStable(steps, func(i, j int) bool { return steps[i].AfterMinutes < steps[j].AfterMinutes })
+		sorted.Severities[severity] = steps
+	}
+	if clock == nil {
+		clock = SystemClock{}
+	}
+	return &EscalationService{db: db, crisis: crisis, policy: sorted, notifier: notifier, clock: clock}, nil
+}
+
+// Run checks for due escalation steps every interval until ctx is
+// cancelled.
+func (s *EscalationService) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := s.Tick(ctx); err != nil {
+			log.Printf("Warning: crisis escalation check failed: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Tick runs every escalation step that is due and returns how many ran.
+func (s *EscalationService) Tick(ctx context.Context) (int, error) {
+	now := s.clock.Now()
+
+	alerts, err := s.unacknowledgedAlerts(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	ran := 0
+	for i := range alerts {
+		alert := &alerts[i]
+		for _, step := range s.policy.Severities[alert.Severity] {
+			if now.Before(alert.CreatedAt.Add(time.Duration(step.AfterMinutes) * time.Minute)) {
+				break
+			}
+			due, err := s.stepDue(ctx, alert.ID, step)
+			if err != nil {
+				return ran, err
+			}
+			if !due {
+				continue
+			}
+			if err := s.runStep(ctx, alert, step, now); err != nil {
+				return ran, err
+			}
+			ran++
+		}
+	}
+	return ran, nil
+}
+
+// GetEscalations lists the steps run for an alert.
+func (s *EscalationService) GetEscalations(alertID string) ([]models.AlertEscalation, error) {
+	rows, err := s.db.Query(`
+		SELECT alert_id, step, action, status, attempts, COALESCE(error, ''), created_at, executed_at
+		FROM crisis_alert_escalations
+		WHERE alert_id = ?
+		ORDER BY created_at, rowid
+	`, alertID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	escalations := []models.AlertEscalation{}
+	for rows.Next() {
+		var e models.AlertEscalation
+		var executedAt sql.NullTime
+		err := rows.Scan(&e.AlertID, &e.Step, &e.Action, &e.Status, &e.Attempts, &e.Error,
+			&e.CreatedAt, &executedAt)
+		if err != nil {
+			return nil, err
+		}
+		if executedAt.Valid {
+			e.ExecutedAt = &executedAt.Time
+		}
+		escalations = append(escalations, e)
+	}
+	return escalations, rows.Err()
+}
+
+// unacknowledgedAlerts are the open alerts no staff member has picked up.
+// There are few of them, so the due check is done here rather than in SQL.
+func (s *EscalationService) unacknowledgedAlerts(ctx context.Context) ([]models.CrisisAlert, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, user_id, severity, COALESCE(status, 'active'), created_at
+		FROM crisis_alerts
+		WHERE COALESCE(status, 'active') IN ('active', 'escalated') AND acknowledged_at IS NULL
+		ORDER BY created_at
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var alerts []models.CrisisAlert
+	for rows.Next() {
+		var a models.CrisisAlert
+		if err := rows.Scan(&a.ID, &a.UserID, &a.Severity, &a.Status, &a.CreatedAt); err != nil {
+			return nil, err
+		}
+		alerts = append(alerts, a)
+	}
+	return alerts, rows.Err()
+}
+
+// stepDue reports whether a step has yet to run for the alert, or failed
+// and can be tried again.
+func (s *EscalationService) stepDue(ctx context.Context, alertID string, step EscalationStep) (bool, error) {
+	var status string
+	var attempts int
+	err := s.db.QueryRowContext(ctx, `
+		SELECT status, attempts FROM crisis_alert_escalations WHERE alert_id = ? AND step = ?
+	`, alertID, step.key()).Scan(&status, &attempts)
+	if err == sql.ErrNoRows {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return status == "failed" && attempts < maxEscalationAttempts, nil
+}
+
+func (s *EscalationService) runStep(ctx context.Context, alert *models.CrisisAlert, step EscalationStep, now time.Time) error {
+	status, stepErr := "done", s.execute(ctx, alert, step)
+	if errors.Is(stepErr, errNoPrimaryContact) || errors.Is(stepErr, errSMSNotOptedIn) || errors.Is(stepErr, errContactsTexted) {
+		status = "skipped"
+	} else if stepErr != nil {
+		status = "failed"
+		log.Printf("Warning: escalation step %s for alert %s failed: %v", step.key(), alert.ID, stepErr)
+	}
+	var errText string
+	if stepErr != nil {
+		errText = stepErr.Error()
+	}
+
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO crisis_alert_escalations (alert_id, step, action, status, attempts, error, created_at, executed_at)
+		VALUES (?, ?, ?, ?, 1, ?, ?, ?)
+		ON CONFLICT(alert_id, step) DO UPDATE SET
+			status = excluded.status,
+			attempts = attempts + 1,
+			error = excluded.error,
+			executed_at = excluded.executed_at
+	`, alert.ID, step.key(), step.Action, status, nullString(errText), now, now)
+	if err != nil {
+		return fmt.Errorf("failed to record escalation step: %w", err)
+	}
+	return nil
+}
+
+var errNoPrimaryContact = errors.New("no emergency contact to notify")
+
+func (s *EscalationService) execute(ctx context.Context, alert *models.CrisisAlert, step EscalationStep) error {
+	if step.Escalate && alert.Status == AlertActive {
+		escalated, err := s.crisis.TransitionAlert(alert.ID, AlertEscalated, "", "system",
+			fmt.Sprintf("not acknowledged within %d minutes", step.AfterMinutes))
+		if err != nil {
+			return err
+		}
+		alert.Status = escalated.Status
+	}
+
+	switch step.Action {
+	case EscalateNotifyPrimaryContact:
+		contact, err := s.crisis.primaryContact(alert.UserID)
+		if err != nil {
+			return err
+		}
+		return s.notifier.NotifyContact(ctx, alert, *contact)
+	case EscalatePageOnCall:
+		return s.notifier.PageOnCall(ctx, alert)
+	}
+	return nil
+}
@@ -0,0 +1,234 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/heal/internal/models"
+)
+
+// recordingNotifier is an AlertNotifier that records what it was asked to
+// send.
+type recordingNotifier struct {
+	contacts []string // contact IDs
+	pages    []string // alert IDs
+	pageErr  error
+}
+
+func (n *recordingNotifier) NotifyContact(ctx context.Context, alert *models.CrisisAlert, contact models.EmergencyContact) error {
+	n.contacts = append(n.contacts, contact.ID)
+	return nil
+}
+
+func (n *recordingNotifier) PageOnCall(ctx context.Context, alert *models.CrisisAlert) error {
+	n.pages = append(n.pages, alert.ID)
+	return n.pageErr
+}
+
+func newTestEscalation(t *testing.T) (*EscalationService, *CrisisService, *recordingNotifier, *fakeClock) {
+	t.Helper()
+	db := newTestDB(t)
+	crisis := NewCrisisService(db, newTestCipher(t, db), nil, nil)
+	notifier := &recordingNotifier{}
+	clock := newFakeClock(time.Now())
+	escalation, err := NewEscalationService(db, crisis, DefaultEscalationPolicy(), notifier, clock)
+	if err != nil {
+		t.Fatalf("NewEscalationService: %v", err)
+	}
+	return escalation, crisis, notifier, clock
+}
+
+func tick(t *testing.T, s *EscalationService) int {
+	t.Helper()
+	ran, err := s.Tick(context.Background())
+	if err != nil {
+		t.Fatalf("Tick: %v", err)
+	}
+	return ran
+}
+
+func TestEscalationRunsStepsWhenDue(t *testing.T) {
+	escalation, crisis, notifier, clock := newTestEscalation(t)
+	userID := createTestUser(t, crisis.db, "Amani")
+	contact, err := crisis.AddEmergencyContact(userID, "Wanjiku", "+254712345678", "sister", true)
+	if err != nil {
+		t.Fatalf("AddEmergencyContact: %v", err)
+	}
+	alert, err := crisis.CreateCrisisAlert(userID, RiskCritical, "help", nil, "")
+	if err != nil {
+		t.Fatalf("CreateCrisisAlert: %v", err)
+	}
+	clock.Set(alert.CreatedAt)
+
+	clock.Advance(4 * time.Minute)
+	if ran := tick(t, escalation); ran != 0 {
+		t.Fatalf("ran %d steps before the first was due", ran)
+	}
+
+	clock.Advance(time.Minute)
+	if ran := tick(t, escalation); ran != 1 {
+		t.Fatalf("ran %d steps at 5 minutes, want 1", ran)
+	}
+	if len(notifier.contacts) != 1 || notifier.contacts[0] != contact.ID {
+		t.Fatalf("notified contacts %v, want [%s]", notifier.contacts, contact.ID)
+	}
+	if ran := tick(t, escalation); ran != 0 {
+		t.Fatalf("ran %d steps again without time passing", ran)
+	}
+
+	clock.Advance(10 * time.Minute)
+	if ran := tick(t, escalation); ran != 1 {
+		t.Fatalf("ran %d steps at 15 minutes, want 1", ran)
+	}
+	if len(notifier.pages) != 1 {
+		t.Fatalf("paged %d times, want 1", len(notifier.pages))
+	}
+	got, err := crisis.GetAlert(alert.ID)
+	if err != nil {
+		t.Fatalf("GetAlert: %v", err)
+	}
+	if got.Status != AlertEscalated {
+		t.Errorf("status = %s, want %s", got.Status, AlertEscalated)
+	}
+
+	escalations, err := escalation.GetEscalations(alert.ID)
+	if err != nil {
+		t.Fatalf("GetEscalations: %v", err)
+	}
+	if len(escalations) != 2 {
+		t.Fatalf("recorded %d escalations, want 2", len(escalations))
+	}
+	for _, e := range escalations {
+		if e.Status != "done" {
+			t.Errorf("step %s status = %s, want done", e.Step, e.Status)
+		}
+	}
+}
+
+func TestEscalationSkipsAcknowledgedAlerts(t *testing.T) {
+	escalation, crisis, notifier, clock := newTestEscalation(t)
+	userID := createTestUser(t, crisis.db, "Amani")
+	alert, err := crisis.CreateCrisisAlert(userID, RiskHigh, "help", nil, "")
+	if err != nil {
+		t.Fatalf("CreateCrisisAlert: %v", err)
+	}
+	clock.Set(alert.CreatedAt)
+	if _, err := crisis.AcknowledgeAlert("staff-1", "counselor", alert.ID, "calling"); err != nil {
+		t.Fatalf("AcknowledgeAlert: %v", err)
+	}
+
+	clock.Advance(time.Hour)
+	if ran := tick(t, escalation); ran != 0 {
+		t.Fatalf("ran %d steps for an acknowledged alert", ran)
+	}
+	if len(notifier.pages) != 0 {
+		t.Errorf("paged %d times for an acknowledged alert", len(notifier.pages))
+	}
+}
+
+func TestEscalationSkipsMissingPrimaryContact(t *testing.T) {
+	escalation, crisis, notifier, clock := newTestEscalation(t)
+	userID := createTestUser(t, crisis.db, "Amani")
+	alert, err := crisis.CreateCrisisAlert(userID, RiskCritical, "help", nil, "")
+	if err != nil {
+		t.Fatalf("CreateCrisisAlert: %v", err)
+	}
+	clock.Set(alert.CreatedAt)
+
+	clock.Advance(5 * time.Minute)
+	if ran := tick(t, escalation); ran != 1 {
+		t.Fatalf("ran %d steps, want 1", ran)
+	}
+	if len(notifier.contacts) != 0 {
+		t.Errorf("notified %d contacts, want none", len(notifier.contacts))
+	}
+	escalations, err := escalation.GetEscalations(alert.ID)
+	if err != nil {
+		t.Fatalf("GetEscalations: %v", err)
+	}
+	if len(escalations) != 1 || escalations[0].Status != "skipped" {
+		t.Fatalf("escalations = %+v, want one skipped step", escalations)
+	}
+}
+
+func TestEscalationRetriesFailedSteps(t *testing.T) {
+	escalation, crisis, notifier, clock := newTestEscalation(t)
+	notifier.pageErr = errors.New("pager down")
+	userID := createTestUser(t, crisis.db, "Amani")
+	alert, err := crisis.CreateCrisisAlert(userID, RiskHigh, "help", nil, "")
+	if err != nil {
+		t.Fatalf("CreateCrisisAlert: %v", err)
+	}
+	clock.Set(alert.CreatedAt)
+
+	clock.Advance(30 * time.Minute)
+	for i := 0; i < maxEscalationAttempts+2; i++ {
+		tick(t, escalation)
+	}
+	if len(notifier.pages) != maxEscalationAttempts {
+		t.Fatalf("paged %d times, want %d", len(notifier.pages), maxEscalationAttempts)
+	}
+	escalations, err := escalation.GetEscalations(alert.ID)
+	if err != nil {
+		t.Fatalf("GetEscalations: %v", err)
+	}
+	if len(escalations) != 1 {
+		t.Fatalf("recorded %d escalations, want 1", len(escalations))
+	}
+	if e := escalations[0]; e.Status != "failed" || e.Attempts != maxEscalationAttempts || e.Error != "pager down" {
+		t.Errorf("escalation = %+v, want failed after %d attempts", e, maxEscalationAttempts)
+	}
+}
+
+func TestEscalationPolicyRejectsUnknownAction(t *testing.T) {
+	policy := EscalationPolicy{Severities: map[string][]EscalationStep{
+		RiskHigh: {{AfterMinutes: 5, Action: "email_everyone"}},
+	}}
+	if err := policy.validate(); err == nil {
+		t.Fatal("validate accepted an unknown action")
+	}
+}
+
+func TestSessionCannotSubscribeToOnCallPages(t *testing.T) {
+	hub := NewEventHub()
+	// Session IDs are chosen by clients, so one may be named after the
+	// on-call channel
+	events, unsubscribe := hub.Subscribe(SessionChannel(OnCallChannel))
+	defer unsubscribe()
+
+	notifier := &HubNotifier{Hub: hub}
+	if err := notifier.PageOnCall(context.Background(), &models.CrisisAlert{ID: "alert-1", Severity: RiskCritical}); !errors.Is(err, errNoOnCall) {
+		t.Errorf("PageOnCall: err = %v, want errNoOnCall", err)
+	}
+	select {
+	case event := <-events:
+		t.Errorf("a session received the page %+v", event)
+	default:
+	}
+}
+
+func TestPageOnCallNeedsConnectedCounselor(t *testing.T) {
+	hub := NewEventHub()
+	notifier := &HubNotifier{Hub: hub}
+	alert := &models.CrisisAlert{ID: "alert-1", Severity: RiskCritical}
+
+	if err := notifier.PageOnCall(context.Background(), alert); !errors.Is(err, errNoOnCall) {
+		t.Fatalf("PageOnCall with nobody on call: err = %v, want errNoOnCall", err)
+	}
+
+	pages, unsubscribe := hub.Subscribe(OnCallChannel)
+	defer unsubscribe()
+	if err := notifier.PageOnCall(context.Background(), alert); err != nil {
+		t.Fatalf("PageOnCall: %v", err)
+	}
+	select {
+	case event := <-pages:
+		if event.Type != "alert_page" {
+			t.Errorf("event = %+v, want an alert page", event)
+		}
+	default:
+		t.Error("the on-call counselor was not paged")
+	}
+}
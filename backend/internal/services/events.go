@@ -0,0 +1,84 @@
+package services
+
+import "sync"
+
+// SessionEvent is pushed to everyone watching a chat session: the survivor
+// and, during a handoff, the counselor.
+type SessionEvent struct {
+	Type string      `json:"type"` // 'message', 'counselor_joined', 'counselor_left', 'handoff_queued', 'alert_page', 'message_audio'
+	Data interface{} `json:"data"`
+}
+
+// EventHub fans session events out to live subscribers. It is in-process
+// only; subscribers connected to another instance will not see events.
+type EventHub struct {
+	mu          sync.Mutex
+	subscribers map[string]map[chan SessionEvent]struct{}
+}
+
+// SessionChannel is the EventHub channel of a chat session. Clients choose
+// their own session IDs, so session channels carry a prefix no other kind
+// of channel uses; a session cannot be named after a user's notifications
+// (UserChannel) or the on-call pages (OnCallChannel).
+func SessionChannel(sessionID string) string {
+	return "session:" + sessionID
+}
+
+func NewEventHub() *EventHub {
+	return &EventHub{subscribers: map[string]map[chan SessionEvent]struct{}{}}
+}
+
+// Subscribe returns a channel of the events published to channel and a
+// function that must be called to stop receiving them.
+func (h *EventHub) Subscribe(channel string) (<-chan SessionEvent, func()) {
+	ch := make(chan SessionEvent, 16)
+
+	h.mu.Lock()
+	if h.subscribers[channel] == nil {
+		h.subscribers[channel] = map[chan SessionEvent]struct{}{}
+	}
+	h.subscribers[channel][ch] = struct{}{}
+	h.mu.Unlock()
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			h.mu.Lock()
+			delete(h.subscribers[channel], ch)
+			if len(h.subscribers[channel]) == 0 {
+				delete(h.subscribers, channel)
+			}
+			h.mu.Unlock()
+			close(ch)
+		})
+	}
+}
+
+// Subscribers returns how many subscribers channel has right now.
+func (h *EventHub) Subscribers(channel string) int {
+	if h == nil {
+		return 0
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.subscribers[channel])
+}
+
+// Publish delivers event to current subscribers of channel. Slow
+// subscribers miss events rather than blocking the sender.
+func (h *EventHub) Publish(channel string, event SessionEvent) {
+	if h == nil {
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for ch := range h.subscribers[channel] {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
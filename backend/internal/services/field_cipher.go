@@ -0,0 +1,566 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/heal/internal/encryption"
+)
+
+// encryptedPrefix marks a column value produced by FieldCipher. Values
+// without it are legacy plaintext and are returned as-is.
+const encryptedPrefix = "enc:v1:"
+
+// RedactedContent replaces values whose data key has been shredded.
+const RedactedContent = "[content deleted]"
+
+// encryptedColumn is a column holding FieldCipher output. userColumn names
+// the user whose data key encrypts it.
+type encryptedColumn struct {
+	table      string
+	column     string
+	userColumn string
+}
+
+// encryptedColumns lists every column the services layer encrypts. The
+// re-encryption job walks exactly these.
+var encryptedColumns = []encryptedColumn{
+	{"chat_messages", "content", "user_id"},
+	{"chat_sessions", "title", "user_id"},
+	{"mood_logs", "notes", "user_id"},
+	{"safety_plans", "warning_signs", "user_id"},
+	{"safety_plans", "coping_strategies", "user_id"},
+	{"safety_plans", "support_contacts", "user_id"},
+	{"safety_plans", "professional_contacts", "user_id"},
+	{"safety_plans", "environment_safety", "user_id"},
+	{"safety_plan_versions", "warning_signs", "user_id"},
+	{"safety_plan_versions", "coping_strategies", "user_id"},
+	{"safety_plan_versions", "support_contacts", "user_id"},
+	{"safety_plan_versions", "professional_contacts", "user_id"},
+	{"safety_plan_versions", "environment_safety", "user_id"},
+	{"crisis_alerts", "message", "user_id"},
+	{"crisis_alerts", "resolution_notes", "user_id"},
+	{"crisis_alerts", "location", "user_id"},
+	{"crisis_alert_locations", "location", "user_id"},
+	{"crisis_alert_responders", "responder", "user_id"},
+	{"crisis_alert_events", "note", "user_id"},
+}
+
+// FieldCipher does envelope encryption of sensitive columns: each user has
+// their own AES-256 data key, stored in user_data_keys wrapped by the KMS
+// master key. Shredding a user's keys makes all of their ciphertext
+// unreadable.
+//
+// A nil *FieldCipher stores and returns plaintext.
+type FieldCipher struct {
+	db  *sql.DB
+	kms encryption.KMS
+
+	mu   sync.Mutex
+	keys map[string]map[int][]byte // user ID -> version -> unwrapped data key
+}
+
+func NewFieldCipher(db *sql.DB, kms encryption.KMS) *FieldCipher {
+	return &FieldCipher{db: db, kms: kms, keys: map[string]map[int][]byte{}}
+}
+
+// Encrypt seals plaintext with userID's current data key, creating the key
+// on first use. Empty strings stay empty.
+func (c *FieldCipher) Encrypt(userID, plaintext string) (string, error) {
+	if c == nil || plaintext == "" {
+		return plaintext, nil
+	}
+
+	version, key, err := c.currentKey(userID)
+	if err != nil {
+		return "", err
+	}
+	sealed, err := encryption.Seal(key, []byte(plaintext), []byte(userID))
+	if err != nil {
+		return "", fmt.Errorf("failed to encrypt field: %w", err)
+	}
+	return encryptedPrefix + strconv.Itoa(version) + ":" + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// Decrypt opens a value produced by Encrypt. Legacy plaintext is returned
+// unchanged; values whose key was shredded yield ErrKeyShredded.
+func (c *FieldCipher) Decrypt(userID, stored string) (string, error) {
+	version, sealed, ok, err := parseEncrypted(stored)
+	if !ok {
+		return stored, nil
+	}
+	if err != nil {
+		return "", err
+	}
+	if c == nil {
+		return "", errors.New("encrypted field but no cipher configured")
+	}
+
+	key, err := c.key(userID, version)
+	if err != nil {
+		return "", err
+	}
+	plaintext, err := encryption.Open(key, sealed, []byte(userID))
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt field: %w", err)
+	}
+	return string(plaintext), nil
+}
+
+// EncryptBytes is Encrypt for binary blobs such as voice notes. The result
+// carries the same "enc:v1:<version>:" header, followed by raw ciphertext.
+func (c *FieldCipher) EncryptBytes(userID string, data []byte) ([]byte, error) {
+	if c == nil {
+		return data, nil
+	}
+
+	version, key, err := c.currentKey(userID)
+	if err != nil {
+		return nil, err
+	}
+	sealed, err := encryption.Seal(key, data, []byte(userID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt blob: %w", err)
+	}
+	header := encryptedPrefix + strconv.Itoa(version) + ":"
+	return append([]byte(header), sealed...), nil
+}
+
+// DecryptBytes reverses EncryptBytes. Blobs without the header are
+// returned unchanged.
+func (c *FieldCipher) DecryptBytes(userID string, data []byte) ([]byte, error) {
+	rest, found := bytes.CutPrefix(data, []byte(encryptedPrefix))
+	if !found {
+		return data, nil
+	}
+	versionStr, sealed, found := bytes.Cut(rest, []byte(":"))
+	if !found {
+		return nil, errors.New("malformed encrypted blob")
+	}
+	version, err := strconv.Atoi(string(versionStr))
+	if err != nil {
+		return nil, errors.New("malformed encrypted blob version")
+	}
+	if c == nil {
+		return nil, errors.New("encrypted blob but no cipher configured")
+	}
+
+	key, err := c.key(userID, version)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := encryption.Open(key, sealed, []byte(userID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt blob: %w", err)
+	}
+	return plaintext, nil
+}
+
+// Reveal is Decrypt for read paths that should degrade rather than fail:
+// shredded or undecryptable values come back as RedactedContent.
+func (c *FieldCipher) Reveal(userID, stored string) string {
+	plaintext, err := c.Decrypt(userID, stored)
+	if err != nil {
+		if !errors.Is(err, ErrKeyShredded) {
+			log.Printf("Warning: failed to decrypt field for user %s: %v", userID, err)
+		}
+		return RedactedContent
+	}
+	return plaintext
+}
+
+// RotateUserKey gives userID a new data key. New writes use it; existing
+// values stay readable under their old version until re-encrypted.
+func (c *FieldCipher) RotateUserKey(userID string) (int, error) {
+	version, _, err := c.createKey(userID)
+	return version, err
+}
+
+// ShredUser destroys every data key userID has, crypto-shredding all of
+// their encrypted content. Values in encrypted columns that were never
+// encrypted, because they predate encryption at rest, are overwritten with
+// RedactedContent, and the user is recorded in shredded_users so the
+// re-encryption job leaves them alone. It runs in tx so callers can clear
+// derived data in the same transaction; once tx commits, dropKeys must be
+// called so no cached key outlives it. It cannot be undone.
+func (c *FieldCipher) ShredUser(tx *sql.Tx, userID string) error {
+	if c == nil {
+		return errors.New("encryption at rest is not configured")
+	}
+	now := time.Now()
+
+	_, err := tx.Exec(`
+		UPDATE user_data_keys SET wrapped_key = NULL, shredded_at = ?
+		WHERE user_id = ? AND shredded_at IS NULL
+	`, now, userID)
+	if err != nil {
+		return fmt.Errorf("failed to shred data keys: %w", err)
+	}
+	for _, col := range encryptedColumns {
+		_, err := tx.Exec(fmt.Sprintf(`
+			UPDATE %s SET %s = ?
+			WHERE %s = ? AND %s IS NOT NULL AND %s != '' AND %s NOT LIKE ?
+		`, col.table, col.column, col.userColumn, col.column, col.column, col.column),
+			RedactedContent, userID, encryptedPrefix+"%")
+		if err != nil {
+			return fmt.Errorf("failed to redact %s.%s: %w", col.table, col.column, err)
+		}
+	}
+	_, err = tx.Exec(`
+		INSERT INTO shredded_users (user_id, shredded_at) VALUES (?, ?)
+		ON CONFLICT(user_id) DO UPDATE SET shredded_at = excluded.shredded_at
+	`, userID, now)
+	if err != nil {
+		return fmt.Errorf("failed to record shredding: %w", err)
+	}
+	return nil
+}
+
+// dropKeys forgets the user's cached data keys. Keys are cached when first
+// unwrapped, so one read while a shredding transaction was open would
+// otherwise stay usable after it committed.
+func (c *FieldCipher) dropKeys(userID string) {
+	c.mu.Lock()
+	delete(c.keys, userID)
+	c.mu.Unlock()
+}
+
+// shredded reports whether userID has ever shredded their data keys.
+func (c *FieldCipher) shredded(ctx context.Context, userID string) (bool, error) {
+	var n int
+	err := c.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM shredded_users WHERE user_id = ?", userID).Scan(&n)
+	return n > 0, err
+}
+
+// RewrapKeys re-wraps every live data key under the KMS's active master
+// key, so older master keys can be retired. It returns how many keys moved.
+func (c *FieldCipher) RewrapKeys(ctx context.Context) (int, error) {
+	active := c.kms.ActiveVersion()
+	rows, err := c.db.QueryContext(ctx, `
+		SELECT user_id, version, wrapped_key, kms_version FROM user_data_keys
+		WHERE shredded_at IS NULL AND kms_version != ?
+	`, active)
+	if err != nil {
+		return 0, err
+	}
+
+	type staleKey struct {
+		userID     string
+		version    int
+		wrapped    []byte
+		kmsVersion int
+	}
+	var stale []staleKey
+	for rows.Next() {
+		var k staleKey
+		if err := rows.Scan(&k.userID, &k.version, &k.wrapped, &k.kmsVersion); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		stale = append(stale, k)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	rewrapped := 0
+	for _, k := range stale {
+		if err := ctx.Err(); err != nil {
+			return rewrapped, err
+		}
+		dataKey, err := c.kms.Unwrap(k.wrapped, k.kmsVersion)
+		if err != nil {
+			return rewrapped, fmt.Errorf("failed to unwrap key %d for user %s: %w", k.version, k.userID, err)
+		}
+		wrapped, kmsVersion, err := c.kms.Wrap(dataKey)
+		if err != nil {
+			return rewrapped, err
+		}
+		_, err = c.db.ExecContext(ctx, `
+			UPDATE user_data_keys SET wrapped_key = ?, kms_version = ?
+			WHERE user_id = ? AND version = ? AND shredded_at IS NULL
+		`, wrapped, kmsVersion, k.userID, k.version)
+		if err != nil {
+			return rewrapped, fmt.Errorf("failed to store rewrapped key: %w", err)
+		}
+		rewrapped++
+	}
+	return rewrapped, nil
+}
+
+// ReencryptStats summarises a ReencryptAll run.
+type ReencryptStats struct {
+	Scanned     int
+	Reencrypted int
+	Shredded    int // values left alone because their key is gone
+}
+
+// ReencryptAll brings every encrypted column up to date: legacy plaintext
+// is encrypted and values under an older data key version are re-sealed
+// with the user's current key. Plaintext belonging to a user who shredded
+// their keys is never encrypted, since that would make it readable again.
+// With rotate, every user first gets a fresh data key. Rows are processed
+// in batches and updated only if unchanged since they were read, so it is
+// safe to run against a live database.
+func (c *FieldCipher) ReencryptAll(ctx context.Context, rotate bool, batchSize int) (ReencryptStats, error) {
+	var stats ReencryptStats
+	if batchSize <= 0 {
+		batchSize = 500
+	}
+
+	if rotate {
+		userIDs, err := c.usersWithKeys(ctx)
+		if err != nil {
+			return stats, err
+		}
+		for _, userID := range userIDs {
+			if _, err := c.RotateUserKey(userID); err != nil {
+				return stats, err
+			}
+		}
+	}
+
+	for _, col := range encryptedColumns {
+		if err := c.reencryptColumn(ctx, col, batchSize, &stats); err != nil {
+			return stats, fmt.Errorf("%s.%s: %w", col.table, col.column, err)
+		}
+	}
+	return stats, nil
+}
+
+func (c *FieldCipher) reencryptColumn(ctx context.Context, col encryptedColumn, batchSize int, stats *ReencryptStats) error {
+	type row struct {
+		rowID  int64
+		userID string
+		value  string
+	}
+
+	var lastRowID int64
+	shredded := map[string]bool{}
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		rows, err := c.db.QueryContext(ctx, fmt.Sprintf(`
+			SELECT rowid, %s, %s FROM %s
+			WHERE rowid > ? AND %s IS NOT NULL AND %s != ''
+			ORDER BY rowid LIMIT ?
+		`, col.userColumn, col.column, col.table, col.column, col.column), lastRowID, batchSize)
+		if err != nil {
+			return err
+		}
+		var batch []row
+		for rows.Next() {
+			var r row
+			if err := rows.Scan(&r.rowID, &r.userID, &r.value); err != nil {
+				rows.Close()
+				return err
+			}
+			batch = append(batch, r)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		if len(batch) == 0 {
+			return nil
+		}
+
+		for _, r := range batch {
+			lastRowID = r.rowID
+			stats.Scanned++
+
+			version, _, encrypted, _ := parseEncrypted(r.value)
+			if !encrypted {
+				gone, ok := shredded[r.userID]
+				if !ok {
+					if gone, err = c.shredded(ctx, r.userID); err != nil {
+						return err
+					}
+					shredded[r.userID] = gone
+				}
+				if gone {
+					stats.Shredded++
+					continue
+				}
+			}
+			currentVersion, _, err := c.currentKey(r.userID)
+			if err != nil {
+				return err
+			}
+			if encrypted && version == currentVersion {
+				continue
+			}
+
+			plaintext, err := c.Decrypt(r.userID, r.value)
+			if errors.Is(err, ErrKeyShredded) {
+				stats.Shredded++
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			sealed, err := c.Encrypt(r.userID, plaintext)
+			if err != nil {
+				return err
+			}
+
+			_, err = c.db.ExecContext(ctx, fmt.Sprintf(
+				"UPDATE %s SET %s = ? WHERE rowid = ? AND %s = ?", col.table, col.column, col.column),
+				sealed, r.rowID, r.value)
+			if err != nil {
+				return err
+			}
+			stats.Reencrypted++
+		}
+	}
+}
+
+func (c *FieldCipher) usersWithKeys(ctx context.Context) ([]string, error) {
+	rows, err := c.db.QueryContext(ctx, "SELECT DISTINCT user_id FROM user_data_keys WHERE shredded_at IS NULL")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var userIDs []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		userIDs = append(userIDs, id)
+	}
+	return userIDs, rows.Err()
+}
+
+// currentKey returns the user's newest data key, creating one if they have
+// none or their newest has been shredded. A user who shredded their keys
+// gets a fresh one for what they write afterwards; reencryptColumn checks
+// shredded_users first so it never uses that key on older plaintext.
+func (c *FieldCipher) currentKey(userID string) (int, []byte, error) {
+	var version int
+	var shredded sql.NullTime
+	err := c.db.QueryRow(`
+		SELECT version, shredded_at FROM user_data_keys
+		WHERE user_id = ? ORDER BY version DESC LIMIT 1
+	`, userID).Scan(&version, &shredded)
+	if err == sql.ErrNoRows || (err == nil && shredded.Valid) {
+		return c.createKey(userID)
+	}
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to load data key: %w", err)
+	}
+
+	key, err := c.key(userID, version)
+	if err != nil {
+		return 0, nil, err
+	}
+	return version, key, nil
+}
+
+// createKey adds the next data key version for userID. If another writer
+// creates the same version first, that key is used instead.
+func (c *FieldCipher) createKey(userID string) (int, []byte, error) {
+	dataKey, err := encryption.NewDataKey()
+	if err != nil {
+		return 0, nil, err
+	}
+	wrapped, kmsVersion, err := c.kms.Wrap(dataKey)
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to wrap data key: %w", err)
+	}
+
+	var version int
+	err = c.db.QueryRow("SELECT COALESCE(MAX(version), 0) + 1 FROM user_data_keys WHERE user_id = ?", userID).Scan(&version)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	result, err := c.db.Exec(`
+		INSERT OR IGNORE INTO user_data_keys (user_id, version, wrapped_key, kms_version, created_at)
+		VALUES (?, ?, ?, ?, ?)
+	`, userID, version, wrapped, kmsVersion, time.Now())
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to store data key: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		key, err := c.key(userID, version)
+		return version, key, err
+	}
+
+	c.cacheKey(userID, version, dataKey)
+	return version, dataKey, nil
+}
+
+// key returns a specific data key version, unwrapping it via the KMS on
+// first use.
+func (c *FieldCipher) key(userID string, version int) ([]byte, error) {
+	c.mu.Lock()
+	cached := c.keys[userID][version]
+	c.mu.Unlock()
+	if cached != nil {
+		return cached, nil
+	}
+
+	var wrapped []byte
+	var kmsVersion int
+	err := c.db.QueryRow(`
+		SELECT wrapped_key, kms_version FROM user_data_keys WHERE user_id = ? AND version = ?
+	`, userID, version).Scan(&wrapped, &kmsVersion)
+	if err == sql.ErrNoRows || (err == nil && wrapped == nil) {
+		return nil, ErrKeyShredded
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load data key: %w", err)
+	}
+
+	dataKey, err := c.kms.Unwrap(wrapped, kmsVersion)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unwrap data key: %w", err)
+	}
+	c.cacheKey(userID, version, dataKey)
+	return dataKey, nil
+}
+
+func (c *FieldCipher) cacheKey(userID string, version int, key []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.keys[userID] == nil {
+		c.keys[userID] = map[int][]byte{}
+	}
+	c.keys[userID][version] = key
+}
+
+// parseEncrypted splits "enc:v1:<version>:<base64>". ok is false for values
+// that are not FieldCipher output.
+func parseEncrypted(stored string) (version int, sealed []byte, ok bool, err error) {
+	rest, found := strings.CutPrefix(stored, encryptedPrefix)
+	if !found {
+		return 0, nil, false, nil
+	}
+	versionStr, encoded, found := strings.Cut(rest, ":")
+	if !found {
+		return 0, nil, true, errors.New("malformed encrypted field")
+	}
+	version, err = strconv.Atoi(versionStr)
+	if err != nil {
+		return 0, nil, true, errors.New("malformed encrypted field version")
+	}
+	sealed, err = base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return 0, nil, true, errors.New("malformed encrypted field payload")
+	}
+	return version, sealed, true, nil
+}
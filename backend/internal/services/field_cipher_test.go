@@ -0,0 +1,226 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func insertMoodLog(t *testing.T, db *sql.DB, userID, notes string) string {
+	t.Helper()
+	id := uuid.New().String()
+	if _, err := db.Exec("INSERT INTO mood_logs (id, user_id, mood_score, notes) VALUES (?, ?, 5, ?)", id, userID, notes); err != nil {
+		t.Fatalf("failed to insert mood log: %v", err)
+	}
+	return id
+}
+
+func moodNotes(t *testing.T, db *sql.DB, id string) string {
+	t.Helper()
+	var notes string
+	if err := db.QueryRow("SELECT notes FROM mood_logs WHERE id = ?", id).Scan(&notes); err != nil {
+		t.Fatalf("failed to read mood log: %v", err)
+	}
+	return notes
+}
+
+func TestFieldCipherRoundTrip(t *testing.T) {
+	db := newTestDB(t)
+	cipher := newTestCipher(t, db)
+	userID := createTestUser(t, db, "Amani")
+
+	stored, err := cipher.Encrypt(userID, "I feel unsafe at home")
+	if err != nil {
+		t.Fatalf("Encrypt: %v", err)
+	}
+	if !strings.HasPrefix(stored, encryptedPrefix) || strings.Contains(stored, "unsafe") {
+		t.Fatalf("stored value %q is not ciphertext", stored)
+	}
+	plaintext, err := cipher.Decrypt(userID, stored)
+	if err != nil || plaintext != "I feel unsafe at home" {
+		t.Fatalf("Decrypt = %q, %v", plaintext, err)
+	}
+
+	if _, err := cipher.Decrypt(createTestUser(t, db, "Baraka"), stored); err == nil {
+		t.Error("another user's key opened the value")
+	}
+	if empty, _ := cipher.Encrypt(userID, ""); empty != "" {
+		t.Errorf("Encrypt(\"\") = %q, want empty", empty)
+	}
+	if legacy, err := cipher.Decrypt(userID, "written before encryption"); err != nil || legacy != "written before encryption" {
+		t.Errorf("Decrypt of legacy plaintext = %q, %v", legacy, err)
+	}
+}
+
+func TestFieldCipherRotateKeepsOldValuesReadable(t *testing.T) {
+	db := newTestDB(t)
+	cipher := newTestCipher(t, db)
+	userID := createTestUser(t, db, "Amani")
+
+	old, err := cipher.Encrypt(userID, "before rotation")
+	if err != nil {
+		t.Fatalf("Encrypt: %v", err)
+	}
+	if _, err := cipher.RotateUserKey(userID); err != nil {
+		t.Fatalf("RotateUserKey: %v", err)
+	}
+	if plaintext, err := cipher.Decrypt(userID, old); err != nil || plaintext != "before rotation" {
+		t.Fatalf("Decrypt after rotation = %q, %v", plaintext, err)
+	}
+	newer, err := cipher.Encrypt(userID, "after rotation")
+	if err != nil {
+		t.Fatalf("Encrypt: %v", err)
+	}
+	oldVersion, _, _, _ := parseEncrypted(old)
+	newVersion, _, _, _ := parseEncrypted(newer)
+	if newVersion <= oldVersion {
+		t.Errorf("new value sealed with key %d, want a key newer than %d", newVersion, oldVersion)
+	}
+}
+
+func TestShredUserRedactsEverything(t *testing.T) {
+	db := newTestDB(t)
+	cipher := newTestCipher(t, db)
+	userID := createTestUser(t, db, "Amani")
+	otherID := createTestUser(t, db, "Baraka")
+
+	stored, err := cipher.Encrypt(userID, "encrypted note")
+	if err != nil {
+		t.Fatalf("Encrypt: %v", err)
+	}
+	encrypted := insertMoodLog(t, db, userID, stored)
+	legacy := insertMoodLog(t, db, userID, "legacy plaintext note")
+	other := insertMoodLog(t, db, otherID, "someone else's note")
+
+	if err := NewUserService(db, cipher).ShredData(userID); err != nil {
+		t.Fatalf("ShredData: %v", err)
+	}
+
+	if _, err := cipher.Decrypt(userID, moodNotes(t, db, encrypted)); !errors.Is(err, ErrKeyShredded) {
+		t.Errorf("Decrypt after shredding: err = %v, want ErrKeyShredded", err)
+	}
+	if got := cipher.Reveal(userID, moodNotes(t, db, encrypted)); got != RedactedContent {
+		t.Errorf("Reveal after shredding = %q, want %q", got, RedactedContent)
+	}
+	if got := moodNotes(t, db, legacy); got != RedactedContent {
+		t.Errorf("legacy plaintext after shredding = %q, want %q", got, RedactedContent)
+	}
+	if got := moodNotes(t, db, other); got != "someone else's note" {
+		t.Errorf("another user's note = %q, want it untouched", got)
+	}
+}
+
+func TestReencryptAllEncryptsLegacyPlaintext(t *testing.T) {
+	db := newTestDB(t)
+	cipher := newTestCipher(t, db)
+	userID := createTestUser(t, db, "Amani")
+	if _, err := cipher.Encrypt(userID, "creates the user's key"); err != nil {
+		t.Fatalf("Encrypt: %v", err)
+	}
+	legacy := insertMoodLog(t, db, userID, "legacy plaintext note")
+
+	if _, err := cipher.ReencryptAll(context.Background(), false, 1); err != nil {
+		t.Fatalf("ReencryptAll: %v", err)
+	}
+	stored := moodNotes(t, db, legacy)
+	if !strings.HasPrefix(stored, encryptedPrefix) {
+		t.Fatalf("note %q was not encrypted", stored)
+	}
+	if plaintext, err := cipher.Decrypt(userID, stored); err != nil || plaintext != "legacy plaintext note" {
+		t.Errorf("Decrypt of re-encrypted note = %q, %v", plaintext, err)
+	}
+}
+
+func TestChatContentEncryptedAtRest(t *testing.T) {
+	db := newTestDB(t)
+	s := NewChatService(db, ChatDeps{Cipher: newTestCipher(t, db)})
+	userID := createTestUser(t, db, "Amani")
+	session := newTestChatSession(t, s, userID, "He took my phone again")
+
+	var stored string
+	if err := db.QueryRow("SELECT content FROM chat_messages WHERE session_id = ?", session.ID).Scan(&stored); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(stored, encryptedPrefix) {
+		t.Errorf("stored content %q is not ciphertext", stored)
+	}
+	if got := contents(history(t, s, userID, session.ID)); got != "He took my phone again" {
+		t.Errorf("history = %q, want the decrypted message", got)
+	}
+}
+
+func TestReencryptAllSkipsShreddedUsers(t *testing.T) {
+	db := newTestDB(t)
+	cipher := newTestCipher(t, db)
+	ctx := context.Background()
+	userID := createTestUser(t, db, "Amani")
+	otherID := createTestUser(t, db, "Baraka")
+
+	stored, err := cipher.Encrypt(userID, "encrypted note")
+	if err != nil {
+		t.Fatalf("Encrypt: %v", err)
+	}
+	encrypted := insertMoodLog(t, db, userID, stored)
+	if err := NewUserService(db, cipher).ShredData(userID); err != nil {
+		t.Fatalf("ShredData: %v", err)
+	}
+	// Plaintext left behind by a write racing the shredding
+	leftover := insertMoodLog(t, db, userID, "written during shredding")
+	other := insertMoodLog(t, db, otherID, "legacy plaintext note")
+
+	stats, err := cipher.ReencryptAll(ctx, false, 1)
+	if err != nil {
+		t.Fatalf("ReencryptAll: %v", err)
+	}
+	if stats.Reencrypted != 1 || stats.Shredded != 2 {
+		t.Errorf("stats = %+v, want 1 re-encrypted and 2 shredded", stats)
+	}
+	if got := moodNotes(t, db, leftover); got != "written during shredding" {
+		t.Errorf("shredded user's plaintext = %q, want it left alone", got)
+	}
+	if got := moodNotes(t, db, encrypted); got != stored {
+		t.Error("shredded user's ciphertext was rewritten")
+	}
+
+	otherStored := moodNotes(t, db, other)
+	if !strings.HasPrefix(otherStored, encryptedPrefix) {
+		t.Fatalf("other user's note %q was not encrypted", otherStored)
+	}
+	if plaintext, err := cipher.Decrypt(otherID, otherStored); err != nil || plaintext != "legacy plaintext note" {
+		t.Errorf("Decrypt of re-encrypted note = %q, %v", plaintext, err)
+	}
+}
+
+func TestShredDataIsAllOrNothing(t *testing.T) {
+	db := newTestDB(t)
+	cipher := newTestCipher(t, db)
+	userID := createTestUser(t, db, "Amani")
+	stored, err := cipher.Encrypt(userID, "encrypted note")
+	if err != nil {
+		t.Fatalf("Encrypt: %v", err)
+	}
+	legacy := insertMoodLog(t, db, userID, "legacy plaintext note")
+
+	// Make a step after the keys are shredded fail
+	if _, err := db.Exec("DROP TABLE moderation_events"); err != nil {
+		t.Fatal(err)
+	}
+	if err := NewUserService(db, cipher).ShredData(userID); err == nil {
+		t.Fatal("ShredData succeeded without moderation_events")
+	}
+
+	// A fresh cipher has no cached key, so this reads the stored one
+	if got, err := newTestCipher(t, db).Decrypt(userID, stored); err != nil || got != "encrypted note" {
+		t.Errorf("Decrypt after a failed shred = %q, %v; want the key kept", got, err)
+	}
+	if got := moodNotes(t, db, legacy); got != "legacy plaintext note" {
+		t.Errorf("legacy note after a failed shred = %q, want it untouched", got)
+	}
+	if n := countRows(t, db, "SELECT COUNT(*) FROM shredded_users WHERE user_id = ?", userID); n != 0 {
+		t.Error("the user was recorded as shredded")
+	}
+}
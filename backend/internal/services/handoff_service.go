@@ -0,0 +1,305 @@
+package services
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/heal/internal/models"
+)
+
+// HandoffService moves chat sessions between Nia and trained human
+// counselors. A survivor (or the risk check) queues a request, a counselor
+// claims it and joins the session, and Nia stays quiet until they release it.
+type HandoffService struct {
+	db          *sql.DB
+	chatService *ChatService
+	hub         *EventHub
+}
+
+func NewHandoffService(db *sql.DB, chatService *ChatService, hub *EventHub) *HandoffService {
+	return &HandoffService{db: db, chatService: chatService, hub: hub}
+}
+
+// RequestHandoff queues the session for a counselor. If the session already
+// has an open request, that request is returned (and its risk raised if
+// needed) instead of queueing a second one.
+func (s *HandoffService) RequestHandoff(userID, sessionID, reason, riskLevel string) (*models.HandoffRequest, error) {
+	if _, err := s.chatService.getChatSession(userID, sessionID); err != nil {
+		return nil, ErrSessionNotFound
+	}
+
+	existing, err := s.openRequest(sessionID)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+	if existing != nil {
+		if riskRank[riskLevel] > riskRank[existing.RiskLevel] {
+			existing.RiskLevel = riskLevel
+			_, err = s.db.Exec("UPDATE handoff_requests SET risk_level = ? WHERE id = ?", riskLevel, existing.ID)
+			if err != nil {
+				return nil, fmt.Errorf("failed to update handoff request: %w", err)
+			}
+		}
+		return existing, nil
+	}
+
+	request := &models.HandoffRequest{
+		ID:        uuid.New().String(),
+		SessionID: sessionID,
+		UserID:    userID,
+		Reason:    reason,
+		RiskLevel: riskLevel,
+		Status:    "waiting",
+		CreatedAt: time.Now(),
+	}
+
+	_, err = s.db.Exec(`
+		INSERT INTO handoff_requests (id, session_id, user_id, reason, risk_level, status, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, request.ID, request.SessionID, request.UserID, request.Reason, request.RiskLevel,
+		request.Status, request.CreatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create handoff request: %w", err)
+	}
+
+	s.hub.Publish(SessionChannel(sessionID), SessionEvent{Type: "handoff_queued", Data: request})
+	return request, nil
+}
+
+// MaybeRequestHandoff queues a handoff when a survivor's message asks for a
+// person or shows high risk. It returns nil when no handoff is needed.
+func (s *HandoffService) MaybeRequestHandoff(userID, sessionID, content string) (*models.HandoffRequest, error) {
+	risk := AssessRisk(content)
+	switch {
+	case WantsHuman(content):
+		return s.RequestHandoff(userID, sessionID, "requested", risk)
+	case RiskAtLeast(risk, RiskHigh):
+		return s.RequestHandoff(userID, sessionID, "risk", risk)
+	default:
+		return nil, nil
+	}
+}
+
+// GetQueue lists sessions waiting for a counselor, highest risk first.
+func (s *HandoffService) GetQueue(limit, offset int) ([]models.HandoffRequest, error) {
+	rows, err := s.db.Query(`
+		SELECT `+handoffColumns+`
+		FROM handoff_requests
+		WHERE status = 'waiting'
+		ORDER BY CASE risk_level
+			WHEN 'critical' THEN 0
+			WHEN 'high' THEN 1
+			WHEN 'medium' THEN 2
+			WHEN 'low' THEN 3
+			ELSE 4 END,
+			created_at ASC
+		LIMIT ? OFFSET ?
+	`, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	requests := []models.HandoffRequest{}
+	for rows.Next() {
+		r, err := scanHandoff(rows)
+		if err != nil {
+			return nil, err
+		}
+		requests = append(requests, *r)
+	}
+	return requests, rows.Err()
+}
+
+// Claim attaches counselorID to the request's session. Only one counselor
+// can win a claim.
+func (s *HandoffService) Claim(counselorID, handoffID string) (*models.HandoffRequest, error) {
+	now := time.Now()
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`
+		UPDATE handoff_requests SET status = 'claimed', counselor_id = ?, claimed_at = ?
+		WHERE id = ? AND status = 'waiting'
+	`, counselorID, now, handoffID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim handoff: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		if _, err := s.getRequest(handoffID); err == sql.ErrNoRows {
+			return nil, ErrHandoffNotFound
+		}
+		return nil, ErrHandoffClaimed
+	}
+
+	request, err := scanHandoff(tx.QueryRow(`SELECT `+handoffColumns+` FROM handoff_requests WHERE id = ?`, handoffID))
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.Exec("UPDATE chat_sessions SET counselor_id = ?, updated_at = ? WHERE id = ?",
+		counselorID, now, request.SessionID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to attach counselor: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	s.hub.Publish(SessionChannel(request.SessionID), SessionEvent{Type: "counselor_joined", Data: map[string]interface{}{
+		"handoffId":     request.ID,
+		"counselorName": s.counselorName(counselorID),
+	}})
+	return request, nil
+}
+
+// Release detaches the counselor. With requeue the session goes back to the
+// queue for another counselor; otherwise Nia resumes.
+func (s *HandoffService) Release(counselorID, handoffID string, requeue bool) (*models.HandoffRequest, error) {
+	request, err := s.getRequest(handoffID)
+	if err == sql.ErrNoRows {
+		return nil, ErrHandoffNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if request.Status != "claimed" || request.CounselorID != counselorID {
+		return nil, ErrNotYourHandoff
+	}
+
+	now := time.Now()
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if requeue {
+		_, err = tx.Exec(`
+			UPDATE handoff_requests SET status = 'waiting', counselor_id = NULL, claimed_at = NULL
+			WHERE id = ?
+		`, handoffID)
+		request.Status = "waiting"
+		request.CounselorID = ""
+		request.ClaimedAt = nil
+	} else {
+		_, err = tx.Exec(`
+			UPDATE handoff_requests SET status = 'released', released_at = ? WHERE id = ?
+		`, now, handoffID)
+		request.Status = "released"
+		request.ReleasedAt = &now
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to release handoff: %w", err)
+	}
+
+	_, err = tx.Exec("UPDATE chat_sessions SET counselor_id = NULL, updated_at = ? WHERE id = ?", now, request.SessionID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to detach counselor: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	s.hub.Publish(SessionChannel(request.SessionID), SessionEvent{Type: "counselor_left", Data: map[string]interface{}{
+		"handoffId": request.ID,
+		"requeued":  requeue,
+	}})
+	return request, nil
+}
+
+// SendCounselorMessage posts a counselor's message into a session they
+// have claimed.
+func (s *HandoffService) SendCounselorMessage(counselorID, sessionID, content string) (*models.ChatMessage, error) {
+	ownerID, err := s.attachedSessionOwner(counselorID, sessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	metadata := map[string]interface{}{
+		"counselorId":   counselorID,
+		"counselorName": s.counselorName(counselorID),
+	}
+	return s.chatService.SaveMessage(sessionID, ownerID, content, "counselor", "text", metadata)
+}
+
+// GetSessionHistory returns the active branch of a session the counselor
+// has claimed.
+func (s *HandoffService) GetSessionHistory(counselorID, sessionID string, limit, offset int) ([]models.ChatMessage, error) {
+	ownerID, err := s.attachedSessionOwner(counselorID, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	return s.chatService.GetChatHistory(ownerID, sessionID, limit, offset, false)
+}
+
+// CanWatch reports whether counselorID is attached to sessionID.
+func (s *HandoffService) CanWatch(counselorID, sessionID string) bool {
+	_, err := s.attachedSessionOwner(counselorID, sessionID)
+	return err == nil
+}
+
+func (s *HandoffService) attachedSessionOwner(counselorID, sessionID string) (string, error) {
+	var ownerID string
+	err := s.db.QueryRow(`
+		SELECT user_id FROM chat_sessions WHERE id = ? AND counselor_id = ?
+	`, sessionID, counselorID).Scan(&ownerID)
+	if err == sql.ErrNoRows {
+		return "", ErrNotYourHandoff
+	}
+	return ownerID, err
+}
+
+func (s *HandoffService) openRequest(sessionID string) (*models.HandoffRequest, error) {
+	return scanHandoff(s.db.QueryRow(`
+		SELECT `+handoffColumns+` FROM handoff_requests
+		WHERE session_id = ? AND status IN ('waiting', 'claimed')
+		ORDER BY created_at DESC LIMIT 1
+	`, sessionID))
+}
+
+func (s *HandoffService) getRequest(handoffID string) (*models.HandoffRequest, error) {
+	return scanHandoff(s.db.QueryRow(`SELECT `+handoffColumns+` FROM handoff_requests WHERE id = ?`, handoffID))
+}
+
+// counselorName is the counselor's first name, which is all the survivor
+// is shown.
+func (s *HandoffService) counselorName(counselorID string) string {
+	var name string
+	if err := s.db.QueryRow("SELECT first_name FROM users WHERE id = ?", counselorID).Scan(&name); err != nil {
+		return "Counselor"
+	}
+	return name
+}
+
+const handoffColumns = `id, session_id, user_id, reason, COALESCE(risk_level, 'none'), status,
+	COALESCE(counselor_id, ''), created_at, claimed_at, released_at`
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanHandoff(row rowScanner) (*models.HandoffRequest, error) {
+	r := &models.HandoffRequest{}
+	var claimedAt, releasedAt sql.NullTime
+	err := row.Scan(&r.ID, &r.SessionID, &r.UserID, &r.Reason, &r.RiskLevel, &r.Status,
+		&r.CounselorID, &r.CreatedAt, &claimedAt, &releasedAt)
+	if err != nil {
+		return nil, err
+	}
+	if claimedAt.Valid {
+		r.ClaimedAt = &claimedAt.Time
+	}
+	if releasedAt.Valid {
+		r.ReleasedAt = &releasedAt.Time
+	}
+	return r, nil
+}
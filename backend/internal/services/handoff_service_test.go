@@ -0,0 +1,115 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestHandoffLifecycle(t *testing.T) {
+	db := newTestDB(t)
+	hub := NewEventHub()
+	chat := NewChatService(db, ChatDeps{LLM: &stubLLM{reply: "Nia here"}, Hub: hub})
+	handoffs := NewHandoffService(db, chat, hub)
+	ctx := context.Background()
+	userID := createTestUser(t, db, "Amani")
+	counselorID := createTestUser(t, db, "Zawadi")
+	session, err := chat.GetOrCreateSession(userID, "", "")
+	if err != nil {
+		t.Fatalf("GetOrCreateSession: %v", err)
+	}
+	events, unsubscribe := hub.Subscribe(SessionChannel(session.ID))
+	defer unsubscribe()
+
+	request, err := handoffs.MaybeRequestHandoff(userID, session.ID, "Can I speak to a person?")
+	if err != nil || request == nil {
+		t.Fatalf("MaybeRequestHandoff = %v, %v; want a request", request, err)
+	}
+	again, err := handoffs.MaybeRequestHandoff(userID, session.ID, "he has a knife")
+	if err != nil {
+		t.Fatalf("MaybeRequestHandoff: %v", err)
+	}
+	if again.ID != request.ID || again.RiskLevel != RiskCritical {
+		t.Errorf("second request = %+v, want the first raised to critical", again)
+	}
+	if none, _ := handoffs.MaybeRequestHandoff(userID, session.ID, "thanks"); none != nil {
+		t.Errorf("an ordinary message queued a handoff: %+v", none)
+	}
+
+	if _, err := handoffs.Claim(counselorID, request.ID); err != nil {
+		t.Fatalf("Claim: %v", err)
+	}
+	if _, err := handoffs.Claim(createTestUser(t, db, "Other"), request.ID); !errors.Is(err, ErrHandoffClaimed) {
+		t.Errorf("second claim: err = %v, want ErrHandoffClaimed", err)
+	}
+
+	question, err := chat.SaveMessage(session.ID, userID, "are you there?", "user", "text", nil)
+	if err != nil {
+		t.Fatalf("SaveMessage: %v", err)
+	}
+	if _, err := chat.Reply(ctx, userID, question); !errors.Is(err, ErrCounselorAttached) {
+		t.Errorf("Reply with a counselor attached: err = %v, want ErrCounselorAttached", err)
+	}
+	if _, err := handoffs.SendCounselorMessage(counselorID, session.ID, "I'm here"); err != nil {
+		t.Fatalf("SendCounselorMessage: %v", err)
+	}
+	if _, err := handoffs.SendCounselorMessage(createTestUser(t, db, "Other"), session.ID, "hi"); !errors.Is(err, ErrNotYourHandoff) {
+		t.Errorf("message from an unattached counselor: err = %v, want ErrNotYourHandoff", err)
+	}
+
+	released, err := handoffs.Release(counselorID, request.ID, false)
+	if err != nil || released.Status != "released" {
+		t.Fatalf("Release = %+v, %v", released, err)
+	}
+	if _, err := chat.Reply(ctx, userID, question); err != nil {
+		t.Errorf("Reply after release: %v", err)
+	}
+
+	var types []string
+	for len(events) > 0 {
+		types = append(types, (<-events).Type)
+	}
+	want := []string{"handoff_queued", "counselor_joined", "message", "message", "counselor_left", "message"}
+	if len(types) != len(want) {
+		t.Fatalf("events = %v, want %v", types, want)
+	}
+	for i := range want {
+		if types[i] != want[i] {
+			t.Fatalf("events = %v, want %v", types, want)
+		}
+	}
+}
+
+func TestHandoffQueueOrder(t *testing.T) {
+	db := newTestDB(t)
+	chat := NewChatService(db, ChatDeps{})
+	handoffs := NewHandoffService(db, chat, nil)
+	userID := createTestUser(t, db, "Amani")
+
+	var ids []string
+	for _, risk := range []string{RiskLow, RiskCritical, RiskHigh} {
+		session, err := chat.GetOrCreateSession(userID, "", "")
+		if err != nil {
+			t.Fatalf("GetOrCreateSession: %v", err)
+		}
+		request, err := handoffs.RequestHandoff(userID, session.ID, "requested", risk)
+		if err != nil {
+			t.Fatalf("RequestHandoff: %v", err)
+		}
+		ids = append(ids, request.ID)
+		time.Sleep(time.Millisecond)
+	}
+
+	queue, err := handoffs.GetQueue(10, 0)
+	if err != nil {
+		t.Fatalf("GetQueue: %v", err)
+	}
+	if len(queue) != 3 || queue[0].ID != ids[1] || queue[1].ID != ids[2] || queue[2].ID != ids[0] {
+		t.Errorf("queue is not ordered by risk")
+	}
+
+	if _, err := handoffs.RequestHandoff(createTestUser(t, db, "Baraka"), queue[0].SessionID, "requested", RiskLow); !errors.Is(err, ErrSessionNotFound) {
+		t.Errorf("handoff for another user's session: err = %v, want ErrSessionNotFound", err)
+	}
+}
@@ -0,0 +1,110 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/heal/internal/database"
+	"github.com/heal/internal/encryption"
+)
+
+// fakeClock is a Clock that only moves when told to.
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func newFakeClock(now time.Time) *fakeClock {
+	return &fakeClock{now: now}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Set(now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// newTestDB opens a fresh database with the full schema.
+func newTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+	db, err := database.Initialize(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("failed to open test database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// createTestUser inserts a user and returns their ID.
+func createTestUser(t *testing.T, db *sql.DB, firstName string) string {
+	t.Helper()
+	id := uuid.New().String()
+	_, err := db.Exec(`
+		INSERT INTO users (id, email, password_hash, first_name, last_name) VALUES (?, ?, 'x', ?, 'Test')
+	`, id, id+"@example.com", firstName)
+	if err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+	return id
+}
+
+// stubLLM is an LLMProvider that replies with fixed text and records the
+// prompts it was sent.
+type stubLLM struct {
+	mu      sync.Mutex
+	reply   string
+	err     error
+	prompts []string
+}
+
+func (l *stubLLM) Name() string {
+	return "stub"
+}
+
+func (l *stubLLM) Generate(ctx context.Context, prompt string, opts GenerateOptions) (*LLMResponse, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.prompts = append(l.prompts, prompt)
+	if l.err != nil {
+		return nil, l.err
+	}
+	return &LLMResponse{Text: l.reply}, nil
+}
+
+func (l *stubLLM) calls() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.prompts)
+}
+
+func newTestChatService(t *testing.T, db *sql.DB, llm LLMProvider) *ChatService {
+	t.Helper()
+	return NewChatService(db, ChatDeps{LLM: llm})
+}
+
+// newTestCipher returns a FieldCipher under a fixed all-zero master key.
+func newTestCipher(t *testing.T, db *sql.DB) *FieldCipher {
+	t.Helper()
+	kms, err := encryption.NewLocalKMS(map[int][]byte{1: make([]byte, 32)}, 1)
+	if err != nil {
+		t.Fatalf("failed to create KMS: %v", err)
+	}
+	return NewFieldCipher(db, kms)
+}
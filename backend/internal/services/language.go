@@ -0,0 +1,41 @@
+package services
+
+import "strings"
+
+// swahiliMarkers are common Kiswahili words that rarely occur in English
+// text. They are enough to tell the two languages Nia supports apart.
+var swahiliMarkers = map[string]bool{
+	"habari": true, "sasa": true, "mimi": true, "wewe": true, "yeye": true,
+	"sisi": true, "nina": true, "sina": true, "nataka": true, "sitaki": true,
+	"naomba": true, "tafadhali": true, "asante": true, "sana": true, "kwa": true,
+	"na": true, "ya": true, "ni": true, "si": true, "kwamba": true, "lakini": true,
+	"nini": true, "wapi": true, "lini": true, "vipi": true, "ndiyo": true,
+	"hapana": true, "sijui": true, "niko": true, "uko": true, "yuko": true,
+	"salama": true, "msaada": true, "nisaidie": true, "mume": true, "mke": true,
+	"nyumbani": true, "leo": true, "jana": true, "kesho": true, "pole": true,
+	"mambo": true, "poa": true, "shida": true, "huzuni": true, "ogopa": true,
+	"naogopa": true, "nimechoka": true, "sawa": true, "kweli": true,
+}
+
+// detectLanguage returns "sw" when the text looks like Kiswahili and "en"
+// otherwise.
+func detectLanguage(text string) string {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !(r >= 'a' && r <= 'z')
+	})
+	if len(words) == 0 {
+		return "en"
+	}
+
+	hits := 0
+	for _, w := range words {
+		if swahiliMarkers[w] {
+			hits++
+		}
+	}
+
+	if hits >= 2 || (hits == 1 && len(words) <= 3) {
+		return "sw"
+	}
+	return "en"
+}
@@ -0,0 +1,19 @@
+package services
+
+import "testing"
+
+func TestDetectLanguage(t *testing.T) {
+	tests := map[string]string{
+		"Habari, nina shida nyumbani":   "sw",
+		"Asante sana":                   "sw",
+		"Mambo":                         "sw",
+		"I am scared to go home":        "en",
+		"Na yes I went to the hospital": "en",
+		"":                              "en",
+	}
+	for text, want := range tests {
+		if got := detectLanguage(text); got != want {
+			t.Errorf("detectLanguage(%q) = %q, want %q", text, got, want)
+		}
+	}
+}
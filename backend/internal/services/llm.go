@@ -0,0 +1,84 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/tmc/langchaingo/llms"
+	"github.com/tmc/langchaingo/llms/googleai"
+)
+
+// LLMProvider is the text-generation backend used by Nia and the
+// background helpers (session titles, etc.).
+type LLMProvider interface {
+	Name() string
+	Generate(ctx context.Context, prompt string, opts GenerateOptions) (*LLMResponse, error)
+}
+
+type GenerateOptions struct {
+	MaxTokens   int
+	Temperature float64
+}
+
+type LLMResponse struct {
+	Text             string
+	PromptTokens     int
+	CompletionTokens int
+}
+
+type GeminiProvider struct {
+	apiKey string
+}
+
+func NewGeminiProvider(apiKey string) *GeminiProvider {
+	return &GeminiProvider{apiKey: apiKey}
+}
+
+func (p *GeminiProvider) Name() string {
+	return "gemini"
+}
+
+func (p *GeminiProvider) Generate(ctx context.Context, prompt string, opts GenerateOptions) (*LLMResponse, error) {
+	if p.apiKey == "" {
+		return nil, fmt.Errorf("NEXT_PUBLIC_GEMINI_API_KEY not set in environment")
+	}
+	llm, err := googleai.New(ctx, googleai.WithAPIKey(p.apiKey))
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize GoogleAI: %w", err)
+	}
+
+	content := []llms.MessageContent{llms.TextParts(llms.ChatMessageTypeHuman, prompt)}
+	resp, err := llm.GenerateContent(ctx, content,
+		llms.WithMaxTokens(opts.MaxTokens),
+		llms.WithTemperature(opts.Temperature),
+	)
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.Choices) == 0 {
+		return nil, fmt.Errorf("empty response from GoogleAI")
+	}
+
+	choice := resp.Choices[0]
+	return &LLMResponse{
+		Text:             strings.TrimSpace(choice.Content),
+		PromptTokens:     tokenCount(choice.GenerationInfo["PromptTokens"]),
+		CompletionTokens: tokenCount(choice.GenerationInfo["CompletionTokens"]),
+	}, nil
+}
+
+func tokenCount(v interface{}) int {
+	switch n := v.(type) {
+	case int:
+		return n
+	case int32:
+		return int(n)
+	case int64:
+		return int(n)
+	case float64:
+		return int(n)
+	default:
+		return 0
+	}
+}
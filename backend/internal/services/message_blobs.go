@@ -0,0 +1,62 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"strings"
+
+	"github.com/heal/internal/storage"
+)
+
+// messageBlobKeys returns the blob storage keys linked from the metadata of
+// the messages query selects: voice note recordings and spoken replies.
+// query must select a single metadata column.
+func messageBlobKeys(ctx context.Context, tx *sql.Tx, query string, args ...interface{}) ([]string, error) {
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []string
+	for rows.Next() {
+		var raw sql.NullString
+		if err := rows.Scan(&raw); err != nil {
+			return nil, err
+		}
+		metadata := decodeMetadata(raw.String)
+		for _, field := range []string{"audioKey", "ttsKey"} {
+			if key := metadataString(metadata, field); key != "" {
+				keys = append(keys, key)
+			}
+		}
+	}
+	return keys, rows.Err()
+}
+
+// deleteMessageBlobs removes the blobs of messages that have been deleted.
+// Spoken replies are cached by their text and shared between messages, so
+// one still linked from a remaining message is kept. Failures are only
+// logged: the messages are gone either way.
+func deleteMessageBlobs(ctx context.Context, db *sql.DB, store storage.BlobStore, keys []string) {
+	if store == nil {
+		return
+	}
+	for _, key := range keys {
+		if strings.HasPrefix(key, "tts/") {
+			var linked int
+			err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM chat_messages WHERE instr(metadata, ?) > 0", key).Scan(&linked)
+			if err != nil {
+				log.Printf("Warning: failed to check whether spoken reply %s is still used: %v", key, err)
+				continue
+			}
+			if linked > 0 {
+				continue
+			}
+		}
+		if err := store.Delete(ctx, key); err != nil {
+			log.Printf("Warning: failed to delete blob %s: %v", key, err)
+		}
+	}
+}
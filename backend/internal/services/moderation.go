@@ -0,0 +1,316 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/heal/internal/models"
+)
+
+// Moderation categories.
+const (
+	ModerationVictimBlaming     = "victim_blaming"
+	ModerationDosageAdvice      = "dosage_advice"
+	ModerationSelfHarm          = "self_harm_encouragement"
+	ModerationUnverifiedHotline = "unverified_hotline"
+)
+
+// What was done about a failing reply.
+const (
+	moderationRegenerated = "regenerated"
+	moderationReplaced    = "replaced"
+)
+
+const defaultModerationRegenerates = 1
+
+// ModerationPolicy is what Nia's replies are checked against before they are
+// saved. It can be loaded from a JSON file so the rules can be tuned without
+// a release.
+type ModerationPolicy struct {
+	// MaxRegenerations is how many times a failing reply is asked for again
+	// before the safe fallback is sent instead.
+	MaxRegenerations int              `json:"maxRegenerations"`
+	Rules            []ModerationRule `json:"rules"`
+	// VerifiedNumbers are the phone numbers Nia may share, in addition to
+	// the hotlines in the system prompt.
+	VerifiedNumbers []string `json:"verifiedNumbers"`
+}
+
+// ModerationRule flags a reply when any pattern matches, unless the match
+// lies inside a match of one of the allow patterns ("it is not your fault"
+// must not trip the victim-blaming rule). Patterns are case-insensitive Go
+// regular expressions.
+type ModerationRule struct {
+	Category string   `json:"category"`
+	Patterns []string `json:"patterns"`
+	Allow    []string `json:"allow"`
+}
+
+// Violation is one policy breach found in a reply.
+type Violation struct {
+	Category string
+	Match    string
+}
+
+// DefaultModerationPolicy is used when no policy file is configured.
+func DefaultModerationPolicy() ModerationPolicy {
+	return ModerationPolicy{
+		MaxRegenerations: defaultModerationRegenerates,
+		Rules: []ModerationRule{
+			{
+				Category: ModerationVictimBlaming,
+				Patterns: []string{
+					`\b(it was|it's|it is) (partly |partially )?your (own )?fault\b`,
+					`\byou (must have|probably) (provoked|upset|angered) (him|her|them)\b`,
+					`\byou provoked\b`,
+					`\byou (deserved|asked for) (it|this|that)\b`,
+					`\bwhat (were you wearing|did you do to (make|cause))\b`,
+					`\bwhy (didn't|did not|don't) you (just )?(leave|fight back|say no|report)\b`,
+					`\byou should(n't| not) have (gone|worn|been|said|made)\b`,
+					`\b(ni|lilikuwa) kosa lako\b`,
+					`\bulistahili\b`,
+					`\bulimchokoza\b`,
+				},
+				Allow: []string{
+					`\b(not|never|isn't|wasn't|is not|was not) (partly |partially )?your (own )?fault\b`,
+					`\b(si|sio|haikuwa) kosa lako\b`,
+					`\b(you|nobody|no one) (did not|didn't|does not|doesn't|ever) deserve\w*`,
+					`\bhukustahili\b`,
+				},
+			},
+			{
+				Category: ModerationDosageAdvice,
+				Patterns: []string{
+					`\b\d+(\.\d+)?\s?(mg|milligrams?|mcg|micrograms?|ml|millilitres?|milliliters?)\b`,
+					`\b(take|swallow|use|kunywa|meza|tumia)\s+(up to\s+)?(\d+|one|two|three|four|five|half)\s+(of (the|your|these)\s+)?(pills?|tablets?|capsules?|doses?|vidonge|kidonge)\b`,
+					`\b(\d+|once|twice|three times)\s+(a|per|every)\s+(day|night|hours?)\b.{0,40}\b(pills?|tablets?|capsules?|medication|medicine|dawa)\b`,
+					`\bmaximum (daily )?dose\b`,
+				},
+			},
+			{
+				Category: ModerationSelfHarm,
+				Patterns: []string{
+					`\b(you should|go ahead and|why not|maybe you should|you could)\s+(just\s+)?(kill|hurt|harm|cut|end)\s+(yourself|your life)\b`,
+					`\b(nobody|no one) (would|will) miss you\b`,
+					`\b(better|best) off dead\b`,
+					`\b(the )?(only|best) way out is\b`,
+					`\bhow to (kill|hurt|harm|cut) yourself\b`,
+					`\b(ujiue|jiue|ujidhuru)\b`,
+				},
+				Allow: []string{
+					`\b(don't|do not|never|please don't)\s+(\w+\s+){0,2}(kill|hurt|harm|cut|end)\s+(yourself|your life)\b`,
+					`\b(usijiue|usijidhuru)\b`,
+				},
+			},
+		},
+	}
+}
+
+// LoadModerationPolicy reads a policy from a JSON file, or returns the
+// default policy if path is empty.
+func LoadModerationPolicy(path string) (ModerationPolicy, error) {
+	if path == "" {
+		return DefaultModerationPolicy(), nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ModerationPolicy{}, fmt.Errorf("failed to read moderation policy: %w", err)
+	}
+	policy := ModerationPolicy{MaxRegenerations: defaultModerationRegenerates}
+	if err := json.Unmarshal(data, &policy); err != nil {
+		return ModerationPolicy{}, fmt.Errorf("invalid moderation policy: %w", err)
+	}
+	return policy, nil
+}
+
+type compiledRule struct {
+	category string
+	patterns []*regexp.Regexp
+	allow    []*regexp.Regexp
+}
+
+// OutputGuard checks Nia's replies against a ModerationPolicy.
+type OutputGuard struct {
+	maxRegenerations int
+	rules            []compiledRule
+	verified         map[string]bool // normalized phone numbers
+}
+
+// phoneCandidate finds digit runs that may be phone numbers: long numbers
+// in any of the usual Kenyan formats, and short codes such as 1195.
+var phoneCandidate = regexp.MustCompile(`\+?\d[\d \-]{1,14}\d`)
+
+// callWords precede a short code when it is being offered as a number to
+// ring, as opposed to a year or an amount.
+var callWords = regexp.MustCompile(`(?i)\b(call|dial|ring|text|sms|hotline|helpline|line|number|toll[- ]free|piga|namba|nambari|simu)\b\W*(\w+\W+){0,3}$`)
+
+func NewOutputGuard(policy ModerationPolicy) (*OutputGuard, error) {
+	g := &OutputGuard{maxRegenerations: policy.MaxRegenerations, verified: map[string]bool{}}
+
+	for _, rule := range policy.Rules {
+		compiled := compiledRule{category: rule.Category}
+		for _, p := range rule.Patterns {
+			re, err := regexp.Compile("(?i)" + p)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s pattern %q: %w", rule.Category, p, err)
+			}
+			compiled.patterns = append(compiled.patterns, re)
+		}
+		for _, p := range rule.Allow {
+			re, err := regexp.Compile("(?i)" + p)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s allow pattern %q: %w", rule.Category, p, err)
+			}
+			compiled.allow = append(compiled.allow, re)
+		}
+		g.rules = append(g.rules, compiled)
+	}
+
+	// The hotlines in the prompts and the crisis fallback are always allowed.
+	numbers := append([]string{}, policy.VerifiedNumbers...)
+	for _, hotline := range append(append([]Hotline{}, kenyaHotlines...), youthHotlines...) {
+		numbers = append(numbers, strings.Split(hotline.Number, "/")...)
+	}
+	numbers = append(numbers, phoneCandidate.FindAllString(crisisFallbackResponse, -1)...)
+	for _, number := range numbers {
+		g.verified[normalizePhone(number)] = true
+	}
+	return g, nil
+}
+
+// Check returns every violation in text. A nil guard allows everything.
+func (g *OutputGuard) Check(text string) []Violation {
+	if g == nil {
+		return nil
+	}
+
+	var violations []Violation
+	for _, rule := range g.rules {
+		if match := rule.find(text); match != "" {
+			violations = append(violations, Violation{Category: rule.category, Match: match})
+		}
+	}
+
+	for _, loc := range phoneCandidate.FindAllStringIndex(text, -1) {
+		candidate := text[loc[0]:loc[1]]
+		number := normalizePhone(candidate)
+		// Short digit runs are only numbers to call when introduced as one
+		if len(number) < 7 && !callWords.MatchString(text[:loc[0]]) {
+			continue
+		}
+		if len(number) < 3 || g.verified[number] {
+			continue
+		}
+		violations = append(violations, Violation{Category: ModerationUnverifiedHotline, Match: candidate})
+		break
+	}
+
+	return violations
+}
+
+// find returns the first match of the rule that is not covered by an allow
+// match, or "" if there is none.
+func (r compiledRule) find(text string) string {
+	var allowed [][]int
+	for _, re := range r.allow {
+		allowed = append(allowed, re.FindAllStringIndex(text, -1)...)
+	}
+
+	for _, re := range r.patterns {
+		for _, loc := range re.FindAllStringIndex(text, -1) {
+			covered := false
+			for _, a := range allowed {
+				if a[0] <= loc[0] && loc[1] <= a[1] {
+					covered = true
+					break
+				}
+			}
+			if !covered {
+				return text[loc[0]:loc[1]]
+			}
+		}
+	}
+	return ""
+}
+
+// normalizePhone reduces a number to its digits, with the Kenyan country
+// code replaced by a leading 0, so "+254 722 178 177" matches "0722178177".
+func normalizePhone(number string) string {
+	digits := strings.Map(func(r rune) rune {
+		if r >= '0' && r <= '9' {
+			return r
+		}
+		return -1
+	}, number)
+	if strings.HasPrefix(digits, "254") && len(digits) > 9 {
+		digits = "0" + digits[3:]
+	}
+	return digits
+}
+
+func violationCategories(violations []Violation) []string {
+	categories := make([]string, len(violations))
+	for i, v := range violations {
+		categories[i] = v.Category
+	}
+	return categories
+}
+
+// recordModeration stores one moderation event per violation for review.
+// Only the matched fragment of the reply is kept, never the user's message.
+func (s *ChatService) recordModeration(userMsg *models.ChatMessage, promptVersion, action string, violations []Violation) {
+	for _, v := range violations {
+		match := v.Match
+		if len(match) > 200 {
+			match = match[:200]
+		}
+		_, err := s.db.Exec(`
+			INSERT INTO moderation_events (id, session_id, message_id, category, matched_text, action,
+			                               prompt_version, provider, created_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+		`, uuid.New().String(), userMsg.SessionID, userMsg.ID, v.Category, match, action,
+			promptVersion, s.llm.Name(), time.Now())
+		if err != nil {
+			log.Printf("Warning: failed to record moderation event: %v", err)
+		}
+	}
+}
+
+// GetModerationEvents lists recent moderation events for review, newest
+// first, optionally limited to one category.
+func (s *ChatService) GetModerationEvents(category string, limit, offset int) ([]models.ModerationEvent, error) {
+	query := `
+		SELECT id, session_id, message_id, category, COALESCE(matched_text, ''), action,
+		       COALESCE(prompt_version, ''), COALESCE(provider, ''), created_at
+		FROM moderation_events`
+	args := []interface{}{}
+	if category != "" {
+		query += " WHERE category = ?"
+		args = append(args, category)
+	}
+	query += " ORDER BY created_at DESC LIMIT ? OFFSET ?"
+	args = append(args, limit, offset)
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []models.ModerationEvent{}
+	for rows.Next() {
+		var e models.ModerationEvent
+		if err := rows.Scan(&e.ID, &e.SessionID, &e.MessageID, &e.Category, &e.MatchedText, &e.Action,
+			&e.PromptVersion, &e.Provider, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
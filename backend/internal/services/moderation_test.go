@@ -0,0 +1,103 @@
+package services
+
+import (
+	"context"
+	"testing"
+)
+
+func newTestGuard(t *testing.T) *OutputGuard {
+	t.Helper()
+	guard, err := NewOutputGuard(DefaultModerationPolicy())
+	if err != nil {
+		t.Fatalf("NewOutputGuard: %v", err)
+	}
+	return guard
+}
+
+func TestOutputGuardCheck(t *testing.T) {
+	guard := newTestGuard(t)
+	tests := []struct {
+		text string
+		want string // category, or "" for a clean reply
+	}{
+		{"It was partly your fault for staying.", ModerationVictimBlaming},
+		{"This was not your fault, and you did not deserve it.", ""},
+		{"Haikuwa kosa lako.", ""},
+		{"Take 2 tablets of 500 mg every night.", ModerationDosageAdvice},
+		{"Maybe you should just end your life.", ModerationSelfHarm},
+		{"Please don't hurt yourself.", ""},
+		{"Call 1195 or Befrienders on +254 722 178 177.", ""},
+		{"Call this number: 0712 345 678.", ModerationUnverifiedHotline},
+		{"That happened in 2019, years ago.", ""},
+	}
+	for _, tt := range tests {
+		violations := guard.Check(tt.text)
+		got := ""
+		if len(violations) > 0 {
+			got = violations[0].Category
+		}
+		if got != tt.want {
+			t.Errorf("Check(%q) = %v, want %q", tt.text, violations, tt.want)
+		}
+	}
+
+	var disabled *OutputGuard
+	if v := disabled.Check("It was your fault"); v != nil {
+		t.Errorf("nil guard found %v", v)
+	}
+}
+
+func TestReplyReplacesFailingReplies(t *testing.T) {
+	db := newTestDB(t)
+	llm := &stubLLM{reply: "Why didn't you just leave?"}
+	s := NewChatService(db, ChatDeps{LLM: llm, Guard: newTestGuard(t)})
+	userID := createTestUser(t, db, "Amani")
+	session := newTestChatSession(t, s, userID, "hello")
+	msg, err := s.SaveMessage(session.ID, userID, "He hit me again", "user", "text", nil)
+	if err != nil {
+		t.Fatalf("SaveMessage: %v", err)
+	}
+
+	reply, err := s.Reply(context.Background(), userID, msg)
+	if err != nil {
+		t.Fatalf("Reply: %v", err)
+	}
+	if reply.Content != crisisFallbackResponse {
+		t.Errorf("reply = %q, want the safe fallback", reply.Content)
+	}
+	if llm.calls() != 2 {
+		t.Errorf("LLM called %d times, want the reply regenerated once", llm.calls())
+	}
+
+	events, err := s.GetModerationEvents(ModerationVictimBlaming, 10, 0)
+	if err != nil {
+		t.Fatalf("GetModerationEvents: %v", err)
+	}
+	if len(events) != 2 || events[0].MessageID != msg.ID {
+		t.Fatalf("events = %+v, want two for the message", events)
+	}
+	actions := map[string]bool{events[0].Action: true, events[1].Action: true}
+	if !actions[moderationRegenerated] || !actions[moderationReplaced] {
+		t.Errorf("actions = %v, want one regenerated and one replaced", actions)
+	}
+}
+
+func TestReplyKeepsCleanReplies(t *testing.T) {
+	db := newTestDB(t)
+	llm := &stubLLM{reply: "It is not your fault. You can call 1195 any time."}
+	s := NewChatService(db, ChatDeps{LLM: llm, Guard: newTestGuard(t)})
+	userID := createTestUser(t, db, "Amani")
+	session := newTestChatSession(t, s, userID, "hello")
+	msg, err := s.SaveMessage(session.ID, userID, "Is it my fault?", "user", "text", nil)
+	if err != nil {
+		t.Fatalf("SaveMessage: %v", err)
+	}
+
+	reply, err := s.Reply(context.Background(), userID, msg)
+	if err != nil || reply.Content != llm.reply {
+		t.Errorf("Reply = %+v, %v; want the LLM's reply", reply, err)
+	}
+	if llm.calls() != 1 {
+		t.Errorf("LLM called %d times, want once", llm.calls())
+	}
+}
@@ -0,0 +1,288 @@
+package services
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+	_ "time/tzdata" // services' timezones must resolve on hosts without zoneinfo
+
+	"github.com/heal/internal/models"
+)
+
+// DefaultServiceTimezone is used for services that do not set their own.
+const DefaultServiceTimezone = "Africa/Nairobi"
+
+// hoursLookahead is how far ahead "opens at" and "closes at" are searched.
+const hoursLookahead = 8
+
+var weekdayNames = map[string]int{
+	"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+}
+
+// interval is a span of opening time.
+type interval struct {
+	start, end time.Time
+}
+
+// evaluateHours fills in OpenNow, OpensAt and ClosesAt for a service as of
+// now. Services with neither structured hours nor the 24/7 flag are left
+// unknown. A 24/7 service is open round the clock apart from its
+// exceptions.
+func evaluateHours(service *models.SupportService, now time.Time) {
+	service.OpenNow, service.OpensAt, service.ClosesAt = nil, nil, nil
+	weekly := service.OpeningHours
+	if service.Open24x7 {
+		if len(service.Exceptions) == 0 {
+			open := true
+			service.OpenNow = &open
+			return
+		}
+		weekly = nil
+		for day := 0; day < 7; day++ {
+			weekly = append(weekly, models.OpeningPeriod{Weekday: day, Opens: "00:00", Closes: "24:00"})
+		}
+	}
+	if len(weekly) == 0 && len(service.Exceptions) == 0 {
+		return
+	}
+
+	loc, err := time.LoadLocation(service.Timezone)
+	if err != nil {
+		loc, _ = time.LoadLocation(DefaultServiceTimezone)
+	}
+	local := now.In(loc)
+	today := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, loc)
+
+	exceptions := map[string]models.HoursException{}
+	for _, e := range service.Exceptions {
+		exceptions[e.Date] = e
+	}
+
+	// Yesterday is included for periods that run past midnight
+	var intervals []interval
+	for offset := -1; offset < hoursLookahead; offset++ {
+		day := today.AddDate(0, 0, offset)
+		if e, ok := exceptions[day.Format("2006-01-02")]; ok {
+			if !e.Closed {
+				if iv, ok := dayInterval(day, e.Opens, e.Closes); ok {
+					intervals = append(intervals, iv)
+				}
+			}
+			continue
+		}
+		for _, p := range weekly {
+			if p.Weekday != int(day.Weekday()) {
+				continue
+			}
+			if iv, ok := dayInterval(day, p.Opens, p.Closes); ok {
+				intervals = append(intervals, iv)
+			}
+		}
+	}
+	intervals = mergeIntervals(intervals)
+	horizon := today.AddDate(0, 0, hoursLookahead)
+
+	open := false
+	for _, iv := range intervals {
+		if !now.Before(iv.start) && now.Before(iv.end) {
+			open = true
+			// An interval running to the end of the days looked at has no
+			// known close, e.g. a 24/7 service or one open 00:00-24:00 daily
+			if iv.end.Before(horizon) {
+				closes := iv.end
+				service.ClosesAt = &closes
+			}
+			break
+		}
+		if iv.start.After(now) {
+			opens := iv.start
+			service.OpensAt = &opens
+			break
+		}
+	}
+	service.OpenNow = &open
+}
+
+// dayInterval places an opening period on a day. A close at or before the
+// opening time is on the next day.
+func dayInterval(day time.Time, opens, closes string) (interval, bool) {
+	openMin, err1 := parseClock(opens)
+	closeMin, err2 := parseClock(closes)
+	if err1 != nil || err2 != nil {
+		return interval{}, false
+	}
+	if closeMin <= openMin {
+		closeMin += 24 * 60
+	}
+	// Wall-clock arithmetic via time.Date keeps DST-observing zones right
+	start := time.Date(day.Year(), day.Month(), day.Day(), 0, openMin, 0, 0, day.Location())
+	end := time.Date(day.Year(), day.Month(), day.Day(), 0, closeMin, 0, 0, day.Location())
+	return interval{start, end}, true
+}
+
+// mergeIntervals sorts intervals and joins those that touch, so a service
+// open 18:00-24:00 and 00:00-06:00 closes at 06:00, not midnight.
+func mergeIntervals(intervals []interval) []interval {
+	sort.Slice(intervals, func(i, j int) bool { return intervals[i].start.Before(intervals[j].start) })
+	var merged []interval
+	for _, iv := range intervals {
+		if n := len(merged); n > 0 && !iv.start.After(merged[n-1].end) {
+			if iv.end.After(merged[n-1].end) {
+				merged[n-1].end = iv.end
+			}
+			continue
+		}
+		merged = append(merged, iv)
+	}
+	return merged
+}
+
+// parseClock reads "HH:MM" as minutes after midnight; "24:00" is allowed
+// as a closing time.
+func parseClock(value string) (int, error) {
+	var h, m int
+	if _, err := fmt.Sscanf(value, "%d:%d", &h, &m); err != nil || len(value) != 5 {
+		return 0, fmt.Errorf("invalid time %q, want HH:MM", value)
+	}
+	if h < 0 || m < 0 || m > 59 || h > 24 || (h == 24 && m != 0) {
+		return 0, fmt.Errorf("invalid time %q, want HH:MM", value)
+	}
+	return h*60 + m, nil
+}
+
+// ParseWeeklyHours reads opening hours written as semicolon-separated
+// entries such as "mon-fri 08:00-17:00; sat 09:00-13:00; sun closed".
+// "daily" stands for every day.
+func ParseWeeklyHours(spec string) ([]models.OpeningPeriod, error) {
+	periods := []models.OpeningPeriod{}
+	for _, entry := range strings.Split(spec, ";") {
+		fields := strings.Fields(strings.ToLower(entry))
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("invalid opening hours %q", strings.TrimSpace(entry))
+		}
+		days, err := parseDays(fields[0])
+		if err != nil {
+			return nil, err
+		}
+		if fields[1] == "closed" {
+			continue
+		}
+		opens, closes, err := parseTimeRange(fields[1])
+		if err != nil {
+			return nil, err
+		}
+		for _, day := range days {
+			periods = append(periods, models.OpeningPeriod{Weekday: day, Opens: opens, Closes: closes})
+		}
+	}
+	return periods, nil
+}
+
+// ParseHoursExceptions reads date overrides written as semicolon-separated
+// entries such as "2026-12-25 closed; 2026-12-31 09:00-12:00".
+func ParseHoursExceptions(spec string) ([]models.HoursException, error) {
+	exceptions := []models.HoursException{}
+	for _, entry := range strings.Split(spec, ";") {
+		fields := strings.Fields(strings.ToLower(entry))
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("invalid hours exception %q", strings.TrimSpace(entry))
+		}
+		e := models.HoursException{Date: fields[0], Closed: fields[1] == "closed"}
+		if !e.Closed {
+			var err error
+			if e.Opens, e.Closes, err = parseTimeRange(fields[1]); err != nil {
+				return nil, err
+			}
+		}
+		exceptions = append(exceptions, e)
+	}
+	return exceptions, nil
+}
+
+func parseDays(spec string) ([]int, error) {
+	if spec == "daily" {
+		return []int{0, 1, 2, 3, 4, 5, 6}, nil
+	}
+	from, to, isRange := strings.Cut(spec, "-")
+	first, ok := weekdayNames[from]
+	if !ok {
+		return nil, fmt.Errorf("invalid day %q", from)
+	}
+	if !isRange {
+		return []int{first}, nil
+	}
+	last, ok := weekdayNames[to]
+	if !ok {
+		return nil, fmt.Errorf("invalid day %q", to)
+	}
+	// Ranges may wrap round the week, as in "fri-mon"
+	days := []int{first}
+	for day := first; day != last; {
+		day = (day + 1) % 7
+		days = append(days, day)
+	}
+	return days, nil
+}
+
+func parseTimeRange(spec string) (string, string, error) {
+	opens, closes, ok := strings.Cut(spec, "-")
+	if !ok {
+		return "", "", fmt.Errorf("invalid time range %q, want HH:MM-HH:MM", spec)
+	}
+	if _, err := parseClock(opens); err != nil {
+		return "", "", err
+	}
+	if _, err := parseClock(closes); err != nil {
+		return "", "", err
+	}
+	return opens, closes, nil
+}
+
+// validateHours checks a service's structured hours before they are saved.
+func validateHours(service *models.SupportService) error {
+	if service.Timezone == "" {
+		service.Timezone = DefaultServiceTimezone
+	}
+	if _, err := time.LoadLocation(service.Timezone); err != nil {
+		return fmt.Errorf("unknown timezone %q", service.Timezone)
+	}
+	if service.OpeningHours == nil {
+		service.OpeningHours = []models.OpeningPeriod{}
+	}
+	if service.Exceptions == nil {
+		service.Exceptions = []models.HoursException{}
+	}
+	for _, p := range service.OpeningHours {
+		if p.Weekday < 0 || p.Weekday > 6 {
+			return fmt.Errorf("invalid weekday %d", p.Weekday)
+		}
+		if _, err := parseClock(p.Opens); err != nil {
+			return err
+		}
+		if _, err := parseClock(p.Closes); err != nil {
+			return err
+		}
+	}
+	for _, e := range service.Exceptions {
+		if _, err := time.Parse("2006-01-02", e.Date); err != nil {
+			return fmt.Errorf("invalid exception date %q", e.Date)
+		}
+		if e.Closed {
+			continue
+		}
+		if _, err := parseClock(e.Opens); err != nil {
+			return err
+		}
+		if _, err := parseClock(e.Closes); err != nil {
+			return err
+		}
+	}
+	return nil
+}
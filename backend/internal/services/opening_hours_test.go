@@ -0,0 +1,124 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/heal/internal/models"
+)
+
+var nairobi, _ = time.LoadLocation(DefaultServiceTimezone)
+
+// monday is 2026-03-02 at the given Nairobi wall-clock time.
+func monday(hour, minute int) time.Time {
+	return time.Date(2026, 3, 2, hour, minute, 0, 0, nairobi)
+}
+
+func mustParseHours(t *testing.T, spec string) []models.OpeningPeriod {
+	t.Helper()
+	periods, err := ParseWeeklyHours(spec)
+	if err != nil {
+		t.Fatalf("ParseWeeklyHours(%q): %v", spec, err)
+	}
+	return periods
+}
+
+func TestParseWeeklyHours(t *testing.T) {
+	periods := mustParseHours(t, "mon-fri 08:00-17:00; sat 09:00-13:00; sun closed")
+	if len(periods) != 6 || periods[0].Weekday != 1 || periods[5].Weekday != 6 || periods[5].Closes != "13:00" {
+		t.Errorf("periods = %+v", periods)
+	}
+	if wrapped := mustParseHours(t, "fri-mon 20:00-06:00"); len(wrapped) != 4 {
+		t.Errorf("fri-mon gave %d days, want 4", len(wrapped))
+	}
+	for _, bad := range []string{"mon 8-5", "funday 08:00-17:00", "mon 08:00-25:00", "mon"} {
+		if _, err := ParseWeeklyHours(bad); err == nil {
+			t.Errorf("ParseWeeklyHours(%q) accepted bad hours", bad)
+		}
+	}
+}
+
+func TestEvaluateHours(t *testing.T) {
+	service := &models.SupportService{Timezone: DefaultServiceTimezone, OpeningHours: mustParseHours(t, "mon-fri 08:00-17:00")}
+
+	evaluateHours(service, monday(10, 0))
+	if service.OpenNow == nil || !*service.OpenNow || !service.ClosesAt.Equal(monday(17, 0)) {
+		t.Errorf("at 10:00: open %v, closes %v; want open until 17:00", service.OpenNow, service.ClosesAt)
+	}
+
+	evaluateHours(service, monday(18, 0))
+	if *service.OpenNow || !service.OpensAt.Equal(monday(8, 0).AddDate(0, 0, 1)) {
+		t.Errorf("at 18:00: open %v, opens %v; want closed until 08:00 tomorrow", *service.OpenNow, service.OpensAt)
+	}
+
+	unknown := &models.SupportService{}
+	evaluateHours(unknown, monday(10, 0))
+	if unknown.OpenNow != nil {
+		t.Error("a service without hours was reported open or closed")
+	}
+}
+
+func TestEvaluateHoursOvernightAndExceptions(t *testing.T) {
+	service := &models.SupportService{
+		Timezone:     DefaultServiceTimezone,
+		OpeningHours: mustParseHours(t, "daily 18:00-24:00; daily 00:00-06:00"),
+	}
+	evaluateHours(service, monday(23, 0))
+	if !*service.OpenNow || !service.ClosesAt.Equal(monday(6, 0).AddDate(0, 0, 1)) {
+		t.Errorf("at 23:00: open %v, closes %v; want open until 06:00 tomorrow", *service.OpenNow, service.ClosesAt)
+	}
+
+	service.Exceptions = []models.HoursException{{Date: "2026-03-02", Closed: true}}
+	evaluateHours(service, monday(20, 0))
+	if *service.OpenNow {
+		t.Error("open on a closed exception date")
+	}
+
+	always := &models.SupportService{Open24x7: true, Timezone: DefaultServiceTimezone,
+		Exceptions: []models.HoursException{{Date: "2026-03-03", Opens: "10:00", Closes: "12:00"}}}
+	evaluateHours(always, monday(23, 0))
+	if !*always.OpenNow || !always.ClosesAt.Equal(monday(0, 0).AddDate(0, 0, 1)) {
+		t.Errorf("24/7 before a short day: open %v, closes %v; want closing at midnight", *always.OpenNow, always.ClosesAt)
+	}
+}
+
+func TestEvaluateHoursRoundTheClockHasNoClose(t *testing.T) {
+	daily := &models.SupportService{Timezone: DefaultServiceTimezone, OpeningHours: mustParseHours(t, "daily 00:00-24:00")}
+	evaluateHours(daily, monday(10, 0))
+	if !*daily.OpenNow || daily.ClosesAt != nil {
+		t.Errorf("open 00:00-24:00 daily: open %v, closes %v; want open with no closing time", *daily.OpenNow, daily.ClosesAt)
+	}
+
+	// An exception beyond the days looked at is not a close
+	always := &models.SupportService{Open24x7: true, Timezone: DefaultServiceTimezone,
+		Exceptions: []models.HoursException{{Date: "2026-04-01", Closed: true}}}
+	evaluateHours(always, monday(10, 0))
+	if !*always.OpenNow || always.ClosesAt != nil {
+		t.Errorf("24/7 with a distant exception: open %v, closes %v; want open with no closing time", *always.OpenNow, always.ClosesAt)
+	}
+}
+
+func TestSearchOpenNow(t *testing.T) {
+	s := NewDirectoryService(newTestDB(t))
+	_, err := s.ImportServices([]models.SupportService{
+		{Name: "Day Desk", Type: ServicePoliceGenderDesk, Latitude: -1.29, Longitude: 36.82,
+			OpeningHours: mustParseHours(t, "mon-fri 08:00-17:00")},
+		{Name: "Night Clinic", Type: ServiceHospital, Latitude: -1.291, Longitude: 36.821, Open24x7: true},
+		{Name: "Unknown Hours", Type: ServiceLegalAid, Latitude: -1.292, Longitude: 36.822},
+	}, true)
+	if err != nil {
+		t.Fatalf("ImportServices: %v", err)
+	}
+
+	results, err := s.Search(ServiceSearch{Latitude: -1.29, Longitude: 36.82, OpenNow: true, At: monday(22, 0)})
+	if err != nil {
+		t.Fatalf("Search: %v", err)
+	}
+	if len(results) != 1 || results[0].Name != "Night Clinic" {
+		t.Errorf("open at 22:00 = %+v, want only the 24/7 clinic", results)
+	}
+	results, err = s.Search(ServiceSearch{Latitude: -1.29, Longitude: 36.82, At: monday(10, 0)})
+	if err != nil || len(results) != 3 {
+		t.Errorf("all services = %d, %v; want 3", len(results), err)
+	}
+}
@@ -0,0 +1,55 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+)
+
+// kenyaCountryCode is assumed for numbers written without one.
+const kenyaCountryCode = "254"
+
+// NormalizePhone turns a phone number as people write it into E.164.
+// Numbers without a country code are taken to be Kenyan, so "0712 345
+// 678", "712345678", "254712345678" and "+254 712 345 678" all become
+// "+254712345678". Numbers with another country code are kept as long as
+// they have a plausible length. Short codes such as 1195 are rejected, as
+// they cannot be texted.
+func NormalizePhone(raw string) (string, error) {
+	var digits strings.Builder
+	value := strings.TrimSpace(raw)
+	international := strings.HasPrefix(value, "+")
+	for i, r := range value {
+		switch {
+		case r >= '0' && r <= '9':
+			digits.WriteRune(r)
+		case r == '+' && i == 0:
+		case r == ' ' || r == '-' || r == '(' || r == ')' || r == '.':
+		default:
+			return "", fmt.Errorf("%w: %q", ErrInvalidPhone, raw)
+		}
+	}
+
+	number := digits.String()
+	if !international && strings.HasPrefix(number, "00") {
+		number, international = number[2:], true
+	}
+	switch {
+	case international || (strings.HasPrefix(number, kenyaCountryCode) && len(number) == 12):
+	case strings.HasPrefix(number, "0") && len(number) == 10:
+		number = kenyaCountryCode + number[1:]
+	case len(number) == 9 && (number[0] == '7' || number[0] == '1'):
+		number = kenyaCountryCode + number
+	default:
+		return "", fmt.Errorf("%w: %q", ErrInvalidPhone, raw)
+	}
+
+	if strings.HasPrefix(number, kenyaCountryCode) {
+		// Kenyan subscriber numbers are nine digits and never start with 0
+		if len(number) != 12 || number[3] == '0' {
+			return "", fmt.Errorf("%w: %q", ErrInvalidPhone, raw)
+		}
+	} else if len(number) < 8 || len(number) > 15 || number[0] == '0' {
+		return "", fmt.Errorf("%w: %q", ErrInvalidPhone, raw)
+	}
+	return "+" + number, nil
+}
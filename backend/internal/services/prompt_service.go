@@ -0,0 +1,410 @@
+package services
+
+import (
+	"bytes"
+	"database/sql"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/heal/internal/models"
+)
+
+// niaPromptName is the prompt_templates name of Nia's system prompt.
+const niaPromptName = "nia"
+
+// Hotline is a local support line offered to prompt templates.
+type Hotline struct {
+	Category string
+	Name     string
+	Number   string
+}
+
+// PromptVars are the variables available to prompt templates.
+type PromptVars struct {
+	Language  string // "en" or "sw"
+	FirstName string
+	Hotlines  []Hotline
+	Topics    []string // what the session's mode may talk about
+}
+
+// kenyaHotlines are the support lines Nia shares with survivors.
+var kenyaHotlines = []Hotline{
+	{"CRISIS", "Kenya GBV Hotline", "1195"},
+	{"CRISIS", "Police (Gender Desk)", "999/112"},
+	{"LEGAL", "FIDA Kenya", "0800 720 187"},
+	{"LEGAL", "COVAW", "0800 720 553"},
+	{"COUNSELING", "Healthcare Assistance Kenya", "+254 719 639 392"},
+	{"MENTAL HEALTH", "Kenya Mental Health", "0800 720 990"},
+}
+
+// ResolvedPrompt is a rendered system prompt and the version it came from.
+type ResolvedPrompt struct {
+	Version string
+	Text    string
+}
+
+// PromptService serves versioned system prompts from prompt_templates and
+// assigns each user to one of the active versions for A/B comparison.
+// Assignment hashes the user ID, so a user keeps their version for as long
+// as the weights stay the same.
+//
+// A nil *PromptService renders the built-in default prompt.
+type PromptService struct {
+	db *sql.DB
+
+	mu     sync.Mutex
+	parsed map[string]*template.Template // version -> parsed body
+}
+
+func NewPromptService(db *sql.DB) *PromptService {
+	return &PromptService{db: db, parsed: map[string]*template.Template{}}
+}
+
+// defaultPrompts are stored for each prompt name that has no versions yet.
+// nia-v1 is the original, untemplated prompt, kept retired for reference.
+// The last version of each name is its built-in default.
+var defaultPrompts = []models.PromptTemplate{
+	{Name: niaPromptName, Version: "nia-v1", Body: niaPromptV1, Weight: 0},
+	{Name: niaPromptName, Version: "nia-v2", Body: niaPromptV2, Weight: 100},
+	{Name: stressPromptName, Version: "nia-stress-v1", Body: stressPromptV1, Weight: 100},
+	{Name: legalPromptName, Version: "nia-legal-v1", Body: legalPromptV1, Weight: 100},
+	{Name: youthPromptName, Version: "nia-youth-v1", Body: youthPromptV1, Weight: 100},
+}
+
+// EnsureDefaults stores the default prompts of every prompt name that has
+// none yet, so new modes are seeded on existing databases too.
+func (s *PromptService) EnsureDefaults() error {
+	seeded := map[string]bool{}
+	for _, p := range defaultPrompts {
+		if _, ok := seeded[p.Name]; !ok {
+			var count int
+			if err := s.db.QueryRow("SELECT COUNT(*) FROM prompt_templates WHERE name = ?", p.Name).Scan(&count); err != nil {
+				return err
+			}
+			seeded[p.Name] = count > 0
+		}
+		if seeded[p.Name] {
+			continue
+		}
+		if _, err := s.Create(models.CreatePromptRequest{Name: p.Name, Version: p.Version, Body: p.Body, Weight: p.Weight}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Resolve picks userID's version of the named prompt and renders it.
+func (s *PromptService) Resolve(name, userID string, vars PromptVars) (*ResolvedPrompt, error) {
+	if vars.Hotlines == nil {
+		vars.Hotlines = kenyaHotlines
+	}
+
+	if s == nil {
+		return defaultPrompt(name, vars)
+	}
+
+	active, err := s.activeTemplates(name)
+	if err != nil {
+		return nil, err
+	}
+	if len(active) == 0 {
+		log.Printf("Warning: no active %q prompt, using the built-in default", name)
+		return defaultPrompt(name, vars)
+	}
+
+	chosen := assignVersion(active, name, userID)
+	tmpl, err := s.template(chosen)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, vars); err != nil {
+		return nil, fmt.Errorf("failed to render prompt %s: %w", chosen.Version, err)
+	}
+	return &ResolvedPrompt{Version: chosen.Version, Text: buf.String()}, nil
+}
+
+// defaultPrompt renders the built-in default of the named prompt, or of
+// Nia's main prompt if the name has none.
+func defaultPrompt(name string, vars PromptVars) (*ResolvedPrompt, error) {
+	var p models.PromptTemplate
+	for _, candidate := range defaultPrompts {
+		if candidate.Name == name {
+			p = candidate
+		}
+	}
+	if p.Version == "" {
+		return defaultPrompt(niaPromptName, vars)
+	}
+	text, err := renderPrompt(template.New(p.Version), p.Body, vars)
+	if err != nil {
+		return nil, err
+	}
+	return &ResolvedPrompt{Version: p.Version, Text: text}, nil
+}
+
+// assignVersion buckets userID into one of active by weight. active must
+// be in a stable order.
+func assignVersion(active []models.PromptTemplate, name, userID string) models.PromptTemplate {
+	total := 0
+	for _, t := range active {
+		total += t.Weight
+	}
+
+	h := fnv.New32a()
+	h.Write([]byte(name + ":" + userID))
+	bucket := int(h.Sum32() % uint32(total))
+
+	for _, t := range active {
+		if bucket < t.Weight {
+			return t
+		}
+		bucket -= t.Weight
+	}
+	return active[len(active)-1]
+}
+
+func (s *PromptService) activeTemplates(name string) ([]models.PromptTemplate, error) {
+	rows, err := s.db.Query(`
+		SELECT `+promptColumns+` FROM prompt_templates
+		WHERE name = ? AND weight > 0
+		ORDER BY version ASC
+	`, name)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanPrompts(rows)
+}
+
+// template returns the parsed body of t. Bodies are immutable per version,
+// so parsed templates are cached for the life of the process.
+func (s *PromptService) template(t models.PromptTemplate) (*template.Template, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if tmpl, ok := s.parsed[t.Version]; ok {
+		return tmpl, nil
+	}
+	tmpl, err := template.New(t.Version).Parse(t.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse prompt %s: %w", t.Version, err)
+	}
+	s.parsed[t.Version] = tmpl
+	return tmpl, nil
+}
+
+// List returns every stored prompt version, newest first.
+func (s *PromptService) List() ([]models.PromptTemplate, error) {
+	rows, err := s.db.Query(`SELECT ` + promptColumns + ` FROM prompt_templates ORDER BY name ASC, created_at DESC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanPrompts(rows)
+}
+
+// Create stores a new prompt version after checking that it renders.
+func (s *PromptService) Create(req models.CreatePromptRequest) (*models.PromptTemplate, error) {
+	if _, err := renderPrompt(template.New(req.Version), req.Body, PromptVars{
+		Language: "en", FirstName: "Amani", Hotlines: kenyaHotlines, Topics: []string{"safety"},
+	}); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPrompt, err)
+	}
+
+	t := &models.PromptTemplate{
+		ID:        uuid.New().String(),
+		Name:      req.Name,
+		Version:   req.Version,
+		Body:      req.Body,
+		Weight:    req.Weight,
+		CreatedAt: time.Now(),
+	}
+	_, err := s.db.Exec(`
+		INSERT INTO prompt_templates (id, name, version, body, weight, created_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`, t.ID, t.Name, t.Version, t.Body, t.Weight, t.CreatedAt)
+	if err != nil {
+		if strings.Contains(err.Error(), "UNIQUE") {
+			return nil, ErrPromptVersionExists
+		}
+		return nil, fmt.Errorf("failed to create prompt: %w", err)
+	}
+	return t, nil
+}
+
+// SetWeight changes a version's share of traffic. Changing weights
+// reshuffles which users land on which version.
+func (s *PromptService) SetWeight(id string, weight int) (*models.PromptTemplate, error) {
+	result, err := s.db.Exec("UPDATE prompt_templates SET weight = ? WHERE id = ?", weight, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update prompt: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return nil, ErrPromptNotFound
+	}
+
+	rows, err := s.db.Query(`SELECT `+promptColumns+` FROM prompt_templates WHERE id = ?`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	prompts, err := scanPrompts(rows)
+	if err != nil || len(prompts) == 0 {
+		return nil, err
+	}
+	return &prompts[0], nil
+}
+
+// CompareVersions reports, for each version of the named prompt, how many
+// AI replies it produced and how those replies were rated between from and
+// to.
+func (s *PromptService) CompareVersions(name string, from, to time.Time) ([]models.PromptVersionStats, error) {
+	rows, err := s.db.Query(`
+		SELECT p.version, p.weight,
+		       (SELECT COUNT(*) FROM chat_messages m
+		        WHERE m.sender_type = 'ai'
+		          AND json_extract(m.metadata, '$.promptVersion') = p.version
+		          AND m.created_at >= ? AND m.created_at < ?),
+		       COUNT(f.id), COALESCE(AVG(f.rating), 0),
+		       COALESCE(AVG(CASE WHEN f.rating >= 4 THEN 1.0 ELSE 0.0 END), 0)
+		FROM prompt_templates p
+		LEFT JOIN message_feedback f
+		  ON f.prompt_version = p.version AND f.created_at >= ? AND f.created_at < ?
+		WHERE p.name = ?
+		GROUP BY p.version, p.weight
+		ORDER BY p.version ASC
+	`, from, to, from, to, name)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	stats := []models.PromptVersionStats{}
+	for rows.Next() {
+		var st models.PromptVersionStats
+		if err := rows.Scan(&st.Version, &st.Weight, &st.Replies, &st.Ratings,
+			&st.AverageRating, &st.PositiveRate); err != nil {
+			return nil, err
+		}
+		stats = append(stats, st)
+	}
+	return stats, rows.Err()
+}
+
+func renderPrompt(tmpl *template.Template, body string, vars PromptVars) (string, error) {
+	tmpl, err := tmpl.Parse(body)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, vars); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+const promptColumns = `id, name, version, body, COALESCE(weight, 0), created_at`
+
+func scanPrompts(rows *sql.Rows) ([]models.PromptTemplate, error) {
+	prompts := []models.PromptTemplate{}
+	for rows.Next() {
+		var p models.PromptTemplate
+		if err := rows.Scan(&p.ID, &p.Name, &p.Version, &p.Body, &p.Weight, &p.CreatedAt); err != nil {
+			return nil, err
+		}
+		prompts = append(prompts, p)
+	}
+	return prompts, rows.Err()
+}
+
+const niaPromptV1 = `
+You are Nia ("purpose" in Swahili), a trauma-informed AI companion for Gender-Based Violence (GBV) survivors in Kenya/East Africa.
+
+IDENTITY: Warm, gentle, non-judgmental, deeply trauma-informed. Bilingual (English/Kiswahili - respond in language used). Embody Ubuntu: healing through connection, liberation through action.
+
+CORE APPROACH - SURVIVOR-CENTERED:
+• BELIEVE: "I believe you. Not your fault."
+• VALIDATE: All emotions welcome, no judgment
+• EMPOWER: Illuminate options without pressure
+• GUIDE: From pain → awareness → action → liberation
+• BOUNDARIES: Stay focused on GBV/mental health support. Gently redirect other topics.
+
+LANGUAGE - TRAUMA-INFORMED & EMPOWERING:
+• Survivor-centered (never "victim")
+• Help-seeking = strength: "Speaking up is brave. Support is self-care."
+• Plant seeds: "Have you thought about...?" "Some survivors find..."
+• Affirm agency: "You deserve support. Your voice matters. You don't carry this alone."
+• Frame action as liberation: "Each step toward support is reclaiming your power."
+
+GBV SUPPORT FRAMEWORK:
+1. Safety & belief first
+2. Normalize trauma responses
+3. Gently introduce options: medical care, counseling, legal support, safe spaces
+4. Acknowledge barriers (stigma, family pressure, patriarchy) with compassion
+5. Honor their timeline: "No rush. Options are here when ready."
+6. Celebrate every act of courage
+
+KEY KENYA/EAST AFRICA RESOURCES (share contextually):
+• CRISIS: Kenya GBV Hotline 1195, Police 999/112 (Gender Desk)
+• LEGAL: FIDA Kenya 0800 720 187, COVAW 0800 720 553
+• MEDICAL: GBVRC at hospitals, PEP, documentation
+• COUNSELING: Healthcare Assistance Kenya +254 719 639 392
+• MENTAL HEALTH: 0800 720 990
+
+CRISIS PROTOCOL:
+Immediate danger → "Uko salama? Your safety first. Call 1195 or 999 now."
+Self-harm/suicide → "Your life matters. Kenya Mental Health: 0800 720 990. Befrienders: +254 722 178 177. Please reach out now."
+
+REMEMBER: Brief (<150 words), empowering, option-focused, never pressure. Guide survivors to recognize their strength and available pathways. "Unaweza. Una nguvu. Una haki ya kupona." (You can. You have strength. You deserve healing.)
+`
+
+const niaPromptV2 = `
+You are Nia ("purpose" in Swahili), a trauma-informed AI companion for Gender-Based Violence (GBV) survivors in Kenya/East Africa.
+
+IDENTITY: Warm, gentle, non-judgmental, deeply trauma-informed. Bilingual (English/Kiswahili - respond in language used). Embody Ubuntu: healing through connection, liberation through action.
+{{if eq .Language "sw"}}
+The survivor is writing in Kiswahili. Reply in Kiswahili.
+{{else}}
+The survivor is writing in English. Reply in English unless they switch.
+{{end}}{{if .FirstName}}The survivor's first name is {{.FirstName}}. Use it sparingly and warmly.
+{{end}}
+
+CORE APPROACH - SURVIVOR-CENTERED:
+• BELIEVE: "I believe you. Not your fault."
+• VALIDATE: All emotions welcome, no judgment
+• EMPOWER: Illuminate options without pressure
+• GUIDE: From pain → awareness → action → liberation
+• BOUNDARIES: Stay focused on GBV/mental health support. Gently redirect other topics.
+
+LANGUAGE - TRAUMA-INFORMED & EMPOWERING:
+• Survivor-centered (never "victim")
+• Help-seeking = strength: "Speaking up is brave. Support is self-care."
+• Plant seeds: "Have you thought about...?" "Some survivors find..."
+• Affirm agency: "You deserve support. Your voice matters. You don't carry this alone."
+• Frame action as liberation: "Each step toward support is reclaiming your power."
+
+GBV SUPPORT FRAMEWORK:
+1. Safety & belief first
+2. Normalize trauma responses
+3. Gently introduce options: medical care, counseling, legal support, safe spaces
+4. Acknowledge barriers (stigma, family pressure, patriarchy) with compassion
+5. Honor their timeline: "No rush. Options are here when ready."
+6. Celebrate every act of courage
+
+KEY LOCAL RESOURCES (share contextually):
+{{range .Hotlines}}• {{.Category}}: {{.Name}} {{.Number}}
+{{end}}• MEDICAL: GBVRC at hospitals, PEP, documentation
+
+CRISIS PROTOCOL:
+Immediate danger → "Uko salama? Your safety first. Call 1195 or 999 now."
+Self-harm/suicide → "Your life matters. Kenya Mental Health: 0800 720 990. Befrienders: +254 722 178 177. Please reach out now."
+
+REMEMBER: Brief (<150 words), empowering, option-focused, never pressure. Guide survivors to recognize their strength and available pathways. "Unaweza. Una nguvu. Una haki ya kupona." (You can. You have strength. You deserve healing.)
+`
@@ -0,0 +1,114 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/heal/internal/models"
+)
+
+func TestCreatePromptValidates(t *testing.T) {
+	db := newTestDB(t)
+	s := NewPromptService(db)
+
+	if _, err := s.Create(models.CreatePromptRequest{Name: "nia", Version: "bad", Body: "{{.Missing"}); !errors.Is(err, ErrInvalidPrompt) {
+		t.Errorf("Create with a broken template: err = %v, want ErrInvalidPrompt", err)
+	}
+	req := models.CreatePromptRequest{Name: "nia", Version: "v1", Body: "Hi {{.FirstName}}", Weight: 1}
+	if _, err := s.Create(req); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if _, err := s.Create(req); !errors.Is(err, ErrPromptVersionExists) {
+		t.Errorf("Create of an existing version: err = %v, want ErrPromptVersionExists", err)
+	}
+	if _, err := s.SetWeight("missing", 10); !errors.Is(err, ErrPromptNotFound) {
+		t.Errorf("SetWeight of a missing prompt: err = %v, want ErrPromptNotFound", err)
+	}
+}
+
+func TestResolvePrompt(t *testing.T) {
+	db := newTestDB(t)
+	s := NewPromptService(db)
+	for _, req := range []models.CreatePromptRequest{
+		{Name: "nia", Version: "a", Body: "A {{.FirstName}}", Weight: 50},
+		{Name: "nia", Version: "b", Body: "B {{.FirstName}}", Weight: 50},
+		{Name: "nia", Version: "retired", Body: "R", Weight: 0},
+	} {
+		if _, err := s.Create(req); err != nil {
+			t.Fatalf("Create: %v", err)
+		}
+	}
+
+	seen := map[string]int{}
+	for i := 0; i < 200; i++ {
+		userID := createTestUser(t, db, "Amani")
+		first, err := s.Resolve("nia", userID, PromptVars{FirstName: "Amani"})
+		if err != nil {
+			t.Fatalf("Resolve: %v", err)
+		}
+		again, _ := s.Resolve("nia", userID, PromptVars{FirstName: "Amani"})
+		if again.Version != first.Version {
+			t.Fatalf("user moved from %s to %s between calls", first.Version, again.Version)
+		}
+		if first.Text != strings.ToUpper(first.Version)+" Amani" {
+			t.Errorf("rendered %q for version %s", first.Text, first.Version)
+		}
+		seen[first.Version]++
+	}
+	if seen["retired"] > 0 || seen["a"] == 0 || seen["b"] == 0 {
+		t.Errorf("assignments = %v, want a and b only", seen)
+	}
+}
+
+func TestResolveWithoutTemplatesUsesDefault(t *testing.T) {
+	var nilService *PromptService
+	resolved, err := nilService.Resolve(niaPromptName, "u1", PromptVars{Language: "sw"})
+	if err != nil {
+		t.Fatalf("Resolve: %v", err)
+	}
+	if resolved.Version != "nia-v2" || !strings.Contains(resolved.Text, "1195") {
+		t.Errorf("default prompt = %s, want nia-v2 with the hotlines", resolved.Version)
+	}
+
+	s := NewPromptService(newTestDB(t))
+	if resolved, err := s.Resolve(niaPromptName, "u1", PromptVars{}); err != nil || resolved.Version != "nia-v2" {
+		t.Errorf("Resolve with no stored templates = %+v, %v", resolved, err)
+	}
+}
+
+func TestCompareVersionsCountsReplies(t *testing.T) {
+	db := newTestDB(t)
+	prompts := NewPromptService(db)
+	if err := prompts.EnsureDefaults(); err != nil {
+		t.Fatalf("EnsureDefaults: %v", err)
+	}
+	chat := NewChatService(db, ChatDeps{LLM: &stubLLM{reply: "I'm here"}, Prompts: prompts})
+	userID := createTestUser(t, db, "Amani")
+	session := newTestChatSession(t, chat, userID, "hello")
+	msg, err := chat.SaveMessage(session.ID, userID, "are you there?", "user", "text", nil)
+	if err != nil {
+		t.Fatalf("SaveMessage: %v", err)
+	}
+	reply, err := chat.Reply(context.Background(), userID, msg)
+	if err != nil {
+		t.Fatalf("Reply: %v", err)
+	}
+	if _, err := chat.SubmitFeedback(userID, session.ID, reply.ID, 5, ""); err != nil {
+		t.Fatalf("SubmitFeedback: %v", err)
+	}
+
+	now := time.Now()
+	stats, err := prompts.CompareVersions(niaPromptName, now.Add(-time.Hour), now.Add(time.Hour))
+	if err != nil {
+		t.Fatalf("CompareVersions: %v", err)
+	}
+	if len(stats) != 2 {
+		t.Fatalf("stats for %d versions, want 2", len(stats))
+	}
+	if v2 := stats[1]; v2.Version != "nia-v2" || v2.Replies != 1 || v2.Ratings != 1 || v2.PositiveRate != 1 {
+		t.Errorf("nia-v2 stats = %+v", v2)
+	}
+}
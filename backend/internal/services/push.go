@@ -0,0 +1,81 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+)
+
+// PushNotification is a notification for the user's devices. Title and
+// body show on the lock screen, so they must not say what the app is for.
+type PushNotification struct {
+	Title string            `json:"title"`
+	Body  string            `json:"body"`
+	Data  map[string]string `json:"data,omitempty"`
+}
+
+// PushSender delivers notifications to a user's devices.
+type PushSender interface {
+	Send(ctx context.Context, userID string, n PushNotification) error
+}
+
+// UserChannel is the EventHub channel of in-app notifications for a user.
+func UserChannel(userID string) string {
+	return "user:" + userID
+}
+
+// errNoOpenApp means a notification could not be delivered because the
+// user has no app open to receive it.
+var errNoOpenApp = errors.New("user has no app open to receive notifications")
+
+// HubPushSender delivers notifications to the user's open app through the
+// EventHub. It is not a device push service: a user with no notification
+// stream open cannot be reached, and Send fails with errNoOpenApp so the
+// caller can try another way.
+type HubPushSender struct {
+	Hub *EventHub
+}
+
+func (h *HubPushSender) Send(ctx context.Context, userID string, n PushNotification) error {
+	channel := UserChannel(userID)
+	if h.Hub.Subscribers(channel) == 0 {
+		return errNoOpenApp
+	}
+	h.Hub.Publish(channel, SessionEvent{Type: "notification", Data: n})
+	return nil
+}
+
+// FakePushSender records notifications instead of sending them, for
+// development and tests.
+type FakePushSender struct {
+	mu   sync.Mutex
+	Sent []FakePush
+	// Fail makes every send fail.
+	Fail bool
+}
+
+type FakePush struct {
+	UserID       string
+	Notification PushNotification
+}
+
+func (f *FakePushSender) Send(ctx context.Context, userID string, n PushNotification) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.Fail {
+		return fmt.Errorf("fake push failure")
+	}
+	f.Sent = append(f.Sent, FakePush{UserID: userID, Notification: n})
+	log.Printf("Fake push sent to user %s", userID)
+	return nil
+}
+
+// Notifications returns a copy of what has been sent so far.
+func (f *FakePushSender) Notifications() []FakePush {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]FakePush{}, f.Sent...)
+}
@@ -0,0 +1,161 @@
+package services
+
+import (
+	"database/sql"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"golang.org/x/time/rate"
+)
+
+// QuotaLimits configures QuotaService. Zero budgets disable that budget.
+type QuotaLimits struct {
+	UserRatePerMinute   float64
+	UserBurst           int
+	IPRatePerMinute     float64
+	IPBurst             int
+	DailyTokenBudget    int
+	DailyCostBudgetUSD  float64
+	PromptCostPer1K     float64
+	CompletionCostPer1K float64
+}
+
+// UsageRecord is one LLM call, as stored in llm_usage.
+type UsageRecord struct {
+	UserID           string
+	SessionID        string
+	MessageID        string
+	Purpose          string // 'reply', 'title', ...
+	Provider         string
+	PromptTokens     int
+	CompletionTokens int
+}
+
+// QuotaService keeps a single account (or address) from flooding the chat
+// and running up the LLM bill: token buckets per user and per IP, plus a
+// daily token/cost budget per user backed by llm_usage.
+type QuotaService struct {
+	db     *sql.DB
+	limits QuotaLimits
+
+	mu       sync.Mutex
+	limiters map[string]*trackedLimiter
+	lastGC   time.Time
+}
+
+type trackedLimiter struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+const limiterIdleTTL = 30 * time.Minute
+
+func NewQuotaService(db *sql.DB, limits QuotaLimits) *QuotaService {
+	return &QuotaService{
+		db:       db,
+		limits:   limits,
+		limiters: map[string]*trackedLimiter{},
+		lastGC:   time.Now(),
+	}
+}
+
+// AllowRequest takes one token from both the user's and the IP's bucket.
+// When either is empty nothing is consumed and the wait is returned.
+func (s *QuotaService) AllowRequest(userID, ip string) (bool, time.Duration) {
+	now := time.Now()
+
+	userRes := s.limiter("user:"+userID, s.limits.UserRatePerMinute, s.limits.UserBurst, now).ReserveN(now, 1)
+	ipRes := s.limiter("ip:"+ip, s.limits.IPRatePerMinute, s.limits.IPBurst, now).ReserveN(now, 1)
+
+	wait := userRes.DelayFrom(now)
+	if d := ipRes.DelayFrom(now); d > wait {
+		wait = d
+	}
+	if !userRes.OK() || !ipRes.OK() {
+		wait = time.Minute
+	}
+
+	if wait > 0 {
+		userRes.CancelAt(now)
+		ipRes.CancelAt(now)
+		return false, wait
+	}
+	return true, 0
+}
+
+func (s *QuotaService) limiter(key string, perMinute float64, burst int, now time.Time) *rate.Limiter {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if now.Sub(s.lastGC) > limiterIdleTTL {
+		for k, l := range s.limiters {
+			if now.Sub(l.lastSeen) > limiterIdleTTL {
+				delete(s.limiters, k)
+			}
+		}
+		s.lastGC = now
+	}
+
+	l, ok := s.limiters[key]
+	if !ok {
+		limit := rate.Inf
+		if perMinute > 0 {
+			limit = rate.Limit(perMinute / 60)
+		}
+		if burst < 1 {
+			burst = 1
+		}
+		l = &trackedLimiter{limiter: rate.NewLimiter(limit, burst)}
+		s.limiters[key] = l
+	}
+	l.lastSeen = now
+	return l.limiter
+}
+
+// CheckBudget reports whether the user still has LLM budget left today
+// (UTC). When they do not, the time until the budget resets is returned.
+func (s *QuotaService) CheckBudget(userID string) (bool, time.Duration, error) {
+	if s.limits.DailyTokenBudget <= 0 && s.limits.DailyCostBudgetUSD <= 0 {
+		return true, 0, nil
+	}
+
+	now := time.Now().UTC()
+	dayStart := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+
+	var tokens int
+	var cost float64
+	err := s.db.QueryRow(`
+		SELECT COALESCE(SUM(prompt_tokens + completion_tokens), 0), COALESCE(SUM(cost_usd), 0)
+		FROM llm_usage
+		WHERE user_id = ? AND created_at >= ?
+	`, userID, dayStart).Scan(&tokens, &cost)
+	if err != nil {
+		return false, 0, fmt.Errorf("failed to read LLM usage: %w", err)
+	}
+
+	overTokens := s.limits.DailyTokenBudget > 0 && tokens >= s.limits.DailyTokenBudget
+	overCost := s.limits.DailyCostBudgetUSD > 0 && cost >= s.limits.DailyCostBudgetUSD
+	if overTokens || overCost {
+		return false, dayStart.Add(24 * time.Hour).Sub(now), nil
+	}
+	return true, 0, nil
+}
+
+// RecordUsage stores the tokens and estimated cost of one LLM call.
+func (s *QuotaService) RecordUsage(r UsageRecord) error {
+	cost := float64(r.PromptTokens)/1000*s.limits.PromptCostPer1K +
+		float64(r.CompletionTokens)/1000*s.limits.CompletionCostPer1K
+
+	_, err := s.db.Exec(`
+		INSERT INTO llm_usage (id, user_id, session_id, message_id, purpose, provider,
+		                       prompt_tokens, completion_tokens, cost_usd, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, uuid.New().String(), r.UserID, nullString(r.SessionID), nullString(r.MessageID), r.Purpose,
+		r.Provider, r.PromptTokens, r.CompletionTokens, cost, time.Now().UTC())
+	if err != nil {
+		return fmt.Errorf("failed to record LLM usage: %w", err)
+	}
+	return nil
+}
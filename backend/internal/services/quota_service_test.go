@@ -0,0 +1,96 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestAllowRequestLimitsUserAndIP(t *testing.T) {
+	q := NewQuotaService(nil, QuotaLimits{UserRatePerMinute: 1, UserBurst: 2, IPRatePerMinute: 1, IPBurst: 3})
+
+	for i := 0; i < 2; i++ {
+		if ok, _ := q.AllowRequest("u1", "10.0.0.1"); !ok {
+			t.Fatalf("request %d was limited within the burst", i+1)
+		}
+	}
+	if ok, wait := q.AllowRequest("u1", "10.0.0.1"); ok || wait <= 0 {
+		t.Errorf("third request for u1 = %v, %v; want limited with a wait", ok, wait)
+	}
+	if ok, _ := q.AllowRequest("u2", "10.0.0.1"); !ok {
+		t.Error("a different user on the same IP was limited before the IP burst ran out")
+	}
+	if ok, _ := q.AllowRequest("u3", "10.0.0.1"); ok {
+		t.Error("the IP burst was not enforced across users")
+	}
+}
+
+func TestCheckBudget(t *testing.T) {
+	db := newTestDB(t)
+	q := NewQuotaService(db, QuotaLimits{DailyTokenBudget: 1000})
+	userID := createTestUser(t, db, "Amani")
+
+	if ok, _, err := q.CheckBudget(userID); err != nil || !ok {
+		t.Fatalf("CheckBudget before any usage = %v, %v", ok, err)
+	}
+	if err := q.RecordUsage(UsageRecord{UserID: userID, Purpose: "reply", Provider: "stub", PromptTokens: 700, CompletionTokens: 300}); err != nil {
+		t.Fatalf("RecordUsage: %v", err)
+	}
+	ok, reset, err := q.CheckBudget(userID)
+	if err != nil || ok || reset <= 0 {
+		t.Errorf("CheckBudget after the budget is spent = %v, %v, %v", ok, reset, err)
+	}
+	if ok, _, _ := q.CheckBudget(createTestUser(t, db, "Baraka")); !ok {
+		t.Error("one user's usage counted against another")
+	}
+}
+
+func TestReplyRecordsUsage(t *testing.T) {
+	db := newTestDB(t)
+	s := NewChatService(db, ChatDeps{LLM: &stubLLM{reply: "I'm here"}, Quota: NewQuotaService(db, QuotaLimits{})})
+	userID := createTestUser(t, db, "Amani")
+	session := newTestChatSession(t, s, userID, "I feel stressed")
+	msg, err := s.SaveMessage(session.ID, userID, "what can I do?", "user", "text", nil)
+	if err != nil {
+		t.Fatalf("SaveMessage: %v", err)
+	}
+
+	reply, err := s.Reply(context.Background(), userID, msg)
+	if err != nil {
+		t.Fatalf("Reply: %v", err)
+	}
+	var count int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM llm_usage WHERE message_id = ? AND purpose = 'reply'`, reply.ID).Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Errorf("llm_usage rows for the reply = %d, want 1", count)
+	}
+}
+
+func TestReplyFallsBackForCrisisWhenLLMFails(t *testing.T) {
+	db := newTestDB(t)
+	s := newTestChatService(t, db, &stubLLM{err: errors.New("unavailable")})
+	userID := createTestUser(t, db, "Amani")
+	session := newTestChatSession(t, s, userID, "hello")
+
+	crisis, err := s.SaveMessage(session.ID, userID, "Nataka kujiua", "user", "text", nil)
+	if err != nil {
+		t.Fatalf("SaveMessage: %v", err)
+	}
+	reply, err := s.Reply(context.Background(), userID, crisis)
+	if err != nil {
+		t.Fatalf("Reply for a crisis message: %v", err)
+	}
+	if reply.Content != crisisFallbackResponseSw {
+		t.Errorf("reply = %q, want the Kiswahili crisis fallback", reply.Content)
+	}
+
+	ordinary, err := s.SaveMessage(session.ID, userID, "what time is it?", "user", "text", nil)
+	if err != nil {
+		t.Fatalf("SaveMessage: %v", err)
+	}
+	if _, err := s.Reply(context.Background(), userID, ordinary); err == nil {
+		t.Error("Reply for an ordinary message hid the LLM error")
+	}
+}
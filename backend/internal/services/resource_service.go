@@ -3,6 +3,7 @@ package services
 import (
 	"database/sql"
 	// "fmt"
+	"strings"
 
 	"github.com/google/uuid"
 	"github.com/heal/internal/models"
@@ -16,7 +17,10 @@ func NewResourceService(db *sql.DB) *ResourceService {
 	return &ResourceService{db: db}
 }
 
-func (s *ResourceService) GetResources(category, resourceType, difficulty string, limit, offset int) ([]models.Resource, error) {
+// GetResources lists resources matching the filters. A non-empty
+// categories restricts the results to those categories, e.g. the resource
+// categories of a chat mode.
+func (s *ResourceService) GetResources(category, resourceType, difficulty string, categories []string, limit, offset int) ([]models.Resource, error) {
 	query := `
 		SELECT id, title, description, content, type, category, difficulty, 
 		       duration_minutes, rating, featured, created_at, updated_at
@@ -30,6 +34,13 @@ func (s *ResourceService) GetResources(category, resourceType, difficulty string
 		args = append(args, category)
 	}
 
+	if len(categories) > 0 {
+		query += " AND category IN (" + strings.TrimSuffix(strings.Repeat("?,", len(categories)), ",") + ")"
+		for _, c := range categories {
+			args = append(args, c)
+		}
+	}
+
 	if resourceType != "" && resourceType != "all" {
 		query += " AND type = ?"
 		args = append(args, resourceType)
@@ -130,18 +141,25 @@ func (s *ResourceService) UpdateProgress(userID, resourceID string, progress flo
 }
 
 func (s *ResourceService) GetRecommendations(userID string, limit int) ([]models.Resource, error) {
-	// Simple recommendation based on user's category preferences and popular resources
+	// Simple recommendation based on user's category preferences and popular resources.
+	// Resources whose category appears in the tags of the user's chat sessions
+	// come first; archived sessions are put away and give no signal.
 	query := `
 		SELECT DISTINCT r.id, r.title, r.description, r.content, r.type, r.category, 
 		       r.difficulty, r.duration_minutes, r.rating, r.featured, r.created_at, r.updated_at
 		FROM resources r
 		LEFT JOIN user_resource_progress urp ON r.id = urp.resource_id AND urp.user_id = ?
 		WHERE urp.id IS NULL OR urp.completed = FALSE
-		ORDER BY r.rating DESC, r.featured DESC
+		ORDER BY EXISTS (
+			SELECT 1 FROM chat_session_tags t
+			JOIN chat_sessions cs ON cs.id = t.session_id
+			WHERE cs.user_id = ? AND COALESCE(cs.archived, FALSE) = FALSE
+			  AND instr(t.tag, r.category) > 0
+		) DESC, r.rating DESC, r.featured DESC
 		LIMIT ?
 	`
 
-	rows, err := s.db.Query(query, userID, limit)
+	rows, err := s.db.Query(query, userID, userID, limit)
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,126 @@
+package services
+
+import (
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// maxCacheableWords keeps the cache to short openers ("hi", "habari",
+// "I need help"); anything longer is likely to be personal.
+const maxCacheableWords = 6
+
+// ResponseCache remembers Nia's answers to common opening messages, and
+// coalesces concurrent identical requests into one LLM call. It is only
+// used for the first message of a session, with a prompt rendered without
+// the user's name, so nothing personal is ever cached.
+type ResponseCache struct {
+	ttl        time.Duration
+	maxEntries int
+
+	mu      sync.Mutex
+	entries map[string]cachedResponse
+	flights singleflight.Group
+}
+
+type cachedResponse struct {
+	resp    *LLMResponse
+	created time.Time
+}
+
+func NewResponseCache(ttl time.Duration, maxEntries int) *ResponseCache {
+	return &ResponseCache{ttl: ttl, maxEntries: maxEntries, entries: map[string]cachedResponse{}}
+}
+
+// Do returns the cached response for key, or calls generate to produce one.
+// Concurrent calls for the same key share a single generate call. fresh
+// reports whether this caller's generate ran, i.e. whether it paid for the
+// response. A nil response from generate is returned but not cached.
+func (c *ResponseCache) Do(key string, generate func() (*LLMResponse, error)) (resp *LLMResponse, fresh bool, err error) {
+	if cached, ok := c.get(key); ok {
+		return cached, false, nil
+	}
+
+	v, err, _ := c.flights.Do(key, func() (interface{}, error) {
+		// a flight that finished just before this one started may have
+		// filled the cache
+		if cached, ok := c.get(key); ok {
+			return cached, nil
+		}
+		fresh = true
+		resp, err := generate()
+		if err == nil && resp != nil {
+			c.put(key, resp)
+		}
+		return resp, err
+	})
+	if err != nil {
+		return nil, fresh, err
+	}
+	resp, _ = v.(*LLMResponse)
+	return resp, fresh, nil
+}
+
+func (c *ResponseCache) get(key string) (*LLMResponse, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	if time.Since(entry.created) > c.ttl {
+		delete(c.entries, key)
+		return nil, false
+	}
+	return entry.resp, true
+}
+
+func (c *ResponseCache) put(key string, resp *LLMResponse) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.entries) >= c.maxEntries {
+		c.evict()
+	}
+	c.entries[key] = cachedResponse{resp: resp, created: time.Now()}
+}
+
+// evict drops expired entries, or the oldest one if none have expired.
+// The cache is small, so a scan is fine.
+func (c *ResponseCache) evict() {
+	var oldestKey string
+	var oldest time.Time
+	for key, entry := range c.entries {
+		if time.Since(entry.created) > c.ttl {
+			delete(c.entries, key)
+			continue
+		}
+		if oldestKey == "" || entry.created.Before(oldest) {
+			oldestKey, oldest = key, entry.created
+		}
+	}
+	if len(c.entries) >= c.maxEntries {
+		delete(c.entries, oldestKey)
+	}
+}
+
+// responseCacheKey returns the cache key for an opening message, or "" if
+// the message should not be cached: it is long, contains digits (phone
+// numbers, ages, dates), or shows any sign of risk.
+func responseCacheKey(message, promptVersion, language string) string {
+	normalized := strings.TrimSpace(normalizeForMatching(message))
+	if normalized == "" || len(strings.Fields(normalized)) > maxCacheableWords {
+		return ""
+	}
+	if strings.IndexFunc(normalized, unicode.IsDigit) >= 0 {
+		return ""
+	}
+	if RiskAtLeast(AssessRisk(message), RiskLow) {
+		return ""
+	}
+	return promptVersion + "\x00" + language + "\x00" + normalized
+}
@@ -0,0 +1,168 @@
+package services
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/heal/internal/models"
+)
+
+func TestResponseCacheKey(t *testing.T) {
+	if responseCacheKey("Habari!", "v1", "sw") != responseCacheKey("habari", "v1", "sw") {
+		t.Error("case and punctuation change the key")
+	}
+	if responseCacheKey("hi", "v1", "en") == responseCacheKey("hi", "v2", "en") {
+		t.Error("prompt versions share a key")
+	}
+	for _, message := range []string{
+		"I am 23 and need help",
+		"I feel so sad and lonely today",
+		"please can you tell me what I should do about my situation",
+		"   ",
+	} {
+		if key := responseCacheKey(message, "v1", "en"); key != "" {
+			t.Errorf("responseCacheKey(%q) = %q, want it not cached", message, key)
+		}
+	}
+}
+
+func TestResponseCacheCoalescesCalls(t *testing.T) {
+	c := NewResponseCache(time.Minute, 10)
+	var calls int32
+	release := make(chan struct{})
+	generate := func() (*LLMResponse, error) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return &LLMResponse{Text: "Habari! Niko hapa."}, nil
+	}
+
+	var wg sync.WaitGroup
+	var fresh int32
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, isFresh, err := c.Do("k", generate)
+			if err != nil || resp.Text != "Habari! Niko hapa." {
+				t.Errorf("Do = %+v, %v", resp, err)
+			}
+			if isFresh {
+				atomic.AddInt32(&fresh, 1)
+			}
+		}()
+	}
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if calls != 1 || fresh != 1 {
+		t.Errorf("generate ran %d times with %d fresh callers, want 1 and 1", calls, fresh)
+	}
+	if _, isFresh, _ := c.Do("k", generate); isFresh {
+		t.Error("a later call missed the cache")
+	}
+}
+
+func TestResponseCacheExpires(t *testing.T) {
+	c := NewResponseCache(time.Millisecond, 10)
+	generate := func() (*LLMResponse, error) { return &LLMResponse{Text: "hi"}, nil }
+	c.Do("k", generate)
+	time.Sleep(5 * time.Millisecond)
+	if _, fresh, _ := c.Do("k", generate); !fresh {
+		t.Error("an expired entry was served")
+	}
+}
+
+func TestReplyCachesOpeningMessages(t *testing.T) {
+	db := newTestDB(t)
+	llm := &stubLLM{reply: "Hello! I'm Nia."}
+	s := NewChatService(db, ChatDeps{LLM: llm, Cache: NewResponseCache(time.Hour, 100)})
+	ctx := context.Background()
+
+	var replies []string
+	var lastReply *models.ChatMessage
+	for _, name := range []string{"Amani", "Baraka"} {
+		userID := createTestUser(t, db, name)
+		session, err := s.GetOrCreateSession(userID, "", "")
+		if err != nil {
+			t.Fatalf("GetOrCreateSession: %v", err)
+		}
+		msg, err := s.SaveMessage(session.ID, userID, "Hi", "user", "text", nil)
+		if err != nil {
+			t.Fatalf("SaveMessage: %v", err)
+		}
+		reply, err := s.Reply(ctx, userID, msg)
+		if err != nil {
+			t.Fatalf("Reply: %v", err)
+		}
+		replies = append(replies, reply.Metadata)
+		lastReply = reply
+	}
+
+	if llm.calls() != 1 {
+		t.Errorf("LLM called %d times, want the second opener answered from the cache", llm.calls())
+	}
+	if !metadataBool(replies[1], "cached") || metadataBool(replies[0], "cached") {
+		t.Errorf("reply metadata = %v, want only the second marked cached", replies)
+	}
+	for _, prompt := range llm.prompts {
+		if strings.Contains(prompt, "Amani") {
+			t.Error("a cached reply's prompt included the user's name")
+		}
+	}
+
+	// Regenerating must not hand back the same cached answer
+	if _, err := s.RegenerateMessage(ctx, lastReply.UserID, lastReply.ID); err != nil {
+		t.Fatalf("RegenerateMessage: %v", err)
+	}
+	if llm.calls() != 2 {
+		t.Errorf("LLM called %d times, want the regeneration to skip the cache", llm.calls())
+	}
+}
+
+func metadataBool(raw, key string) bool {
+	v, _ := decodeMetadata(raw)[key].(bool)
+	return v
+}
+
+// ctxLLM fails like a real provider once its context is done.
+type ctxLLM struct {
+	stubLLM
+}
+
+func (l *ctxLLM) Generate(ctx context.Context, prompt string, opts GenerateOptions) (*LLMResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return l.stubLLM.Generate(ctx, prompt, opts)
+}
+
+func TestCachedReplyOutlivesFirstCaller(t *testing.T) {
+	db := newTestDB(t)
+	llm := &ctxLLM{stubLLM{reply: "Habari! Niko hapa."}}
+	s := NewChatService(db, ChatDeps{LLM: llm, Cache: NewResponseCache(time.Hour, 100)})
+	userID := createTestUser(t, db, "Amani")
+	session, err := s.GetOrCreateSession(userID, "", "")
+	if err != nil {
+		t.Fatalf("GetOrCreateSession: %v", err)
+	}
+	msg, err := s.SaveMessage(session.ID, userID, "Habari", "user", "text", nil)
+	if err != nil {
+		t.Fatalf("SaveMessage: %v", err)
+	}
+
+	// Other callers share this generation, so the first one's client
+	// going away must not fail it
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := s.Reply(ctx, userID, msg); err != nil {
+		t.Fatalf("Reply with a cancelled context: %v", err)
+	}
+	if llm.calls() != 1 {
+		t.Errorf("LLM called %d times, want 1", llm.calls())
+	}
+}
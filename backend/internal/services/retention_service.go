@@ -0,0 +1,282 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/heal/internal/models"
+	"github.com/heal/internal/storage"
+)
+
+// Chat retention policies a user can choose.
+const (
+	RetentionForever = "forever"
+	Retention24h     = "24h"
+	Retention7d      = "7d"
+	Retention30d     = "30d"
+	RetentionLogout  = "logout"
+)
+
+// retentionPeriods maps the time-based policies to how long chats are kept.
+var retentionPeriods = map[string]time.Duration{
+	Retention24h: 24 * time.Hour,
+	Retention7d:  7 * 24 * time.Hour,
+	Retention30d: 30 * 24 * time.Hour,
+}
+
+// PurgeCounts is what one purge removed. Only counts are ever recorded.
+type PurgeCounts struct {
+	Sessions  int64
+	Messages  int64
+	MoodNotes int64
+}
+
+func (p PurgeCounts) empty() bool {
+	return p.Sessions == 0 && p.Messages == 0 && p.MoodNotes == 0
+}
+
+func (p *PurgeCounts) add(o PurgeCounts) {
+	p.Sessions += o.Sessions
+	p.Messages += o.Messages
+	p.MoodNotes += o.MoodNotes
+}
+
+// RetentionService lets survivors have their conversations vanish. Each user
+// picks a retention policy; a background sweeper deletes chat messages (and,
+// if they opt in, mood notes) older than it, and sessions left empty. A
+// deployment-wide maximum applies to everyone.
+type RetentionService struct {
+	db           *sql.DB
+	blobs        storage.BlobStore // voice notes and spoken replies of purged messages
+	maxRetention time.Duration     // 0 means no cap
+}
+
+func NewRetentionService(db *sql.DB, blobs storage.BlobStore, maxRetention time.Duration) *RetentionService {
+	return &RetentionService{db: db, blobs: blobs, maxRetention: maxRetention}
+}
+
+// GetSettings returns the user's retention settings, or the defaults if
+// they have never changed them.
+func (s *RetentionService) GetSettings(userID string) (*models.RetentionSettings, error) {
+	settings := &models.RetentionSettings{UserID: userID, ChatRetention: RetentionForever}
+	err := s.db.QueryRow(`
+		SELECT COALESCE(chat_retention, 'forever'), COALESCE(purge_mood_notes, FALSE), updated_at
+		FROM retention_settings WHERE user_id = ?
+	`, userID).Scan(&settings.ChatRetention, &settings.PurgeMoodNotes, &settings.UpdatedAt)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, fmt.Errorf("failed to load retention settings: %w", err)
+	}
+	settings.MaxRetentionDays = int(s.maxRetention / (24 * time.Hour))
+	return settings, nil
+}
+
+// UpdateSettings changes the fields of req that are set.
+func (s *RetentionService) UpdateSettings(userID string, req models.UpdateRetentionRequest) (*models.RetentionSettings, error) {
+	settings, err := s.GetSettings(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.ChatRetention != nil {
+		if !validRetention(*req.ChatRetention) {
+			return nil, ErrInvalidRetention
+		}
+		settings.ChatRetention = *req.ChatRetention
+	}
+	if req.PurgeMoodNotes != nil {
+		settings.PurgeMoodNotes = *req.PurgeMoodNotes
+	}
+	settings.UpdatedAt = time.Now()
+
+	_, err = s.db.Exec(`
+		INSERT INTO retention_settings (user_id, chat_retention, purge_mood_notes, updated_at)
+		VALUES (?, ?, ?, ?)
+		ON CONFLICT(user_id) DO UPDATE SET
+			chat_retention = excluded.chat_retention,
+			purge_mood_notes = excluded.purge_mood_notes,
+			updated_at = excluded.updated_at
+	`, userID, settings.ChatRetention, settings.PurgeMoodNotes, settings.UpdatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to save retention settings: %w", err)
+	}
+	return settings, nil
+}
+
+func validRetention(policy string) bool {
+	_, timed := retentionPeriods[policy]
+	return timed || policy == RetentionForever || policy == RetentionLogout
+}
+
+// Run sweeps expired data every interval until ctx is cancelled.
+func (s *RetentionService) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := s.Sweep(ctx); err != nil {
+			log.Printf("Warning: retention sweep failed: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Sweep purges everything past its retention period: per-user policies
+// first, then the deployment-wide maximum for all users.
+func (s *RetentionService) Sweep(ctx context.Context) (PurgeCounts, error) {
+	var total PurgeCounts
+	now := time.Now()
+
+	for policy, period := range retentionPeriods {
+		if s.maxRetention > 0 && s.maxRetention < period {
+			period = s.maxRetention
+		}
+		counts, err := s.purge(ctx, now.Add(-period),
+			"SELECT user_id FROM retention_settings WHERE chat_retention = ?", policy)
+		if err != nil {
+			return total, err
+		}
+		total.add(counts)
+	}
+
+	if s.maxRetention > 0 {
+		counts, err := s.purge(ctx, now.Add(-s.maxRetention), "SELECT id FROM users")
+		if err != nil {
+			return total, err
+		}
+		total.add(counts)
+	}
+
+	return total, s.audit(ctx, "expired", total)
+}
+
+// PurgeOnLogout deletes all of the user's chats if their policy is
+// "logout". Mood notes go too when they have opted in.
+func (s *RetentionService) PurgeOnLogout(ctx context.Context, userID string) (PurgeCounts, error) {
+	settings, err := s.GetSettings(userID)
+	if err != nil || settings.ChatRetention != RetentionLogout {
+		return PurgeCounts{}, err
+	}
+
+	counts, err := s.purge(ctx, time.Now(), "SELECT ?", userID)
+	if err != nil {
+		return counts, err
+	}
+	return counts, s.audit(ctx, "logout", counts)
+}
+
+// purge deletes chat messages created before cutoff for the users selected
+// by userQuery, then sessions left empty whose last activity is also before
+// cutoff. Mood notes of users who opted in are cleared with the same cutoff.
+func (s *RetentionService) purge(ctx context.Context, cutoff time.Time, userQuery string, args ...interface{}) (PurgeCounts, error) {
+	var counts PurgeCounts
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return counts, err
+	}
+	defer tx.Rollback()
+
+	// exec runs query with the user selection's args followed by cutoff.
+	exec := func(query string) (int64, error) {
+		result, err := tx.ExecContext(ctx, query, append(append([]interface{}{}, args...), cutoff)...)
+		if err != nil {
+			return 0, err
+		}
+		return result.RowsAffected()
+	}
+
+	// chat_messages.user_id is the session owner, even for AI and
+	// counselor messages.
+	_, err = exec(`
+		DELETE FROM message_feedback WHERE message_id IN (
+			SELECT id FROM chat_messages WHERE user_id IN (` + userQuery + `) AND created_at < ?
+		)`)
+	if err != nil {
+		return counts, fmt.Errorf("failed to purge feedback: %w", err)
+	}
+
+	_, err = exec(`
+		DELETE FROM moderation_events WHERE message_id IN (
+			SELECT id FROM chat_messages WHERE user_id IN (` + userQuery + `) AND created_at < ?
+		)`)
+	if err != nil {
+		return counts, fmt.Errorf("failed to purge moderation events: %w", err)
+	}
+
+	blobKeys, err := messageBlobKeys(ctx, tx, `
+		SELECT metadata FROM chat_messages WHERE user_id IN (`+userQuery+`) AND created_at < ?`,
+		append(append([]interface{}{}, args...), cutoff)...)
+	if err != nil {
+		return counts, fmt.Errorf("failed to find purged audio: %w", err)
+	}
+
+	counts.Messages, err = exec(`
+		DELETE FROM chat_messages WHERE user_id IN (` + userQuery + `) AND created_at < ?`)
+	if err != nil {
+		return counts, fmt.Errorf("failed to purge messages: %w", err)
+	}
+
+	emptySessions := `
+		SELECT id FROM chat_sessions
+		WHERE user_id IN (` + userQuery + `) AND updated_at < ?
+		  AND NOT EXISTS (SELECT 1 FROM chat_messages m WHERE m.session_id = chat_sessions.id)`
+	if _, err = exec(`DELETE FROM handoff_requests WHERE session_id IN (` + emptySessions + `)`); err != nil {
+		return counts, fmt.Errorf("failed to purge handoff requests: %w", err)
+	}
+	if _, err = exec(`DELETE FROM chat_session_tags WHERE session_id IN (` + emptySessions + `)`); err != nil {
+		return counts, fmt.Errorf("failed to purge session tags: %w", err)
+	}
+	counts.Sessions, err = exec(`DELETE FROM chat_sessions WHERE id IN (` + emptySessions + `)`)
+	if err != nil {
+		return counts, fmt.Errorf("failed to purge sessions: %w", err)
+	}
+
+	// A purged active leaf takes all of its (older) ancestors with it, so
+	// the next message simply starts a new root.
+	_, err = tx.ExecContext(ctx, `
+		UPDATE chat_sessions SET active_leaf_id = NULL
+		WHERE active_leaf_id IS NOT NULL
+		  AND NOT EXISTS (SELECT 1 FROM chat_messages m WHERE m.id = chat_sessions.active_leaf_id)
+	`)
+	if err != nil {
+		return counts, fmt.Errorf("failed to reset active branches: %w", err)
+	}
+
+	counts.MoodNotes, err = exec(`
+		UPDATE mood_logs SET notes = NULL
+		WHERE user_id IN (SELECT user_id FROM retention_settings WHERE purge_mood_notes = TRUE)
+		  AND user_id IN (` + userQuery + `) AND created_at < ?
+		  AND notes IS NOT NULL AND notes != ''`)
+	if err != nil {
+		return counts, fmt.Errorf("failed to purge mood notes: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return counts, err
+	}
+	deleteMessageBlobs(ctx, s.db, s.blobs, blobKeys)
+	return counts, nil
+}
+
+// audit records how much a purge removed. Empty purges are not recorded.
+func (s *RetentionService) audit(ctx context.Context, reason string, counts PurgeCounts) error {
+	if counts.empty() {
+		return nil
+	}
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO retention_audit (id, reason, sessions_purged, messages_purged, mood_notes_purged, created_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`, uuid.New().String(), reason, counts.Sessions, counts.Messages, counts.MoodNotes, time.Now())
+	if err != nil {
+		return fmt.Errorf("failed to record retention audit: %w", err)
+	}
+	return nil
+}
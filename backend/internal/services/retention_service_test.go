@@ -0,0 +1,126 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/heal/internal/models"
+)
+
+func setRetention(t *testing.T, s *RetentionService, userID, policy string) {
+	t.Helper()
+	if _, err := s.UpdateSettings(userID, models.UpdateRetentionRequest{ChatRetention: &policy}); err != nil {
+		t.Fatalf("UpdateSettings: %v", err)
+	}
+}
+
+// backdate moves every chat row of userID to at.
+func backdate(t *testing.T, db *sql.DB, userID string, at time.Time) {
+	t.Helper()
+	if _, err := db.Exec("UPDATE chat_messages SET created_at = ? WHERE user_id = ?", at, userID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("UPDATE chat_sessions SET updated_at = ? WHERE user_id = ?", at, userID); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func countRows(t *testing.T, db *sql.DB, query string, args ...interface{}) int {
+	t.Helper()
+	var n int
+	if err := db.QueryRow(query, args...).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestUpdateRetentionSettings(t *testing.T) {
+	db := newTestDB(t)
+	s := NewRetentionService(db, nil, 0)
+	userID := createTestUser(t, db, "Amani")
+
+	settings, err := s.GetSettings(userID)
+	if err != nil || settings.ChatRetention != RetentionForever {
+		t.Fatalf("default settings = %+v, %v", settings, err)
+	}
+	bad := "1y"
+	if _, err := s.UpdateSettings(userID, models.UpdateRetentionRequest{ChatRetention: &bad}); !errors.Is(err, ErrInvalidRetention) {
+		t.Errorf("UpdateSettings(1y): err = %v, want ErrInvalidRetention", err)
+	}
+	setRetention(t, s, userID, Retention7d)
+	if settings, _ := s.GetSettings(userID); settings.ChatRetention != Retention7d {
+		t.Errorf("policy = %q, want 7d", settings.ChatRetention)
+	}
+}
+
+func TestSweepPurgesExpiredChats(t *testing.T) {
+	db := newTestDB(t)
+	chat := newTestChatService(t, db, nil)
+	s := NewRetentionService(db, nil, 0)
+	expiring := createTestUser(t, db, "Amani")
+	keeping := createTestUser(t, db, "Baraka")
+	setRetention(t, s, expiring, Retention24h)
+
+	newTestChatSession(t, chat, expiring, "old message")
+	newTestChatSession(t, chat, keeping, "old message")
+	backdate(t, db, expiring, time.Now().Add(-48*time.Hour))
+	backdate(t, db, keeping, time.Now().Add(-48*time.Hour))
+	newTestChatSession(t, chat, expiring, "recent message")
+
+	counts, err := s.Sweep(context.Background())
+	if err != nil {
+		t.Fatalf("Sweep: %v", err)
+	}
+	if counts.Messages != 1 || counts.Sessions != 1 {
+		t.Errorf("counts = %+v, want 1 message and 1 session", counts)
+	}
+	if n := countRows(t, db, "SELECT COUNT(*) FROM chat_messages WHERE user_id = ?", expiring); n != 1 {
+		t.Errorf("expiring user has %d messages, want only the recent one", n)
+	}
+	if n := countRows(t, db, "SELECT COUNT(*) FROM chat_messages WHERE user_id = ?", keeping); n != 1 {
+		t.Errorf("user with the default policy has %d messages, want 1", n)
+	}
+	if n := countRows(t, db, "SELECT COUNT(*) FROM retention_audit WHERE reason = 'expired' AND messages_purged = 1"); n != 1 {
+		t.Errorf("retention_audit rows = %d, want 1", n)
+	}
+}
+
+func TestSweepAppliesDeploymentMaximum(t *testing.T) {
+	db := newTestDB(t)
+	chat := newTestChatService(t, db, nil)
+	s := NewRetentionService(db, nil, 7*24*time.Hour)
+	userID := createTestUser(t, db, "Amani")
+
+	newTestChatSession(t, chat, userID, "old message")
+	backdate(t, db, userID, time.Now().Add(-8*24*time.Hour))
+
+	if _, err := s.Sweep(context.Background()); err != nil {
+		t.Fatalf("Sweep: %v", err)
+	}
+	if n := countRows(t, db, "SELECT COUNT(*) FROM chat_messages WHERE user_id = ?", userID); n != 0 {
+		t.Errorf("%d messages older than the maximum survived", n)
+	}
+}
+
+func TestPurgeOnLogout(t *testing.T) {
+	db := newTestDB(t)
+	chat := newTestChatService(t, db, nil)
+	s := NewRetentionService(db, nil, 0)
+	userID := createTestUser(t, db, "Amani")
+	newTestChatSession(t, chat, userID, "hello")
+
+	if counts, err := s.PurgeOnLogout(context.Background(), userID); err != nil || !counts.empty() {
+		t.Fatalf("PurgeOnLogout with the default policy = %+v, %v; want nothing purged", counts, err)
+	}
+	setRetention(t, s, userID, RetentionLogout)
+	counts, err := s.PurgeOnLogout(context.Background(), userID)
+	if err != nil {
+		t.Fatalf("PurgeOnLogout: %v", err)
+	}
+	if counts.Messages != 1 {
+		t.Errorf("counts = %+v, want the message purged", counts)
+	}
+}
@@ -0,0 +1,127 @@
+package services
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Risk levels, lowest first.
+const (
+	RiskNone     = "none"
+	RiskLow      = "low"
+	RiskMedium   = "medium"
+	RiskHigh     = "high"
+	RiskCritical = "critical"
+)
+
+var riskRank = map[string]int{
+	RiskNone:     0,
+	RiskLow:      1,
+	RiskMedium:   2,
+	RiskHigh:     3,
+	RiskCritical: 4,
+}
+
+// riskPhrases maps each level to phrases that indicate it, in English and
+// Kiswahili. This is a coarse safety net for routing (handoff, quotas); it
+// is not a clinical assessment.
+var riskPhrases = map[string][]string{
+	RiskCritical: {
+		"kill myself", "end my life", "want to die", "going to die", "take my own life",
+		"going to kill me", "will kill me", "kill me tonight", "has a knife", "has a gun",
+		"nitajiua", "nataka kujiua", "nataka kufa", "ataniua", "anataka kuniua",
+	},
+	RiskHigh: {
+		"suicide", "suicidal", "self harm", "self-harm", "hurt myself", "cut myself",
+		"overdose", "raped", "rape me", "sexually assaulted", "he hit me", "beat me",
+		"beaten", "strangled", "choked me", "not safe", "unsafe at home", "threatened to kill",
+		"locked me", "kujiua", "nimebakwa", "alinibaka", "kubakwa", "ananipiga", "alinipiga",
+		"si salama", "sio salama",
+	},
+	RiskMedium: {
+		"abuse", "abused", "abusive", "violence", "violent", "assault", "hit me", "hurt me",
+		"threaten", "scared", "afraid", "harass", "stalking", "controls my money",
+		"unyanyasaji", "dhuluma", "vurugu", "naogopa", "ananitishia",
+	},
+	RiskLow: {
+		"sad", "stressed", "stress", "anxious", "anxiety", "lonely", "depressed",
+		"can't sleep", "crying", "hopeless", "huzuni", "nimechoka", "msongo",
+	},
+}
+
+// humanRequestPhrases are requests for a person. Words like "counselor" or
+// "mshauri" alone also appear in ordinary conversation ("my counselor at
+// school said..."), so they only count as part of a request.
+var humanRequestPhrases = append(append([]string{
+	"real person", "a human", "human being", "talk to someone", "speak to someone",
+	"talk to a person", "speak to a person", "not a bot", "are you a bot",
+	"real counselor", "live agent", "mtu halisi", "ongea na mtu", "zungumza na mtu",
+}, requestPhrases(
+	[]string{"talk to", "speak to", "talk with", "speak with", "connect me to", "connect me with",
+		"i want", "i need", "get me", "can i have"},
+	[]string{"a counselor", "a counsellor", "a therapist", "the counselor", "the counsellor",
+		"the therapist", "your counselor", "your counsellor"},
+)...), requestPhrases(
+	[]string{"ongea na", "kuongea na", "zungumza na", "kuzungumza na", "niunganishe na",
+		"nataka", "nahitaji"},
+	[]string{"mshauri", "binadamu"},
+)...)
+
+// requestPhrases joins every verb with every target.
+func requestPhrases(verbs, targets []string) []string {
+	phrases := make([]string, 0, len(verbs)*len(targets))
+	for _, verb := range verbs {
+		for _, target := range targets {
+			phrases = append(phrases, verb+" "+target)
+		}
+	}
+	return phrases
+}
+
+// AssessRisk returns the highest risk level whose phrases occur in text.
+func AssessRisk(text string) string {
+	normalized := normalizeForMatching(text)
+	for _, level := range []string{RiskCritical, RiskHigh, RiskMedium, RiskLow} {
+		for _, phrase := range riskPhrases[level] {
+			if containsPhrase(normalized, phrase) {
+				return level
+			}
+		}
+	}
+	return RiskNone
+}
+
+// RiskAtLeast reports whether level is at or above threshold.
+func RiskAtLeast(level, threshold string) bool {
+	return riskRank[level] >= riskRank[threshold]
+}
+
+// WantsHuman reports whether the user is asking for a real person.
+func WantsHuman(text string) bool {
+	normalized := normalizeForMatching(text)
+	for _, phrase := range humanRequestPhrases {
+		if containsPhrase(normalized, phrase) {
+			return true
+		}
+	}
+	return false
+}
+
+// normalizeForMatching lower-cases text, turns punctuation into spaces and
+// pads it with spaces so phrases can be matched on word boundaries.
+func normalizeForMatching(text string) string {
+	mapped := strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || r == '\'' || r == '-' {
+			return unicode.ToLower(r)
+		}
+		return ' '
+	}, text)
+	return " " + strings.Join(strings.Fields(mapped), " ") + " "
+}
+
+func containsPhrase(normalized, phrase string) bool {
+	return strings.Contains(normalized, " "+phrase+" ") ||
+		strings.Contains(normalized, " "+phrase+"s ") ||
+		strings.Contains(normalized, " "+phrase+"ed ") ||
+		strings.Contains(normalized, " "+phrase+"ing ")
+}
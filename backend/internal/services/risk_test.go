@@ -0,0 +1,57 @@
+package services
+
+import "testing"
+
+func TestAssessRisk(t *testing.T) {
+	tests := map[string]string{
+		"I want to die":                      RiskCritical,
+		"Nataka kujiua":                      RiskCritical,
+		"He hit me last night":               RiskHigh,
+		"he beat me and I am not safe":       RiskHigh,
+		"Ananipiga kila siku":                RiskHigh,
+		"I'm scared of him":                  RiskMedium,
+		"Feeling stressed about exams":       RiskLow,
+		"What are your opening hours?":       RiskNone,
+		"My friend's ex-husband is abusive.": RiskMedium,
+	}
+	for text, want := range tests {
+		if got := AssessRisk(text); got != want {
+			t.Errorf("AssessRisk(%q) = %s, want %s", text, got, want)
+		}
+	}
+}
+
+func TestRiskAtLeast(t *testing.T) {
+	if !RiskAtLeast(RiskCritical, RiskHigh) || !RiskAtLeast(RiskHigh, RiskHigh) || RiskAtLeast(RiskMedium, RiskHigh) {
+		t.Error("RiskAtLeast does not follow the risk order")
+	}
+}
+
+func TestWantsHuman(t *testing.T) {
+	for _, text := range []string{
+		"Can I talk to someone real?",
+		"I want a real person please",
+		"Are you a bot?",
+		"Nataka kuongea na mtu halisi",
+		"Can I talk to a counselor?",
+		"I need a therapist",
+		"Nataka kuongea na mshauri",
+		"Niunganishe na binadamu tafadhali",
+	} {
+		if !WantsHuman(text) {
+			t.Errorf("WantsHuman(%q) = false, want true", text)
+		}
+	}
+	for _, text := range []string{
+		"Thank you, that helps",
+		"Habari yako",
+		"My counselor at school said I should write things down",
+		"I stopped seeing my therapist last year",
+		"Mshauri wangu alisema nipumzike",
+		"Sisi sote ni binadamu",
+	} {
+		if WantsHuman(text) {
+			t.Errorf("WantsHuman(%q) = true, want false", text)
+		}
+	}
+}
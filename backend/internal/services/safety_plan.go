@@ -0,0 +1,598 @@
+package services
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"reflect"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/heal/internal/models"
+)
+
+const (
+	maxPlanItems      = 20
+	maxPlanTextLength = 500
+)
+
+var (
+	warningSignCategories    = map[string]bool{"": true, "thought": true, "feeling": true, "situation": true, "behaviour": true}
+	copingStrategyCategories = map[string]bool{"": true, "internal": true, "social": true}
+
+	// planPhone accepts local, international and short-code numbers such
+	// as 1195; the plan is the survivor's own notes, so it is not strict.
+	planPhone = regexp.MustCompile(`^\+?[0-9][0-9 ()\-]{1,19}$`)
+)
+
+// CreateSafetyPlan validates and saves userID's safety plan as a new
+// version. Earlier versions are kept.
+func (s *CrisisService) CreateSafetyPlan(userID string, content models.SafetyPlanContent) (*models.SafetyPlan, error) {
+	if err := validateSafetyPlan(&content); err != nil {
+		return nil, err
+	}
+	if err := s.linkSupportContacts(userID, content.SupportContacts); err != nil {
+		return nil, err
+	}
+	return s.saveSafetyPlan(userID, content, nil)
+}
+
+// RestoreSafetyPlanVersion saves an earlier version as the newest one.
+func (s *CrisisService) RestoreSafetyPlanVersion(userID string, version int) (*models.SafetyPlan, error) {
+	old, err := s.GetSafetyPlanVersion(userID, version)
+	if err != nil {
+		return nil, err
+	}
+	return s.saveSafetyPlan(userID, old.SafetyPlanContent, &version)
+}
+
+func (s *CrisisService) saveSafetyPlan(userID string, content models.SafetyPlanContent, restoredFrom *int) (*models.SafetyPlan, error) {
+	columns, err := encodeSafetyPlan(content)
+	if err != nil {
+		return nil, err
+	}
+	stored, err := s.encryptPlanFields(userID, columns...)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	plan := &models.SafetyPlan{UserID: userID, SafetyPlanContent: content, CreatedAt: now, UpdatedAt: now}
+	err = tx.QueryRow("SELECT id, COALESCE(version, 0), created_at FROM safety_plans WHERE user_id = ?", userID).
+		Scan(&plan.ID, &plan.Version, &plan.CreatedAt)
+	switch {
+	case err == sql.ErrNoRows:
+		plan.ID = uuid.New().String()
+		plan.CreatedAt = now
+		_, err = tx.Exec(`
+			INSERT INTO safety_plans (id, user_id, warning_signs, coping_strategies, support_contacts,
+			                          professional_contacts, environment_safety, version, created_at, updated_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?, 0, ?, ?)
+		`, plan.ID, userID, stored[0], stored[1], stored[2], stored[3], stored[4], now, now)
+	case err == nil && plan.Version == 0:
+		// Plans saved before versioning keep their content as version 1
+		_, err = tx.Exec(`
+			INSERT INTO safety_plan_versions (plan_id, user_id, version, warning_signs, coping_strategies,
+			                                  support_contacts, professional_contacts, environment_safety, created_at)
+			SELECT id, user_id, 1, warning_signs, coping_strategies, support_contacts,
+			       professional_contacts, environment_safety, updated_at
+			FROM safety_plans WHERE id = ?
+		`, plan.ID)
+		plan.Version = 1
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to save safety plan: %w", err)
+	}
+
+	plan.Version++
+	_, err = tx.Exec(`
+		UPDATE safety_plans
+		SET warning_signs = ?, coping_strategies = ?, support_contacts = ?,
+		    professional_contacts = ?, environment_safety = ?, version = ?, updated_at = ?
+		WHERE id = ?
+	`, stored[0], stored[1], stored[2], stored[3], stored[4], plan.Version, now, plan.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to save safety plan: %w", err)
+	}
+	_, err = tx.Exec(`
+		INSERT INTO safety_plan_versions (plan_id, user_id, version, warning_signs, coping_strategies,
+		                                  support_contacts, professional_contacts, environment_safety,
+		                                  restored_from, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, plan.ID, userID, plan.Version, stored[0], stored[1], stored[2], stored[3], stored[4], restoredFrom, now)
+	if err != nil {
+		return nil, fmt.Errorf("failed to save safety plan version: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	if err := s.refreshSupportContacts(userID, plan.SupportContacts); err != nil {
+		return nil, err
+	}
+	return plan, nil
+}
+
+func (s *CrisisService) GetSafetyPlan(userID string) (*models.SafetyPlan, error) {
+	plan := &models.SafetyPlan{}
+	columns := make([]string, 5)
+	err := s.db.QueryRow(`
+		SELECT id, user_id, COALESCE(version, 0), COALESCE(warning_signs, ''), COALESCE(coping_strategies, ''),
+		       COALESCE(support_contacts, ''), COALESCE(professional_contacts, ''),
+		       COALESCE(environment_safety, ''), created_at, updated_at
+		FROM safety_plans WHERE user_id = ?
+	`, userID).Scan(&plan.ID, &plan.UserID, &plan.Version, &columns[0], &columns[1], &columns[2],
+		&columns[3], &columns[4], &plan.CreatedAt, &plan.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrSafetyPlanNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	plan.SafetyPlanContent = s.decodeSafetyPlan(userID, columns)
+	if err := s.refreshSupportContacts(userID, plan.SupportContacts); err != nil {
+		return nil, err
+	}
+	return plan, nil
+}
+
+const safetyPlanVersionColumns = `v.plan_id, v.version, v.restored_from, COALESCE(v.warning_signs, ''),
+	COALESCE(v.coping_strategies, ''), COALESCE(v.support_contacts, ''),
+	COALESCE(v.professional_contacts, ''), COALESCE(v.environment_safety, ''), v.created_at`
+
+// GetSafetyPlanVersions returns every saved version of userID's safety
+// plan, newest first.
+func (s *CrisisService) GetSafetyPlanVersions(userID string) ([]models.SafetyPlanVersion, error) {
+	rows, err := s.db.Query(`SELECT `+safetyPlanVersionColumns+` FROM safety_plan_versions v
+		WHERE v.user_id = ? ORDER BY v.version DESC`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	versions := []models.SafetyPlanVersion{}
+	for rows.Next() {
+		version, err := s.scanSafetyPlanVersion(userID, rows)
+		if err != nil {
+			return nil, err
+		}
+		versions = append(versions, *version)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	contacts, err := s.emergencyContactsByID(userID)
+	if err != nil {
+		return nil, err
+	}
+	for i := range versions {
+		applySupportContacts(versions[i].SupportContacts, contacts)
+	}
+	return versions, nil
+}
+
+// GetSafetyPlanVersion returns one saved version of userID's safety plan.
+func (s *CrisisService) GetSafetyPlanVersion(userID string, version int) (*models.SafetyPlanVersion, error) {
+	v, err := s.scanSafetyPlanVersion(userID, s.db.QueryRow(`SELECT `+safetyPlanVersionColumns+`
+		FROM safety_plan_versions v WHERE v.user_id = ? AND v.version = ?`, userID, version))
+	if err == sql.ErrNoRows {
+		return nil, ErrSafetyPlanVersionNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := s.refreshSupportContacts(userID, v.SupportContacts); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+func (s *CrisisService) scanSafetyPlanVersion(userID string, row rowScanner) (*models.SafetyPlanVersion, error) {
+	v := &models.SafetyPlanVersion{}
+	columns := make([]string, 5)
+	var restoredFrom sql.NullInt64
+	err := row.Scan(&v.PlanID, &v.Version, &restoredFrom, &columns[0], &columns[1], &columns[2],
+		&columns[3], &columns[4], &v.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if restoredFrom.Valid {
+		from := int(restoredFrom.Int64)
+		v.RestoredFrom = &from
+	}
+	v.SafetyPlanContent = s.decodeSafetyPlan(userID, columns)
+	return v, nil
+}
+
+// DiffSafetyPlanVersions compares two versions of userID's safety plan. A
+// zero to means the latest version and a zero from the one before to.
+func (s *CrisisService) DiffSafetyPlanVersions(userID string, from, to int) (*models.SafetyPlanDiff, error) {
+	if to == 0 {
+		err := s.db.QueryRow("SELECT COALESCE(MAX(version), 0) FROM safety_plan_versions WHERE user_id = ?", userID).Scan(&to)
+		if err != nil {
+			return nil, err
+		}
+		if to == 0 {
+			return nil, ErrSafetyPlanNotFound
+		}
+	}
+	if from == 0 {
+		from = to - 1
+	}
+
+	// Diffing against version 0 shows everything in to as added
+	var before models.SafetyPlanContent
+	if from > 0 {
+		v, err := s.GetSafetyPlanVersion(userID, from)
+		if err != nil {
+			return nil, err
+		}
+		before = v.SafetyPlanContent
+	}
+	after, err := s.GetSafetyPlanVersion(userID, to)
+	if err != nil {
+		return nil, err
+	}
+	return &models.SafetyPlanDiff{From: from, To: to, Changes: diffSafetyPlans(before, after.SafetyPlanContent)}, nil
+}
+
+// encryptPlanFields encrypts safety plan columns in the order given.
+func (s *CrisisService) encryptPlanFields(userID string, fields ...string) ([]string, error) {
+	stored := make([]string, len(fields))
+	for i, field := range fields {
+		var err error
+		if stored[i], err = s.cipher.Encrypt(userID, field); err != nil {
+			return nil, err
+		}
+	}
+	return stored, nil
+}
+
+// encodeSafetyPlan returns the plan's column values, in column order.
+func encodeSafetyPlan(content models.SafetyPlanContent) ([]string, error) {
+	sections := []interface{}{content.WarningSigns, content.CopingStrategies, content.SupportContacts,
+		content.ProfessionalContacts, content.EnvironmentSafety}
+	columns := make([]string, len(sections))
+	for i, section := range sections {
+		raw, err := json.Marshal(section)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode safety plan: %w", err)
+		}
+		columns[i] = string(raw)
+	}
+	return columns, nil
+}
+
+// decodeSafetyPlan reads the plan's column values. Plans saved before the
+// sections were typed may hold plain strings where objects are expected;
+// those are kept as the item's main text.
+func (s *CrisisService) decodeSafetyPlan(userID string, columns []string) models.SafetyPlanContent {
+	for i := range columns {
+		columns[i] = s.cipher.Reveal(userID, columns[i])
+	}
+
+	var content models.SafetyPlanContent
+	decodePlanList(columns[0], &content.WarningSigns, "sign")
+	decodePlanList(columns[1], &content.CopingStrategies, "strategy")
+	decodePlanList(columns[2], &content.SupportContacts, "name")
+	decodePlanList(columns[3], &content.ProfessionalContacts, "name")
+	if columns[4] != "" && json.Unmarshal([]byte(columns[4]), &content.EnvironmentSafety) != nil {
+		content.EnvironmentSafety = models.EnvironmentSafety{}
+		var notes string
+		if json.Unmarshal([]byte(columns[4]), &notes) == nil {
+			content.EnvironmentSafety.Notes = notes
+		} else if columns[4] != RedactedContent {
+			log.Printf("Warning: could not read environment safety of a safety plan for user %s", userID)
+		}
+	}
+	normalizeSafetyPlan(&content)
+	return content
+}
+
+// decodePlanList unmarshals a JSON array into list, turning plain string
+// items into objects with the string in textField.
+func decodePlanList(raw string, list interface{}, textField string) {
+	if raw == "" || raw == RedactedContent {
+		return
+	}
+	if json.Unmarshal([]byte(raw), list) == nil {
+		return
+	}
+
+	var items []interface{}
+	if json.Unmarshal([]byte(raw), &items) != nil {
+		return
+	}
+	for i, item := range items {
+		if text, ok := item.(string); ok {
+			items[i] = map[string]string{textField: text}
+		}
+	}
+	converted, _ := json.Marshal(items)
+	reflect.ValueOf(list).Elem().Set(reflect.Zero(reflect.TypeOf(list).Elem()))
+	json.Unmarshal(converted, list)
+}
+
+// normalizeSafetyPlan replaces missing lists with empty ones so every
+// section is an array in responses.
+func normalizeSafetyPlan(content *models.SafetyPlanContent) {
+	if content.WarningSigns == nil {
+		content.WarningSigns = []models.WarningSign{}
+	}
+	if content.CopingStrategies == nil {
+		content.CopingStrategies = []models.CopingStrategy{}
+	}
+	if content.SupportContacts == nil {
+		content.SupportContacts = []models.SupportContact{}
+	}
+	if content.ProfessionalContacts == nil {
+		content.ProfessionalContacts = []models.ProfessionalContact{}
+	}
+	env := &content.EnvironmentSafety
+	if env.SafePlaces == nil {
+		env.SafePlaces = []string{}
+	}
+	if env.EmergencyBag == nil {
+		env.EmergencyBag = []string{}
+	}
+	if env.ItemsToSecure == nil {
+		env.ItemsToSecure = []string{}
+	}
+}
+
+func planError(format string, args ...interface{}) error {
+	return fmt.Errorf("%w: "+format, append([]interface{}{ErrInvalidSafetyPlan}, args...)...)
+}
+
+// planText trims a free-text field and checks its length.
+func planText(field string, value *string, required bool) error {
+	*value = strings.TrimSpace(*value)
+	if required && *value == "" {
+		return planError("%s is required", field)
+	}
+	if len(*value) > maxPlanTextLength {
+		return planError("%s must be at most %d characters", field, maxPlanTextLength)
+	}
+	return nil
+}
+
+func planPhoneNumber(field string, value *string, required bool) error {
+	if err := planText(field, value, required); err != nil {
+		return err
+	}
+	if *value != "" && !planPhone.MatchString(*value) {
+		return planError("%s is not a valid phone number", field)
+	}
+	return nil
+}
+
+func planList(field string, items []string) error {
+	if len(items) > maxPlanItems {
+		return planError("%s can have at most %d items", field, maxPlanItems)
+	}
+	for i := range items {
+		if err := planText(fmt.Sprintf("%s[%d]", field, i), &items[i], true); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateSafetyPlan checks a plan and trims its text in place.
+func validateSafetyPlan(content *models.SafetyPlanContent) error {
+	normalizeSafetyPlan(content)
+	for name, n := range map[string]int{
+		"warningSigns":         len(content.WarningSigns),
+		"copingStrategies":     len(content.CopingStrategies),
+		"supportContacts":      len(content.SupportContacts),
+		"professionalContacts": len(content.ProfessionalContacts),
+	} {
+		if n > maxPlanItems {
+			return planError("%s can have at most %d items", name, maxPlanItems)
+		}
+	}
+
+	for i := range content.WarningSigns {
+		sign := &content.WarningSigns[i]
+		if err := planText(fmt.Sprintf("warningSigns[%d].sign", i), &sign.Sign, true); err != nil {
+			return err
+		}
+		if !warningSignCategories[sign.Category] {
+			return planError("warningSigns[%d].category must be one of thought, feeling, situation or behaviour", i)
+		}
+	}
+	for i := range content.CopingStrategies {
+		strategy := &content.CopingStrategies[i]
+		if err := planText(fmt.Sprintf("copingStrategies[%d].strategy", i), &strategy.Strategy, true); err != nil {
+			return err
+		}
+		if !copingStrategyCategories[strategy.Category] {
+			return planError("copingStrategies[%d].category must be internal or social", i)
+		}
+	}
+	for i := range content.SupportContacts {
+		contact := &content.SupportContacts[i]
+		contact.EmergencyContactID = strings.TrimSpace(contact.EmergencyContactID)
+		// Linked contacts take their name from the emergency contact
+		linked := contact.EmergencyContactID != ""
+		if err := planText(fmt.Sprintf("supportContacts[%d].name", i), &contact.Name, !linked); err != nil {
+			return err
+		}
+		if err := planPhoneNumber(fmt.Sprintf("supportContacts[%d].phone", i), &contact.Phone, false); err != nil {
+			return err
+		}
+		if err := planText(fmt.Sprintf("supportContacts[%d].relationship", i), &contact.Relationship, false); err != nil {
+			return err
+		}
+	}
+	for i := range content.ProfessionalContacts {
+		contact := &content.ProfessionalContacts[i]
+		if err := planText(fmt.Sprintf("professionalContacts[%d].name", i), &contact.Name, true); err != nil {
+			return err
+		}
+		if err := planText(fmt.Sprintf("professionalContacts[%d].role", i), &contact.Role, false); err != nil {
+			return err
+		}
+		if err := planText(fmt.Sprintf("professionalContacts[%d].organization", i), &contact.Organization, false); err != nil {
+			return err
+		}
+		if err := planPhoneNumber(fmt.Sprintf("professionalContacts[%d].phone", i), &contact.Phone, true); err != nil {
+			return err
+		}
+	}
+
+	env := &content.EnvironmentSafety
+	if err := planList("environmentSafety.safePlaces", env.SafePlaces); err != nil {
+		return err
+	}
+	if err := planList("environmentSafety.emergencyBag", env.EmergencyBag); err != nil {
+		return err
+	}
+	if err := planList("environmentSafety.itemsToSecure", env.ItemsToSecure); err != nil {
+		return err
+	}
+	if err := planText("environmentSafety.escapePlan", &env.EscapePlan, false); err != nil {
+		return err
+	}
+	if err := planText("environmentSafety.codeWord", &env.CodeWord, false); err != nil {
+		return err
+	}
+	return planText("environmentSafety.notes", &env.Notes, false)
+}
+
+// linkSupportContacts checks that linked support contacts are the user's
+// own emergency contacts and fills in what the plan left out.
+func (s *CrisisService) linkSupportContacts(userID string, supportContacts []models.SupportContact) error {
+	contacts, err := s.emergencyContactsByID(userID)
+	if err != nil {
+		return err
+	}
+	for i := range supportContacts {
+		id := supportContacts[i].EmergencyContactID
+		if id == "" {
+			continue
+		}
+		if _, ok := contacts[id]; !ok {
+			return planError("supportContacts[%d].emergencyContactId is not one of your emergency contacts", i)
+		}
+	}
+	applySupportContacts(supportContacts, contacts)
+	return nil
+}
+
+// refreshSupportContacts shows linked support contacts with the current
+// details of their emergency contact. Contacts since deleted keep the
+// details saved with the plan.
+func (s *CrisisService) refreshSupportContacts(userID string, supportContacts []models.SupportContact) error {
+	contacts, err := s.emergencyContactsByID(userID)
+	if err != nil {
+		return err
+	}
+	applySupportContacts(supportContacts, contacts)
+	return nil
+}
+
+func applySupportContacts(supportContacts []models.SupportContact, contacts map[string]models.EmergencyContact) {
+	for i := range supportContacts {
+		contact, ok := contacts[supportContacts[i].EmergencyContactID]
+		if !ok {
+			continue
+		}
+		supportContacts[i].Name = contact.Name
+		supportContacts[i].Phone = contact.Phone
+		if contact.Relationship != "" {
+			supportContacts[i].Relationship = contact.Relationship
+		}
+	}
+}
+
+func (s *CrisisService) emergencyContactsByID(userID string) (map[string]models.EmergencyContact, error) {
+	contacts, err := s.GetEmergencyContacts(userID)
+	if err != nil {
+		return nil, err
+	}
+	byID := make(map[string]models.EmergencyContact, len(contacts))
+	for _, contact := range contacts {
+		byID[contact.ID] = contact
+	}
+	return byID, nil
+}
+
+// diffSafetyPlans lists the sections that differ between two plans.
+func diffSafetyPlans(from, to models.SafetyPlanContent) []models.SafetyPlanChange {
+	changes := []models.SafetyPlanChange{}
+	add := func(change *models.SafetyPlanChange) {
+		if change != nil {
+			changes = append(changes, *change)
+		}
+	}
+	add(diffPlanList("warningSigns", from.WarningSigns, to.WarningSigns))
+	add(diffPlanList("copingStrategies", from.CopingStrategies, to.CopingStrategies))
+	add(diffPlanList("supportContacts", from.SupportContacts, to.SupportContacts))
+	add(diffPlanList("professionalContacts", from.ProfessionalContacts, to.ProfessionalContacts))
+
+	fromEnv, toEnv := from.EnvironmentSafety, to.EnvironmentSafety
+	add(diffPlanList("environmentSafety.safePlaces", fromEnv.SafePlaces, toEnv.SafePlaces))
+	add(diffPlanList("environmentSafety.emergencyBag", fromEnv.EmergencyBag, toEnv.EmergencyBag))
+	add(diffPlanList("environmentSafety.itemsToSecure", fromEnv.ItemsToSecure, toEnv.ItemsToSecure))
+	add(diffPlanText("environmentSafety.escapePlan", fromEnv.EscapePlan, toEnv.EscapePlan))
+	add(diffPlanText("environmentSafety.codeWord", fromEnv.CodeWord, toEnv.CodeWord))
+	add(diffPlanText("environmentSafety.notes", fromEnv.Notes, toEnv.Notes))
+	return changes
+}
+
+// diffPlanList compares two slices of plan items, counting repeats, and
+// returns nil if they hold the same items. Reordering is not a change.
+func diffPlanList(section string, from, to interface{}) *models.SafetyPlanChange {
+	key := func(item interface{}) string {
+		raw, _ := json.Marshal(item)
+		return string(raw)
+	}
+
+	remaining := map[string]int{}
+	fromItems := reflect.ValueOf(from)
+	for i := 0; i < fromItems.Len(); i++ {
+		remaining[key(fromItems.Index(i).Interface())]++
+	}
+
+	change := &models.SafetyPlanChange{Section: section}
+	toItems := reflect.ValueOf(to)
+	for i := 0; i < toItems.Len(); i++ {
+		item := toItems.Index(i).Interface()
+		if k := key(item); remaining[k] > 0 {
+			remaining[k]--
+			continue
+		}
+		change.Added = append(change.Added, item)
+	}
+	for i := 0; i < fromItems.Len(); i++ {
+		item := fromItems.Index(i).Interface()
+		if k := key(item); remaining[k] > 0 {
+			remaining[k]--
+			change.Removed = append(change.Removed, item)
+		}
+	}
+
+	if len(change.Added) == 0 && len(change.Removed) == 0 {
+		return nil
+	}
+	return change
+}
+
+func diffPlanText(section, from, to string) *models.SafetyPlanChange {
+	if from == to {
+		return nil
+	}
+	return &models.SafetyPlanChange{Section: section, From: from, To: to}
+}
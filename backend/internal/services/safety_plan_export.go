@@ -0,0 +1,296 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/heal/internal/models"
+	"github.com/heal/internal/pdf"
+)
+
+// Safety plan export formats, besides ExportPDF.
+const (
+	ExportText  = "txt"
+	ExportVCard = "vcf"
+)
+
+const (
+	// maxPlanSMSLength fits six concatenated SMS of 153 GSM-7 characters.
+	maxPlanSMSLength = 6 * 153
+	// smsLineMinimum is the least space worth filling with a cut-down line.
+	smsLineMinimum = 20
+)
+
+// planHotlines are printed on every export so they can be reached offline.
+var planHotlines = []Hotline{
+	{"CRISIS", "Police", "999/112"},
+	{"CRISIS", "GBV hotline", "1195"},
+}
+
+// RenderSafetyPlan renders a safety plan in the given format and returns
+// the document with its content type. The vCard bundle holds the plan's
+// support and professional contacts that have a phone number.
+func RenderSafetyPlan(plan *models.SafetyPlan, format string) ([]byte, string, error) {
+	switch format {
+	case ExportPDF:
+		return renderSafetyPlanPDF(plan), "application/pdf", nil
+	case ExportText:
+		return []byte(renderSafetyPlanText(plan)), "text/plain; charset=utf-8", nil
+	case ExportVCard:
+		cards := renderSafetyPlanVCards(plan)
+		if cards == "" {
+			return nil, "", ErrNoPlanContacts
+		}
+		return []byte(cards), "text/vcard; charset=utf-8", nil
+	default:
+		return nil, "", ErrInvalidPlanExportFormat
+	}
+}
+
+// planSection is a titled list of lines of a safety plan, in the order
+// they are printed. Pinned sections are never cut short.
+type planSection struct {
+	title  string
+	items  []string
+	pinned bool
+}
+
+func safetyPlanSections(plan *models.SafetyPlan) []planSection {
+	var signs, coping, people, professionals []string
+	for _, s := range plan.WarningSigns {
+		signs = append(signs, s.Sign)
+	}
+	for _, s := range plan.CopingStrategies {
+		coping = append(coping, s.Strategy)
+	}
+	for _, c := range plan.SupportContacts {
+		people = append(people, joinNonEmpty(" ", c.Name, parenthesize(c.Relationship), c.Phone))
+	}
+	for _, c := range plan.ProfessionalContacts {
+		professionals = append(professionals, joinNonEmpty(" ", c.Name, parenthesize(joinNonEmpty(", ", c.Role, c.Organization)), c.Phone))
+	}
+	var hotlines []string
+	for _, h := range planHotlines {
+		hotlines = append(hotlines, h.Name+" "+h.Number)
+	}
+
+	env := plan.EnvironmentSafety
+	var escape []string
+	if env.EscapePlan != "" {
+		escape = append(escape, env.EscapePlan)
+	}
+	if env.CodeWord != "" {
+		escape = append(escape, "Code word: "+env.CodeWord)
+	}
+	var notes []string
+	if env.Notes != "" {
+		notes = append(notes, env.Notes)
+	}
+
+	return []planSection{
+		{"Warning signs", signs, false},
+		{"Things I can do", coping, false},
+		{"People I can call", people, false},
+		{"Professional help", professionals, false},
+		{"Safe places", env.SafePlaces, false},
+		{"Escape plan", escape, false},
+		{"Emergency bag", env.EmergencyBag, false},
+		{"Make my surroundings safer", env.ItemsToSecure, false},
+		{"Notes", notes, false},
+		{"Emergency numbers", hotlines, true},
+	}
+}
+
+// renderSafetyPlanPDF lays the plan out on a single page. If the full
+// layout runs over, sections are run together and then cut short until it
+// fits.
+func renderSafetyPlanPDF(plan *models.SafetyPlan) []byte {
+	sections := safetyPlanSections(plan)
+	longest := 0
+	for _, section := range sections {
+		longest = max(longest, len(section.items))
+	}
+
+	doc := safetyPlanPDF(plan, sections, false, longest)
+	for limit := longest; doc.PageCount() > 1 && limit > 0; limit-- {
+		doc = safetyPlanPDF(plan, sections, true, limit)
+	}
+	return doc.Bytes()
+}
+
+func safetyPlanPDF(plan *models.SafetyPlan, sections []planSection, compact bool, limit int) *pdf.Document {
+	doc := pdf.New("My safety plan")
+	doc.SetFooter("Keep this somewhere safe")
+	doc.Heading("My safety plan")
+	updated := "Updated " + plan.UpdatedAt.Format("2 January 2006")
+	if plan.Version > 0 {
+		updated = fmt.Sprintf("Version %d, updated %s", plan.Version, plan.UpdatedAt.Format("2 January 2006"))
+	}
+	doc.Small(updated)
+	doc.Space(8)
+
+	for _, section := range sections {
+		if len(section.items) == 0 {
+			continue
+		}
+		items := section.items
+		if !section.pinned && len(items) > limit {
+			items = append(items[:limit:limit], fmt.Sprintf("and %d more", len(section.items)-limit))
+		}
+		doc.Label(section.title)
+		if compact {
+			doc.Small(strings.Join(items, "; "))
+			doc.Space(4)
+			continue
+		}
+		for _, item := range items {
+			doc.Text("- " + item)
+		}
+		doc.Space(8)
+	}
+	return doc
+}
+
+// renderSafetyPlanText writes the plan as plain ASCII text short enough to
+// send as one concatenated SMS. Contacts and emergency numbers come first
+// so they survive when the rest has to be cut.
+func renderSafetyPlanText(plan *models.SafetyPlan) string {
+	sections := safetyPlanSections(plan)
+	byTitle := map[string][]string{}
+	for _, section := range sections {
+		byTitle[section.title] = section.items
+	}
+
+	type smsLine struct{ label, title string }
+	order := []smsLine{
+		{"Call", "People I can call"},
+		{"Emergency", "Emergency numbers"},
+		{"Help", "Professional help"},
+		{"Safe places", "Safe places"},
+		{"Escape", "Escape plan"},
+		{"Signs", "Warning signs"},
+		{"Do", "Things I can do"},
+		{"Bag", "Emergency bag"},
+		{"Safer", "Make my surroundings safer"},
+		{"Notes", "Notes"},
+	}
+
+	var b strings.Builder
+	b.WriteString("MY SAFETY PLAN")
+	for _, l := range order {
+		items := byTitle[l.title]
+		if len(items) == 0 {
+			continue
+		}
+		line := "\n" + l.label + ": " + gsmSafe(strings.Join(items, "; "))
+		room := maxPlanSMSLength - b.Len()
+		if len(line) > room {
+			if room < smsLineMinimum {
+				continue
+			}
+			line = strings.TrimRight(line[:room-2], " ;") + ".."
+		}
+		b.WriteString(line)
+	}
+	return b.String()
+}
+
+// SMSSegments is how many SMS a GSM-7 text is sent as.
+func SMSSegments(text string) int {
+	if len(text) <= 160 {
+		return 1
+	}
+	return (len(text) + 152) / 153
+}
+
+// gsmSafe keeps text to printable ASCII, which every phone sends as GSM-7.
+// Anything else would switch the message to UCS-2 and cut its length by
+// more than half.
+func gsmSafe(text string) string {
+	replacer := strings.NewReplacer("‘", "'", "’", "'", "“", `"`, "”", `"`,
+		"–", "-", "—", "-", "…", "...", "\n", " ")
+	text = replacer.Replace(text)
+	return strings.Map(func(r rune) rune {
+		if r >= ' ' && r <= '~' {
+			return r
+		}
+		return -1
+	}, text)
+}
+
+// renderSafetyPlanVCards writes a vCard 3.0 bundle of the plan's contacts,
+// or "" if none has a phone number. The cards do not mention the plan, as
+// someone else may look through the phone's contacts.
+func renderSafetyPlanVCards(plan *models.SafetyPlan) string {
+	var b strings.Builder
+	card := func(name, org, title, relationship, phone string) {
+		lines := []string{"BEGIN:VCARD", "VERSION:3.0", "FN:" + vcardEscape(name), "N:" + vcardEscape(name) + ";;;;"}
+		if org != "" {
+			lines = append(lines, "ORG:"+vcardEscape(org))
+		}
+		if title != "" {
+			lines = append(lines, "TITLE:"+vcardEscape(title))
+		}
+		lines = append(lines, "TEL;TYPE=VOICE:"+vcardEscape(phone))
+		if relationship != "" {
+			lines = append(lines, "X-RELATIONSHIP:"+vcardEscape(relationship))
+		}
+		lines = append(lines, "REV:"+time.Now().UTC().Format("20060102T150405Z"), "END:VCARD")
+		for _, line := range lines {
+			b.WriteString(vcardFold(line))
+		}
+	}
+
+	for _, c := range plan.SupportContacts {
+		if c.Phone != "" {
+			card(c.Name, "", "", c.Relationship, c.Phone)
+		}
+	}
+	for _, c := range plan.ProfessionalContacts {
+		if c.Phone != "" {
+			card(c.Name, c.Organization, c.Role, "", c.Phone)
+		}
+	}
+	return b.String()
+}
+
+// vcardEscape escapes a vCard text value.
+func vcardEscape(value string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`, ",", `\,`, ";", `\;`).Replace(value)
+}
+
+// vcardFold folds a content line at 75 octets, without splitting a UTF-8
+// character, and ends it with CRLF.
+func vcardFold(line string) string {
+	var b strings.Builder
+	width := 0
+	for _, r := range line {
+		size := len(string(r))
+		if width+size > 75 {
+			b.WriteString("\r\n ")
+			width = 1
+		}
+		b.WriteRune(r)
+		width += size
+	}
+	b.WriteString("\r\n")
+	return b.String()
+}
+
+func joinNonEmpty(sep string, parts ...string) string {
+	var kept []string
+	for _, part := range parts {
+		if part != "" {
+			kept = append(kept, part)
+		}
+	}
+	return strings.Join(kept, sep)
+}
+
+func parenthesize(text string) string {
+	if text == "" {
+		return ""
+	}
+	return "(" + text + ")"
+}
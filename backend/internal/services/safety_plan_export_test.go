@@ -0,0 +1,102 @@
+package services
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/heal/internal/models"
+)
+
+func testSafetyPlan() *models.SafetyPlan {
+	return &models.SafetyPlan{
+		SafetyPlanContent: models.SafetyPlanContent{
+			WarningSigns:     []models.WarningSign{{Sign: "He checks my phone", Category: "situation"}},
+			CopingStrategies: []models.CopingStrategy{{Strategy: "Walk to the market", Category: "internal"}},
+			SupportContacts: []models.SupportContact{
+				{Name: "Wanjiku, my sister", Phone: "+254712345678", Relationship: "sister"},
+			},
+			ProfessionalContacts: []models.ProfessionalContact{
+				{Name: "GBV Hotline", Phone: "1195"},
+				{Name: "Counsellor without a phone"},
+			},
+			EnvironmentSafety: models.EnvironmentSafety{
+				SafePlaces: []string{"Church “St. Mary’s”"},
+				EscapePlan: strings.Repeat("Take the back road to the matatu stage. ", 40),
+			},
+		},
+	}
+}
+
+func TestRenderSafetyPlanText(t *testing.T) {
+	out, contentType, err := RenderSafetyPlan(testSafetyPlan(), ExportText)
+	if err != nil || !strings.HasPrefix(contentType, "text/plain") {
+		t.Fatalf("RenderSafetyPlan = %q, %v", contentType, err)
+	}
+	text := string(out)
+	if len(text) > maxPlanSMSLength {
+		t.Errorf("text is %d characters, want at most %d", len(text), maxPlanSMSLength)
+	}
+	if !strings.Contains(text, "+254712345678") || !strings.Contains(text, "1195") {
+		t.Errorf("contacts missing from the text export:\n%s", text)
+	}
+	if !strings.Contains(text, `Church "St. Mary's"`) {
+		t.Errorf("curly quotes were not replaced:\n%s", text)
+	}
+	for _, r := range text {
+		if r != '\n' && (r < ' ' || r > '~') {
+			t.Fatalf("text export contains non-GSM character %q", r)
+		}
+	}
+	if !strings.Contains(text, "..") {
+		t.Error("the long escape plan was not cut short")
+	}
+}
+
+func TestRenderSafetyPlanVCards(t *testing.T) {
+	out, _, err := RenderSafetyPlan(testSafetyPlan(), ExportVCard)
+	if err != nil {
+		t.Fatalf("RenderSafetyPlan: %v", err)
+	}
+	cards := string(out)
+	if n := strings.Count(cards, "BEGIN:VCARD"); n != 2 {
+		t.Errorf("got %d cards, want 2 (contacts without a phone are skipped)", n)
+	}
+	if !strings.Contains(cards, `FN:Wanjiku\, my sister`) {
+		t.Errorf("name was not escaped:\n%s", cards)
+	}
+	if strings.Contains(strings.ToLower(cards), "safety") {
+		t.Error("vCards must not mention the safety plan")
+	}
+
+	_, _, err = RenderSafetyPlan(&models.SafetyPlan{}, ExportVCard)
+	if !errors.Is(err, ErrNoPlanContacts) {
+		t.Errorf("empty plan: err = %v, want ErrNoPlanContacts", err)
+	}
+}
+
+func TestRenderSafetyPlanPDF(t *testing.T) {
+	out, contentType, err := RenderSafetyPlan(testSafetyPlan(), ExportPDF)
+	if err != nil || contentType != "application/pdf" {
+		t.Fatalf("RenderSafetyPlan = %q, %v", contentType, err)
+	}
+	if !bytes.HasPrefix(out, []byte("%PDF-")) {
+		t.Error("output is not a PDF")
+	}
+	if _, _, err := RenderSafetyPlan(testSafetyPlan(), "docx"); !errors.Is(err, ErrInvalidPlanExportFormat) {
+		t.Errorf("unknown format: err = %v, want ErrInvalidPlanExportFormat", err)
+	}
+}
+
+func TestVcardFold(t *testing.T) {
+	folded := vcardFold("NOTE:" + strings.Repeat("é", 60))
+	for _, line := range strings.Split(strings.TrimSuffix(folded, "\r\n"), "\r\n") {
+		if len(line) > 75 {
+			t.Errorf("line is %d octets, want at most 75", len(line))
+		}
+	}
+	if !strings.HasPrefix(strings.Split(folded, "\r\n")[1], " ") {
+		t.Error("continuation line does not start with a space")
+	}
+}
@@ -0,0 +1,119 @@
+package services
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/heal/internal/models"
+)
+
+func TestValidateSafetyPlan(t *testing.T) {
+	valid := models.SafetyPlanContent{
+		WarningSigns:         []models.WarningSign{{Sign: "  He starts drinking  ", Category: "situation"}},
+		ProfessionalContacts: []models.ProfessionalContact{{Name: "GBV Hotline", Phone: "1195"}},
+	}
+	if err := validateSafetyPlan(&valid); err != nil {
+		t.Fatalf("validateSafetyPlan: %v", err)
+	}
+	if valid.WarningSigns[0].Sign != "He starts drinking" || valid.EnvironmentSafety.SafePlaces == nil {
+		t.Errorf("plan was not trimmed and normalized: %+v", valid)
+	}
+
+	for name, content := range map[string]models.SafetyPlanContent{
+		"bad category":     {WarningSigns: []models.WarningSign{{Sign: "x", Category: "mood"}}},
+		"missing phone":    {ProfessionalContacts: []models.ProfessionalContact{{Name: "Clinic"}}},
+		"bad phone":        {ProfessionalContacts: []models.ProfessionalContact{{Name: "Clinic", Phone: "call me"}}},
+		"unnamed contact":  {SupportContacts: []models.SupportContact{{Phone: "0712345678"}}},
+		"long escape plan": {EnvironmentSafety: models.EnvironmentSafety{EscapePlan: strings.Repeat("x", maxPlanTextLength+1)}},
+	} {
+		if err := validateSafetyPlan(&content); !errors.Is(err, ErrInvalidSafetyPlan) {
+			t.Errorf("%s: err = %v, want ErrInvalidSafetyPlan", name, err)
+		}
+	}
+}
+
+func TestSafetyPlanVersions(t *testing.T) {
+	db := newTestDB(t)
+	crisis := NewCrisisService(db, newTestCipher(t, db), nil, nil)
+	userID := createTestUser(t, db, "Amani")
+
+	first, err := crisis.CreateSafetyPlan(userID, models.SafetyPlanContent{
+		EnvironmentSafety: models.EnvironmentSafety{CodeWord: "mango", SafePlaces: []string{"sister's house"}},
+	})
+	if err != nil || first.Version != 1 {
+		t.Fatalf("CreateSafetyPlan = %+v, %v", first, err)
+	}
+	second, err := crisis.CreateSafetyPlan(userID, models.SafetyPlanContent{
+		EnvironmentSafety: models.EnvironmentSafety{CodeWord: "pineapple", SafePlaces: []string{"sister's house", "church"}},
+	})
+	if err != nil || second.Version != 2 {
+		t.Fatalf("CreateSafetyPlan = %+v, %v", second, err)
+	}
+
+	diff, err := crisis.DiffSafetyPlanVersions(userID, 1, 2)
+	if err != nil {
+		t.Fatalf("DiffSafetyPlanVersions: %v", err)
+	}
+	sections := map[string]models.SafetyPlanChange{}
+	for _, change := range diff.Changes {
+		sections[change.Section] = change
+	}
+	if c := sections["environmentSafety.codeWord"]; c.From != "mango" || c.To != "pineapple" {
+		t.Errorf("codeWord change = %+v", c)
+	}
+	if c := sections["environmentSafety.safePlaces"]; len(c.Added) != 1 || c.Added[0] != "church" {
+		t.Errorf("safePlaces change = %+v", c)
+	}
+
+	restored, err := crisis.RestoreSafetyPlanVersion(userID, 1)
+	if err != nil || restored.Version != 3 || restored.EnvironmentSafety.CodeWord != "mango" {
+		t.Fatalf("RestoreSafetyPlanVersion = %+v, %v", restored, err)
+	}
+	versions, err := crisis.GetSafetyPlanVersions(userID)
+	if err != nil || len(versions) != 3 {
+		t.Fatalf("GetSafetyPlanVersions = %d versions, %v", len(versions), err)
+	}
+	if _, err := crisis.GetSafetyPlanVersion(userID, 9); !errors.Is(err, ErrSafetyPlanVersionNotFound) {
+		t.Errorf("GetSafetyPlanVersion(9): err = %v, want ErrSafetyPlanVersionNotFound", err)
+	}
+
+	var stored string
+	if err := db.QueryRow("SELECT environment_safety FROM safety_plans WHERE user_id = ?", userID).Scan(&stored); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(stored, "mango") {
+		t.Error("the safety plan was stored in plaintext")
+	}
+}
+
+func TestSafetyPlanLinksEmergencyContacts(t *testing.T) {
+	db := newTestDB(t)
+	crisis := NewCrisisService(db, nil, nil, nil)
+	userID := createTestUser(t, db, "Amani")
+	contact, err := crisis.AddEmergencyContact(userID, "Wanjiku", "+254712345678", "sister", true)
+	if err != nil {
+		t.Fatalf("AddEmergencyContact: %v", err)
+	}
+
+	plan, err := crisis.CreateSafetyPlan(userID, models.SafetyPlanContent{
+		SupportContacts: []models.SupportContact{{EmergencyContactID: contact.ID}},
+	})
+	if err != nil {
+		t.Fatalf("CreateSafetyPlan: %v", err)
+	}
+	if got := plan.SupportContacts[0]; got.Name != "Wanjiku" || got.Phone != "+254712345678" {
+		t.Errorf("linked contact = %+v, want the emergency contact's details", got)
+	}
+
+	other, err := crisis.AddEmergencyContact(createTestUser(t, db, "Baraka"), "Juma", "+254700000000", "friend", true)
+	if err != nil {
+		t.Fatalf("AddEmergencyContact: %v", err)
+	}
+	_, err = crisis.CreateSafetyPlan(userID, models.SafetyPlanContent{
+		SupportContacts: []models.SupportContact{{EmergencyContactID: other.ID}},
+	})
+	if !errors.Is(err, ErrInvalidSafetyPlan) {
+		t.Errorf("linking another user's contact: err = %v, want ErrInvalidSafetyPlan", err)
+	}
+}
@@ -0,0 +1,204 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/heal/internal/models"
+)
+
+const (
+	maxSessionTags   = 10
+	maxTagLength     = 32
+	maxBulkSessionOp = 100
+)
+
+// normalizeTag lower-cases a tag and collapses its whitespace, so "Work
+// Stress" and "work  stress" are the same tag.
+func normalizeTag(tag string) string {
+	return strings.Join(strings.Fields(strings.ToLower(tag)), " ")
+}
+
+// normalizeTags normalizes and de-duplicates tags, keeping their order.
+func normalizeTags(tags []string) ([]string, error) {
+	seen := map[string]bool{}
+	normalized := []string{}
+	for _, tag := range tags {
+		tag = normalizeTag(tag)
+		if tag == "" || seen[tag] {
+			continue
+		}
+		if len([]rune(tag)) > maxTagLength {
+			return nil, fmt.Errorf("%w: tags must be at most %d characters", ErrInvalidTags, maxTagLength)
+		}
+		seen[tag] = true
+		normalized = append(normalized, tag)
+	}
+	if len(normalized) > maxSessionTags {
+		return nil, fmt.Errorf("%w: a session can have at most %d tags", ErrInvalidTags, maxSessionTags)
+	}
+	return normalized, nil
+}
+
+func replaceSessionTags(tx *sql.Tx, sessionID string, tags []string) error {
+	if _, err := tx.Exec("DELETE FROM chat_session_tags WHERE session_id = ?", sessionID); err != nil {
+		return fmt.Errorf("failed to update tags: %w", err)
+	}
+	now := time.Now()
+	for _, tag := range tags {
+		_, err := tx.Exec(`
+			INSERT INTO chat_session_tags (session_id, tag, created_at) VALUES (?, ?, ?)
+		`, sessionID, tag, now)
+		if err != nil {
+			return fmt.Errorf("failed to update tags: %w", err)
+		}
+	}
+	return nil
+}
+
+// loadSessionTags fills in the tags of the user's sessions with one query.
+func (s *ChatService) loadSessionTags(userID string, sessions []models.ChatSession) error {
+	index := map[string]int{}
+	for i := range sessions {
+		sessions[i].Tags = []string{}
+		index[sessions[i].ID] = i
+	}
+	if len(sessions) == 0 {
+		return nil
+	}
+
+	rows, err := s.db.Query(`
+		SELECT t.session_id, t.tag
+		FROM chat_session_tags t
+		JOIN chat_sessions cs ON cs.id = t.session_id
+		WHERE cs.user_id = ?
+		ORDER BY t.created_at, t.rowid
+	`, userID)
+	if err != nil {
+		return fmt.Errorf("failed to load tags: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var sessionID, tag string
+		if err := rows.Scan(&sessionID, &tag); err != nil {
+			return err
+		}
+		if i, ok := index[sessionID]; ok {
+			sessions[i].Tags = append(sessions[i].Tags, tag)
+		}
+	}
+	return rows.Err()
+}
+
+// GetSessionTags lists the tags the user has used, with how many sessions
+// carry each, most used first.
+func (s *ChatService) GetSessionTags(userID string) ([]models.SessionTagCount, error) {
+	rows, err := s.db.Query(`
+		SELECT t.tag, COUNT(*)
+		FROM chat_session_tags t
+		JOIN chat_sessions cs ON cs.id = t.session_id
+		WHERE cs.user_id = ?
+		GROUP BY t.tag
+		ORDER BY COUNT(*) DESC, t.tag
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tags := []models.SessionTagCount{}
+	for rows.Next() {
+		var tag models.SessionTagCount
+		if err := rows.Scan(&tag.Tag, &tag.Sessions); err != nil {
+			return nil, err
+		}
+		tags = append(tags, tag)
+	}
+	return tags, rows.Err()
+}
+
+// ArchiveSessions archives (or unarchives) the given sessions of the user.
+// IDs that are not the user's are ignored; the number changed is returned.
+func (s *ChatService) ArchiveSessions(userID string, sessionIDs []string, archived bool) (int64, error) {
+	placeholders, args, err := sessionIDArgs(sessionIDs)
+	if err != nil {
+		return 0, err
+	}
+
+	result, err := s.db.Exec(`
+		UPDATE chat_sessions SET archived = ?, updated_at = ?
+		WHERE user_id = ? AND id IN (`+placeholders+`)
+	`, append([]interface{}{archived, time.Now(), userID}, args...)...)
+	if err != nil {
+		return 0, fmt.Errorf("failed to archive sessions: %w", err)
+	}
+	return result.RowsAffected()
+}
+
+// DeleteSessions deletes the given sessions of the user with everything
+// attached to them. IDs that are not the user's are ignored; the number of
+// sessions deleted is returned.
+func (s *ChatService) DeleteSessions(userID string, sessionIDs []string) (int64, error) {
+	placeholders, args, err := sessionIDArgs(sessionIDs)
+	if err != nil {
+		return 0, err
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	owned := `SELECT id FROM chat_sessions WHERE user_id = ? AND id IN (` + placeholders + `)`
+	ownedArgs := append([]interface{}{userID}, args...)
+
+	blobKeys, err := messageBlobKeys(context.Background(), tx,
+		`SELECT metadata FROM chat_messages WHERE session_id IN (`+owned+`)`, ownedArgs...)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete sessions: %w", err)
+	}
+
+	// Foreign keys are not enforced, so dependents go first, explicitly
+	for _, query := range []string{
+		`DELETE FROM message_feedback WHERE session_id IN (` + owned + `)`,
+		`DELETE FROM moderation_events WHERE session_id IN (` + owned + `)`,
+		`DELETE FROM handoff_requests WHERE session_id IN (` + owned + `)`,
+		`DELETE FROM chat_session_tags WHERE session_id IN (` + owned + `)`,
+		`DELETE FROM chat_messages WHERE session_id IN (` + owned + `)`,
+	} {
+		if _, err := tx.Exec(query, ownedArgs...); err != nil {
+			return 0, fmt.Errorf("failed to delete sessions: %w", err)
+		}
+	}
+
+	result, err := tx.Exec(`DELETE FROM chat_sessions WHERE id IN (`+owned+`)`, ownedArgs...)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete sessions: %w", err)
+	}
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	deleteMessageBlobs(context.Background(), s.db, s.blobs, blobKeys)
+	return deleted, nil
+}
+
+func sessionIDArgs(sessionIDs []string) (string, []interface{}, error) {
+	if len(sessionIDs) == 0 || len(sessionIDs) > maxBulkSessionOp {
+		return "", nil, fmt.Errorf("%w: between 1 and %d session IDs are required", ErrInvalidBulkRequest, maxBulkSessionOp)
+	}
+	args := make([]interface{}, len(sessionIDs))
+	for i, id := range sessionIDs {
+		args[i] = id
+	}
+	return strings.TrimSuffix(strings.Repeat("?,", len(sessionIDs)), ","), args, nil
+}
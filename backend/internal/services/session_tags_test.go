@@ -0,0 +1,127 @@
+package services
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/heal/internal/models"
+)
+
+func TestNormalizeTags(t *testing.T) {
+	got, err := normalizeTags([]string{"Work  Stress", "work stress", " ", "Family"})
+	if err != nil || strings.Join(got, ",") != "work stress,family" {
+		t.Errorf("normalizeTags = %q, %v", got, err)
+	}
+	if _, err := normalizeTags([]string{strings.Repeat("x", maxTagLength+1)}); !errors.Is(err, ErrInvalidTags) {
+		t.Errorf("long tag: err = %v, want ErrInvalidTags", err)
+	}
+	many := make([]string, maxSessionTags+1)
+	for i := range many {
+		many[i] = string(rune('a' + i))
+	}
+	if _, err := normalizeTags(many); !errors.Is(err, ErrInvalidTags) {
+		t.Errorf("too many tags: err = %v, want ErrInvalidTags", err)
+	}
+}
+
+func sessionIDs(sessions []models.ChatSession) string {
+	var ids []string
+	for _, session := range sessions {
+		ids = append(ids, session.ID)
+	}
+	return strings.Join(ids, ",")
+}
+
+func TestGetChatSessionsFilters(t *testing.T) {
+	db := newTestDB(t)
+	s := newTestChatService(t, db, nil)
+	userID := createTestUser(t, db, "Amani")
+	plain := newTestChatSession(t, s, userID, "one")
+	tagged := newTestChatSession(t, s, userID, "two")
+	archived := newTestChatSession(t, s, userID, "three")
+
+	pinned, tags := true, []string{"Court", "Family"}
+	if _, err := s.UpdateSession(userID, tagged.ID, models.UpdateSessionRequest{Pinned: &pinned, Tags: &tags}); err != nil {
+		t.Fatalf("UpdateSession: %v", err)
+	}
+	if n, err := s.ArchiveSessions(userID, []string{archived.ID}, true); err != nil || n != 1 {
+		t.Fatalf("ArchiveSessions = %d, %v", n, err)
+	}
+
+	list := func(filter models.SessionFilter) string {
+		t.Helper()
+		filter.Limit = 10
+		sessions, err := s.GetChatSessions(userID, filter)
+		if err != nil {
+			t.Fatalf("GetChatSessions: %v", err)
+		}
+		return sessionIDs(sessions)
+	}
+	if got := list(models.SessionFilter{}); got != tagged.ID+","+plain.ID {
+		t.Errorf("default list = %s, want the pinned session first and no archived ones", got)
+	}
+	if got := list(models.SessionFilter{Archived: models.ArchivedOnly}); got != archived.ID {
+		t.Errorf("archived list = %s", got)
+	}
+	if got := list(models.SessionFilter{Tag: "COURT"}); got != tagged.ID {
+		t.Errorf("tag filter = %s", got)
+	}
+
+	counts, err := s.GetSessionTags(userID)
+	if err != nil || len(counts) != 2 || counts[0].Sessions != 1 {
+		t.Errorf("GetSessionTags = %+v, %v", counts, err)
+	}
+}
+
+func TestDeleteSessionsIgnoresOtherUsers(t *testing.T) {
+	db := newTestDB(t)
+	s := newTestChatService(t, db, nil)
+	userID := createTestUser(t, db, "Amani")
+	otherID := createTestUser(t, db, "Baraka")
+	mine := newTestChatSession(t, s, userID, "mine")
+	theirs := newTestChatSession(t, s, otherID, "theirs")
+	tags := []string{"court"}
+	if _, err := s.UpdateSession(userID, mine.ID, models.UpdateSessionRequest{Tags: &tags}); err != nil {
+		t.Fatalf("UpdateSession: %v", err)
+	}
+
+	deleted, err := s.DeleteSessions(userID, []string{mine.ID, theirs.ID})
+	if err != nil || deleted != 1 {
+		t.Fatalf("DeleteSessions = %d, %v; want 1", deleted, err)
+	}
+	if n := countRows(t, db, "SELECT COUNT(*) FROM chat_messages WHERE session_id = ?", theirs.ID); n != 1 {
+		t.Error("another user's session was deleted")
+	}
+	if n := countRows(t, db, "SELECT COUNT(*) FROM chat_session_tags WHERE session_id = ?", mine.ID); n != 0 {
+		t.Errorf("%d tags survived their session", n)
+	}
+	if _, err := s.DeleteSessions(userID, nil); !errors.Is(err, ErrInvalidBulkRequest) {
+		t.Errorf("DeleteSessions(nil): err = %v, want ErrInvalidBulkRequest", err)
+	}
+}
+
+func TestShredDataDeletesSessionTags(t *testing.T) {
+	db := newTestDB(t)
+	cipher := newTestCipher(t, db)
+	s := NewChatService(db, ChatDeps{Cipher: cipher})
+	userID := createTestUser(t, db, "Amani")
+	otherID := createTestUser(t, db, "Baraka")
+	tags := []string{"custody hearing"}
+	for _, id := range []string{userID, otherID} {
+		session := newTestChatSession(t, s, id, "hello")
+		if _, err := s.UpdateSession(id, session.ID, models.UpdateSessionRequest{Tags: &tags}); err != nil {
+			t.Fatalf("UpdateSession: %v", err)
+		}
+	}
+
+	if err := NewUserService(db, cipher).ShredData(userID); err != nil {
+		t.Fatalf("ShredData: %v", err)
+	}
+	if got, err := s.GetSessionTags(userID); err != nil || len(got) != 0 {
+		t.Errorf("tags after shredding = %+v, %v; want none", got, err)
+	}
+	if got, err := s.GetSessionTags(otherID); err != nil || len(got) != 1 {
+		t.Errorf("another user's tags = %+v, %v; want them kept", got, err)
+	}
+}
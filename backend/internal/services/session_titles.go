@@ -0,0 +1,297 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+)
+
+const (
+	maxSessionTitleLength  = 60
+	titleGenerationTimeout = 20 * time.Second
+)
+
+// sensitiveTitleTerms must never appear in a title when the user has turned
+// on discreet titles: anyone glancing at the session list should only see
+// something bland. A word matches when its stem (see titleStem) is a term's
+// stem, so "rape" covers "raped", "raping" and "rapist". Terms of four or
+// more letters also match as word prefixes, so "abus" covers "abusive";
+// shorter ones never do, so "hit" does not catch "history".
+var sensitiveTitleTerms = []string{
+	// English
+	"abus", "assault", "rape", "violen", "beat", "hit", "hurt", "attack", "stab",
+	"strangl", "choke", "slap", "harass", "molest", "incest", "defile", "gbv",
+	"sgbv", "fgm", "traffick", "suicid", "self-harm", "selfharm", "kill", "die",
+	"died", "dying", "dead", "death", "overdose", "cut", "police", "lawyer",
+	"court", "divorce", "custody", "shelter", "escape", "pregnan", "abortion",
+	"hiv", "pep", "std", "sti", "sex", "sexual", "husband", "wife", "wives",
+	"boyfriend", "girlfriend", "partner", "crisis", "emergency", "danger", "threat",
+	"trauma", "fida", "covaw", "1195",
+	// Kiswahili
+	"ubakaji", "kubaka", "alinibaka", "dhuluma", "unyanyasaji", "kupigwa",
+	"alinipiga", "vurugu", "kujiua", "kifo", "polisi", "mume", "mke", "mimba",
+	"talaka", "hatari",
+}
+
+var titleStopWords = map[string]bool{
+	"i": true, "me": true, "my": true, "im": true, "i'm": true, "you": true, "your": true,
+	"we": true, "our": true, "he": true, "she": true, "they": true, "them": true, "it": true,
+	"a": true, "an": true, "the": true, "and": true, "or": true, "but": true, "so": true,
+	"to": true, "of": true, "in": true, "on": true, "at": true, "for": true, "with": true,
+	"about": true, "from": true, "is": true, "am": true, "are": true, "was": true,
+	"were": true, "be": true, "been": true, "have": true, "has": true, "had": true,
+	"do": true, "does": true, "did": true, "not": true, "no": true, "yes": true,
+	"this": true, "that": true, "what": true, "how": true, "why": true, "when": true,
+	"can": true, "could": true, "would": true, "should": true, "will": true, "just": true,
+	"really": true, "very": true, "feel": true, "feeling": true, "want": true,
+	"need": true, "help": true, "know": true, "like": true, "get": true, "got": true,
+	"hi": true, "hello": true, "hey": true, "please": true, "thanks": true, "thank": true,
+	"don't": true, "dont": true, "can't": true, "cant": true, "there": true, "here": true,
+	// Kiswahili
+	"na": true, "ya": true, "wa": true, "kwa": true, "ni": true, "si": true, "la": true,
+	"za": true, "katika": true, "mimi": true, "wewe": true, "yeye": true, "sisi": true,
+	"habari": true, "sasa": true, "nina": true, "sina": true, "nataka": true,
+	"naomba": true, "tafadhali": true, "asante": true, "sana": true, "pia": true,
+}
+
+// GenerateTitleAsync gives a session a short descriptive title after its
+// first exchange. It runs in the background so the chat reply is never held
+// up, and it never replaces a title the user chose.
+func (s *ChatService) GenerateTitleAsync(userID, sessionID string) {
+	session, err := s.getChatSession(userID, sessionID)
+	if err != nil || session.TitleSource != "default" {
+		return
+	}
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), titleGenerationTimeout)
+		defer cancel()
+
+		if err := s.generateSessionTitle(ctx, userID, sessionID); err != nil {
+			log.Printf("Warning: failed to generate title for session %s: %v", sessionID, err)
+		}
+	}()
+}
+
+func (s *ChatService) generateSessionTitle(ctx context.Context, userID, sessionID string) error {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT content, sender_type FROM chat_messages
+		WHERE session_id = ?
+		ORDER BY created_at ASC
+		LIMIT 2
+	`, sessionID)
+	if err != nil {
+		return err
+	}
+
+	var userText, aiText string
+	for rows.Next() {
+		var content, senderType string
+		if err := rows.Scan(&content, &senderType); err != nil {
+			rows.Close()
+			return err
+		}
+		content = s.cipher.Reveal(userID, content)
+		if senderType == "user" && userText == "" {
+			userText = content
+		} else if senderType == "ai" && aiText == "" {
+			aiText = content
+		}
+	}
+	rows.Close()
+
+	if userText == "" {
+		return nil
+	}
+
+	discreet := s.discreetTitlesEnabled(userID)
+
+	title, err := s.llmSessionTitle(ctx, userID, sessionID, userText, aiText, discreet)
+	if err != nil || title == "" {
+		title = keywordSessionTitle(userText, discreet)
+	}
+	if title == "" {
+		title = neutralSessionTitle(time.Now())
+	}
+
+	// The title is drawn from the conversation, so it is stored like it.
+	storedTitle, err := s.cipher.Encrypt(userID, title)
+	if err != nil {
+		return err
+	}
+
+	// Only replace the placeholder; a rename that landed meanwhile wins.
+	_, err = s.db.ExecContext(ctx, `
+		UPDATE chat_sessions SET title = ?, title_source = 'auto'
+		WHERE id = ? AND user_id = ? AND COALESCE(title_source, 'default') = 'default'
+	`, storedTitle, sessionID, userID)
+	return err
+}
+
+func (s *ChatService) llmSessionTitle(ctx context.Context, userID, sessionID, userText, aiText string, discreet bool) (string, error) {
+	if s.llm == nil {
+		return "", fmt.Errorf("no LLM provider configured")
+	}
+	if s.quota != nil {
+		if ok, _, err := s.quota.CheckBudget(userID); err != nil || !ok {
+			return "", fmt.Errorf("LLM budget exhausted")
+		}
+	}
+
+	var prompt strings.Builder
+	prompt.WriteString("Write a short, neutral title (at most 5 words) for the conversation below. ")
+	prompt.WriteString("Use the language the user wrote in. Do not include names, phone numbers, quotes or a trailing full stop. ")
+	if discreet {
+		prompt.WriteString("The title will be seen by other people, so it must be completely generic: ")
+		prompt.WriteString("never mention violence, abuse, relationships, self-harm, health, police, legal matters or emotions in detail. ")
+	}
+	prompt.WriteString("Reply with the title only.\n\nUser: ")
+	prompt.WriteString(userText)
+	if aiText != "" {
+		prompt.WriteString("\nAssistant: ")
+		prompt.WriteString(aiText)
+	}
+	prompt.WriteString("\n\nTitle:")
+
+	resp, err := s.llm.Generate(ctx, prompt.String(), GenerateOptions{MaxTokens: 20, Temperature: 0.3})
+	if err != nil {
+		return "", err
+	}
+	s.recordUsage(UsageRecord{UserID: userID, SessionID: sessionID, Purpose: "title"}, resp)
+
+	title := cleanSessionTitle(resp.Text)
+	if discreet && containsSensitiveTerm(title) {
+		return "", nil
+	}
+	return title, nil
+}
+
+// keywordSessionTitle is the offline fallback: the most frequent meaningful
+// words of the user's first message, in order of first appearance.
+func keywordSessionTitle(text string, discreet bool) string {
+	words := tokenizeTitleWords(text)
+
+	counts := map[string]int{}
+	firstSeen := map[string]int{}
+	for i, w := range words {
+		if len([]rune(w)) < 3 || titleStopWords[w] {
+			continue
+		}
+		if discreet && isSensitiveWord(w) {
+			continue
+		}
+		if _, ok := firstSeen[w]; !ok {
+			firstSeen[w] = i
+		}
+		counts[w]++
+	}
+
+	keywords := make([]string, 0, len(counts))
+	for w := range counts {
+		keywords = append(keywords, w)
+	}
+	sort.Slice(keywords, func(i, j int) bool {
+		if counts[keywords[i]] != counts[keywords[j]] {
+			return counts[keywords[i]] > counts[keywords[j]]
+		}
+		return firstSeen[keywords[i]] < firstSeen[keywords[j]]
+	})
+	if len(keywords) > 3 {
+		keywords = keywords[:3]
+	}
+	sort.Slice(keywords, func(i, j int) bool {
+		return firstSeen[keywords[i]] < firstSeen[keywords[j]]
+	})
+
+	for i, w := range keywords {
+		r := []rune(w)
+		r[0] = unicode.ToUpper(r[0])
+		keywords[i] = string(r)
+	}
+	return cleanSessionTitle(strings.Join(keywords, " "))
+}
+
+func neutralSessionTitle(t time.Time) string {
+	return fmt.Sprintf("Conversation - %s", t.Format("Jan 2"))
+}
+
+func cleanSessionTitle(title string) string {
+	title = strings.TrimSpace(strings.SplitN(title, "\n", 2)[0])
+	title = strings.TrimPrefix(title, "Title:")
+	title = strings.Trim(title, " \"'`*#.")
+	title = strings.Join(strings.Fields(title), " ")
+
+	if r := []rune(title); len(r) > maxSessionTitleLength {
+		title = strings.TrimSpace(string(r[:maxSessionTitleLength]))
+	}
+	return title
+}
+
+func tokenizeTitleWords(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '\'' && r != '-'
+	})
+}
+
+// sensitiveTitleStems are the stems of sensitiveTitleTerms.
+var sensitiveTitleStems = func() []string {
+	stems := make([]string, len(sensitiveTitleTerms))
+	for i, term := range sensitiveTitleTerms {
+		stems[i] = titleStem(term)
+	}
+	return stems
+}()
+
+// titleSuffixes are the English inflections titleStem strips, longest
+// first.
+var titleSuffixes = []string{
+	"ations", "ation", "ings", "ists", "ing", "ist", "ers", "ment", "ness",
+	"ies", "ied", "ive", "er", "ed", "es", "ly", "s", "e",
+}
+
+// titleStem reduces a lower-cased word to a rough stem by dropping one
+// inflection and a doubled final consonant: "raping", "rapes" and "rape"
+// all become "rap", "hitting" becomes "hit". It only needs to be good
+// enough to make a word and its inflections agree.
+func titleStem(word string) string {
+	for _, suffix := range titleSuffixes {
+		if len(word)-len(suffix) >= 3 && strings.HasSuffix(word, suffix) {
+			word = strings.TrimSuffix(word, suffix)
+			break
+		}
+	}
+	if n := len(word); n >= 4 && word[n-1] == word[n-2] && !strings.ContainsRune("aeiousl", rune(word[n-1])) {
+		word = word[:n-1]
+	}
+	return word
+}
+
+func isSensitiveWord(word string) bool {
+	stem := titleStem(word)
+	for i, term := range sensitiveTitleTerms {
+		if stem == sensitiveTitleStems[i] || (len(term) >= 4 && strings.HasPrefix(word, term)) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsSensitiveTerm(text string) bool {
+	for _, w := range tokenizeTitleWords(text) {
+		if isSensitiveWord(w) {
+			return true
+		}
+	}
+	return false
+}
+
+// discreetTitlesEnabled reads the "discreetTitles" flag from the user's
+// profile preferences JSON. Any read or parse failure is treated as enabled,
+// since a bland title is always the safe outcome.
+func (s *ChatService) discreetTitlesEnabled(userID string) bool {
+	return s.preferences(userID).DiscreetTitles
+}
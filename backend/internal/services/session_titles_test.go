@@ -0,0 +1,172 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/heal/internal/models"
+)
+
+func TestKeywordSessionTitle(t *testing.T) {
+	tests := []struct {
+		text     string
+		discreet bool
+		want     string
+	}{
+		{"I need advice about school fees and school uniforms", false, "Advice School Fees"},
+		{"My husband beat me again last night", false, "Husband Beat Again"},
+		{"My husband beat me again last night", true, "Again Last Night"},
+		{"hi hello", false, ""},
+	}
+	for _, tt := range tests {
+		if got := keywordSessionTitle(tt.text, tt.discreet); got != tt.want {
+			t.Errorf("keywordSessionTitle(%q, %v) = %q, want %q", tt.text, tt.discreet, got, tt.want)
+		}
+	}
+}
+
+func TestIsSensitiveWord(t *testing.T) {
+	for _, word := range []string{"raped", "raping", "rapist", "hitting", "abusive", "trafficked", "strangled", "wives"} {
+		if !isSensitiveWord(word) {
+			t.Errorf("isSensitiveWord(%q) = false, want true", word)
+		}
+	}
+	for _, word := range []string{"history", "dietary", "hitch", "budget", "school"} {
+		if isSensitiveWord(word) {
+			t.Errorf("isSensitiveWord(%q) = true, want false", word)
+		}
+	}
+}
+
+func TestCleanSessionTitle(t *testing.T) {
+	if got := cleanSessionTitle("Title: \"Planning a   budget.\"\nmore"); got != "Planning a budget" {
+		t.Errorf("cleanSessionTitle = %q", got)
+	}
+	long := cleanSessionTitle("word word word word word word word word word word word word word word word")
+	if len([]rune(long)) > maxSessionTitleLength {
+		t.Errorf("title of %d characters is longer than %d", len([]rune(long)), maxSessionTitleLength)
+	}
+}
+
+func newTestChatSession(t *testing.T, s *ChatService, userID, firstMessage string) *models.ChatSession {
+	t.Helper()
+	session, err := s.GetOrCreateSession(userID, "", "")
+	if err != nil {
+		t.Fatalf("GetOrCreateSession: %v", err)
+	}
+	if _, err := s.SaveMessage(session.ID, userID, firstMessage, "user", "text", nil); err != nil {
+		t.Fatalf("SaveMessage: %v", err)
+	}
+	return session
+}
+
+func sessionTitle(t *testing.T, s *ChatService, userID, sessionID string) (string, string) {
+	t.Helper()
+	session, err := s.getChatSession(userID, sessionID)
+	if err != nil {
+		t.Fatalf("getChatSession: %v", err)
+	}
+	return session.Title, session.TitleSource
+}
+
+func TestGenerateSessionTitle(t *testing.T) {
+	db := newTestDB(t)
+	llm := &stubLLM{reply: "Title: Budgeting for school fees."}
+	s := newTestChatService(t, db, llm)
+	userID := createTestUser(t, db, "Amani")
+	session := newTestChatSession(t, s, userID, "How do I budget for school fees?")
+
+	if err := s.generateSessionTitle(context.Background(), userID, session.ID); err != nil {
+		t.Fatalf("generateSessionTitle: %v", err)
+	}
+	if title, source := sessionTitle(t, s, userID, session.ID); title != "Budgeting for school fees" || source != "auto" {
+		t.Errorf("title = %q (%s), want the LLM title", title, source)
+	}
+}
+
+func TestGenerateSessionTitleDiscreet(t *testing.T) {
+	db := newTestDB(t)
+	llm := &stubLLM{reply: "Escaping an abusive husband"}
+	s := newTestChatService(t, db, llm)
+	userID := createTestUser(t, db, "Amani")
+	if _, err := db.Exec(`INSERT INTO user_profiles (user_id, preferences) VALUES (?, '{"discreetTitles":true}')`, userID); err != nil {
+		t.Fatal(err)
+	}
+	session := newTestChatSession(t, s, userID, "My husband hits me and I want to find a safe place")
+
+	if err := s.generateSessionTitle(context.Background(), userID, session.ID); err != nil {
+		t.Fatalf("generateSessionTitle: %v", err)
+	}
+	title, _ := sessionTitle(t, s, userID, session.ID)
+	if containsSensitiveTerm(title) {
+		t.Errorf("discreet title %q contains a sensitive term", title)
+	}
+	if title == "Escaping an abusive husband" {
+		t.Error("the LLM's sensitive title was used")
+	}
+}
+
+func TestGenerateSessionTitleKeepsUserTitle(t *testing.T) {
+	db := newTestDB(t)
+	s := newTestChatService(t, db, &stubLLM{reply: "Something else"})
+	userID := createTestUser(t, db, "Amani")
+	session := newTestChatSession(t, s, userID, "How do I budget for school fees?")
+
+	title := "My notes"
+	if _, err := s.UpdateSession(userID, session.ID, models.UpdateSessionRequest{Title: &title}); err != nil {
+		t.Fatalf("UpdateSession: %v", err)
+	}
+	if err := s.generateSessionTitle(context.Background(), userID, session.ID); err != nil {
+		t.Fatalf("generateSessionTitle: %v", err)
+	}
+	if got, source := sessionTitle(t, s, userID, session.ID); got != title || source != "user" {
+		t.Errorf("title = %q (%s), want the user's title kept", got, source)
+	}
+}
+
+func TestUpdateSessionValidatesTitle(t *testing.T) {
+	db := newTestDB(t)
+	s := newTestChatService(t, db, nil)
+	userID := createTestUser(t, db, "Amani")
+	session := newTestChatSession(t, s, userID, "hello")
+
+	blank := "   "
+	if _, err := s.UpdateSession(userID, session.ID, models.UpdateSessionRequest{Title: &blank}); !errors.Is(err, ErrInvalidTitle) {
+		t.Errorf("blank title: err = %v, want ErrInvalidTitle", err)
+	}
+	long := strings.Repeat("a", maxSessionTitleLength+1)
+	if _, err := s.UpdateSession(userID, session.ID, models.UpdateSessionRequest{Title: &long}); !errors.Is(err, ErrInvalidTitle) {
+		t.Errorf("long title: err = %v, want ErrInvalidTitle", err)
+	}
+	archived := true
+	updated, err := s.UpdateSession(userID, session.ID, models.UpdateSessionRequest{Archived: &archived})
+	if err != nil || !updated.Archived {
+		t.Errorf("UpdateSession archive = %+v, %v", updated, err)
+	}
+	if _, err := s.UpdateSession(createTestUser(t, db, "Baraka"), session.ID, models.UpdateSessionRequest{Archived: &archived}); !errors.Is(err, ErrSessionNotFound) {
+		t.Errorf("another user updating the session: err = %v, want ErrSessionNotFound", err)
+	}
+}
+
+func TestSessionTitleEncrypted(t *testing.T) {
+	db := newTestDB(t)
+	s := NewChatService(db, ChatDeps{LLM: &stubLLM{reply: "Budgeting for school fees"}, Cipher: newTestCipher(t, db)})
+	userID := createTestUser(t, db, "Amani")
+	session := newTestChatSession(t, s, userID, "How do I budget for school fees?")
+
+	if err := s.generateSessionTitle(context.Background(), userID, session.ID); err != nil {
+		t.Fatalf("generateSessionTitle: %v", err)
+	}
+	if title, _ := sessionTitle(t, s, userID, session.ID); title != "Budgeting for school fees" {
+		t.Errorf("title = %q, want it decrypted", title)
+	}
+	var stored string
+	if err := db.QueryRow("SELECT title FROM chat_sessions WHERE id = ?", session.ID).Scan(&stored); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(stored, "school") {
+		t.Errorf("title stored in plaintext: %q", stored)
+	}
+}
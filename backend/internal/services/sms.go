@@ -0,0 +1,226 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SMS delivery statuses, in the order a message normally moves through them.
+const (
+	SMSQueued    = "queued"
+	SMSSent      = "sent"
+	SMSDelivered = "delivered"
+	SMSFailed    = "failed"
+)
+
+// SMSSender sends text messages through an SMS provider.
+type SMSSender interface {
+	Name() string
+	// Send hands the message to the provider and returns the provider's ID
+	// for it, which delivery reports refer to.
+	Send(ctx context.Context, to, body string) (string, error)
+}
+
+// AfricasTalkingSender sends through the Africa's Talking messaging API.
+type AfricasTalkingSender struct {
+	endpoint string
+	username string
+	apiKey   string
+	from     string // registered sender ID or short code; empty uses the default
+	client   *http.Client
+}
+
+func NewAfricasTalkingSender(endpoint, username, apiKey, from string) *AfricasTalkingSender {
+	return &AfricasTalkingSender{
+		endpoint: strings.TrimRight(endpoint, "/"),
+		username: username,
+		apiKey:   apiKey,
+		from:     from,
+		client:   &http.Client{Timeout: 15 * time.Second},
+	}
+}
+
+func (a *AfricasTalkingSender) Name() string {
+	return "africastalking"
+}
+
+func (a *AfricasTalkingSender) Send(ctx context.Context, to, body string) (string, error) {
+	form := url.Values{"username": {a.username}, "to": {to}, "message": {body}}
+	if a.from != "" {
+		form.Set("from", a.from)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, a.endpoint+"/version1/messaging",
+		strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("apiKey", a.apiKey)
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("africastalking request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	raw, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("africastalking returned %s: %s", resp.Status, strings.TrimSpace(string(raw)))
+	}
+
+	var result struct {
+		SMSMessageData struct {
+			Message    string `json:"Message"`
+			Recipients []struct {
+				StatusCode int    `json:"statusCode"`
+				Status     string `json:"status"`
+				MessageID  string `json:"messageId"`
+			} `json:"Recipients"`
+		} `json:"SMSMessageData"`
+	}
+	if err := json.Unmarshal(raw, &result); err != nil {
+		return "", fmt.Errorf("failed to parse africastalking response: %w", err)
+	}
+	if len(result.SMSMessageData.Recipients) == 0 {
+		return "", fmt.Errorf("africastalking did not accept the message: %s", result.SMSMessageData.Message)
+	}
+	recipient := result.SMSMessageData.Recipients[0]
+	// 100 Processed, 101 Sent, 102 Queued; everything else is a failure
+	if recipient.StatusCode < 100 || recipient.StatusCode > 102 {
+		return "", fmt.Errorf("africastalking rejected the message: %s", recipient.Status)
+	}
+	return recipient.MessageID, nil
+}
+
+// africasTalkingStatus maps an Africa's Talking delivery report status.
+func africasTalkingStatus(status string) string {
+	switch status {
+	case "Success":
+		return SMSDelivered
+	case "Failed", "Rejected", "AbsentSubscriber", "Expired":
+		return SMSFailed
+	default: // Sent, Submitted, Buffered
+		return SMSSent
+	}
+}
+
+// TwilioSender sends through Twilio's Messages API, or any service that
+// speaks the same protocol.
+type TwilioSender struct {
+	endpoint   string
+	accountSID string
+	authToken  string
+	from       string
+	client     *http.Client
+}
+
+func NewTwilioSender(endpoint, accountSID, authToken, from string) *TwilioSender {
+	return &TwilioSender{
+		endpoint:   strings.TrimRight(endpoint, "/"),
+		accountSID: accountSID,
+		authToken:  authToken,
+		from:       from,
+		client:     &http.Client{Timeout: 15 * time.Second},
+	}
+}
+
+func (t *TwilioSender) Name() string {
+	return "twilio"
+}
+
+func (t *TwilioSender) Send(ctx context.Context, to, body string) (string, error) {
+	form := url.Values{"To": {to}, "From": {t.from}, "Body": {body}}
+	endpoint := fmt.Sprintf("%s/2010-04-01/Accounts/%s/Messages.json", t.endpoint, url.PathEscape(t.accountSID))
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(t.accountSID, t.authToken)
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("twilio request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	raw, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	var result struct {
+		SID     string `json:"sid"`
+		Status  string `json:"status"`
+		Message string `json:"message"` // set on errors
+	}
+	json.Unmarshal(raw, &result)
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusOK {
+		if result.Message == "" {
+			result.Message = strings.TrimSpace(string(raw))
+		}
+		return "", fmt.Errorf("twilio returned %s: %s", resp.Status, result.Message)
+	}
+	if result.SID == "" {
+		return "", fmt.Errorf("twilio response has no message SID")
+	}
+	return result.SID, nil
+}
+
+// twilioStatus maps a Twilio status callback MessageStatus.
+func twilioStatus(status string) string {
+	switch status {
+	case "delivered", "read":
+		return SMSDelivered
+	case "failed", "undelivered", "canceled":
+		return SMSFailed
+	default: // accepted, queued, sending, sent
+		return SMSSent
+	}
+}
+
+// FakeSMSSender records messages instead of sending them, for development
+// and tests.
+type FakeSMSSender struct {
+	mu   sync.Mutex
+	Sent []FakeSMS
+	// Fail makes every send fail.
+	Fail bool
+}
+
+type FakeSMS struct {
+	ID   string
+	To   string
+	Body string
+}
+
+func (f *FakeSMSSender) Name() string {
+	return "fake"
+}
+
+func (f *FakeSMSSender) Send(ctx context.Context, to, body string) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.Fail {
+		return "", fmt.Errorf("fake SMS failure")
+	}
+	id := fmt.Sprintf("fake-%d", len(f.Sent)+1)
+	f.Sent = append(f.Sent, FakeSMS{ID: id, To: to, Body: body})
+	log.Printf("Fake SMS %s sent (%d characters)", id, len(body))
+	return id, nil
+}
+
+// Messages returns a copy of what has been sent so far.
+func (f *FakeSMSSender) Messages() []FakeSMS {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]FakeSMS{}, f.Sent...)
+}
@@ -0,0 +1,477 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/heal/internal/models"
+)
+
+// Who a user's emergency contacts are texted for: nobody (the default),
+// their primary contact, or all of them.
+const (
+	SMSAlertsOff     = "off"
+	SMSAlertsPrimary = "primary"
+	SMSAlertsAll     = "all"
+)
+
+const maxSMSAttempts = 4
+
+// smsRetryBase is the wait before the first retry; it doubles each time.
+const smsRetryBase = 30 * time.Second
+
+// DefaultSMSAlertTemplate is the text sent to emergency contacts about an
+// alert, whichever feature raised it. It is deliberately vague: whoever reads the contact's
+// phone should not learn what the survivor is going through.
+const DefaultSMSAlertTemplate = `Hi {{.ContactName}}, {{.FirstName}} asked us to let you know they would like you to check in with them as soon as you can. If you think they are in danger, call 999.`
+
+// DefaultSMSVerifyTemplate asks a new emergency contact to agree to be
+// one. {{.Code}} is the code their reply must quote.
+const DefaultSMSVerifyTemplate = `Hi {{.ContactName}}, {{.FirstName}} would like you to be their emergency contact. We would only text you if they may need help. Reply YES {{.Code}} to agree or NO {{.Code}} to decline.`
+
+var (
+	errSMSNotOptedIn = errors.New("user has not opted in to SMS alerts")
+	errNoSMSProvider = errors.New("no SMS provider configured")
+	// errContactsTexted means the contacts were texted when the alert was
+	// raised, so a later step has nothing to add.
+	errContactsTexted = errors.New("contacts were texted when the alert was raised")
+)
+
+// SMSOptions configure an SMSDispatcher.
+type SMSOptions struct {
+	// MinSeverity is the lowest alert severity that texts contacts.
+	MinSeverity string
+	// Template is a text/template for the alert SMS; DefaultSMSAlertTemplate
+	// is used when empty.
+	Template string
+	// VerifyTemplate is a text/template for the request sent to verify a
+	// contact; DefaultSMSVerifyTemplate is used when empty.
+	VerifyTemplate string
+	Clock          Clock
+}
+
+// SMSDispatcher texts a survivor's emergency contacts when they raise a
+// crisis alert, if they have opted in. Messages are queued in sms_messages
+// and sent by a background worker that retries failures with backoff;
+// provider delivery reports update their status.
+//
+// A dispatcher without a sender still stores settings but sends nothing.
+type SMSDispatcher struct {
+	db          *sql.DB
+	sender      SMSSender
+	minSeverity string
+	template    *template.Template
+	verify      *template.Template
+	clock       Clock
+	wake        chan struct{}
+}
+
+func NewSMSDispatcher(db *sql.DB, sender SMSSender, opts SMSOptions) (*SMSDispatcher, error) {
+	if opts.MinSeverity == "" {
+		opts.MinSeverity = RiskHigh
+	}
+	if _, ok := riskRank[opts.MinSeverity]; !ok || opts.MinSeverity == RiskNone {
+		return nil, fmt.Errorf("invalid SMS alert severity %q", opts.MinSeverity)
+	}
+	if opts.Template == "" {
+		opts.Template = DefaultSMSAlertTemplate
+	}
+	tmpl, err := template.New("sms").Option("missingkey=error").Parse(opts.Template)
+	if err != nil {
+		return nil, fmt.Errorf("invalid SMS alert template: %w", err)
+	}
+	if opts.VerifyTemplate == "" {
+		opts.VerifyTemplate = DefaultSMSVerifyTemplate
+	}
+	verify, err := template.New("verify").Option("missingkey=error").Parse(opts.VerifyTemplate)
+	if err != nil {
+		return nil, fmt.Errorf("invalid SMS verification template: %w", err)
+	}
+	if opts.Clock == nil {
+		opts.Clock = SystemClock{}
+	}
+	return &SMSDispatcher{
+		db:          db,
+		sender:      sender,
+		minSeverity: opts.MinSeverity,
+		template:    tmpl,
+		verify:      verify,
+		clock:       opts.Clock,
+		wake:        make(chan struct{}, 1),
+	}, nil
+}
+
+// GetSettings returns who the user's alerts text.
+func (d *SMSDispatcher) GetSettings(userID string) (*models.SMSAlertSettings, error) {
+	settings := &models.SMSAlertSettings{UserID: userID, Recipients: SMSAlertsOff, MinSeverity: d.minSeverity}
+	err := d.db.QueryRow(`
+		SELECT COALESCE(recipients, 'off'), updated_at FROM sms_alert_settings WHERE user_id = ?
+	`, userID).Scan(&settings.Recipients, &settings.UpdatedAt)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, fmt.Errorf("failed to load SMS alert settings: %w", err)
+	}
+	return settings, nil
+}
+
+// UpdateSettings opts the user in to (or out of) texting their contacts.
+func (d *SMSDispatcher) UpdateSettings(userID, recipients string) (*models.SMSAlertSettings, error) {
+	if recipients != SMSAlertsOff && recipients != SMSAlertsPrimary && recipients != SMSAlertsAll {
+		return nil, ErrInvalidSMSRecipients
+	}
+	now := time.Now()
+	_, err := d.db.Exec(`
+		INSERT INTO sms_alert_settings (user_id, recipients, updated_at) VALUES (?, ?, ?)
+		ON CONFLICT(user_id) DO UPDATE SET recipients = excluded.recipients, updated_at = excluded.updated_at
+	`, userID, recipients, now)
+	if err != nil {
+		return nil, fmt.Errorf("failed to save SMS alert settings: %w", err)
+	}
+	return &models.SMSAlertSettings{UserID: userID, Recipients: recipients, MinSeverity: d.minSeverity, UpdatedAt: now}, nil
+}
+
+// AlertRaised queues the alert SMS for the user's contacts, if the alert is
+// severe enough and the user has opted in. It returns the number queued.
+func (d *SMSDispatcher) AlertRaised(alert *models.CrisisAlert) (int, error) {
+	if d == nil || d.sender == nil || !RiskAtLeast(alert.Severity, d.minSeverity) {
+		return 0, nil
+	}
+	settings, err := d.GetSettings(alert.UserID)
+	if err != nil {
+		return 0, err
+	}
+	if settings.Recipients == SMSAlertsOff {
+		return 0, nil
+	}
+
+	contacts, err := d.contacts(alert.UserID, settings.Recipients == SMSAlertsPrimary)
+	if err != nil {
+		return 0, err
+	}
+	firstName := d.firstName(alert.UserID)
+	for _, contact := range contacts {
+		body, err := d.render(d.template, contact, firstName, "")
+		if err != nil {
+			return 0, err
+		}
+		if err := d.queue(alert.UserID, alert.ID, contact.ID, contact.Phone, body); err != nil {
+			return 0, err
+		}
+	}
+	d.kick()
+	return len(contacts), nil
+}
+
+// NotifyContact texts one contact about an alert, if the user has opted in
+// to SMS alerts at all. The escalation worker uses it. If raising the alert
+// already texted the user's contacts, nothing more is sent.
+func (d *SMSDispatcher) NotifyContact(ctx context.Context, alert *models.CrisisAlert, contact models.EmergencyContact) error {
+	if d.sender == nil {
+		return errNoSMSProvider
+	}
+	settings, err := d.GetSettings(alert.UserID)
+	if err != nil {
+		return err
+	}
+	if settings.Recipients == SMSAlertsOff {
+		return errSMSNotOptedIn
+	}
+	if d.textsContacts(alert) {
+		return errContactsTexted
+	}
+	return d.SendToContact(ctx, alert, contact)
+}
+
+// SendToContact texts one contact about an alert whatever the user's SMS
+// alert settings. It is for features the user turned on knowing a contact
+// would be texted, such as safety check-ins. The text is the alert
+// template, the same as AlertRaised sends.
+func (d *SMSDispatcher) SendToContact(ctx context.Context, alert *models.CrisisAlert, contact models.EmergencyContact) error {
+	if d == nil || d.sender == nil {
+		return errNoSMSProvider
+	}
+	body, err := d.render(d.template, contact, d.firstName(alert.UserID), "")
+	if err != nil {
+		return err
+	}
+	if err := d.queue(alert.UserID, alert.ID, contact.ID, contact.Phone, body); err != nil {
+		return err
+	}
+	d.kick()
+	return nil
+}
+
+// render fills in a template for one contact. code is only set for
+// verification requests.
+func (d *SMSDispatcher) render(tmpl *template.Template, contact models.EmergencyContact, firstName, code string) (string, error) {
+	data := map[string]string{
+		"ContactName": contact.Name,
+		"FirstName":   firstName,
+	}
+	if code != "" {
+		data["Code"] = code
+	}
+	var body bytes.Buffer
+	if err := tmpl.Execute(&body, data); err != nil {
+		return "", fmt.Errorf("failed to render %s SMS: %w", tmpl.Name(), err)
+	}
+	return body.String(), nil
+}
+
+// requestVerification texts a contact asking them to agree to be the
+// user's emergency contact, quoting code.
+func (d *SMSDispatcher) requestVerification(ctx context.Context, contact models.EmergencyContact, code string) error {
+	if d == nil || d.sender == nil {
+		return errNoSMSProvider
+	}
+	body, err := d.render(d.verify, contact, d.firstName(contact.UserID), code)
+	if err != nil {
+		return err
+	}
+	if err := d.queue(contact.UserID, "", contact.ID, contact.Phone, body); err != nil {
+		return err
+	}
+	d.kick()
+	return nil
+}
+
+// SendToUser queues a message to the user themselves.
+func (d *SMSDispatcher) SendToUser(ctx context.Context, userID, phone, message string) error {
+	if d == nil || d.sender == nil {
+		return errNoSMSProvider
+	}
+	if err := d.queue(userID, "", "", phone, message); err != nil {
+		return err
+	}
+	d.kick()
+	return nil
+}
+
+// textsContacts reports whether raising alert already texts the user's
+// contacts, so callers do not text them twice.
+func (d *SMSDispatcher) textsContacts(alert *models.CrisisAlert) bool {
+	if d == nil || d.sender == nil || !RiskAtLeast(alert.Severity, d.minSeverity) {
+		return false
+	}
+	settings, err := d.GetSettings(alert.UserID)
+	return err == nil && settings.Recipients != SMSAlertsOff
+}
+
+func (d *SMSDispatcher) queue(userID, alertID, contactID, to, body string) error {
+	now := d.clock.Now()
+	_, err := d.db.Exec(`
+		INSERT INTO sms_messages (id, user_id, alert_id, contact_id, to_number, body, provider, status,
+		                          attempts, next_attempt_at, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, 0, ?, ?, ?)
+	`, uuid.New().String(), userID, nullString(alertID), nullString(contactID), to, body, d.sender.Name(),
+		SMSQueued, now, now, now)
+	if err != nil {
+		return fmt.Errorf("failed to queue SMS: %w", err)
+	}
+	return nil
+}
+
+// kick wakes the worker so a new message goes out without waiting for the
+// next tick.
+func (d *SMSDispatcher) kick() {
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run sends queued messages every interval, and whenever one is queued,
+// until ctx is cancelled.
+func (d *SMSDispatcher) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := d.Flush(ctx); err != nil {
+			log.Printf("Warning: SMS dispatch failed: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-d.wake:
+		}
+	}
+}
+
+// Flush sends every queued message whose next attempt is due, and returns
+// how many were sent.
+func (d *SMSDispatcher) Flush(ctx context.Context) (int, error) {
+	if d.sender == nil {
+		return 0, nil
+	}
+	type pending struct {
+		id, to, body string
+		attempts     int
+		nextAttempt  time.Time
+	}
+
+	rows, err := d.db.QueryContext(ctx, `
+		SELECT id, to_number, body, attempts, next_attempt_at
+		FROM sms_messages WHERE status = ?
+		ORDER BY created_at
+	`, SMSQueued)
+	if err != nil {
+		return 0, err
+	}
+	var batch []pending
+	for rows.Next() {
+		var p pending
+		if err := rows.Scan(&p.id, &p.to, &p.body, &p.attempts, &p.nextAttempt); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		batch = append(batch, p)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	now := d.clock.Now()
+	sent := 0
+	for _, p := range batch {
+		if p.nextAttempt.After(now) {
+			continue
+		}
+		providerID, sendErr := d.sender.Send(ctx, p.to, p.body)
+		attempts := p.attempts + 1
+		if sendErr == nil {
+			_, err = d.db.ExecContext(ctx, `
+				UPDATE sms_messages SET status = ?, provider_message_id = ?, attempts = ?, error = NULL,
+				       sent_at = ?, updated_at = ?
+				WHERE id = ?
+			`, SMSSent, providerID, attempts, now, now, p.id)
+			sent++
+		} else if attempts >= maxSMSAttempts {
+			log.Printf("Warning: giving up on SMS %s after %d attempts: %v", p.id, attempts, sendErr)
+			_, err = d.db.ExecContext(ctx, `
+				UPDATE sms_messages SET status = ?, attempts = ?, error = ?, updated_at = ? WHERE id = ?
+			`, SMSFailed, attempts, sendErr.Error(), now, p.id)
+		} else {
+			retryAt := now.Add(smsRetryBase << (attempts - 1))
+			_, err = d.db.ExecContext(ctx, `
+				UPDATE sms_messages SET attempts = ?, error = ?, next_attempt_at = ?, updated_at = ? WHERE id = ?
+			`, attempts, sendErr.Error(), retryAt, now, p.id)
+		}
+		if err != nil {
+			return sent, fmt.Errorf("failed to update SMS status: %w", err)
+		}
+	}
+	return sent, nil
+}
+
+// UpdateDeliveryStatus applies a provider's delivery report. Reports for
+// unknown messages are ignored, as are reports that would move a message
+// backwards (a late "sent" after "delivered").
+func (d *SMSDispatcher) UpdateDeliveryStatus(provider, providerMessageID, providerStatus, reason string) error {
+	var status string
+	switch provider {
+	case "africastalking":
+		status = africasTalkingStatus(providerStatus)
+	case "twilio":
+		status = twilioStatus(providerStatus)
+	default:
+		return ErrUnknownSMSProvider
+	}
+
+	now := time.Now()
+	var err error
+	switch status {
+	case SMSDelivered:
+		_, err = d.db.Exec(`
+			UPDATE sms_messages SET status = ?, delivered_at = ?, updated_at = ?
+			WHERE provider = ? AND provider_message_id = ? AND status IN ('sent', 'queued')
+		`, status, now, now, provider, providerMessageID)
+	case SMSFailed:
+		_, err = d.db.Exec(`
+			UPDATE sms_messages SET status = ?, error = ?, updated_at = ?
+			WHERE provider = ? AND provider_message_id = ? AND status IN ('sent', 'queued')
+		`, status, nullString(reason), now, provider, providerMessageID)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to update SMS status: %w", err)
+	}
+	return nil
+}
+
+// GetAlertMessages lists the messages sent about an alert.
+func (d *SMSDispatcher) GetAlertMessages(alertID string) ([]models.SMSMessage, error) {
+	rows, err := d.db.Query(`
+		SELECT m.id, m.alert_id, m.contact_id, COALESCE(c.name, ''), m.to_number, m.body, m.provider,
+		       m.status, m.attempts, COALESCE(m.error, ''), m.created_at, m.sent_at, m.delivered_at
+		FROM sms_messages m
+		LEFT JOIN emergency_contacts c ON c.id = m.contact_id
+		WHERE m.alert_id = ?
+		ORDER BY m.created_at, m.rowid
+	`, alertID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	messages := []models.SMSMessage{}
+	for rows.Next() {
+		var m models.SMSMessage
+		var sentAt, deliveredAt sql.NullTime
+		err := rows.Scan(&m.ID, &m.AlertID, &m.ContactID, &m.ContactName, &m.To, &m.Body, &m.Provider,
+			&m.Status, &m.Attempts, &m.Error, &m.CreatedAt, &sentAt, &deliveredAt)
+		if err != nil {
+			return nil, err
+		}
+		if sentAt.Valid {
+			m.SentAt = &sentAt.Time
+		}
+		if deliveredAt.Valid {
+			m.DeliveredAt = &deliveredAt.Time
+		}
+		messages = append(messages, m)
+	}
+	return messages, rows.Err()
+}
+
+func (d *SMSDispatcher) contacts(userID string, primaryOnly bool) ([]models.EmergencyContact, error) {
+	query := `
+		SELECT id, name, phone FROM emergency_contacts
+		WHERE user_id = ? AND COALESCE(verification_status, '') != 'declined'
+		ORDER BY is_primary DESC, created_at ASC`
+	if primaryOnly {
+		query += " LIMIT 1"
+	}
+	rows, err := d.db.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var contacts []models.EmergencyContact
+	for rows.Next() {
+		contact := models.EmergencyContact{UserID: userID}
+		if err := rows.Scan(&contact.ID, &contact.Name, &contact.Phone); err != nil {
+			return nil, err
+		}
+		contacts = append(contacts, contact)
+	}
+	return contacts, rows.Err()
+}
+
+func (d *SMSDispatcher) firstName(userID string) string {
+	var name string
+	d.db.QueryRow("SELECT first_name FROM users WHERE id = ?", userID).Scan(&name)
+	if name = strings.TrimSpace(name); name == "" {
+		return "Your friend"
+	}
+	return name
+}
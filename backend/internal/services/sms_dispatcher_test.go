@@ -0,0 +1,195 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/heal/internal/models"
+)
+
+type queuedSMS struct {
+	status      string
+	attempts    int
+	nextAttempt time.Time
+}
+
+func smsState(t *testing.T, d *SMSDispatcher, userID string) queuedSMS {
+	t.Helper()
+	var m queuedSMS
+	err := d.db.QueryRow(`
+		SELECT status, attempts, next_attempt_at FROM sms_messages WHERE user_id = ?
+	`, userID).Scan(&m.status, &m.attempts, &m.nextAttempt)
+	if err != nil {
+		t.Fatalf("failed to read SMS: %v", err)
+	}
+	return m
+}
+
+func newTestDispatcher(t *testing.T) (*SMSDispatcher, *FakeSMSSender, *fakeClock) {
+	t.Helper()
+	sender := &FakeSMSSender{}
+	clock := newFakeClock(time.Date(2026, 3, 1, 9, 0, 0, 0, time.UTC))
+	d, err := NewSMSDispatcher(newTestDB(t), sender, SMSOptions{Clock: clock})
+	if err != nil {
+		t.Fatalf("NewSMSDispatcher: %v", err)
+	}
+	return d, sender, clock
+}
+
+// createContact gives userID a primary emergency contact named Wanjiku.
+func createContact(t *testing.T, d *SMSDispatcher, userID string) *models.EmergencyContact {
+	t.Helper()
+	contact, err := NewCrisisService(d.db, nil, d, nil).AddEmergencyContact(userID, "Wanjiku", "+254712345678", "sister", true)
+	if err != nil {
+		t.Fatalf("AddEmergencyContact: %v", err)
+	}
+	return contact
+}
+
+// queueAlertSMS opts a new user in and raises an alert, queueing one SMS.
+func queueAlertSMS(t *testing.T, d *SMSDispatcher) string {
+	t.Helper()
+	userID := createTestUser(t, d.db, "Amani")
+	createContact(t, d, userID)
+	if _, err := d.UpdateSettings(userID, SMSAlertsPrimary); err != nil {
+		t.Fatalf("UpdateSettings: %v", err)
+	}
+	if n, err := d.AlertRaised(&models.CrisisAlert{ID: "alert-1", UserID: userID, Severity: RiskHigh}); err != nil || n != 1 {
+		t.Fatalf("AlertRaised = %d, %v; want 1 queued", n, err)
+	}
+	return userID
+}
+
+func TestSMSFlushRetriesWithBackoff(t *testing.T) {
+	d, sender, clock := newTestDispatcher(t)
+	ctx := context.Background()
+	sender.Fail = true
+	userID := queueAlertSMS(t, d)
+
+	wait := smsRetryBase
+	for attempt := 1; attempt < maxSMSAttempts; attempt++ {
+		if _, err := d.Flush(ctx); err != nil {
+			t.Fatalf("Flush: %v", err)
+		}
+		m := smsState(t, d, userID)
+		if m.status != SMSQueued || m.attempts != attempt {
+			t.Fatalf("after attempt %d: %+v", attempt, m)
+		}
+		if want := clock.Now().Add(wait); !m.nextAttempt.Equal(want) {
+			t.Fatalf("after attempt %d: next attempt at %v, want %v", attempt, m.nextAttempt, want)
+		}
+
+		// Not due yet
+		clock.Advance(wait - time.Second)
+		if _, err := d.Flush(ctx); err != nil {
+			t.Fatalf("Flush: %v", err)
+		}
+		if m := smsState(t, d, userID); m.attempts != attempt {
+			t.Fatalf("retried %s early", wait-time.Second)
+		}
+		clock.Advance(time.Second)
+		wait *= 2
+	}
+
+	if _, err := d.Flush(ctx); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+	if m := smsState(t, d, userID); m.status != SMSFailed || m.attempts != maxSMSAttempts {
+		t.Fatalf("after the last attempt: %+v, want failed", m)
+	}
+}
+
+func TestSMSFlushSendsAfterFailure(t *testing.T) {
+	d, sender, clock := newTestDispatcher(t)
+	ctx := context.Background()
+	sender.Fail = true
+	userID := queueAlertSMS(t, d)
+
+	if _, err := d.Flush(ctx); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+
+	sender.Fail = false
+	clock.Advance(smsRetryBase)
+	sent, err := d.Flush(ctx)
+	if err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+	if sent != 1 || len(sender.Messages()) != 1 {
+		t.Fatalf("sent %d messages, want 1", sent)
+	}
+	if m := smsState(t, d, userID); m.status != SMSSent || m.attempts != 2 {
+		t.Errorf("after retrying: %+v, want sent on attempt 2", m)
+	}
+}
+
+func TestSMSAlertRaisedRequiresOptIn(t *testing.T) {
+	d, sender, _ := newTestDispatcher(t)
+	ctx := context.Background()
+	userID := createTestUser(t, d.db, "Amani")
+	contact := createContact(t, d, userID)
+	alert := &models.CrisisAlert{ID: "alert-1", UserID: userID, Severity: RiskHigh}
+
+	if n, err := d.AlertRaised(alert); err != nil || n != 0 {
+		t.Fatalf("AlertRaised before opting in = %d, %v; want nothing queued", n, err)
+	}
+	if err := d.NotifyContact(ctx, alert, *contact); !errors.Is(err, errSMSNotOptedIn) {
+		t.Errorf("NotifyContact before opting in: err = %v, want errSMSNotOptedIn", err)
+	}
+	if _, err := d.UpdateSettings(userID, "everyone"); !errors.Is(err, ErrInvalidSMSRecipients) {
+		t.Errorf("UpdateSettings(everyone): err = %v, want ErrInvalidSMSRecipients", err)
+	}
+	if _, err := d.UpdateSettings(userID, SMSAlertsPrimary); err != nil {
+		t.Fatalf("UpdateSettings: %v", err)
+	}
+	if n, err := d.AlertRaised(&models.CrisisAlert{ID: "alert-2", UserID: userID, Severity: RiskLow}); err != nil || n != 0 {
+		t.Fatalf("AlertRaised for a low alert = %d, %v; want nothing queued", n, err)
+	}
+	if n, err := d.AlertRaised(alert); err != nil || n != 1 {
+		t.Fatalf("AlertRaised = %d, %v; want 1 queued", n, err)
+	}
+
+	if _, err := d.Flush(ctx); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+	messages := sender.Messages()
+	if len(messages) != 1 {
+		t.Fatalf("sent %d messages, want 1", len(messages))
+	}
+	if body := messages[0].Body; !strings.HasPrefix(body, "Hi Wanjiku, Amani asked us") {
+		t.Errorf("body = %q, want the alert template", body)
+	}
+	if err := d.NotifyContact(ctx, alert, *contact); !errors.Is(err, errContactsTexted) {
+		t.Errorf("NotifyContact after AlertRaised: err = %v, want errContactsTexted", err)
+	}
+}
+
+func TestSMSVerifyTemplate(t *testing.T) {
+	db := newTestDB(t)
+	sender := &FakeSMSSender{}
+	if _, err := NewSMSDispatcher(db, sender, SMSOptions{VerifyTemplate: "Reply {{.Code"}); err == nil {
+		t.Error("NewSMSDispatcher accepted a broken verification template")
+	}
+	d, err := NewSMSDispatcher(db, sender, SMSOptions{VerifyTemplate: "Jambo {{.ContactName}}, jibu NDIO {{.Code}}"})
+	if err != nil {
+		t.Fatalf("NewSMSDispatcher: %v", err)
+	}
+	userID := createTestUser(t, db, "Amani")
+	contact := createContact(t, d, userID)
+	ctx := context.Background()
+
+	if _, err := NewCrisisService(db, nil, d, nil).RequestContactVerification(ctx, userID, contact.ID); err != nil {
+		t.Fatalf("RequestContactVerification: %v", err)
+	}
+	if _, err := d.Flush(ctx); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+	messages := sender.Messages()
+	if len(messages) != 1 || !regexp.MustCompile(`^Jambo Wanjiku, jibu NDIO \d{4}$`).MatchString(messages[0].Body) {
+		t.Errorf("sent %+v, want the verification template", messages)
+	}
+}
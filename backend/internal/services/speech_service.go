@@ -0,0 +1,201 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/heal/internal/encryption"
+	"github.com/heal/internal/storage"
+)
+
+// Synthesizer turns text into speech.
+type Synthesizer interface {
+	Name() string
+	Synthesize(ctx context.Context, text, language string) (*SynthesizedAudio, error)
+}
+
+type SynthesizedAudio struct {
+	Data        []byte
+	ContentType string
+}
+
+// PiperSynthesizer calls a piper HTTP server (python -m piper.http_server).
+// Voices are chosen per language; servers that host a single voice ignore
+// the choice.
+type PiperSynthesizer struct {
+	endpoint string
+	voices   map[string]string // language -> piper voice name
+	client   *http.Client
+}
+
+func NewPiperSynthesizer(endpoint string, voices map[string]string) *PiperSynthesizer {
+	return &PiperSynthesizer{
+		endpoint: strings.TrimRight(endpoint, "/"),
+		voices:   voices,
+		client:   &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+func (p *PiperSynthesizer) Name() string {
+	return "piper"
+}
+
+func (p *PiperSynthesizer) Synthesize(ctx context.Context, text, language string) (*SynthesizedAudio, error) {
+	payload := map[string]string{"text": text}
+	if voice := p.voices[language]; voice != "" {
+		payload["voice"] = voice
+	}
+	body, _ := json.Marshal(payload)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("piper request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, fmt.Errorf("piper returned %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	return &SynthesizedAudio{Data: data, ContentType: "audio/wav"}, nil
+}
+
+// FakeSynthesizer returns a short silent WAV. It stands in for a real TTS
+// engine in development and tests.
+type FakeSynthesizer struct {
+	mu    sync.Mutex
+	calls int
+}
+
+func (f *FakeSynthesizer) Name() string {
+	return "fake"
+}
+
+func (f *FakeSynthesizer) Synthesize(ctx context.Context, text, language string) (*SynthesizedAudio, error) {
+	f.mu.Lock()
+	f.calls++
+	f.mu.Unlock()
+	return &SynthesizedAudio{Data: silentWAV(len(text) * 100), ContentType: "audio/wav"}, nil
+}
+
+// Calls returns how many times Synthesize has been called.
+func (f *FakeSynthesizer) Calls() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.calls
+}
+
+// silentWAV builds a 16 kHz mono 16-bit PCM WAV of the given sample count.
+func silentWAV(samples int) []byte {
+	var buf bytes.Buffer
+	dataSize := uint32(samples * 2)
+	buf.WriteString("RIFF")
+	binary.Write(&buf, binary.LittleEndian, 36+dataSize)
+	buf.WriteString("WAVEfmt ")
+	for _, field := range []interface{}{
+		uint32(16), uint16(1), uint16(1), uint32(16000), uint32(32000), uint16(2), uint16(16),
+	} {
+		binary.Write(&buf, binary.LittleEndian, field)
+	}
+	buf.WriteString("data")
+	binary.Write(&buf, binary.LittleEndian, dataSize)
+	buf.Write(make([]byte, dataSize))
+	return buf.Bytes()
+}
+
+// SpeechService reads Nia's replies aloud. Generated audio is cached in
+// blob storage by a hash of language and text, so identical replies (the
+// crisis fallback, for one) are only synthesized once.
+//
+// Cached audio is shared between users, so it cannot use a per-user data
+// key. Instead it is encrypted with a key derived from the text itself:
+// only someone who can read the reply can play it, and crypto-shredding
+// the reply also makes its audio unrecoverable.
+type SpeechService struct {
+	store       storage.BlobStore
+	synthesizer Synthesizer
+}
+
+func NewSpeechService(store storage.BlobStore, synthesizer Synthesizer) *SpeechService {
+	return &SpeechService{store: store, synthesizer: synthesizer}
+}
+
+// SpokenAudio identifies a synthesized reply in blob storage.
+type SpokenAudio struct {
+	Key         string
+	ContentType string
+	Cached      bool
+}
+
+// Speak returns the cached audio for text, synthesizing it on a miss.
+func (s *SpeechService) Speak(ctx context.Context, text, language string) (*SpokenAudio, error) {
+	key := speechCacheKey(text, language)
+
+	if _, err := s.store.Get(ctx, key); err == nil {
+		return &SpokenAudio{Key: key, ContentType: "audio/wav", Cached: true}, nil
+	} else if err != storage.ErrNotFound {
+		return nil, fmt.Errorf("failed to read speech cache: %w", err)
+	}
+
+	audio, err := s.synthesizer.Synthesize(ctx, text, language)
+	if err != nil {
+		return nil, err
+	}
+	sealed, err := encryption.Seal(speechKey(text, language), audio.Data, []byte(key))
+	if err != nil {
+		return nil, err
+	}
+	if err := s.store.Put(ctx, key, "application/octet-stream", sealed); err != nil {
+		return nil, fmt.Errorf("failed to store speech: %w", err)
+	}
+	return &SpokenAudio{Key: key, ContentType: audio.ContentType}, nil
+}
+
+// Load returns the audio stored under key for text. It fails with
+// ErrKeyShredded if text is not the text that was spoken.
+func (s *SpeechService) Load(ctx context.Context, key, text, language string) ([]byte, error) {
+	sealed, err := s.store.Get(ctx, key)
+	if err == storage.ErrNotFound {
+		return nil, ErrNoAudio
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load speech: %w", err)
+	}
+	audio, err := encryption.Open(speechKey(text, language), sealed, []byte(key))
+	if err != nil {
+		return nil, ErrKeyShredded
+	}
+	return audio, nil
+}
+
+// speechCacheKey and speechKey are derived from the same input with
+// different labels, so the blob name reveals nothing about the key.
+func speechCacheKey(text, language string) string {
+	sum := sha256.Sum256([]byte("heal-tts-name\x00" + language + "\x00" + text))
+	return "tts/" + language + "/" + hex.EncodeToString(sum[:])
+}
+
+func speechKey(text, language string) []byte {
+	sum := sha256.Sum256([]byte("heal-tts-key\x00" + language + "\x00" + text))
+	return sum[:]
+}
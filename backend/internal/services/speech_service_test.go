@@ -0,0 +1,110 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/heal/internal/storage"
+)
+
+func TestSpeakCachesAudio(t *testing.T) {
+	ctx := context.Background()
+	store, err := storage.NewLocalStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewLocalStore: %v", err)
+	}
+	synth := &FakeSynthesizer{}
+	s := NewSpeechService(store, synth)
+
+	first, err := s.Speak(ctx, "Pole sana", "sw")
+	if err != nil || first.Cached {
+		t.Fatalf("first Speak = %+v, %v; want a fresh synthesis", first, err)
+	}
+	second, err := s.Speak(ctx, "Pole sana", "sw")
+	if err != nil || !second.Cached || second.Key != first.Key {
+		t.Fatalf("second Speak = %+v, %v; want the cached audio", second, err)
+	}
+	if synth.Calls() != 1 {
+		t.Errorf("synthesized %d times, want once", synth.Calls())
+	}
+
+	audio, err := s.Load(ctx, first.Key, "Pole sana", "sw")
+	if err != nil || !bytes.HasPrefix(audio, []byte("RIFF")) {
+		t.Errorf("Load = %d bytes, %v; want the WAV", len(audio), err)
+	}
+	if _, err := s.Load(ctx, first.Key, "other text", "sw"); !errors.Is(err, ErrKeyShredded) {
+		t.Errorf("Load with the wrong text: err = %v, want ErrKeyShredded", err)
+	}
+}
+
+func TestVoiceNoteReplyIsSpoken(t *testing.T) {
+	db := newTestDB(t)
+	ctx := context.Background()
+	store, err := storage.NewLocalStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewLocalStore: %v", err)
+	}
+	speech := NewSpeechService(store, &FakeSynthesizer{})
+	hub := NewEventHub()
+	chat := NewChatService(db, ChatDeps{LLM: &stubLLM{reply: "Niko hapa nawe"}, Hub: hub, Speech: speech})
+	voice := NewVoiceService(chat, store, &FakeTranscriber{Text: "Nahitaji msaada"}, nil, speech)
+	userID := createTestUser(t, db, "Amani")
+	session, err := chat.GetOrCreateSession(userID, "", "")
+	if err != nil {
+		t.Fatalf("GetOrCreateSession: %v", err)
+	}
+	events, unsubscribe := hub.Subscribe(SessionChannel(session.ID))
+	defer unsubscribe()
+
+	typed, err := chat.SaveMessage(session.ID, userID, "hello", "user", "text", nil)
+	if err != nil {
+		t.Fatalf("SaveMessage: %v", err)
+	}
+	reply, err := chat.Reply(ctx, userID, typed)
+	if err != nil {
+		t.Fatalf("Reply: %v", err)
+	}
+	if _, _, err := voice.GetAudio(ctx, userID, reply.ID); !errors.Is(err, ErrNoAudio) {
+		t.Errorf("reply to a typed message: err = %v, want ErrNoAudio", err)
+	}
+
+	transcript, err := voice.Transcribe(ctx, []byte("wav"), "audio/wav")
+	if err != nil {
+		t.Fatalf("Transcribe: %v", err)
+	}
+	spoken, err := voice.SaveVoiceMessage(ctx, userID, session.ID, []byte("wav"), "audio/wav", transcript)
+	if err != nil {
+		t.Fatalf("SaveVoiceMessage: %v", err)
+	}
+	reply, err = chat.Reply(ctx, userID, spoken)
+	if err != nil {
+		t.Fatalf("Reply: %v", err)
+	}
+	if !metadataBool(reply.Metadata, "ttsPending") {
+		t.Errorf("reply metadata = %s, want ttsPending until the audio is ready", reply.Metadata)
+	}
+	waitForEvent(t, events, "message_audio")
+	audio, contentType, err := voice.GetAudio(ctx, userID, reply.ID)
+	if err != nil || contentType != "audio/wav" || len(audio) == 0 {
+		t.Errorf("GetAudio of a reply to a voice note = %d bytes, %q, %v", len(audio), contentType, err)
+	}
+}
+
+// waitForEvent returns the first event of the given type, skipping others.
+func waitForEvent(t *testing.T, events <-chan SessionEvent, eventType string) SessionEvent {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case event := <-events:
+			if event.Type == eventType {
+				return event
+			}
+		case <-timeout:
+			t.Fatalf("no %q event within 5s", eventType)
+		}
+	}
+}
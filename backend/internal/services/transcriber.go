@@ -0,0 +1,110 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Transcriber turns a voice note into text.
+type Transcriber interface {
+	Name() string
+	Transcribe(ctx context.Context, audio []byte, contentType string) (*Transcript, error)
+}
+
+type Transcript struct {
+	Text     string
+	Language string // as reported by the transcriber; may be empty
+}
+
+// WhisperCppTranscriber calls the HTTP server that ships with whisper.cpp
+// (examples/server). The server only decodes WAV unless it was started with
+// --convert, which needs ffmpeg for formats such as webm or ogg.
+type WhisperCppTranscriber struct {
+	endpoint string // base URL, e.g. http://localhost:8081
+	client   *http.Client
+}
+
+func NewWhisperCppTranscriber(endpoint string) *WhisperCppTranscriber {
+	return &WhisperCppTranscriber{
+		endpoint: strings.TrimRight(endpoint, "/"),
+		client:   &http.Client{Timeout: 60 * time.Second},
+	}
+}
+
+func (t *WhisperCppTranscriber) Name() string {
+	return "whisper.cpp"
+}
+
+func (t *WhisperCppTranscriber) Transcribe(ctx context.Context, audio []byte, contentType string) (*Transcript, error) {
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	file, err := form.CreateFormFile("file", "voice-note")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := file.Write(audio); err != nil {
+		return nil, err
+	}
+	// Kiswahili and English are both common, so let whisper detect it
+	form.WriteField("language", "auto")
+	form.WriteField("response_format", "verbose_json")
+	form.WriteField("temperature", "0.0")
+	if err := form.Close(); err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.endpoint+"/inference", &body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", form.FormDataContentType())
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("whisper.cpp request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, fmt.Errorf("whisper.cpp returned %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+
+	var result struct {
+		Text     string `json:"text"`
+		Language string `json:"language"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("invalid whisper.cpp response: %w", err)
+	}
+	return &Transcript{Text: strings.TrimSpace(result.Text), Language: result.Language}, nil
+}
+
+// FakeTranscriber returns a fixed transcript. It stands in for a real
+// speech-to-text backend in development and tests.
+type FakeTranscriber struct {
+	Text string
+	Err  error
+}
+
+func (t *FakeTranscriber) Name() string {
+	return "fake"
+}
+
+func (t *FakeTranscriber) Transcribe(ctx context.Context, audio []byte, contentType string) (*Transcript, error) {
+	if t.Err != nil {
+		return nil, t.Err
+	}
+	text := t.Text
+	if text == "" {
+		text = fmt.Sprintf("(voice note, %d bytes)", len(audio))
+	}
+	return &Transcript{Text: text}, nil
+}
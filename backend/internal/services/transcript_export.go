@@ -0,0 +1,222 @@
+package services
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/heal/internal/models"
+	"github.com/heal/internal/pdf"
+)
+
+// Transcript export formats.
+const (
+	ExportPDF      = "pdf"
+	ExportMarkdown = "md"
+	ExportJSON     = "json"
+)
+
+// ExportTranscript builds a record of the session's active branch that a
+// survivor can hand to a counselor or lawyer. The hash is SHA-256 over the
+// canonical (compact, field-ordered) JSON of the transcript, so anyone given
+// the JSON export can check that a document has not been altered. The hash
+// is also recorded, so VerifyTranscript can show it came from this server.
+func (s *ChatService) ExportTranscript(userID, sessionID string, includeAI bool) (*models.TranscriptExport, error) {
+	session, err := s.getChatSession(userID, sessionID)
+	if err != nil {
+		return nil, ErrSessionNotFound
+	}
+
+	messages, err := s.GetChatHistory(userID, sessionID, -1, 0, false)
+	if err != nil {
+		return nil, err
+	}
+
+	userLabel := s.firstName(userID)
+	if userLabel == "" {
+		userLabel = "User"
+	}
+
+	transcript := models.Transcript{
+		SessionID:  session.ID,
+		Title:      session.Title,
+		IncludesAI: includeAI,
+		Messages:   []models.TranscriptMessage{},
+	}
+	for _, message := range messages {
+		if message.SenderType == "ai" && !includeAI {
+			continue
+		}
+		transcript.Messages = append(transcript.Messages, models.TranscriptMessage{
+			ID:          message.ID,
+			SenderType:  message.SenderType,
+			Sender:      senderLabel(message.SenderType, userLabel),
+			MessageType: message.MessageType,
+			Content:     message.Content,
+			CreatedAt:   message.CreatedAt.UTC(),
+		})
+	}
+
+	hash, err := TranscriptHash(transcript)
+	if err != nil {
+		return nil, err
+	}
+	export := &models.TranscriptExport{
+		Transcript: transcript,
+		Algorithm:  "sha256",
+		Hash:       hash,
+		ExportedAt: time.Now().UTC(),
+	}
+
+	// Re-exporting an unchanged transcript keeps the first export's time
+	_, err = s.db.Exec(`
+		INSERT INTO transcript_exports (hash, user_id, session_id, includes_ai, message_count, exported_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT(hash) DO NOTHING
+	`, hash, userID, session.ID, includeAI, len(transcript.Messages), export.ExportedAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to record transcript export: %w", err)
+	}
+	return export, nil
+}
+
+// VerifyTranscript looks up a hash recorded by ExportTranscript. A match
+// means a transcript with exactly that content was exported from this
+// server at the receipt's time.
+func (s *ChatService) VerifyTranscript(hash string) (*models.TranscriptReceipt, error) {
+	receipt := &models.TranscriptReceipt{Algorithm: "sha256"}
+	err := s.db.QueryRow(`
+		SELECT hash, includes_ai, message_count, exported_at
+		FROM transcript_exports
+		WHERE hash = ?
+	`, strings.ToLower(strings.TrimSpace(hash))).Scan(&receipt.Hash, &receipt.IncludesAI, &receipt.MessageCount, &receipt.ExportedAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrTranscriptNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to verify transcript: %w", err)
+	}
+	receipt.ExportedAt = receipt.ExportedAt.UTC()
+	return receipt, nil
+}
+
+// TranscriptHash returns the hex SHA-256 of the transcript's canonical JSON.
+func TranscriptHash(transcript models.Transcript) (string, error) {
+	canonical, err := canonicalJSON(transcript)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(canonical)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// canonicalJSON encodes v compactly without HTML escaping, so the bytes are
+// the same as a plain re-encoding in any language.
+func canonicalJSON(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(v); err != nil {
+		return nil, fmt.Errorf("failed to encode transcript: %w", err)
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
+
+func senderLabel(senderType, userLabel string) string {
+	switch senderType {
+	case "user":
+		return userLabel
+	case "ai":
+		return "Nia (AI assistant)"
+	case "counselor":
+		return "Counselor"
+	default:
+		return senderType
+	}
+}
+
+const transcriptTimeLayout = "2006-01-02 15:04:05 UTC"
+
+// transcriptNote explains how to check a printed transcript.
+const transcriptNote = "Integrity: SHA-256 of the canonical JSON transcript. " +
+	"Export this session as JSON to check the hash."
+
+// RenderTranscript renders an export in the given format and returns the
+// document with its content type.
+func RenderTranscript(export *models.TranscriptExport, format string) ([]byte, string, error) {
+	switch format {
+	case ExportJSON:
+		data, err := json.MarshalIndent(export, "", "  ")
+		return data, "application/json", err
+	case ExportMarkdown:
+		return renderTranscriptMarkdown(export), "text/markdown; charset=utf-8", nil
+	case ExportPDF:
+		return renderTranscriptPDF(export), "application/pdf", nil
+	default:
+		return nil, "", ErrInvalidExportFormat
+	}
+}
+
+func renderTranscriptMarkdown(export *models.TranscriptExport) []byte {
+	var b strings.Builder
+	t := export.Transcript
+
+	fmt.Fprintf(&b, "# Chat transcript: %s\n\n", t.Title)
+	fmt.Fprintf(&b, "- Session: `%s`\n", t.SessionID)
+	fmt.Fprintf(&b, "- Exported: %s\n", export.ExportedAt.Format(transcriptTimeLayout))
+	if !t.IncludesAI {
+		b.WriteString("- AI assistant messages are not included\n")
+	}
+	fmt.Fprintf(&b, "- SHA-256: `%s`\n\n", export.Hash)
+
+	for _, m := range t.Messages {
+		fmt.Fprintf(&b, "---\n\n**%s** · %s", m.Sender, m.CreatedAt.Format(transcriptTimeLayout))
+		if m.MessageType == "audio" {
+			b.WriteString(" · voice note (transcribed)")
+		}
+		b.WriteString("\n\n")
+		// quote every line so message text cannot be read as markdown structure
+		for _, line := range strings.Split(m.Content, "\n") {
+			b.WriteString("> " + line + "\n")
+		}
+		b.WriteString("\n")
+	}
+
+	fmt.Fprintf(&b, "---\n\n_%s_\n", transcriptNote)
+	return []byte(b.String())
+}
+
+func renderTranscriptPDF(export *models.TranscriptExport) []byte {
+	t := export.Transcript
+	doc := pdf.New("Chat transcript")
+	doc.SetFooter("SHA-256 " + export.Hash)
+
+	doc.Heading("Chat transcript: " + t.Title)
+	doc.Small("Session " + t.SessionID)
+	doc.Small("Exported " + export.ExportedAt.Format(transcriptTimeLayout))
+	if !t.IncludesAI {
+		doc.Small("AI assistant messages are not included")
+	}
+	doc.Space(12)
+
+	for _, m := range t.Messages {
+		label := m.Sender
+		if m.MessageType == "audio" {
+			label += " (voice note, transcribed)"
+		}
+		doc.Label(label)
+		doc.Small(m.CreatedAt.Format(transcriptTimeLayout))
+		doc.Text(m.Content)
+		doc.Space(10)
+	}
+
+	doc.Space(10)
+	doc.Small(transcriptNote)
+	doc.Small("SHA-256: " + export.Hash)
+	return doc.Bytes()
+}
@@ -0,0 +1,131 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/heal/internal/models"
+)
+
+func TestExportTranscript(t *testing.T) {
+	db := newTestDB(t)
+	s := newTestChatService(t, db, nil)
+	userID := createTestUser(t, db, "Amani")
+	session := newTestChatSession(t, s, userID, "He took my phone")
+	if _, err := s.SaveMessage(session.ID, userID, "That sounds hard", "ai", "text", nil); err != nil {
+		t.Fatalf("SaveMessage: %v", err)
+	}
+
+	export, err := s.ExportTranscript(userID, session.ID, false)
+	if err != nil {
+		t.Fatalf("ExportTranscript: %v", err)
+	}
+	if len(export.Transcript.Messages) != 1 || export.Transcript.Messages[0].Sender != "Amani" {
+		t.Errorf("messages = %+v, want only the user's message", export.Transcript.Messages)
+	}
+	withAI, err := s.ExportTranscript(userID, session.ID, true)
+	if err != nil || len(withAI.Transcript.Messages) != 2 {
+		t.Fatalf("ExportTranscript with AI = %+v, %v", withAI, err)
+	}
+	if withAI.Hash == export.Hash {
+		t.Error("transcripts with different content have the same hash")
+	}
+	if _, err := s.ExportTranscript(createTestUser(t, db, "Baraka"), session.ID, true); !errors.Is(err, ErrSessionNotFound) {
+		t.Errorf("export of another user's session: err = %v, want ErrSessionNotFound", err)
+	}
+}
+
+func TestVerifyTranscript(t *testing.T) {
+	db := newTestDB(t)
+	s := newTestChatService(t, db, nil)
+	userID := createTestUser(t, db, "Amani")
+	session := newTestChatSession(t, s, userID, "He took my phone")
+
+	first, err := s.ExportTranscript(userID, session.ID, true)
+	if err != nil {
+		t.Fatalf("ExportTranscript: %v", err)
+	}
+	// Exporting the same conversation again keeps the first receipt
+	if _, err := s.ExportTranscript(userID, session.ID, true); err != nil {
+		t.Fatalf("ExportTranscript again: %v", err)
+	}
+
+	receipt, err := s.VerifyTranscript(strings.ToUpper(first.Hash))
+	if err != nil {
+		t.Fatalf("VerifyTranscript: %v", err)
+	}
+	if receipt.Hash != first.Hash || receipt.MessageCount != 1 || !receipt.IncludesAI {
+		t.Errorf("receipt = %+v, want the exported transcript's", receipt)
+	}
+	if !receipt.ExportedAt.Equal(first.ExportedAt) {
+		t.Errorf("receipt exported at %v, want the first export at %v", receipt.ExportedAt, first.ExportedAt)
+	}
+
+	first.Transcript.Messages[0].Content = "edited"
+	altered, _ := TranscriptHash(first.Transcript)
+	if _, err := s.VerifyTranscript(altered); !errors.Is(err, ErrTranscriptNotFound) {
+		t.Errorf("VerifyTranscript of an edited transcript: err = %v, want ErrTranscriptNotFound", err)
+	}
+}
+
+func TestTranscriptHashMatchesJSONExport(t *testing.T) {
+	db := newTestDB(t)
+	s := newTestChatService(t, db, nil)
+	userID := createTestUser(t, db, "Amani")
+	session := newTestChatSession(t, s, userID, "<b>Tom & Jerry</b>\nline two")
+
+	export, err := s.ExportTranscript(userID, session.ID, true)
+	if err != nil {
+		t.Fatalf("ExportTranscript: %v", err)
+	}
+	data, _, err := RenderTranscript(export, ExportJSON)
+	if err != nil {
+		t.Fatalf("RenderTranscript: %v", err)
+	}
+
+	// Someone given only the JSON export re-hashes its transcript
+	var decoded models.TranscriptExport
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("decoding export: %v", err)
+	}
+	hash, err := TranscriptHash(decoded.Transcript)
+	if err != nil || hash != export.Hash {
+		t.Errorf("re-hashed %q, want %q (%v)", hash, export.Hash, err)
+	}
+
+	decoded.Transcript.Messages[0].Content = "edited"
+	if altered, _ := TranscriptHash(decoded.Transcript); altered == export.Hash {
+		t.Error("an edited transcript kept the same hash")
+	}
+}
+
+func TestRenderTranscriptFormats(t *testing.T) {
+	export := &models.TranscriptExport{
+		Transcript: models.Transcript{
+			SessionID: "s1",
+			Title:     "Notes",
+			Messages:  []models.TranscriptMessage{{Sender: "Amani", SenderType: "user", Content: "# not a heading"}},
+		},
+		Hash: "abc123",
+	}
+
+	md, contentType, err := RenderTranscript(export, ExportMarkdown)
+	if err != nil || !strings.HasPrefix(contentType, "text/markdown") {
+		t.Fatalf("RenderTranscript(md) = %q, %v", contentType, err)
+	}
+	if !strings.Contains(string(md), "> # not a heading") || !strings.Contains(string(md), "abc123") {
+		t.Errorf("markdown does not quote the message or show the hash:\n%s", md)
+	}
+
+	doc, contentType, err := RenderTranscript(export, ExportPDF)
+	if err != nil || contentType != "application/pdf" || !bytes.HasPrefix(doc, []byte("%PDF-")) {
+		t.Errorf("RenderTranscript(pdf) = %q, %v", contentType, err)
+	}
+
+	if _, _, err := RenderTranscript(export, "docx"); !errors.Is(err, ErrInvalidExportFormat) {
+		t.Errorf("RenderTranscript(docx): err = %v, want ErrInvalidExportFormat", err)
+	}
+}
@@ -10,11 +10,12 @@ import (
 )
 
 type UserService struct {
-	db *sql.DB
+	db     *sql.DB
+	cipher *FieldCipher
 }
 
-func NewUserService(db *sql.DB) *UserService {
-	return &UserService{db: db}
+func NewUserService(db *sql.DB, cipher *FieldCipher) *UserService {
+	return &UserService{db: db, cipher: cipher}
 }
 
 func (s *UserService) GetProfile(userID string) (*models.UserProfile, error) {
@@ -124,10 +125,15 @@ func (s *UserService) LogMood(userID string, moodScore int, notes string) (*mode
 	logID := uuid.New().String()
 	now := time.Now()
 
-	_, err := s.db.Exec(`
+	storedNotes, err := s.cipher.Encrypt(userID, notes)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = s.db.Exec(`
 		INSERT INTO mood_logs (id, user_id, mood_score, notes, created_at)
 		VALUES (?, ?, ?, ?, ?)
-	`, logID, userID, moodScore, notes, now)
+	`, logID, userID, moodScore, storedNotes, now)
 	if err != nil {
 		return nil, fmt.Errorf("failed to log mood: %w", err)
 	}
@@ -143,7 +149,7 @@ func (s *UserService) LogMood(userID string, moodScore int, notes string) (*mode
 
 func (s *UserService) GetMoodHistory(userID string, days int) ([]models.MoodLog, error) {
 	query := `
-		SELECT id, user_id, mood_score, notes, created_at
+		SELECT id, user_id, mood_score, COALESCE(notes, ''), created_at
 		FROM mood_logs
 		WHERE user_id = ? AND created_at > datetime('now', '-' || ? || ' days')
 		ORDER BY created_at DESC
@@ -162,12 +168,95 @@ func (s *UserService) GetMoodHistory(userID string, days int) ([]models.MoodLog,
 		if err != nil {
 			return nil, err
 		}
+		log.Notes = s.cipher.Reveal(userID, log.Notes)
 		logs = append(logs, log)
 	}
 
 	return logs, nil
 }
 
+// ShredData destroys the user's data keys, so their chat messages, mood
+// notes, safety plan and crisis alert messages become permanently
+// unreadable. Text derived from them and stored unencrypted goes too:
+// session titles are reset to a neutral date, session tags and the
+// responders attached to alerts are deleted, and feedback comments and
+// moderation excerpts are cleared. It all happens in one transaction, so
+// a failure leaves nothing half shredded.
+func (s *UserService) ShredData(userID string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to shred data: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := s.cipher.ShredUser(tx, userID); err != nil {
+		return err
+	}
+	if err := resetSessionTitles(tx, userID); err != nil {
+		return err
+	}
+	// Tags are the user's own words about what a session was about
+	_, err = tx.Exec(`
+		DELETE FROM chat_session_tags
+		WHERE session_id IN (SELECT id FROM chat_sessions WHERE user_id = ?)
+	`, userID)
+	if err != nil {
+		return fmt.Errorf("failed to clear session tags: %w", err)
+	}
+	// The services nearest to an alert give away where the user was
+	if _, err := tx.Exec("DELETE FROM crisis_alert_responders WHERE user_id = ?", userID); err != nil {
+		return fmt.Errorf("failed to clear alert responders: %w", err)
+	}
+	_, err = tx.Exec("UPDATE message_feedback SET feedback = NULL WHERE user_id = ?", userID)
+	if err != nil {
+		return fmt.Errorf("failed to clear feedback: %w", err)
+	}
+	_, err = tx.Exec(`
+		UPDATE moderation_events SET matched_text = NULL
+		WHERE session_id IN (SELECT id FROM chat_sessions WHERE user_id = ?)
+	`, userID)
+	if err != nil {
+		return fmt.Errorf("failed to clear moderation excerpts: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to shred data: %w", err)
+	}
+	s.cipher.dropKeys(userID)
+	return nil
+}
+
+// resetSessionTitles gives each of the user's sessions a neutral title
+// from its start date. They are marked auto so none is generated again
+// from what is left of the conversation.
+func resetSessionTitles(tx *sql.Tx, userID string) error {
+	rows, err := tx.Query("SELECT id, created_at FROM chat_sessions WHERE user_id = ?", userID)
+	if err != nil {
+		return fmt.Errorf("failed to reset session titles: %w", err)
+	}
+	titles := map[string]string{}
+	for rows.Next() {
+		var id string
+		var createdAt time.Time
+		if err := rows.Scan(&id, &createdAt); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to reset session titles: %w", err)
+		}
+		titles[id] = neutralSessionTitle(createdAt)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to reset session titles: %w", err)
+	}
+
+	for id, title := range titles {
+		_, err := tx.Exec("UPDATE chat_sessions SET title = ?, title_source = 'auto' WHERE id = ?", title, id)
+		if err != nil {
+			return fmt.Errorf("failed to reset session titles: %w", err)
+		}
+	}
+	return nil
+}
+
 func (s *UserService) calculateStreak(userID string) int {
 	// Simplified streak calculation - count consecutive days with chat activity
 	rows, err := s.db.Query(`
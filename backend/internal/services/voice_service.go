@@ -0,0 +1,130 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/heal/internal/models"
+	"github.com/heal/internal/storage"
+)
+
+// VoiceService handles voice notes: the audio is encrypted with the user's
+// data key and kept in blob storage, and the transcript becomes the content
+// of an "audio" chat message that Nia answers like any other. It also serves
+// the spoken versions of Nia's replies.
+type VoiceService struct {
+	chatService *ChatService
+	store       storage.BlobStore
+	transcriber Transcriber
+	cipher      *FieldCipher
+	speech      *SpeechService // nil when text-to-speech is disabled
+}
+
+func NewVoiceService(chatService *ChatService, store storage.BlobStore, transcriber Transcriber, cipher *FieldCipher, speech *SpeechService) *VoiceService {
+	return &VoiceService{chatService: chatService, store: store, transcriber: transcriber, cipher: cipher, speech: speech}
+}
+
+// Transcribe converts audio to text. An empty transcript is an error, as
+// there is nothing for Nia to answer.
+func (s *VoiceService) Transcribe(ctx context.Context, audio []byte, contentType string) (*Transcript, error) {
+	transcript, err := s.transcriber.Transcribe(ctx, audio, contentType)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrTranscriptionFailed, err)
+	}
+	transcript.Text = strings.TrimSpace(transcript.Text)
+	if transcript.Text == "" {
+		return nil, fmt.Errorf("%w: no speech detected", ErrTranscriptionFailed)
+	}
+	return transcript, nil
+}
+
+// SaveVoiceMessage stores audio and appends its transcript to the session
+// as a user message. The message metadata links back to the audio.
+func (s *VoiceService) SaveVoiceMessage(ctx context.Context, userID, sessionID string, audio []byte, contentType string, transcript *Transcript) (*models.ChatMessage, error) {
+	sealed, err := s.cipher.EncryptBytes(userID, audio)
+	if err != nil {
+		return nil, err
+	}
+
+	key := "voice/" + userID + "/" + uuid.New().String()
+	if err := s.store.Put(ctx, key, "application/octet-stream", sealed); err != nil {
+		return nil, fmt.Errorf("failed to store voice note: %w", err)
+	}
+
+	metadata := map[string]interface{}{
+		"audioKey":         key,
+		"audioContentType": contentType,
+		"audioBytes":       len(audio),
+		"transcriber":      s.transcriber.Name(),
+	}
+	if transcript.Language != "" {
+		metadata["transcriptLanguage"] = transcript.Language
+	}
+
+	message, err := s.chatService.SaveMessage(sessionID, userID, transcript.Text, "user", "audio", metadata)
+	if err != nil {
+		s.store.Delete(ctx, key)
+		return nil, err
+	}
+	return message, nil
+}
+
+// GetAudio returns the audio behind one of userID's messages and its
+// content type: the original recording of a voice note, or the spoken
+// version of one of Nia's replies.
+func (s *VoiceService) GetAudio(ctx context.Context, userID, messageID string) ([]byte, string, error) {
+	message, err := s.chatService.getOwnedMessage(userID, messageID)
+	if err != nil {
+		return nil, "", err
+	}
+
+	metadata := decodeMetadata(message.Metadata)
+	key := metadataString(metadata, "audioKey")
+	if key == "" {
+		return s.spokenReply(ctx, message, metadata)
+	}
+
+	sealed, err := s.store.Get(ctx, key)
+	if err == storage.ErrNotFound {
+		return nil, "", ErrNoAudio
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to load voice note: %w", err)
+	}
+
+	audio, err := s.cipher.DecryptBytes(message.UserID, sealed)
+	if err != nil {
+		return nil, "", err
+	}
+
+	contentType := metadataString(metadata, "audioContentType")
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	return audio, contentType, nil
+}
+
+// spokenReply loads the synthesized speech linked from an AI message. The
+// audio is keyed by the reply text, so a shredded reply cannot be played.
+func (s *VoiceService) spokenReply(ctx context.Context, message *models.ChatMessage, metadata map[string]interface{}) ([]byte, string, error) {
+	key := metadataString(metadata, "ttsKey")
+	if key == "" || s.speech == nil {
+		return nil, "", ErrNoAudio
+	}
+	if message.Content == RedactedContent {
+		return nil, "", ErrKeyShredded
+	}
+
+	audio, err := s.speech.Load(ctx, key, message.Content, metadataString(metadata, "ttsLanguage"))
+	if err != nil {
+		return nil, "", err
+	}
+
+	contentType := metadataString(metadata, "ttsContentType")
+	if contentType == "" {
+		contentType = "audio/wav"
+	}
+	return audio, contentType, nil
+}
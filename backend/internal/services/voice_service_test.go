@@ -0,0 +1,125 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/heal/internal/storage"
+)
+
+func TestVoiceMessageRoundTrip(t *testing.T) {
+	db := newTestDB(t)
+	ctx := context.Background()
+	dir := t.TempDir()
+	store, err := storage.NewLocalStore(dir)
+	if err != nil {
+		t.Fatalf("NewLocalStore: %v", err)
+	}
+	cipher := newTestCipher(t, db)
+	chat := NewChatService(db, ChatDeps{Cipher: cipher})
+	voice := NewVoiceService(chat, store, &FakeTranscriber{Text: "  Nahitaji msaada  "}, cipher, nil)
+	userID := createTestUser(t, db, "Amani")
+	session, err := chat.GetOrCreateSession(userID, "", "")
+	if err != nil {
+		t.Fatalf("GetOrCreateSession: %v", err)
+	}
+
+	audio := []byte("RIFF fake wav data")
+	transcript, err := voice.Transcribe(ctx, audio, "audio/wav")
+	if err != nil || transcript.Text != "Nahitaji msaada" {
+		t.Fatalf("Transcribe = %+v, %v", transcript, err)
+	}
+	message, err := voice.SaveVoiceMessage(ctx, userID, session.ID, audio, "audio/wav", transcript)
+	if err != nil {
+		t.Fatalf("SaveVoiceMessage: %v", err)
+	}
+	if message.MessageType != "audio" || message.Content != "Nahitaji msaada" {
+		t.Errorf("message = %+v, want an audio message with the transcript", message)
+	}
+
+	key := metadataString(decodeMetadata(message.Metadata), "audioKey")
+	onDisk, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(key)))
+	if err != nil {
+		t.Fatalf("reading stored blob: %v", err)
+	}
+	if bytes.Contains(onDisk, audio) {
+		t.Error("the stored blob contains the plaintext audio")
+	}
+
+	got, contentType, err := voice.GetAudio(ctx, userID, message.ID)
+	if err != nil || !bytes.Equal(got, audio) || contentType != "audio/wav" {
+		t.Errorf("GetAudio = %q, %q, %v", got, contentType, err)
+	}
+	if _, _, err := voice.GetAudio(ctx, createTestUser(t, db, "Baraka"), message.ID); err == nil {
+		t.Error("another user fetched the voice note")
+	}
+}
+
+func TestTranscribeRejectsSilence(t *testing.T) {
+	voice := NewVoiceService(nil, nil, &FakeTranscriber{Text: "   "}, nil, nil)
+	if _, err := voice.Transcribe(context.Background(), []byte("x"), "audio/wav"); !errors.Is(err, ErrTranscriptionFailed) {
+		t.Errorf("Transcribe of silence: err = %v, want ErrTranscriptionFailed", err)
+	}
+	voice = NewVoiceService(nil, nil, &FakeTranscriber{Err: errors.New("offline")}, nil, nil)
+	if _, err := voice.Transcribe(context.Background(), []byte("x"), "audio/wav"); !errors.Is(err, ErrTranscriptionFailed) {
+		t.Errorf("Transcribe with a failing backend: err = %v, want ErrTranscriptionFailed", err)
+	}
+}
+
+func TestDeleteSessionsDeletesBlobs(t *testing.T) {
+	db := newTestDB(t)
+	ctx := context.Background()
+	store, err := storage.NewLocalStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewLocalStore: %v", err)
+	}
+	chat := NewChatService(db, ChatDeps{Blobs: store})
+	voice := NewVoiceService(chat, store, &FakeTranscriber{}, nil, nil)
+	userID := createTestUser(t, db, "Amani")
+
+	// Two sessions share one cached spoken reply
+	const ttsKey = "tts/shared.mp3"
+	if err := store.Put(ctx, ttsKey, "audio/mpeg", []byte("spoken")); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	var sessions []string
+	var audioKey string
+	for i := 0; i < 2; i++ {
+		session, err := chat.GetOrCreateSession(userID, "", "")
+		if err != nil {
+			t.Fatalf("GetOrCreateSession: %v", err)
+		}
+		sessions = append(sessions, session.ID)
+		if _, err := chat.SaveMessage(session.ID, userID, "Pole sana", "ai", "text", map[string]interface{}{"ttsKey": ttsKey}); err != nil {
+			t.Fatalf("SaveMessage: %v", err)
+		}
+		if i == 0 {
+			message, err := voice.SaveVoiceMessage(ctx, userID, session.ID, []byte("audio"), "audio/wav", &Transcript{Text: "hello"})
+			if err != nil {
+				t.Fatalf("SaveVoiceMessage: %v", err)
+			}
+			audioKey = metadataString(decodeMetadata(message.Metadata), "audioKey")
+		}
+	}
+
+	if _, err := chat.DeleteSessions(userID, sessions[:1]); err != nil {
+		t.Fatalf("DeleteSessions: %v", err)
+	}
+	if _, err := store.Get(ctx, audioKey); err == nil {
+		t.Error("the voice note outlived its session")
+	}
+	if _, err := store.Get(ctx, ttsKey); err != nil {
+		t.Errorf("the spoken reply still used by another session was deleted: %v", err)
+	}
+
+	if _, err := chat.DeleteSessions(userID, sessions[1:]); err != nil {
+		t.Fatalf("DeleteSessions: %v", err)
+	}
+	if _, err := store.Get(ctx, ttsKey); err == nil {
+		t.Error("the spoken reply outlived the last message using it")
+	}
+}
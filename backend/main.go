@@ -1,17 +1,21 @@
 package main
 
 import (
+	"context"
 	"log"
 	"net/http"
 	"os"
+	"time"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
 	"github.com/heal/internal/config"
 	"github.com/heal/internal/database"
+	"github.com/heal/internal/encryption"
 	"github.com/heal/internal/handlers"
 	"github.com/heal/internal/middleware"
 	"github.com/heal/internal/services"
+	"github.com/heal/internal/storage"
 )
 
 func main() {
@@ -25,19 +29,141 @@ func main() {
 	}
 	defer db.Close()
 
+	kms, err := encryption.NewKMS(cfg.EncryptionMasterKeys, cfg.EncryptionKeyFile)
+	if err != nil {
+		log.Fatal("Failed to initialize encryption keys:", err)
+	}
+	if cfg.EncryptionMasterKeys == "" && cfg.Environment == "production" {
+		log.Printf("Warning: using local key file %s; set ENCRYPTION_MASTER_KEYS in production", cfg.EncryptionKeyFile)
+	}
+
 	// Initialize services
+	hub := services.NewEventHub()
+	cipher := services.NewFieldCipher(db, kms)
 	authService := services.NewAuthService(db, cfg.JWTSecret)
-	chatService := services.NewChatService(db)
+	quotaService := services.NewQuotaService(db, services.QuotaLimits{
+		UserRatePerMinute:   cfg.ChatUserRatePerMinute,
+		UserBurst:           cfg.ChatUserBurst,
+		IPRatePerMinute:     cfg.ChatIPRatePerMinute,
+		IPBurst:             cfg.ChatIPBurst,
+		DailyTokenBudget:    cfg.LLMDailyTokenBudget,
+		DailyCostBudgetUSD:  cfg.LLMDailyCostBudgetUSD,
+		PromptCostPer1K:     cfg.LLMPromptCostPer1K,
+		CompletionCostPer1K: cfg.LLMCompletionCostPer1K,
+	})
+	promptService := services.NewPromptService(db)
+	if err := promptService.EnsureDefaults(); err != nil {
+		log.Fatal("Failed to seed prompt templates:", err)
+	}
+	blobStore, err := storage.New(storage.Config{
+		Backend:     cfg.BlobStorage,
+		LocalDir:    cfg.BlobLocalDir,
+		S3Endpoint:  cfg.S3Endpoint,
+		S3Region:    cfg.S3Region,
+		S3Bucket:    cfg.S3Bucket,
+		S3AccessKey: cfg.S3AccessKey,
+		S3SecretKey: cfg.S3SecretKey,
+	})
+	if err != nil {
+		log.Fatal("Failed to initialize blob storage:", err)
+	}
+	var speechService *services.SpeechService
+	switch cfg.TTS {
+	case "piper":
+		speechService = services.NewSpeechService(blobStore, services.NewPiperSynthesizer(cfg.PiperURL,
+			map[string]string{"en": cfg.PiperVoiceEN, "sw": cfg.PiperVoiceSW}))
+	case "fake":
+		speechService = services.NewSpeechService(blobStore, &services.FakeSynthesizer{})
+	}
+	moderationPolicy, err := services.LoadModerationPolicy(cfg.ModerationPolicyFile)
+	if err != nil {
+		log.Fatal("Failed to load moderation policy:", err)
+	}
+	outputGuard, err := services.NewOutputGuard(moderationPolicy)
+	if err != nil {
+		log.Fatal("Failed to load moderation policy:", err)
+	}
+	var responseCache *services.ResponseCache
+	if cfg.ResponseCacheTTLMinutes > 0 {
+		responseCache = services.NewResponseCache(time.Duration(cfg.ResponseCacheTTLMinutes)*time.Minute,
+			cfg.ResponseCacheMaxEntries)
+	}
+	chatService := services.NewChatService(db, services.ChatDeps{
+		LLM:     services.NewGeminiProvider(cfg.GeminiAPIKey),
+		Hub:     hub,
+		Quota:   quotaService,
+		Cipher:  cipher,
+		Prompts: promptService,
+		Speech:  speechService,
+		Guard:   outputGuard,
+		Cache:   responseCache,
+		Blobs:   blobStore,
+	})
+	handoffService := services.NewHandoffService(db, chatService, hub)
+
+	var transcriber services.Transcriber = services.NewWhisperCppTranscriber(cfg.WhisperURL)
+	if cfg.Transcriber == "fake" {
+		transcriber = &services.FakeTranscriber{}
+	}
+	voiceService := services.NewVoiceService(chatService, blobStore, transcriber, cipher, speechService)
 	resourceService := services.NewResourceService(db)
-	crisisService := services.NewCrisisService(db)
-	userService := services.NewUserService(db)
+	var smsSender services.SMSSender
+	switch cfg.SMSProvider {
+	case "africastalking":
+		smsSender = services.NewAfricasTalkingSender(cfg.AfricasTalkingURL, cfg.AfricasTalkingUser,
+			cfg.AfricasTalkingAPIKey, cfg.AfricasTalkingFrom)
+	case "twilio":
+		smsSender = services.NewTwilioSender(cfg.TwilioURL, cfg.TwilioAccountSID, cfg.TwilioAuthToken, cfg.TwilioFrom)
+	case "fake":
+		smsSender = &services.FakeSMSSender{}
+	}
+	smsDispatcher, err := services.NewSMSDispatcher(db, smsSender, services.SMSOptions{
+		MinSeverity:    cfg.SMSAlertMinSeverity,
+		Template:       cfg.SMSAlertTemplate,
+		VerifyTemplate: cfg.SMSVerifyTemplate,
+	})
+	if err != nil {
+		log.Fatal("Failed to configure SMS alerts:", err)
+	}
+	if smsSender != nil && cfg.SMSDispatchSeconds > 0 {
+		go smsDispatcher.Run(context.Background(), time.Duration(cfg.SMSDispatchSeconds)*time.Second)
+	}
+	directoryService := services.NewDirectoryService(db)
+	crisisService := services.NewCrisisService(db, cipher, smsDispatcher, directoryService)
+	escalationPolicy, err := services.LoadEscalationPolicy(cfg.EscalationPolicyFile)
+	if err != nil {
+		log.Fatal("Failed to load escalation policy:", err)
+	}
+	escalationService, err := services.NewEscalationService(db, crisisService, escalationPolicy,
+		&services.HubNotifier{Hub: hub, SMS: smsDispatcher}, services.SystemClock{})
+	if err != nil {
+		log.Fatal("Failed to load escalation policy:", err)
+	}
+	if cfg.EscalationCheckSeconds > 0 {
+		go escalationService.Run(context.Background(), time.Duration(cfg.EscalationCheckSeconds)*time.Second)
+	}
+	checkinService := services.NewCheckinService(db, crisisService, smsDispatcher,
+		&services.HubPushSender{Hub: hub}, services.SystemClock{})
+	if cfg.CheckinCheckSeconds > 0 {
+		go checkinService.Run(context.Background(), time.Duration(cfg.CheckinCheckSeconds)*time.Second)
+	}
+	userService := services.NewUserService(db, cipher)
+	retentionService := services.NewRetentionService(db, blobStore, time.Duration(cfg.ChatMaxRetentionDays)*24*time.Hour)
+	if cfg.RetentionSweepMinutes > 0 {
+		go retentionService.Run(context.Background(), time.Duration(cfg.RetentionSweepMinutes)*time.Minute)
+	}
 
 	// Initialize handlers
-	authHandler := handlers.NewAuthHandler(authService)
-	chatHandler := handlers.NewChatHandler(chatService)
+	authHandler := handlers.NewAuthHandler(authService, retentionService)
+	chatHandler := handlers.NewChatHandler(chatService, handoffService, quotaService, voiceService, hub,
+		int64(cfg.VoiceMaxBytes))
 	resourceHandler := handlers.NewResourceHandler(resourceService)
-	crisisHandler := handlers.NewCrisisHandler(crisisService)
-	userHandler := handlers.NewUserHandler(userService)
+	crisisHandler := handlers.NewCrisisHandler(crisisService, escalationService, smsDispatcher, directoryService,
+		checkinService, hub)
+	webhookHandler := handlers.NewWebhookHandler(smsDispatcher, crisisService, cfg.SMSWebhookToken)
+	userHandler := handlers.NewUserHandler(userService, retentionService)
+	adminHandler := handlers.NewAdminHandler(chatService, promptService)
+	counselorHandler := handlers.NewCounselorHandler(handoffService, hub)
 
 	// Setup router
 	router := gin.Default()
@@ -45,9 +171,9 @@ func main() {
 	// CORS middleware
 	router.Use(cors.New(cors.Config{
 		AllowOrigins:     []string{"http://localhost:3000", "https://heal-app.com"},
-		AllowMethods:     []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
+		AllowMethods:     []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
 		AllowHeaders:     []string{"Origin", "Content-Type", "Accept", "Authorization"},
-		ExposeHeaders:    []string{"Content-Length"},
+		ExposeHeaders:    []string{"Content-Length", "Retry-After"},
 		AllowCredentials: true,
 	}))
 
@@ -75,6 +201,16 @@ func main() {
 			auth.GET("/verify-email/:token", authHandler.VerifyEmail)
 		}
 
+		// Provider callbacks, authenticated by a shared token
+		webhooks := api.Group("/webhooks")
+		{
+			webhooks.POST("/sms/:provider", webhookHandler.SMSDeliveryReport)
+			webhooks.POST("/sms/:provider/inbound", webhookHandler.SMSInbound)
+		}
+
+		// Anyone holding an exported transcript can check its hash
+		api.GET("/transcripts/verify/:hash", chatHandler.VerifyTranscript)
+
 		// Protected routes
 		protected := api.Group("/")
 		protected.Use(middleware.AuthRequired(authService))
@@ -87,14 +223,30 @@ func main() {
 				user.GET("/stats", userHandler.GetStats)
 				user.POST("/mood", userHandler.LogMood)
 				user.GET("/mood-history", userHandler.GetMoodHistory)
+				user.GET("/retention", userHandler.GetRetentionSettings)
+				user.PUT("/retention", userHandler.UpdateRetentionSettings)
+				user.DELETE("/data-key", userHandler.ShredData)
 			}
 
 			// Chat routes
 			chat := protected.Group("/chat")
 			{
 				chat.POST("/message", chatHandler.SendMessage)
+				chat.POST("/voice", chatHandler.SendVoiceMessage)
+				chat.GET("/message/:id/audio", chatHandler.GetMessageAudio)
+				chat.PUT("/message/:id", chatHandler.EditMessage)
+				chat.POST("/message/:id/regenerate", chatHandler.RegenerateMessage)
+				chat.POST("/message/:id/activate", chatHandler.ActivateBranch)
 				chat.GET("/history", chatHandler.GetChatHistory)
 				chat.GET("/sessions", chatHandler.GetChatSessions)
+				chat.POST("/sessions/archive", chatHandler.ArchiveSessions)
+				chat.POST("/sessions/delete", chatHandler.DeleteSessions)
+				chat.GET("/tags", chatHandler.GetSessionTags)
+				chat.GET("/modes", chatHandler.GetChatModes)
+				chat.PATCH("/session/:id", chatHandler.UpdateChatSession)
+				chat.POST("/session/:id/handoff", chatHandler.RequestHandoff)
+				chat.GET("/session/:id/events", chatHandler.StreamSessionEvents)
+				chat.GET("/session/:id/export", chatHandler.ExportTranscript)
 				chat.DELETE("/session/:id", chatHandler.DeleteChatSession)
 				chat.POST("/feedback", chatHandler.SubmitFeedback)
 			}
@@ -114,11 +266,71 @@ func main() {
 			crisis := protected.Group("/crisis")
 			{
 				crisis.POST("/alert", crisisHandler.CreateCrisisAlert)
+				crisis.GET("/alerts", crisisHandler.GetCrisisAlerts)
+				crisis.GET("/alerts/:id", crisisHandler.GetCrisisAlert)
+				crisis.GET("/alerts/:id/notifications", crisisHandler.GetCrisisAlertNotifications)
+				crisis.POST("/alerts/:id/location", crisisHandler.UpdateAlertLocation)
+				crisis.GET("/sms-alerts", crisisHandler.GetSMSAlertSettings)
+				crisis.PUT("/sms-alerts", crisisHandler.UpdateSMSAlertSettings)
 				crisis.GET("/contacts", crisisHandler.GetEmergencyContacts)
 				crisis.POST("/contacts", crisisHandler.AddEmergencyContact)
+				crisis.PUT("/contacts/:id", crisisHandler.UpdateEmergencyContact)
+				crisis.DELETE("/contacts/:id", crisisHandler.DeleteEmergencyContact)
+				crisis.POST("/contacts/:id/primary", crisisHandler.SetPrimaryContact)
+				crisis.POST("/contacts/:id/verify", crisisHandler.VerifyEmergencyContact)
 				crisis.GET("/services", crisisHandler.GetLocalServices)
+				crisis.GET("/services/:id", crisisHandler.GetLocalService)
 				crisis.POST("/safety-plan", crisisHandler.CreateSafetyPlan)
 				crisis.GET("/safety-plan", crisisHandler.GetSafetyPlan)
+				crisis.GET("/safety-plan/versions", crisisHandler.GetSafetyPlanVersions)
+				crisis.GET("/safety-plan/versions/:version", crisisHandler.GetSafetyPlanVersion)
+				crisis.POST("/safety-plan/versions/:version/restore", crisisHandler.RestoreSafetyPlanVersion)
+				crisis.GET("/safety-plan/diff", crisisHandler.DiffSafetyPlan)
+				crisis.GET("/safety-plan/export", crisisHandler.ExportSafetyPlan)
+				crisis.POST("/checkins", crisisHandler.CreateCheckin)
+				crisis.GET("/checkins", crisisHandler.GetCheckins)
+				crisis.GET("/checkins/stream", crisisHandler.StreamNotifications)
+				crisis.GET("/checkins/:id", crisisHandler.GetCheckin)
+				crisis.POST("/checkins/:id/confirm", crisisHandler.ConfirmCheckin)
+				crisis.POST("/checkins/:id/cancel", crisisHandler.CancelCheckin)
+			}
+
+			// Counselor routes
+			counselor := protected.Group("/counselor")
+			counselor.Use(middleware.RequireRole("counselor", "admin"))
+			{
+				counselor.GET("/queue", counselorHandler.GetQueue)
+				counselor.POST("/handoffs/:id/claim", counselorHandler.ClaimHandoff)
+				counselor.POST("/handoffs/:id/release", counselorHandler.ReleaseHandoff)
+				counselor.GET("/sessions/:id/messages", counselorHandler.GetSessionMessages)
+				counselor.POST("/sessions/:id/messages", counselorHandler.SendMessage)
+				counselor.GET("/sessions/:id/events", counselorHandler.StreamSessionEvents)
+			}
+
+			// Crisis alert routes for staff
+			alerts := protected.Group("/staff/alerts")
+			alerts.Use(middleware.RequireRole("staff", "counselor", "admin"))
+			{
+				alerts.GET("", crisisHandler.ListAlerts)
+				alerts.GET("/pages", crisisHandler.StreamOnCallPages)
+				alerts.GET("/:id", crisisHandler.GetAlert)
+				alerts.GET("/:id/escalations", crisisHandler.GetEscalations)
+				alerts.GET("/:id/notifications", crisisHandler.GetAlertNotifications)
+				alerts.POST("/:id/acknowledge", crisisHandler.AcknowledgeAlert)
+				alerts.POST("/:id/escalate", crisisHandler.EscalateAlert)
+				alerts.POST("/:id/resolve", crisisHandler.ResolveAlert)
+			}
+
+			// Admin routes
+			admin := protected.Group("/admin")
+			admin.Use(middleware.RequireRole("admin"))
+			{
+				admin.GET("/feedback/analytics", adminHandler.GetFeedbackAnalytics)
+				admin.GET("/prompts", adminHandler.GetPrompts)
+				admin.POST("/prompts", adminHandler.CreatePrompt)
+				admin.PATCH("/prompts/:id", adminHandler.UpdatePromptWeight)
+				admin.GET("/prompts/compare", adminHandler.ComparePrompts)
+				admin.GET("/moderation", adminHandler.GetModerationEvents)
 			}
 		}
 	}